@@ -15,24 +15,76 @@ import (
 var Configures *Config
 
 var (
-	defaultHost              = "127.0.0.1"
-	defaultPort              = 6380
-	defaultLogDir            = "./"
-	defaultLogLevel          = "info"
-	defaultSharedNumber      = 1024
-	defaultChannelBufferSize = 10
+	defaultHost               = "127.0.0.1"
+	defaultPort               = 6380
+	defaultLogDir             = "./"
+	defaultLogLevel           = "info"
+	defaultSharedNumber       = 1024
+	defaultDatabases          = 16
+	defaultListMaxSize        = -2
+	defaultListCompressDepth  = 0
+	defaultDir                = "./"
+	defaultDBFilename         = "dump.rdb"
+	defaultSaveRules          = "3600 1 300 100 60 10000"
+	defaultAppendFilename     = "appendonly.aof"
+	defaultAppendFsync        = "everysec"
+	defaultReplBacklogSize    = 1024 * 1024
+	defaultClusterConfigFile  = "nodes.conf"
+	defaultClusterNodeTimeout = 15000
+	// the pubsub hard limit of client-output-buffer-limit of redis
+	defaultPubSubOutputBufferLimit = 32 * 1024 * 1024
+	// the keyspace notifications are disabled by default
+	defaultNotifyKeyspaceEvents = ""
 )
 
 type Config struct {
-	ConfFile          string
-	Host              string
-	Port              int
-	LogDir            string
-	LogLevel          string
-	ShardNumber       int
-	ChannelBufferSize int
-	Databases         int
-	Others            map[string]any
+	ConfFile    string
+	Host        string
+	Port        int
+	LogDir      string
+	LogLevel    string
+	ShardNumber int
+	Databases   int
+	// ListMaxListpackSize limits the elements of a list node when positive, or its bytes from 4KB (-1) to 64KB (-5) when negative
+	ListMaxListpackSize int
+	// ListCompressDepth is the number of list nodes at each end that are never compressed, 0 disables compression
+	ListCompressDepth int
+	// Dir is the working directory where the rdb file is written
+	Dir string
+	// DBFilename is the name of the rdb file
+	DBFilename string
+	// SaveRules save the dataset when any rule is satisfied, empty disables the save rules
+	SaveRules []SaveRule
+	// AppendOnly enables the append only file, it is loaded instead of the rdb file on startup
+	AppendOnly bool
+	// AppendFilename is the name of the append only file in Dir
+	AppendFilename string
+	// AppendFsync is when the append only file is synced to disk: always, everysec or no
+	AppendFsync string
+	// MasterHost and MasterPort make the server a replica of this master on startup, MasterHost is empty for a master
+	MasterHost string
+	MasterPort int
+	// ReplicaReadOnly rejects the writes of clients while the server is a replica
+	ReplicaReadOnly bool
+	// ReplBacklogSize is the bytes of the replication stream kept for the partial resynchronization of replicas
+	ReplBacklogSize int
+	// ClusterEnabled runs the server as a node of a cluster, its bus listens on Port+10000
+	ClusterEnabled bool
+	// ClusterConfigFile is the file in Dir where the node saves the cluster state
+	ClusterConfigFile string
+	// ClusterNodeTimeout is the milliseconds a node can be unreachable before it is considered failing
+	ClusterNodeTimeout int
+	// NotifyKeyspaceEvents are the classes of keyspace events published, as the characters of redis, empty disables them
+	NotifyKeyspaceEvents string
+	// PubSubOutputBufferLimit is the bytes of messages a subscriber can have pending before it is disconnected, 0 is no limit
+	PubSubOutputBufferLimit int
+	Others                  map[string]any
+}
+
+// SaveRule save the dataset when at least Changes writes happened in Seconds
+type SaveRule struct {
+	Seconds int
+	Changes int
 }
 
 type ConfError struct {
@@ -48,20 +100,63 @@ func Init(cfg *Config) {
 	flag.IntVar(&(cfg.Port), "port", defaultPort, "Bind host ip: default is 127.0.0.1")
 	flag.StringVar(&(cfg.LogDir), "logdir", defaultLogDir, "Create log directory: default is /tmp")
 	flag.StringVar(&(cfg.LogLevel), "loglevel", defaultLogLevel, "Create log level: default is info")
-	flag.IntVar(&(cfg.ChannelBufferSize), "channelbuffersize", defaultChannelBufferSize, "set the buffer size of channels in PUB/SUB commands. ")
+	flag.IntVar(&(cfg.Databases), "databases", defaultDatabases, "Set the number of databases: default is 16")
+	flag.IntVar(&(cfg.ListMaxListpackSize), "list-max-listpack-size", defaultListMaxSize, "Set the max size of a list node: default is -2 (8KB)")
+	flag.IntVar(&(cfg.ListCompressDepth), "list-compress-depth", defaultListCompressDepth, "Set the number of uncompressed list nodes at each end: default is 0 (no compression)")
+	flag.StringVar(&(cfg.ConfFile), "config", "", "Set the config file path")
+	flag.StringVar(&(cfg.Dir), "dir", defaultDir, "Set the directory of the rdb file: default is ./")
+	flag.StringVar(&(cfg.DBFilename), "dbfilename", defaultDBFilename, "Set the name of the rdb file: default is dump.rdb")
+	flag.Func("save", "Set the save rules as \"<seconds> <changes> ...\", \"\" disables saving: default is \""+defaultSaveRules+"\"", func(s string) error {
+		rules, err := ParseSaveRules(s)
+		if err != nil {
+			return err
+		}
+		cfg.SaveRules = rules
+		return nil
+	})
+	flag.BoolVar(&(cfg.AppendOnly), "appendonly", false, "Enable the append only file: default is false")
+	flag.StringVar(&(cfg.AppendFilename), "appendfilename", defaultAppendFilename, "Set the name of the append only file: default is appendonly.aof")
+	flag.StringVar(&(cfg.AppendFsync), "appendfsync", defaultAppendFsync, "Set the fsync policy of the append only file, always, everysec or no: default is everysec")
+	flag.Func("replicaof", "Make the server a replica of \"<host> <port>\": default is a master", func(s string) error {
+		host, port, err := ParseReplicaOf(strings.Fields(s))
+		if err != nil {
+			return err
+		}
+		cfg.MasterHost, cfg.MasterPort = host, port
+		return nil
+	})
+	flag.BoolVar(&(cfg.ReplicaReadOnly), "replica-read-only", true, "Reject the writes of clients on a replica: default is true")
+	flag.IntVar(&(cfg.ReplBacklogSize), "repl-backlog-size", defaultReplBacklogSize, "Set the bytes of the replication backlog: default is 1MB")
+	flag.BoolVar(&(cfg.ClusterEnabled), "cluster-enabled", false, "Run the server as a cluster node: default is false")
+	flag.StringVar(&(cfg.ClusterConfigFile), "cluster-config-file", defaultClusterConfigFile, "Set the name of the cluster state file: default is nodes.conf")
+	flag.IntVar(&(cfg.ClusterNodeTimeout), "cluster-node-timeout", defaultClusterNodeTimeout, "Set the milliseconds before an unreachable node is failing: default is 15000")
+	flag.StringVar(&(cfg.NotifyKeyspaceEvents), "notify-keyspace-events", defaultNotifyKeyspaceEvents, "Set the classes of keyspace events to publish, as \"KEA\": default is \"\" (disabled)")
+	flag.IntVar(&(cfg.PubSubOutputBufferLimit), "pubsub-output-buffer-limit", defaultPubSubOutputBufferLimit, "Set the bytes of messages a subscriber can have pending before it is disconnected, 0 is no limit: default is 32MB")
 }
 
 func Setup() (*Config, error) {
 	cfg := &Config{
-		Host:              defaultHost,
-		Port:              defaultPort,
-		LogDir:            defaultLogDir,
-		LogLevel:          defaultLogLevel,
-		ShardNumber:       defaultSharedNumber,
-		ChannelBufferSize: defaultChannelBufferSize,
-		Databases:         16,
-		Others:            make(map[string]any),
+		Host:                    defaultHost,
+		Port:                    defaultPort,
+		LogDir:                  defaultLogDir,
+		LogLevel:                defaultLogLevel,
+		ShardNumber:             defaultSharedNumber,
+		Databases:               defaultDatabases,
+		ListMaxListpackSize:     defaultListMaxSize,
+		ListCompressDepth:       defaultListCompressDepth,
+		Dir:                     defaultDir,
+		DBFilename:              defaultDBFilename,
+		AppendFilename:          defaultAppendFilename,
+		AppendFsync:             defaultAppendFsync,
+		ReplicaReadOnly:         true,
+		ReplBacklogSize:         defaultReplBacklogSize,
+		ClusterConfigFile:       defaultClusterConfigFile,
+		ClusterNodeTimeout:      defaultClusterNodeTimeout,
+		NotifyKeyspaceEvents:    defaultNotifyKeyspaceEvents,
+		PubSubOutputBufferLimit: defaultPubSubOutputBufferLimit,
+		Others:                  make(map[string]any),
 	}
+	cfg.SaveRules, _ = ParseSaveRules(defaultSaveRules)
 	// init information
 	Init(cfg)
 	// parse command line flags
@@ -84,6 +179,30 @@ func Setup() (*Config, error) {
 			}
 			return nil, portErr
 		}
+		if cfg.Databases <= 0 {
+			dbErr := &ConfError{
+				message: fmt.Sprintf("Databases should be an positive integer, but %d is given.", cfg.Databases),
+			}
+			return nil, dbErr
+		}
+		if err := checkListConfig(cfg); err != nil {
+			return nil, err
+		}
+		if err := checkAppendFsync(cfg.AppendFsync); err != nil {
+			return nil, err
+		}
+		if err := checkReplBacklogSize(cfg.ReplBacklogSize); err != nil {
+			return nil, err
+		}
+		if err := checkClusterConfig(cfg); err != nil {
+			return nil, err
+		}
+		if err := checkNotifyKeyspaceEvents(cfg.NotifyKeyspaceEvents); err != nil {
+			return nil, err
+		}
+		if err := checkPubSubOutputBufferLimit(cfg.PubSubOutputBufferLimit); err != nil {
+			return nil, err
+		}
 	}
 
 	return cfg, nil
@@ -104,6 +223,8 @@ func (cfg *Config) ParseConfFile(confFile string) error {
 	}()
 
 	reader := bufio.NewReader(file)
+	// the first save line replaces the default save rules
+	saveParsed := false
 	for {
 		line, ioErr := reader.ReadString('\n')
 		if ioErr != nil && ioErr != io.EOF {
@@ -149,13 +270,116 @@ func (cfg *Config) ParseConfFile(confFile string) error {
 					panic(err)
 				}
 			case "databases":
-				cfg.ShardNumber, err = strconv.Atoi(fields[1])
+				cfg.Databases, err = strconv.Atoi(fields[1])
 				if err != nil {
 					log.Fatal("Databases should be an integer. Get: ", fields[1])
 				}
 				if cfg.Databases <= 0 {
 					log.Fatal("Databases should be an positive integer. Get: ", fields[1])
 				}
+			case "list-max-listpack-size", "list-max-ziplist-size":
+				cfg.ListMaxListpackSize, err = strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if err = checkListConfig(cfg); err != nil {
+					return err
+				}
+			case "list-compress-depth":
+				cfg.ListCompressDepth, err = strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if err = checkListConfig(cfg); err != nil {
+					return err
+				}
+			case "dir":
+				cfg.Dir = fields[1]
+			case "dbfilename":
+				cfg.DBFilename = fields[1]
+			case "save":
+				rules, err := ParseSaveRules(strings.Trim(strings.Join(fields[1:], " "), "\""))
+				if err != nil {
+					return err
+				}
+				if !saveParsed {
+					cfg.SaveRules = nil
+					saveParsed = true
+				}
+				cfg.SaveRules = append(cfg.SaveRules, rules...)
+			case "appendonly":
+				switch strings.ToLower(fields[1]) {
+				case "yes":
+					cfg.AppendOnly = true
+				case "no":
+					cfg.AppendOnly = false
+				default:
+					return &ConfError{message: fmt.Sprintf("Appendonly should be yes or no, but %s is given.", fields[1])}
+				}
+			case "appendfilename":
+				cfg.AppendFilename = strings.Trim(fields[1], "\"")
+			case "appendfsync":
+				cfg.AppendFsync = strings.ToLower(fields[1])
+				if err = checkAppendFsync(cfg.AppendFsync); err != nil {
+					return err
+				}
+			case "replicaof", "slaveof":
+				cfg.MasterHost, cfg.MasterPort, err = ParseReplicaOf(fields[1:])
+				if err != nil {
+					return err
+				}
+			case "replica-read-only", "slave-read-only":
+				switch strings.ToLower(fields[1]) {
+				case "yes":
+					cfg.ReplicaReadOnly = true
+				case "no":
+					cfg.ReplicaReadOnly = false
+				default:
+					return &ConfError{message: fmt.Sprintf("Replica-read-only should be yes or no, but %s is given.", fields[1])}
+				}
+			case "repl-backlog-size":
+				cfg.ReplBacklogSize, err = strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if err = checkReplBacklogSize(cfg.ReplBacklogSize); err != nil {
+					return err
+				}
+			case "cluster-enabled":
+				switch strings.ToLower(fields[1]) {
+				case "yes":
+					cfg.ClusterEnabled = true
+				case "no":
+					cfg.ClusterEnabled = false
+				default:
+					return &ConfError{message: fmt.Sprintf("Cluster-enabled should be yes or no, but %s is given.", fields[1])}
+				}
+				if err = checkClusterConfig(cfg); err != nil {
+					return err
+				}
+			case "cluster-config-file":
+				cfg.ClusterConfigFile = strings.Trim(fields[1], "\"")
+			case "cluster-node-timeout":
+				cfg.ClusterNodeTimeout, err = strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if err = checkClusterConfig(cfg); err != nil {
+					return err
+				}
+			case "notify-keyspace-events":
+				cfg.NotifyKeyspaceEvents = strings.Trim(fields[1], "\"")
+				if err = checkNotifyKeyspaceEvents(cfg.NotifyKeyspaceEvents); err != nil {
+					return err
+				}
+			case "pubsub-output-buffer-limit":
+				cfg.PubSubOutputBufferLimit, err = strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if err = checkPubSubOutputBufferLimit(cfg.PubSubOutputBufferLimit); err != nil {
+					return err
+				}
 			default:
 				cfg.Others[cfgName] = fields[1]
 			}
@@ -166,3 +390,101 @@ func (cfg *Config) ParseConfFile(confFile string) error {
 	}
 	return nil
 }
+
+// checkListConfig check list-max-listpack-size is a positive number or within [-5, -1], and list-compress-depth is not negative
+func checkListConfig(cfg *Config) error {
+	if cfg.ListMaxListpackSize == 0 || cfg.ListMaxListpackSize < -5 {
+		return &ConfError{
+			message: fmt.Sprintf("List max listpack size should be positive or between -5 and -1, but %d is given.", cfg.ListMaxListpackSize),
+		}
+	}
+	if cfg.ListCompressDepth < 0 {
+		return &ConfError{
+			message: fmt.Sprintf("List compress depth should not be negative, but %d is given.", cfg.ListCompressDepth),
+		}
+	}
+	return nil
+}
+
+// checkAppendFsync check the fsync policy is always, everysec or no
+func checkAppendFsync(policy string) error {
+	switch policy {
+	case "always", "everysec", "no":
+		return nil
+	}
+	return &ConfError{
+		message: fmt.Sprintf("Appendfsync should be always, everysec or no, but %s is given.", policy),
+	}
+}
+
+// checkReplBacklogSize check the replication backlog has at least 16KB
+func checkReplBacklogSize(size int) error {
+	if size < 16*1024 {
+		return &ConfError{
+			message: fmt.Sprintf("Repl backlog size should be at least 16384, but %d is given.", size),
+		}
+	}
+	return nil
+}
+
+// checkClusterConfig check the node timeout is positive and the bus port Port+10000 is valid
+func checkClusterConfig(cfg *Config) error {
+	if cfg.ClusterNodeTimeout <= 0 {
+		return &ConfError{message: fmt.Sprintf("Cluster node timeout should be positive, but %d is given.", cfg.ClusterNodeTimeout)}
+	}
+	if cfg.ClusterEnabled && cfg.Port+10000 >= 65535 {
+		return &ConfError{message: fmt.Sprintf("Listening port should be below 55535 in cluster mode, but %d is given.", cfg.Port)}
+	}
+	return nil
+}
+
+// checkNotifyKeyspaceEvents check the keyspace event classes are among KEg$lshzxetdmnA
+func checkNotifyKeyspaceEvents(classes string) error {
+	for _, c := range classes {
+		if !strings.ContainsRune("KEg$lshzxetdmnA", c) {
+			return &ConfError{message: fmt.Sprintf("Invalid notify-keyspace-events %q, unknown class %q.", classes, c)}
+		}
+	}
+	return nil
+}
+
+// checkPubSubOutputBufferLimit check the pubsub output buffer limit is not negative
+func checkPubSubOutputBufferLimit(limit int) error {
+	if limit < 0 {
+		return &ConfError{message: fmt.Sprintf("Pubsub output buffer limit should not be negative, but %d is given.", limit)}
+	}
+	return nil
+}
+
+// ParseReplicaOf parse the master given as host and port, "no one" means no master and returns an empty host
+func ParseReplicaOf(fields []string) (string, int, error) {
+	if len(fields) != 2 {
+		return "", 0, &ConfError{message: fmt.Sprintf("Invalid replicaof %q, should be <host> <port>.", strings.Join(fields, " "))}
+	}
+	if strings.EqualFold(fields[0], "no") && strings.EqualFold(fields[1], "one") {
+		return "", 0, nil
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, &ConfError{message: fmt.Sprintf("Invalid master port %s.", fields[1])}
+	}
+	return fields[0], port, nil
+}
+
+// ParseSaveRules parse save rules given as "<seconds> <changes>" pairs, an empty string means no rule
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, &ConfError{message: fmt.Sprintf("Invalid save rules %q, should be <seconds> <changes> pairs.", s)}
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds <= 0 || changes <= 0 {
+			return nil, &ConfError{message: fmt.Sprintf("Invalid save rule %s %s, seconds and changes should be positive integers.", fields[i], fields[i+1])}
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
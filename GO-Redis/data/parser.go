@@ -0,0 +1,391 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// MaxInlineSize is the longest inline command or header line accepted from a client
+	MaxInlineSize = 64 * 1024
+	// MaxBulkSize is the largest bulk string accepted from a client (512MB as redis proto-max-bulk-len)
+	MaxBulkSize = 512 * 1024 * 1024
+	// MaxMultiBulkSize is the largest number of arguments of one multi-bulk request
+	MaxMultiBulkSize = 1024 * 1024
+)
+
+// ProtocolError is returned when the input stream does not follow RESP
+// The connection can not be recovered after it and should be closed after replying the error
+type ProtocolError struct {
+	message string
+}
+
+func (protoErr *ProtocolError) Error() string {
+	return "Protocol error: " + protoErr.message
+}
+
+// ToErrorData make the error reply sent back to the client before closing the connection
+func (protoErr *ProtocolError) ToErrorData() *ErrorData {
+	return MakeErrorData("ERR ", protoErr.Error())
+}
+
+func makeProtocolError(format string, args ...any) *ProtocolError {
+	return &ProtocolError{message: fmt.Sprintf(format, args...)}
+}
+
+// Parser decode RESP data from a stream.
+// bufio.Reader keeps the bytes that have not been consumed,
+// so a request split over several tcp packets is read as a whole.
+type Parser struct {
+	reader *bufio.Reader
+}
+
+func NewParser(reader *bufio.Reader) *Parser {
+	return &Parser{
+		reader: reader,
+	}
+}
+
+// ReadCommand read one client request.
+// Multi-bulk requests "*<n>\r\n$<len>\r\n<arg>\r\n..." are binary safe,
+// any other line is treated as an inline command and split by spaces (quotes are supported).
+// An empty inline line returns an empty ArrayData.
+func (parser *Parser) ReadCommand() (*ArrayData, error) {
+	first, err := parser.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == '*' {
+		return parser.readMultiBulk()
+	}
+
+	line, err := parser.readLine()
+	if err != nil {
+		return nil, err
+	}
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]RedisData, 0, len(args))
+	for _, arg := range args {
+		res = append(res, MakeBulkData(arg))
+	}
+	return MakeArrayData(res), nil
+}
+
+// ReadValue read one RESP2 or RESP3 value of any type, it is used to read replies sent by another server
+func (parser *Parser) ReadValue() (RedisData, error) {
+	line, err := parser.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, makeProtocolError("empty line")
+	}
+	switch line[0] {
+	case '+':
+		return MakeStringData(string(line[1:])), nil
+	case '-':
+		return MakeErrorData(string(line[1:])), nil
+	case ':':
+		val, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, makeProtocolError("invalid integer %q", line[1:])
+		}
+		return MakeIntData(val), nil
+	case '$':
+		size, err := parseSize(line[1:], MaxBulkSize)
+		if err != nil {
+			return nil, makeProtocolError("invalid bulk length")
+		}
+		if size < 0 {
+			return MakeBulkData(nil), nil
+		}
+		return parser.readBulkBody(size)
+	case '*':
+		size, err := parseSize(line[1:], MaxMultiBulkSize)
+		if err != nil {
+			return nil, makeProtocolError("invalid multibulk length")
+		}
+		if size < 0 {
+			return &ArrayData{data: nil}, nil
+		}
+		res := make([]RedisData, 0, size)
+		for i := 0; i < size; i++ {
+			item, err := parser.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, item)
+		}
+		return MakeArrayData(res), nil
+	case '%':
+		size, err := parseSize(line[1:], MaxMultiBulkSize)
+		if err != nil || size < 0 {
+			return nil, makeProtocolError("invalid map length")
+		}
+		res := MakeMapData()
+		for i := 0; i < size; i++ {
+			key, err := parser.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parser.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			res.Add(key, value)
+		}
+		return res, nil
+	case '~', '>':
+		size, err := parseSize(line[1:], MaxMultiBulkSize)
+		if err != nil || size < 0 {
+			return nil, makeProtocolError("invalid aggregate length")
+		}
+		res := make([]RedisData, 0, size)
+		for i := 0; i < size; i++ {
+			item, err := parser.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, item)
+		}
+		if line[0] == '~' {
+			return MakeSetData(res), nil
+		}
+		return MakePushData(res), nil
+	case ',':
+		val, err := strconv.ParseFloat(string(line[1:]), 64)
+		if err != nil {
+			return nil, makeProtocolError("invalid double %q", line[1:])
+		}
+		return MakeDoubleData(val), nil
+	case '#':
+		if len(line) != 2 || (line[1] != 't' && line[1] != 'f') {
+			return nil, makeProtocolError("invalid boolean %q", line[1:])
+		}
+		return MakeBooleanData(line[1] == 't'), nil
+	case '_':
+		return MakeNullData(), nil
+	case '(':
+		return MakeBigNumberData(string(line[1:])), nil
+	case '=':
+		size, err := parseSize(line[1:], MaxBulkSize)
+		if err != nil || size < 4 {
+			return nil, makeProtocolError("invalid verbatim string length")
+		}
+		body, err := parser.readBulkBody(size)
+		if err != nil {
+			return nil, err
+		}
+		return MakeVerbatimData(string(body.data[:3]), string(body.data[4:])), nil
+	default:
+		return nil, makeProtocolError("unknown reply type '%c'", line[0])
+	}
+}
+
+func (parser *Parser) readMultiBulk() (*ArrayData, error) {
+	line, err := parser.readLine()
+	if err != nil {
+		return nil, err
+	}
+	size, err := parseSize(line[1:], MaxMultiBulkSize)
+	if err != nil {
+		return nil, makeProtocolError("invalid multibulk length")
+	}
+	if size <= 0 {
+		return MakeEmptyArrayData(), nil
+	}
+
+	res := make([]RedisData, 0, size)
+	for i := 0; i < size; i++ {
+		line, err = parser.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			got := byte(' ')
+			if len(line) > 0 {
+				got = line[0]
+			}
+			return nil, makeProtocolError("expected '$', got '%c'", got)
+		}
+		bulkSize, err := parseSize(line[1:], MaxBulkSize)
+		if err != nil || bulkSize < 0 {
+			return nil, makeProtocolError("invalid bulk length")
+		}
+		bulk, err := parser.readBulkBody(bulkSize)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, bulk)
+	}
+	return MakeArrayData(res), nil
+}
+
+// readBulkBody read size bytes and the tailing CRLF of a bulk string
+func (parser *Parser) readBulkBody(size int) (*BulkData, error) {
+	body := make([]byte, size+2)
+	if _, err := io.ReadFull(parser.reader, body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if body[size] != '\r' || body[size+1] != '\n' {
+		return nil, makeProtocolError("bulk string is not terminated by CRLF")
+	}
+	return MakeBulkData(body[:size]), nil
+}
+
+// readLine read a line ended with "\n" and return it without the "\r\n" or "\n" suffix
+func (parser *Parser) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		part, err := parser.reader.ReadSlice('\n')
+		if err == nil {
+			if line == nil {
+				line = part
+			} else {
+				line = append(line, part...)
+			}
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			if line != nil || len(part) > 0 {
+				return nil, unexpectedEOF(err)
+			}
+			return nil, err
+		}
+		line = append(line, part...)
+		if len(line) > MaxInlineSize {
+			return nil, makeProtocolError("too big inline request")
+		}
+	}
+	if len(line) > MaxInlineSize {
+		return nil, makeProtocolError("too big inline request")
+	}
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	// the slice returned by ReadSlice is overwritten by the next read
+	res := make([]byte, len(line))
+	copy(res, line)
+	return res, nil
+}
+
+func parseSize(raw []byte, limit int) (int, error) {
+	size, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, err
+	}
+	if size > limit {
+		return 0, errors.New("size is out of range")
+	}
+	return size, nil
+}
+
+// unexpectedEOF mark an EOF in the middle of a request, the request can not be completed any more
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// splitArgs split an inline command line like redis sdssplitargs.
+// Arguments are separated by spaces, "double quoted" arguments support escapes like \n and \x41,
+// 'single quoted' arguments only support \'.
+func splitArgs(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var inDouble, inSingle, done bool
+		arg := make([]byte, 0)
+		for !done {
+			if inDouble {
+				if i >= len(line) {
+					return nil, makeProtocolError("unbalanced quotes in request")
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					val, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(val))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if line[i] == '"' {
+					// closing quote must be followed by a space or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, makeProtocolError("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else if inSingle {
+				if i >= len(line) {
+					return nil, makeProtocolError("unbalanced quotes in request")
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, makeProtocolError("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
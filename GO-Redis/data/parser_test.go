@@ -0,0 +1,134 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+	}{
+		{"multi bulk", "*2\r\n$3\r\nget\r\n$3\r\nkey\r\n", [][]string{{"get", "key"}}},
+		{"binary safe", "*2\r\n$3\r\nget\r\n$4\r\na\r\nb\r\n", [][]string{{"get", "a\r\nb"}}},
+		{"empty bulk", "*2\r\n$3\r\nget\r\n$0\r\n\r\n", [][]string{{"get", ""}}},
+		{"empty multi bulk", "*0\r\n", [][]string{{}}},
+		{"inline", "set key value\r\n", [][]string{{"set", "key", "value"}}},
+		{"inline lf", "ping\n", [][]string{{"ping"}}},
+		{"inline spaces", "  set   key\tvalue  \r\n", [][]string{{"set", "key", "value"}}},
+		{"inline double quotes", "set \"a key\" \"\\x41\\n\"\r\n", [][]string{{"set", "a key", "A\n"}}},
+		{"inline single quotes", "set 'it\\'s' 'a\\nb'\r\n", [][]string{{"set", "it's", "a\\nb"}}},
+		{"inline empty", "\r\n", [][]string{{}}},
+		{"pipeline", "ping\r\n*1\r\n$4\r\nping\r\n", [][]string{{"ping"}, {"ping"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewParser(bufio.NewReader(strings.NewReader(tt.input)))
+			for _, want := range tt.want {
+				cmd, err := parser.ReadCommand()
+				if err != nil {
+					t.Fatalf("ReadCommand() error = %v", err)
+				}
+				if got := cmd.ToStringCommand(); !reflect.DeepEqual(got, want) {
+					t.Fatalf("ReadCommand() = %q, want %q", got, want)
+				}
+			}
+			if _, err := parser.ReadCommand(); err != io.EOF {
+				t.Fatalf("ReadCommand() at the end error = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadCommandSplit(t *testing.T) {
+	// a request split over several reads is read as a whole
+	input := "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	reader, writer := io.Pipe()
+	go func() {
+		for i := 0; i < len(input); i++ {
+			_, _ = writer.Write([]byte{input[i]})
+		}
+		_ = writer.Close()
+	}()
+	cmd, err := NewParser(bufio.NewReader(reader)).ReadCommand()
+	if err != nil {
+		t.Fatalf("ReadCommand() error = %v", err)
+	}
+	if got, want := cmd.ToStringCommand(), []string{"set", "key", "value"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		protocol bool
+		err      error
+	}{
+		{"invalid multi bulk length", "*x\r\n", true, nil},
+		{"too many arguments", "*1048577\r\n", true, nil},
+		{"expected bulk", "*1\r\n:1\r\n", true, nil},
+		{"invalid bulk length", "*1\r\n$-1\r\n", true, nil},
+		{"too big bulk", "*1\r\n$536870913\r\n", true, nil},
+		{"bulk not terminated", "*1\r\n$3\r\nabcd\r\n", true, nil},
+		{"unbalanced double quotes", "set \"key\r\n", true, nil},
+		{"text after closing quote", "set \"key\"x\r\n", true, nil},
+		{"unbalanced single quotes", "set 'key\r\n", true, nil},
+		{"too big inline", strings.Repeat("a", MaxInlineSize+1) + "\r\n", true, nil},
+		{"truncated bulk", "*1\r\n$3\r\nab", false, io.ErrUnexpectedEOF},
+		{"truncated line", "*1\r\n$3", false, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewParser(bufio.NewReader(strings.NewReader(tt.input))).ReadCommand()
+			var protoErr *ProtocolError
+			if tt.protocol && !errors.As(err, &protoErr) {
+				t.Fatalf("ReadCommand() error = %v, want a protocol error", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("ReadCommand() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  RedisData
+	}{
+		{"simple string", "+OK\r\n", MakeStringData("OK")},
+		{"error", "-ERR wrong\r\n", MakeErrorData("ERR wrong")},
+		{"integer", ":-12\r\n", MakeIntData(-12)},
+		{"bulk", "$3\r\nabc\r\n", MakeBulkData([]byte("abc"))},
+		{"null bulk", "$-1\r\n", MakeBulkData(nil)},
+		{"array", "*2\r\n:1\r\n$1\r\na\r\n", MakeArrayData([]RedisData{MakeIntData(1), MakeBulkData([]byte("a"))})},
+		{"map", "%1\r\n+k\r\n:1\r\n", MakeMapData().Add(MakeStringData("k"), MakeIntData(1))},
+		{"set", "~1\r\n+a\r\n", MakeSetData([]RedisData{MakeStringData("a")})},
+		{"push", ">2\r\n+message\r\n+a\r\n", MakePushData([]RedisData{MakeStringData("message"), MakeStringData("a")})},
+		{"double", ",1.5\r\n", MakeDoubleData(1.5)},
+		{"boolean", "#t\r\n", MakeBooleanData(true)},
+		{"null", "_\r\n", MakeNullData()},
+		{"big number", "(12345678901234567890\r\n", MakeBigNumberData("12345678901234567890")},
+		{"verbatim", "=8\r\ntxt:text\r\n", MakeVerbatimData("txt", "text")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewParser(bufio.NewReader(strings.NewReader(tt.input))).ReadValue()
+			if err != nil {
+				t.Fatalf("ReadValue() error = %v", err)
+			}
+			if string(got.ToBytes()) != string(tt.want.ToBytes()) {
+				t.Fatalf("ReadValue() = %q, want %q", got.ToBytes(), tt.want.ToBytes())
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -11,6 +12,12 @@ var (
 	NIL  = []byte("$-1\r\n")
 )
 
+// protocol versions negotiated by HELLO
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
 type RedisData interface {
 	ToBytes() []byte  // return resp transfer format data
 	ByteData() []byte // return byte data
@@ -41,6 +48,40 @@ type PlainData struct {
 	data string
 }
 
+// RESP3 types
+
+type MapData struct {
+	keys   []RedisData
+	values []RedisData
+}
+
+type SetData struct {
+	data []RedisData
+}
+
+type DoubleData struct {
+	data float64
+}
+
+type BooleanData struct {
+	data bool
+}
+
+type NullData struct{}
+
+type BigNumberData struct {
+	data string
+}
+
+type VerbatimData struct {
+	format string // three bytes like "txt" or "mkd"
+	data   string
+}
+
+type PushData struct {
+	data []RedisData
+}
+
 // MakeBulkData make bulk data
 func MakeBulkData(data []byte) *BulkData {
 	return &BulkData{
@@ -219,3 +260,331 @@ func (plainData *PlainData) String() string {
 func (plainData *PlainData) ByteData() []byte {
 	return []byte(plainData.data)
 }
+
+func MakeMapData() *MapData {
+	return &MapData{
+		keys:   make([]RedisData, 0),
+		values: make([]RedisData, 0),
+	}
+}
+
+// Add append a key value pair, the insertion order is kept in the reply
+func (mapData *MapData) Add(key, value RedisData) *MapData {
+	mapData.keys = append(mapData.keys, key)
+	mapData.values = append(mapData.values, value)
+	return mapData
+}
+
+func (mapData *MapData) ToBytes() []byte {
+	res := []byte("%" + strconv.Itoa(len(mapData.keys)) + CRLF)
+	for i := range mapData.keys {
+		res = append(res, mapData.keys[i].ToBytes()...)
+		res = append(res, mapData.values[i].ToBytes()...)
+	}
+	return res
+}
+
+// Data return the pairs as a flat list: key1, value1, key2, value2...
+func (mapData *MapData) Data() []RedisData {
+	res := make([]RedisData, 0, len(mapData.keys)*2)
+	for i := range mapData.keys {
+		res = append(res, mapData.keys[i], mapData.values[i])
+	}
+	return res
+}
+
+func (mapData *MapData) ByteData() []byte {
+	res := make([]byte, 0)
+	for _, v := range mapData.Data() {
+		res = append(res, v.ByteData()...)
+	}
+	return res
+}
+
+func (mapData *MapData) String() string {
+	res := make([]string, 0, len(mapData.keys)*2)
+	for _, v := range mapData.Data() {
+		res = append(res, v.String())
+	}
+	return strings.Join(res, " ")
+}
+
+func MakeSetData(data []RedisData) *SetData {
+	return &SetData{
+		data: data,
+	}
+}
+
+func (setData *SetData) ToBytes() []byte {
+	res := []byte("~" + strconv.Itoa(len(setData.data)) + CRLF)
+	for _, v := range setData.data {
+		res = append(res, v.ToBytes()...)
+	}
+	return res
+}
+
+func (setData *SetData) Data() []RedisData {
+	return setData.data
+}
+
+func (setData *SetData) ByteData() []byte {
+	res := make([]byte, 0)
+	for _, v := range setData.data {
+		res = append(res, v.ByteData()...)
+	}
+	return res
+}
+
+func (setData *SetData) String() string {
+	res := make([]string, 0, len(setData.data))
+	for _, v := range setData.data {
+		res = append(res, v.String())
+	}
+	return strings.Join(res, " ")
+}
+
+func MakeDoubleData(data float64) *DoubleData {
+	return &DoubleData{
+		data: data,
+	}
+}
+
+func (doubleData *DoubleData) ToBytes() []byte {
+	return []byte("," + FormatFloat(doubleData.data) + CRLF)
+}
+
+func (doubleData *DoubleData) Data() float64 {
+	return doubleData.data
+}
+
+func (doubleData *DoubleData) ByteData() []byte {
+	return []byte(FormatFloat(doubleData.data))
+}
+
+func (doubleData *DoubleData) String() string {
+	return FormatFloat(doubleData.data)
+}
+
+// FormatFloat format a float the way redis replies it: the shortest representation, "inf", "-inf" or "nan"
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if abs := math.Abs(f); abs == 0 || (abs >= 1e-5 && abs < 1e17) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func MakeBooleanData(data bool) *BooleanData {
+	return &BooleanData{
+		data: data,
+	}
+}
+
+func (booleanData *BooleanData) ToBytes() []byte {
+	if booleanData.data {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+func (booleanData *BooleanData) Data() bool {
+	return booleanData.data
+}
+
+func (booleanData *BooleanData) ByteData() []byte {
+	return []byte(booleanData.String())
+}
+
+func (booleanData *BooleanData) String() string {
+	if booleanData.data {
+		return "1"
+	}
+	return "0"
+}
+
+func MakeNullData() *NullData {
+	return &NullData{}
+}
+
+func (nullData *NullData) ToBytes() []byte {
+	return []byte("_" + CRLF)
+}
+
+func (nullData *NullData) ByteData() []byte {
+	return nil
+}
+
+func (nullData *NullData) String() string {
+	return ""
+}
+
+func MakeBigNumberData(data string) *BigNumberData {
+	return &BigNumberData{
+		data: data,
+	}
+}
+
+func (bigNumberData *BigNumberData) ToBytes() []byte {
+	return []byte("(" + bigNumberData.data + CRLF)
+}
+
+func (bigNumberData *BigNumberData) Data() string {
+	return bigNumberData.data
+}
+
+func (bigNumberData *BigNumberData) ByteData() []byte {
+	return []byte(bigNumberData.data)
+}
+
+func (bigNumberData *BigNumberData) String() string {
+	return bigNumberData.data
+}
+
+// MakeVerbatimData make a verbatim string, format is "txt" for plain text and "mkd" for markdown
+func MakeVerbatimData(format string, data string) *VerbatimData {
+	return &VerbatimData{
+		format: format,
+		data:   data,
+	}
+}
+
+func (verbatimData *VerbatimData) ToBytes() []byte {
+	return []byte("=" + strconv.Itoa(len(verbatimData.data)+4) + CRLF + verbatimData.format + ":" + verbatimData.data + CRLF)
+}
+
+func (verbatimData *VerbatimData) Data() string {
+	return verbatimData.data
+}
+
+func (verbatimData *VerbatimData) Format() string {
+	return verbatimData.format
+}
+
+func (verbatimData *VerbatimData) ByteData() []byte {
+	return []byte(verbatimData.data)
+}
+
+func (verbatimData *VerbatimData) String() string {
+	return verbatimData.data
+}
+
+func MakePushData(data []RedisData) *PushData {
+	return &PushData{
+		data: data,
+	}
+}
+
+func (pushData *PushData) ToBytes() []byte {
+	res := []byte(">" + strconv.Itoa(len(pushData.data)) + CRLF)
+	for _, v := range pushData.data {
+		res = append(res, v.ToBytes()...)
+	}
+	return res
+}
+
+func (pushData *PushData) Data() []RedisData {
+	return pushData.data
+}
+
+func (pushData *PushData) ByteData() []byte {
+	res := make([]byte, 0)
+	for _, v := range pushData.data {
+		res = append(res, v.ByteData()...)
+	}
+	return res
+}
+
+func (pushData *PushData) String() string {
+	res := make([]string, 0, len(pushData.data))
+	for _, v := range pushData.data {
+		res = append(res, v.String())
+	}
+	return strings.Join(res, " ")
+}
+
+// ToProtocol convert a reply to the shape of the protocol version negotiated by the client.
+// For RESP2 clients the RESP3 types are downgraded as redis does:
+// maps are flattened into arrays, sets and pushes become arrays, doubles, big numbers and
+// verbatim strings become bulk strings, booleans become integers and null becomes a nil bulk string.
+// For RESP3 clients the RESP2 nil bulk string and nil array become null.
+func ToProtocol(redisData RedisData, protocol int) RedisData {
+	if protocol == RESP3 {
+		return upgrade(redisData)
+	}
+	return downgrade(redisData)
+}
+
+func downgrade(redisData RedisData) RedisData {
+	switch v := redisData.(type) {
+	case *MapData:
+		return MakeArrayData(downgradeAll(v.Data()))
+	case *SetData:
+		return MakeArrayData(downgradeAll(v.data))
+	case *PushData:
+		return MakeArrayData(downgradeAll(v.data))
+	case *ArrayData:
+		if v.data == nil {
+			return v
+		}
+		return MakeArrayData(downgradeAll(v.data))
+	case *DoubleData:
+		return MakeBulkData(v.ByteData())
+	case *BigNumberData:
+		return MakeBulkData(v.ByteData())
+	case *VerbatimData:
+		return MakeBulkData(v.ByteData())
+	case *BooleanData:
+		if v.data {
+			return MakeIntData(1)
+		}
+		return MakeIntData(0)
+	case *NullData:
+		return MakeBulkData(nil)
+	default:
+		return redisData
+	}
+}
+
+func downgradeAll(items []RedisData) []RedisData {
+	res := make([]RedisData, len(items))
+	for i, item := range items {
+		res[i] = downgrade(item)
+	}
+	return res
+}
+
+func upgrade(redisData RedisData) RedisData {
+	switch v := redisData.(type) {
+	case *BulkData:
+		if v.data == nil {
+			return MakeNullData()
+		}
+	case *ArrayData:
+		if v.data == nil {
+			return MakeNullData()
+		}
+		return MakeArrayData(upgradeAll(v.data))
+	case *MapData:
+		return &MapData{keys: upgradeAll(v.keys), values: upgradeAll(v.values)}
+	case *SetData:
+		return MakeSetData(upgradeAll(v.data))
+	case *PushData:
+		return MakePushData(upgradeAll(v.data))
+	}
+	return redisData
+}
+
+func upgradeAll(items []RedisData) []RedisData {
+	res := make([]RedisData, len(items))
+	for i, item := range items {
+		res[i] = upgrade(item)
+	}
+	return res
+}
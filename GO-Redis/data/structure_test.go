@@ -0,0 +1,49 @@
+package data
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToProtocol(t *testing.T) {
+	nested := MakeArrayData([]RedisData{
+		MakeMapData().Add(MakeBulkData([]byte("k")), MakeDoubleData(1.5)),
+		MakeSetData([]RedisData{MakeBooleanData(true)}),
+		MakeBulkData(nil),
+	})
+	tests := []struct {
+		name  string
+		data  RedisData
+		resp2 string
+		resp3 string
+	}{
+		{"simple string", MakeStringData("OK"), "+OK\r\n", "+OK\r\n"},
+		{"integer", MakeIntData(3), ":3\r\n", ":3\r\n"},
+		{"null bulk", MakeBulkData(nil), "$-1\r\n", "_\r\n"},
+		{"null array", &ArrayData{}, "*-1\r\n", "_\r\n"},
+		{"empty array", MakeEmptyArrayData(), "*0\r\n", "*0\r\n"},
+		{"null", MakeNullData(), "$-1\r\n", "_\r\n"},
+		{"double", MakeDoubleData(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"infinite double", MakeDoubleData(math.Inf(1)), "$3\r\ninf\r\n", ",inf\r\n"},
+		{"true", MakeBooleanData(true), ":1\r\n", "#t\r\n"},
+		{"false", MakeBooleanData(false), ":0\r\n", "#f\r\n"},
+		{"big number", MakeBigNumberData("123"), "$3\r\n123\r\n", "(123\r\n"},
+		{"verbatim", MakeVerbatimData("txt", "ab"), "$2\r\nab\r\n", "=6\r\ntxt:ab\r\n"},
+		{"map", MakeMapData().Add(MakeBulkData([]byte("k")), MakeIntData(1)), "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{"set", MakeSetData([]RedisData{MakeIntData(1)}), "*1\r\n:1\r\n", "~1\r\n:1\r\n"},
+		{"push", MakePushData([]RedisData{MakeIntData(1)}), "*1\r\n:1\r\n", ">1\r\n:1\r\n"},
+		{"nested", nested,
+			"*3\r\n*2\r\n$1\r\nk\r\n$3\r\n1.5\r\n*1\r\n:1\r\n$-1\r\n",
+			"*3\r\n%1\r\n$1\r\nk\r\n,1.5\r\n~1\r\n#t\r\n_\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(ToProtocol(tt.data, RESP2).ToBytes()); got != tt.resp2 {
+				t.Errorf("ToProtocol(RESP2) = %q, want %q", got, tt.resp2)
+			}
+			if got := string(ToProtocol(tt.data, RESP3).ToBytes()); got != tt.resp3 {
+				t.Errorf("ToProtocol(RESP3) = %q, want %q", got, tt.resp3)
+			}
+		})
+	}
+}
@@ -0,0 +1,673 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The append only file logs every write command as a RESP multi-bulk request, with a SELECT before the
+// commands of another db. Replaying it on startup rebuilds the dataset.
+// The write commands are serialized by Databases.writeMu, so that they are appended in the same order as they are applied.
+
+// aofRewriteItemsPerCmd is the max number of elements of a collection written in one command by a rewrite
+const aofRewriteItemsPerCmd = 64
+
+var (
+	errAOFDisabled          = errors.New("ERR Append only file is disabled")
+	errAOFRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+)
+
+// aof is the append only file being written
+type aof struct {
+	mu    sync.Mutex
+	file  *os.File
+	path  string
+	fsync string
+	// dbIndex is the db selected by the last command in the file, -1 if there is none
+	dbIndex int
+
+	// rewriting is set during BGREWRITEAOF, the commands appended meanwhile are also kept in rewriteBuf
+	// and written to the end of the new file, rewriteDB is the db selected in rewriteBuf
+	rewriting  bool
+	rewriteBuf []byte
+	rewriteDB  int
+	rewriteWG  sync.WaitGroup
+}
+
+// aofRewrite is the dataset dumped by a background rewrite as commands, see datasetDump
+// The changes made after the start are appended to the new file from the rewrite buffer instead.
+type aofRewrite struct {
+	*datasetDump
+	buf []byte
+	// selected is the db selected at the end of buf
+	selected int
+}
+
+// newAOFRewrite start the dump of the dataset, writeMu should be held exclusively
+func newAOFRewrite(dbs *Databases) *aofRewrite {
+	r := &aofRewrite{selected: -1}
+	r.datasetDump = dbs.startDump(func(index int, key string, val any, expireAt int64) {
+		for _, cmd := range rebuildCommands(key, val) {
+			r.buf = appendSelected(r.buf, &r.selected, index, cmd)
+		}
+		if expireAt > 0 {
+			r.buf = appendSelected(r.buf, &r.selected, index, [][]byte{[]byte("expireat"), []byte(key), []byte(strconv.FormatInt(expireAt, 10))})
+		}
+	})
+	return r
+}
+
+// run dump the dataset key by key, and return the dump and the db selected at its end
+func (r *aofRewrite) run() ([]byte, int) {
+	r.datasetDump.run()
+	return r.buf, r.selected
+}
+
+// propagatedCommand is a command waiting to be appended to the aof and the replication stream
+type propagatedCommand struct {
+	index int
+	cmd   [][]byte
+}
+
+// AOFPath return the path of the append only file given by dir and appendfilename
+func AOFPath() string {
+	return filepath.Join(config.Configures.Dir, config.Configures.AppendFilename)
+}
+
+// appendRESPCommand append cmd encoded as a RESP multi-bulk request to buf
+func appendRESPCommand(buf []byte, cmd [][]byte) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(cmd)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range cmd {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// appendSelected append cmd executed on the db at index to buf, with a SELECT first if the db is not selected by *selected
+func appendSelected(buf []byte, selected *int, index int, cmd [][]byte) []byte {
+	if *selected != index {
+		buf = appendRESPCommand(buf, [][]byte{[]byte("select"), []byte(strconv.Itoa(index))})
+		*selected = index
+	}
+	return appendRESPCommand(buf, cmd)
+}
+
+// write append the commands to the file, and to the rewrite buffer during a rewrite
+func (a *aof) write(cmds []propagatedCommand) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var buf []byte
+	for _, c := range cmds {
+		buf = appendSelected(buf, &a.dbIndex, c.index, c.cmd)
+		if a.rewriting {
+			a.rewriteBuf = appendSelected(a.rewriteBuf, &a.rewriteDB, c.index, c.cmd)
+		}
+	}
+	_, err := a.file.Write(buf)
+	if err == nil && a.fsync == "always" {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		log.Printf("Write the append only file error: %s", err.Error())
+	}
+}
+
+func (a *aof) sync() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.file.Sync(); err != nil {
+		log.Printf("Fsync the append only file error: %s", err.Error())
+	}
+}
+
+// propagate append cmd to the aof and the replication stream after it is applied on db, res is its reply
+// Commands which depend on the time or are random are rewritten to what they did, see rewritePropagated.
+// It should be called with writeMu held.
+func (db *DB) propagate(cmd [][]byte, res data.RedisData) {
+	if db.dbs == nil || !db.dbs.propagating() {
+		return
+	}
+	if cmd = db.rewritePropagated(cmd, res); cmd == nil {
+		return
+	}
+	db.dbs.feed([]propagatedCommand{{index: db.Index(), cmd: cmd}})
+}
+
+// propagateLater is like propagate, but cmd is appended after the command currently executed.
+// It is used by the blocked clients served while a write command is executed.
+func (db *DB) propagateLater(cmd [][]byte, res data.RedisData) {
+	if db.dbs == nil || !db.dbs.propagating() {
+		return
+	}
+	if cmd = db.rewritePropagated(cmd, res); cmd == nil {
+		return
+	}
+	db.dbs.pending = append(db.dbs.pending, propagatedCommand{index: db.Index(), cmd: cmd})
+}
+
+// flushPropagated append the commands kept by propagateLater
+func (dbs *Databases) flushPropagated() {
+	if len(dbs.pending) == 0 {
+		return
+	}
+	dbs.feed(dbs.pending)
+	dbs.pending = dbs.pending[:0]
+}
+
+// propagating report whether the write commands are written anywhere
+func (dbs *Databases) propagating() bool {
+	return dbs.aof != nil || dbs.repl.feeding()
+}
+
+// feed write the commands to the aof and the replication stream
+func (dbs *Databases) feed(cmds []propagatedCommand) {
+	if dbs.aof != nil {
+		dbs.aof.write(cmds)
+	}
+	dbs.repl.feed(cmds)
+}
+
+// rewritePropagated return the command to append for cmd, nil if nothing should be appended
+// Relative expire times become absolute, random pops and blocking pops become the pops they did.
+// Conditions on values and versions are dropped once they are satisfied, versions are local to the server.
+func (db *DB) rewritePropagated(cmd [][]byte, res data.RedisData) [][]byte {
+	name := strings.ToLower(string(cmd[0]))
+	switch name {
+	case "expire":
+		if n, ok := res.(*data.IntData); !ok || n.Data() == 0 {
+			return nil
+		}
+		if at := db.expireAtCommand(string(cmd[1])); at != nil {
+			return at
+		}
+		// the key is already deleted by an expire time in the past
+		seconds, _ := strconv.ParseInt(string(cmd[2]), 10, 64)
+		return [][]byte{[]byte("expireat"), cmd[1], []byte(strconv.FormatInt(time.Now().Unix()+seconds, 10))}
+	case "set":
+		args := make([][]byte, 0, len(cmd))
+		hasExpire := false
+		for i := 0; i < len(cmd); i++ {
+			if i >= 3 {
+				switch strings.ToLower(string(cmd[i])) {
+				case "ex", "px", "exat":
+					hasExpire = true
+					i++
+					continue
+				case "ifeq", "ifver":
+					// the condition failed if nothing is set
+					if r, ok := res.(*data.BulkData); ok && r.Data() == nil {
+						return nil
+					}
+					i++
+					continue
+				}
+			}
+			args = append(args, cmd[i])
+		}
+		if hasExpire {
+			if at := db.expireAt(string(cmd[1])); at > 0 {
+				args = append(args, []byte("exat"), []byte(strconv.FormatInt(at, 10)))
+			}
+		}
+		return args
+	case "setex":
+		res := [][]byte{[]byte("set"), cmd[1], cmd[3]}
+		if at := db.expireAt(string(cmd[1])); at > 0 {
+			res = append(res, []byte("exat"), []byte(strconv.FormatInt(at, 10)))
+		}
+		return res
+	case "incrbyfloat":
+		// the float result is appended so that the replay does not accumulate rounding differences
+		val, ok := res.(*data.BulkData)
+		if !ok {
+			return nil
+		}
+		return [][]byte{[]byte("set"), cmd[1], val.Data(), []byte("keepttl")}
+	case "spop":
+		members := [][]byte{[]byte("srem"), cmd[1]}
+		switch r := res.(type) {
+		case *data.BulkData:
+			if r.Data() == nil {
+				return nil
+			}
+			members = append(members, r.Data())
+		case *data.ArrayData:
+			for _, m := range r.Data() {
+				members = append(members, m.ByteData())
+			}
+		}
+		if len(members) == 2 {
+			return nil
+		}
+		return members
+	case "lpushifver", "rpushifver":
+		if _, ok := res.(*data.IntData); !ok {
+			return nil
+		}
+		return append([][]byte{[]byte(strings.TrimSuffix(name, "ifver")), cmd[1]}, cmd[3:]...)
+	case "restore", "restore-asking":
+		// the ttl is made absolute and the key replaced, so that the replay restores the same key
+		key := string(cmd[1])
+		if _, ok := db.db.Get(key); !ok {
+			return [][]byte{[]byte("del"), cmd[1]}
+		}
+		args := [][]byte{[]byte("restore"), cmd[1], []byte("0"), cmd[3], []byte("replace")}
+		if at := db.expireAt(key); at > 0 {
+			args[2] = []byte(strconv.FormatInt(at*1000, 10))
+			args = append(args, []byte("absttl"))
+		}
+		return args
+	case "migrate":
+		// the keys moved to the target are deleted
+		if r, ok := res.(*data.StringData); !ok || r.String() != "OK" || isCopyMigrate(cmd) {
+			return nil
+		}
+		args := [][]byte{[]byte("del")}
+		for _, pos := range migrateKeyPositions(cmd) {
+			if _, ok := db.db.Get(string(cmd[pos])); !ok {
+				args = append(args, cmd[pos])
+			}
+		}
+		if len(args) == 1 {
+			return nil
+		}
+		return args
+	case "delifeq":
+		if n, ok := res.(*data.IntData); !ok || n.Data() == 0 {
+			return nil
+		}
+		return [][]byte{[]byte("del"), cmd[1]}
+	case "xadd":
+		// the id is the one generated and an approximated trimming is made exact, as both depend on the server
+		id, ok := res.(*data.BulkData)
+		if !ok || id.Data() == nil {
+			return nil
+		}
+		args, _ := parseXAdd(cmd)
+		res := [][]byte{cmd[0], cmd[1]}
+		if args.trim != nil {
+			stream, _ := getStream(db, string(cmd[1]))
+			if stream != nil {
+				res = append(res, exactTrimArgs(stream)...)
+			}
+		}
+		res = append(res, id.Data())
+		return append(res, cmd[args.idIndex+1:]...)
+	case "xtrim":
+		if n, ok := res.(*data.IntData); !ok || n.Data() == 0 {
+			return nil
+		}
+		stream, _ := getStream(db, string(cmd[1]))
+		if stream == nil {
+			return nil
+		}
+		return append([][]byte{cmd[0], cmd[1]}, exactTrimArgs(stream)...)
+	case "blpop", "brpop", "bzpopmin", "bzpopmax":
+		// the reply is [key, ...]
+		r, ok := res.(*data.ArrayData)
+		if !ok || len(r.Data()) == 0 {
+			return nil
+		}
+		return [][]byte{[]byte(name[1:]), r.Data()[0].ByteData()}
+	case "blmove":
+		return [][]byte{[]byte("lmove"), cmd[1], cmd[2], cmd[3], cmd[4]}
+	case "brpoplpush":
+		return [][]byte{[]byte("rpoplpush"), cmd[1], cmd[2]}
+	case "blmpop", "bzmpop":
+		// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count], the reply is [key, [element ...]]
+		r, ok := res.(*data.ArrayData)
+		if !ok || len(r.Data()) != 2 {
+			return nil
+		}
+		numKeys, _ := strconv.Atoi(string(cmd[2]))
+		popped, _ := r.Data()[1].(*data.ArrayData)
+		if popped == nil {
+			return nil
+		}
+		return [][]byte{[]byte(name[1:]), []byte("1"), r.Data()[0].ByteData(), cmd[3+numKeys], []byte("count"), []byte(strconv.Itoa(len(popped.Data())))}
+	}
+	return cmd
+}
+
+// expireAt return the unix time key expires at, 0 if it has no ttl
+func (db *DB) expireAt(key string) int64 {
+	if ttl, ok := db.ttlKeys.Get(key); ok {
+		return ttl.(*TTLInfo).value
+	}
+	return 0
+}
+
+// expireAtCommand return the EXPIREAT command setting the current ttl of key
+func (db *DB) expireAtCommand(key string) [][]byte {
+	at := db.expireAt(key)
+	if at == 0 {
+		return nil
+	}
+	return [][]byte{[]byte("expireat"), []byte(key), []byte(strconv.FormatInt(at, 10))}
+}
+
+// rewriteCommands return the commands which rebuild the whole dataset, and the db selected at their end
+func (dbs *Databases) rewriteCommands() ([]byte, int) {
+	dbs.mu.RLock()
+	defer dbs.mu.RUnlock()
+	for _, db := range dbs.dbs {
+		db.locks.LockAll()
+	}
+	defer func() {
+		for _, db := range dbs.dbs {
+			db.locks.UnLockAll()
+		}
+	}()
+
+	var buf []byte
+	selected := -1
+	now := time.Now().Unix()
+	for i, db := range dbs.dbs {
+		for key, val := range db.db.KeyValues() {
+			at := db.expireAt(key)
+			if at > 0 && at <= now {
+				continue
+			}
+			for _, cmd := range rebuildCommands(key, val) {
+				buf = appendSelected(buf, &selected, i, cmd)
+			}
+			if at > 0 {
+				buf = appendSelected(buf, &selected, i, db.expireAtCommand(key))
+			}
+		}
+	}
+	return buf, selected
+}
+
+// rebuildCommands return the commands which create key with val
+func rebuildCommands(key string, val any) [][][]byte {
+	var cmds [][][]byte
+	var cmd [][]byte
+	// add append the items of a collection, at most aofRewriteItemsPerCmd items in a command
+	add := func(name string, items ...[]byte) {
+		if cmd == nil {
+			cmd = [][]byte{[]byte(name), []byte(key)}
+		}
+		cmd = append(cmd, items...)
+		if len(cmd)-2 >= aofRewriteItemsPerCmd*len(items) {
+			cmds = append(cmds, cmd)
+			cmd = nil
+		}
+	}
+
+	switch v := val.(type) {
+	case []byte:
+		cmd = [][]byte{[]byte("set"), []byte(key), v}
+	case *List:
+		v.ForEach(0, false, func(index int, elem []byte) bool {
+			add("rpush", copyBytes(elem))
+			return true
+		})
+	case *Set:
+		for member := range v.table {
+			add("sadd", []byte(member))
+		}
+	case *Hash:
+		for field, value := range v.table {
+			add("hset", []byte(field), value)
+		}
+	case *SortedSet:
+		for node := v.Last(); node != nil; node = node.Prev() {
+			add("zadd", []byte(data.FormatFloat(node.Score)), []byte(node.Member))
+		}
+	case *Stream:
+		// an entry per command, the ids are kept and XSETID restores the last id of the deleted entries
+		v.Range(StreamID{}, maxStreamID, false, func(entry *StreamEntry) bool {
+			cmds = append(cmds, append([][]byte{[]byte("xadd"), []byte(key), []byte(entry.ID.String())}, entry.Fields...))
+			return true
+		})
+		if v.Len() == 0 {
+			// an empty stream is created by an entry trimmed at once
+			id := v.LastID()
+			if id == (StreamID{}) {
+				id.Seq = 1
+			}
+			cmds = append(cmds, [][]byte{[]byte("xadd"), []byte(key), []byte("maxlen"), []byte("0"), []byte(id.String()), []byte("x"), []byte("y")})
+		}
+		cmds = append(cmds, [][]byte{[]byte("xsetid"), []byte(key), []byte(v.LastID().String())})
+	default:
+		log.Printf("Rewrite the append only file: skip key %s of type %T", key, val)
+	}
+	if cmd != nil {
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// OpenAOF load the append only file at path and append the following write commands to it
+// When the file does not exist yet, the rdb file is loaded and the dataset is written into the new file
+func (dbs *Databases) OpenAOF(path string, fsync string) error {
+	_, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		if err = dbs.loadAOF(path); err != nil {
+			return err
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		if err = dbs.LoadRDB(RDBPath()); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	a := &aof{
+		file:    file,
+		path:    path,
+		fsync:   fsync,
+		dbIndex: -1,
+	}
+	if !exists {
+		content, selected := dbs.rewriteCommands()
+		if _, err = file.Write(content); err == nil {
+			err = file.Sync()
+		}
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		a.dbIndex = selected
+	}
+	dbs.aof = a
+	return nil
+}
+
+// loadAOF replay the commands in the append only file at path
+// A command cut at the end of the file, left by a crash during writing, is truncated from the file
+func (dbs *Databases) loadAOF(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	start := time.Now()
+	reader := bufio.NewReader(file)
+	parser := data.NewParser(reader)
+	client := NewClient(nil)
+	ctx := context.Background()
+	var valid int64
+	count := 0
+	for {
+		cmd, err := parser.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("The append only file %s is truncated at offset %d, the incomplete command at its end is removed", path, valid)
+			_ = file.Close()
+			if err = os.Truncate(path, valid); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("bad file format reading the append only file %s at offset %d: %w", path, valid, err)
+		}
+		// the offset of the next command is the file position minus what is buffered
+		pos, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		valid = pos - int64(reader.Buffered())
+
+		args := cmd.ToCommand()
+		if len(args) == 0 {
+			continue
+		}
+		res := dbs.Get(client.DBIndex()).ExecCommand(ctx, args, client)
+		if errData, ok := res.(*data.ErrorData); ok {
+			log.Printf("Replay %s of the append only file error: %s", string(args[0]), errData.String())
+		}
+		count++
+	}
+	dbs.persist.dirty.Store(0)
+	log.Printf("DB loaded from append only file: %d commands in %.3f seconds", count, time.Since(start).Seconds())
+	return nil
+}
+
+// ServeAOFFsync sync the append only file every second when appendfsync is everysec, until ctx is done
+func (dbs *Databases) ServeAOFFsync(ctx context.Context) {
+	if dbs.aof == nil || dbs.aof.fsync != "everysec" {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dbs.aof.sync()
+		}
+	}
+}
+
+// BGRewriteAOF rewrite the append only file in background with the minimal commands which rebuild the dataset
+// The dataset is dumped as it is when BGRewriteAOF is called, see aofRewrite, the writes done during the rewrite
+// are appended to both files and the new file replaces the old one once it is complete.
+func (dbs *Databases) BGRewriteAOF() error {
+	a := dbs.aof
+	if a == nil {
+		return errAOFDisabled
+	}
+	// the rewrite starts between two writes
+	dbs.writeMu.Lock()
+	a.mu.Lock()
+	if a.rewriting {
+		a.mu.Unlock()
+		dbs.writeMu.Unlock()
+		return errAOFRewriteInProgress
+	}
+	a.rewriting = true
+	a.rewriteBuf = nil
+	a.rewriteDB = -1
+	r := newAOFRewrite(dbs)
+	a.rewriteWG.Add(1)
+	a.mu.Unlock()
+	dbs.writeMu.Unlock()
+
+	go func() {
+		defer a.rewriteWG.Done()
+		content, selected := r.run()
+		if err := a.finishRewrite(content, selected); err != nil {
+			log.Printf("Background append only file rewriting error: %s", err.Error())
+			return
+		}
+		log.Printf("Background append only file rewriting terminated with success")
+	}()
+	return nil
+}
+
+// finishRewrite write content and the rewrite buffer into a temp file and replace the aof with it
+// selected is the db selected at the end of content
+func (a *aof) finishRewrite(content []byte, selected int) (err error) {
+	tmp := filepath.Join(filepath.Dir(a.path), fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		a.mu.Lock()
+		a.rewriting = false
+		a.rewriteBuf = nil
+		a.mu.Unlock()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+	// the bulk of the file is written without blocking the writers
+	if _, err = file.Write(content); err == nil {
+		err = file.Sync()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	buf := a.rewriteBuf
+	a.rewriting = false
+	a.rewriteBuf = nil
+	if err != nil {
+		return err
+	}
+	if len(buf) > 0 {
+		selected = a.rewriteDB
+		if _, err = file.Write(buf); err == nil {
+			err = file.Sync()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp, a.path); err != nil {
+		return err
+	}
+	_ = a.file.Close()
+	a.file = file
+	a.dbIndex = selected
+	return nil
+}
+
+// closeAOF wait for the background rewrite, sync and close the append only file
+func (dbs *Databases) closeAOF() {
+	a := dbs.aof
+	if a == nil {
+		return
+	}
+	a.rewriteWG.Wait()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.file.Sync(); err != nil {
+		log.Printf("Fsync the append only file error: %s", err.Error())
+	}
+	_ = a.file.Close()
+}
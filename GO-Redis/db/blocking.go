@@ -0,0 +1,216 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// blockingKeys record the clients blocked on each key of the db at index, in the order they blocked
+// Like redis the clients stay at the index when SWAPDB moves the dbs, and are served by the db found there.
+type blockingKeys struct {
+	mu      sync.Mutex
+	index   int
+	waiters map[string][]*waiter
+}
+
+// waiter is a client blocked on some keys
+// pop is called with the locks of lockKeys held on db, the db serving the client, and returns the reply if the
+// key can serve the client
+type waiter struct {
+	// cmd is the blocking command, it is propagated with the reply once the waiter is served
+	cmd      [][]byte
+	keys     []string
+	lockKeys []string
+	pop      func(db *DB, key string) (data.RedisData, bool)
+	// registry is where the waiter is recorded while it is blocked
+	registry *blockingKeys
+	// readOnly waiters only read the keys, like XREAD, they are neither propagated nor touch the keys
+	readOnly bool
+
+	// done is set once the waiter is served or gives up, res receives the reply when it is served
+	mu   sync.Mutex
+	done bool
+	res  chan data.RedisData
+}
+
+func newBlockingKeys(index int) *blockingKeys {
+	return &blockingKeys{
+		index:   index,
+		waiters: make(map[string][]*waiter),
+	}
+}
+
+func (b *blockingKeys) add(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w.registry = b
+	for _, key := range w.keys {
+		b.waiters[key] = append(b.waiters[key], w)
+	}
+}
+
+func (b *blockingKeys) remove(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range w.keys {
+		waiters := b.waiters[key]
+		for i, other := range waiters {
+			if other == w {
+				waiters = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(b.waiters, key)
+		} else {
+			b.waiters[key] = waiters
+		}
+	}
+}
+
+// get return a copy of the waiters of key
+func (b *blockingKeys) get(key string) []*waiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	waiters := b.waiters[key]
+	if len(waiters) == 0 {
+		return nil
+	}
+	res := make([]*waiter, len(waiters))
+	copy(res, waiters)
+	return res
+}
+
+// keys return the keys some clients are blocked on
+func (b *blockingKeys) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.waiters))
+	for key := range b.waiters {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// blockingKeys return the clients blocked on the index of db
+func (db *DB) blockingKeys() *blockingKeys {
+	return db.dbs.blocking[db.Index()]
+}
+
+// parseBlockTimeout parse the timeout of blocking commands in seconds, 0 means blocking forever
+func parseBlockTimeout(raw []byte) (time.Duration, data.RedisData) {
+	timeout, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, data.MakeErrorData("ERROR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, data.MakeErrorData("ERR timeout is negative")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+// blockPop try pop on keys in order and block the client until one of them can serve it
+// pop is called with the locks of lockKeys held, lockKeys should contain keys and every other key pop writes
+// It returns false if timeout expires or the client disconnects before being served, a timeout of 0 never expires
+// The caller should signal the keys written by pop after blockPop returns
+// cmd is the blocking command, it is propagated with the reply when pop succeeds
+func (db *DB) blockPop(ctx context.Context, cmd [][]byte, keys []string, lockKeys []string, timeout time.Duration, pop func(db *DB, key string) (data.RedisData, bool)) (data.RedisData, bool) {
+	return db.block(ctx, &waiter{cmd: cmd, keys: keys, lockKeys: lockKeys, pop: pop}, timeout)
+}
+
+// blockRead is like blockPop for the commands which only read the keys, like XREAD
+// read is called with the locks of keys held, nothing is propagated and writeMu is not held by the caller.
+func (db *DB) blockRead(ctx context.Context, keys []string, timeout time.Duration, read func(db *DB, key string) (data.RedisData, bool)) (data.RedisData, bool) {
+	return db.block(ctx, &waiter{keys: keys, lockKeys: keys, pop: read, readOnly: true}, timeout)
+}
+
+// block try w on its keys and block the client until one of them can serve it, see blockPop
+func (db *DB) block(ctx context.Context, w *waiter, timeout time.Duration) (data.RedisData, bool) {
+	for _, key := range w.lockKeys {
+		db.CheckTTL(key)
+	}
+
+	// keys are locked while trying and registering, so a producer can not push between them unnoticed
+	db.locks.LockMulti(w.lockKeys)
+	for _, key := range w.keys {
+		if res, ok := w.pop(db, key); ok {
+			db.locks.UnLockMulti(w.lockKeys)
+			if !w.readOnly {
+				db.propagateLater(w.cmd, res)
+			}
+			return res, true
+		}
+	}
+	w.res = make(chan data.RedisData, 1)
+	db.blockingKeys().add(w)
+	db.locks.UnLockMulti(w.lockKeys)
+
+	// let the other write commands run while the client is blocked
+	if db.dbs != nil && !w.readOnly {
+		defer db.dbs.releaseWrite()()
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case res := <-w.res:
+		return res, true
+	case <-timer:
+	case <-ctx.Done():
+	}
+
+	w.mu.Lock()
+	if w.done {
+		// served right before giving up
+		w.mu.Unlock()
+		return <-w.res, true
+	}
+	w.done = true
+	w.mu.Unlock()
+	w.registry.remove(w)
+	return nil, false
+}
+
+// signalKeyReady serve the clients blocked on key in the order they blocked
+// It should be called after key is written and its lock is released
+func (db *DB) signalKeyReady(key string) {
+	registry := db.blockingKeys()
+	for _, w := range registry.get(key) {
+		db.dbs.Get(registry.index).serveWaiter(w, key)
+	}
+}
+
+// serveWaiter try to serve w with key of db, the db at the index w is blocked on
+func (db *DB) serveWaiter(w *waiter, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	for _, k := range w.lockKeys {
+		db.CheckTTL(k)
+	}
+	if !w.readOnly && db.dbs != nil {
+		db.dbs.beforeWrite(db, w.lockKeys)
+	}
+	db.locks.LockMulti(w.lockKeys)
+	res, ok := w.pop(db, key)
+	db.locks.UnLockMulti(w.lockKeys)
+	if !ok {
+		return
+	}
+	w.done = true
+	w.res <- res
+	w.registry.remove(w)
+	if !w.readOnly {
+		db.propagateLater(w.cmd, res)
+	}
+}
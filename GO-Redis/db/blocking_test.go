@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockedClients return the number of clients blocked on any key of the index of db
+func blockedClients(db *DB) int {
+	registry := db.blockingKeys()
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	waiters := make(map[*waiter]struct{})
+	for _, ws := range registry.waiters {
+		for _, w := range ws {
+			waiters[w] = struct{}{}
+		}
+	}
+	return len(waiters)
+}
+
+// waitBlocked wait until n clients are blocked on db
+func waitBlocked(t *testing.T, db *DB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for blockedClients(db) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked, want %d", blockedClients(db), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBlockingWakeUpOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		// blocked are the blocking commands, run one after the other
+		blocked [][]string
+		// pushes are run once all the commands are blocked
+		pushes [][]string
+		// want are the replies of the blocked commands in their order
+		want []string
+	}{
+		{
+			name:    "one push serves the clients in the order they blocked",
+			blocked: [][]string{{"blpop", "k", "0"}, {"blpop", "k", "0"}, {"brpop", "k", "0"}},
+			pushes:  [][]string{{"rpush", "k", "a", "b", "c"}},
+			want:    []string{"*2\r\n$1\r\nk\r\n$1\r\na\r\n", "*2\r\n$1\r\nk\r\n$1\r\nb\r\n", "*2\r\n$1\r\nk\r\n$1\r\nc\r\n"},
+		},
+		{
+			name:    "each push serves the oldest client",
+			blocked: [][]string{{"blpop", "k", "0"}, {"blpop", "k", "0"}},
+			pushes:  [][]string{{"lpush", "k", "a"}, {"lpush", "k", "b"}},
+			want:    []string{"*2\r\n$1\r\nk\r\n$1\r\na\r\n", "*2\r\n$1\r\nk\r\n$1\r\nb\r\n"},
+		},
+		{
+			name:    "a client blocked on several keys is served by the first key pushed",
+			blocked: [][]string{{"blpop", "k1", "k", "0"}, {"blpop", "k", "0"}},
+			pushes:  [][]string{{"rpush", "k", "a"}, {"rpush", "k", "b"}},
+			want:    []string{"*2\r\n$1\r\nk\r\n$1\r\na\r\n", "*2\r\n$1\r\nk\r\n$1\r\nb\r\n"},
+		},
+		{
+			name:    "blmove serves the clients blocked on its destination",
+			blocked: [][]string{{"blmove", "src", "k", "left", "right", "0"}, {"blpop", "k", "0"}},
+			pushes:  [][]string{{"rpush", "src", "a"}},
+			want:    []string{"$1\r\na\r\n", "*2\r\n$1\r\nk\r\n$1\r\na\r\n"},
+		},
+		{
+			name:    "sorted sets serve the clients in the order they blocked",
+			blocked: [][]string{{"bzpopmin", "k", "0"}, {"bzpopmax", "k", "0"}},
+			pushes:  [][]string{{"zadd", "k", "1", "a", "2", "b", "3", "c"}},
+			want:    []string{"*3\r\n$1\r\nk\r\n$1\r\na\r\n$1\r\n1\r\n", "*3\r\n$1\r\nk\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDatabases(1).Get(0)
+			replies := make([]chan string, len(tt.blocked))
+			for i, cmd := range tt.blocked {
+				replies[i] = make(chan string, 1)
+				go func(cmd []string, reply chan string) {
+					reply <- execString(db, cmd...)
+				}(cmd, replies[i])
+				// the commands are blocked one after the other, so that their order is known
+				waitBlocked(t, db, i+1)
+			}
+			for _, cmd := range tt.pushes {
+				execString(db, cmd...)
+			}
+			for i, reply := range replies {
+				select {
+				case got := <-reply:
+					if got != tt.want[i] {
+						t.Fatalf("reply of %q = %q, want %q", tt.blocked[i], got, tt.want[i])
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%q is still blocked", tt.blocked[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  []string
+		want string
+	}{
+		{"blpop", []string{"blpop", "k", "0.05"}, "*-1\r\n"},
+		{"brpop several keys", []string{"brpop", "k1", "k2", "0.05"}, "*-1\r\n"},
+		{"blmove", []string{"blmove", "k", "d", "left", "left", "0.05"}, "$-1\r\n"},
+		{"negative timeout", []string{"blpop", "k", "-1"}, "-ERR timeout is negative\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDatabases(1).Get(0)
+			if got := execString(db, tt.cmd...); got != tt.want {
+				t.Fatalf("%q = %q, want %q", tt.cmd, got, tt.want)
+			}
+			if n := blockedClients(db); n != 0 {
+				t.Fatalf("%d clients still blocked after the timeout", n)
+			}
+		})
+	}
+}
+
+func TestBlockingCancel(t *testing.T) {
+	// a client which disconnects is not served anymore, the element goes to the next client
+	db := NewDatabases(1).Get(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	gone := make(chan struct{})
+	go func() {
+		db.ExecCommand(ctx, [][]byte{[]byte("blpop"), []byte("k"), []byte("0")}, nil)
+		close(gone)
+	}()
+	waitBlocked(t, db, 1)
+	reply := make(chan string, 1)
+	go func() {
+		reply <- execString(db, "blpop", "k", "0")
+	}()
+	waitBlocked(t, db, 2)
+	cancel()
+	<-gone
+	execString(db, "rpush", "k", "a")
+	if got, want := <-reply, "*2\r\n$1\r\nk\r\n$1\r\na\r\n"; got != want {
+		t.Fatalf("reply = %q, want %q", got, want)
+	}
+}
+
+func TestBlockingSwapDB(t *testing.T) {
+	tests := []struct {
+		name string
+		// blocked is run on db 0 before the swap
+		blocked []string
+		// before are run on db 1 before SWAPDB 0 1
+		before [][]string
+		// after are run on db 0 after SWAPDB 0 1
+		after [][]string
+		want  string
+		// llen is the length of k in db 0 once the client is served
+		llen string
+	}{
+		{
+			name:    "a push into the db swapped in serves the client",
+			blocked: []string{"blpop", "k", "5"},
+			after:   [][]string{{"rpush", "k", "a"}},
+			want:    "*2\r\n$1\r\nk\r\n$1\r\na\r\n",
+			llen:    ":0\r\n",
+		},
+		{
+			name:    "the db swapped in already holds the key",
+			blocked: []string{"blpop", "k", "5"},
+			before:  [][]string{{"rpush", "k", "a", "b"}},
+			want:    "*2\r\n$1\r\nk\r\n$1\r\na\r\n",
+			llen:    ":1\r\n",
+		},
+		{
+			name:    "blmove pushes into the db swapped in",
+			blocked: []string{"blmove", "src", "k", "left", "left", "5"},
+			before:  [][]string{{"rpush", "src", "a"}},
+			want:    "$1\r\na\r\n",
+			llen:    ":1\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbs := NewDatabases(2)
+			reply := make(chan string, 1)
+			go func() {
+				reply <- execString(dbs.Get(0), tt.blocked...)
+			}()
+			waitBlocked(t, dbs.Get(0), 1)
+			for _, cmd := range tt.before {
+				execString(dbs.Get(1), cmd...)
+			}
+			execString(dbs.Get(0), "swapdb", "0", "1")
+			for _, cmd := range tt.after {
+				execString(dbs.Get(0), cmd...)
+			}
+			select {
+			case got := <-reply:
+				if got != tt.want {
+					t.Fatalf("reply = %q, want %q", got, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("the client is still blocked")
+			}
+			if got := execString(dbs.Get(0), "llen", "k"); got != tt.llen {
+				t.Fatalf("llen k = %q, want %q", got, tt.llen)
+			}
+			if n := blockedClients(dbs.Get(0)) + blockedClients(dbs.Get(1)); n != 0 {
+				t.Fatalf("%d clients still blocked", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// replyBufferSize is the size of the reply buffer of a client
+// replies of pipelined commands are collected in it and sent in one write
+const replyBufferSize = 64 * 1024
+
+var clientIDGenerator int64
+
+// Client wraps a client connection and keeps the states negotiated on it
+// Executors receive it as conn and can type assert it to read or change these states
+type Client struct {
+	net.Conn
+	id       int64
+	name     string
+	protocol int
+	// dbIndex is the db selected by SELECT, commands of the client are executed on it
+	dbIndex int
+	// master is set on the client executing the replication stream of the master, it may write on a read only replica
+	master bool
+	// replicaPort is the listening port announced by a replica with REPLCONF
+	replicaPort int
+	// asking is set by ASKING for the next command, readOnly by READONLY, they are used in cluster mode
+	asking   bool
+	readOnly bool
+	// sub is the pub/sub state of the client, nil until it subscribes
+	sub *subscriber
+	// writer buffer replies until Flush is called, wmu guards it
+	writer *bufio.Writer
+	wmu    sync.Mutex
+}
+
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		Conn:     conn,
+		id:       atomic.AddInt64(&clientIDGenerator, 1),
+		protocol: data.RESP2,
+		writer:   bufio.NewWriterSize(conn, replyBufferSize),
+	}
+}
+
+func (client *Client) ID() int64 {
+	return client.id
+}
+
+func (client *Client) Name() string {
+	return client.name
+}
+
+// Protocol return the RESP version used by the client, it is RESP2 until HELLO 3 is called
+func (client *Client) Protocol() int {
+	return client.protocol
+}
+
+// DBIndex return the index of the selected db
+func (client *Client) DBIndex() int {
+	return client.dbIndex
+}
+
+// WriteReply convert a reply to the protocol version of the client and append it to the reply buffer
+// The buffer is written to the connection when it is full or Flush is called
+func (client *Client) WriteReply(res data.RedisData) error {
+	client.wmu.Lock()
+	defer client.wmu.Unlock()
+	_, err := client.writer.Write(data.ToProtocol(res, client.protocol).ToBytes())
+	return err
+}
+
+// Flush send all buffered replies to the connection
+func (client *Client) Flush() error {
+	client.wmu.Lock()
+	defer client.wmu.Unlock()
+	return client.writer.Flush()
+}
+
+// WriteRaw send the buffered replies and then p to the connection, it is used to stream data which is not a reply
+func (client *Client) WriteRaw(p []byte) error {
+	client.wmu.Lock()
+	defer client.wmu.Unlock()
+	if err := client.writer.Flush(); err != nil {
+		return err
+	}
+	_, err := client.Conn.Write(p)
+	return err
+}
+
+// protocolOf return the RESP version of the connection, RESP2 if it is not a client connection
+func protocolOf(conn net.Conn) int {
+	if client, ok := conn.(*Client); ok {
+		return client.protocol
+	}
+	return data.RESP2
+}
@@ -0,0 +1,1223 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In cluster mode the keys are split into 16384 hash slots, each one served by a master node. The slot of a key is
+// CRC16(key) mod 16384, computed on the part between the first { and the next } if it is not empty, so that keys
+// with the same {hashtag} are in the same slot. A command on keys the node does not serve is redirected with
+// MOVED, or with ASK while the slot is migrating to another node. The nodes exchange their state on the cluster
+// bus, see clusterbus.go, and the state of the node is saved in the cluster config file. Only db 0 is used.
+
+const clusterSlots = 16384
+
+// flags of a cluster node
+const (
+	nodeMyself = 1 << iota
+	nodeMaster
+	nodeReplica
+	nodePFail
+	nodeFail
+	nodeHandshake
+	// nodeMeet is set on a handshake node started by CLUSTER MEET, it is sent a MEET instead of a PING
+	nodeMeet
+)
+
+// nodeFlagNames are the names of the flags in CLUSTER NODES, nodeMeet is not shown
+var nodeFlagNames = []struct {
+	flag int
+	name string
+}{
+	{nodeMyself, "myself"},
+	{nodeMaster, "master"},
+	{nodeReplica, "slave"},
+	{nodePFail, "fail?"},
+	{nodeFail, "fail"},
+	{nodeHandshake, "handshake"},
+}
+
+// clusterForgetTTL is how long a node removed by CLUSTER FORGET can not be added again by the gossip
+const clusterForgetTTL = 60 * time.Second
+
+var crc16Table = makeCRC16Table()
+
+// makeCRC16Table return the table of the CRC16 XMODEM used by the hash slots, its polynomial is 0x1021
+func makeCRC16Table() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func crc16(p []byte) uint16 {
+	var crc uint16
+	for _, b := range p {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// keySlot return the hash slot of key
+func keySlot(key []byte) int {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) & (clusterSlots - 1)
+}
+
+// clusterNode is a node of the cluster known by the server, including the server itself
+type clusterNode struct {
+	id    string
+	ip    string
+	port  int
+	cport int
+	flags int
+	// masterID is the id of the master of a replica
+	masterID    string
+	configEpoch int64
+	// pingSent is when the pending PING was sent, zero if there is none
+	pingSent     time.Time
+	pongReceived time.Time
+	// created is when a handshake node was added
+	created time.Time
+	// failReports are the masters which reported the node as failing, with the time of their last report
+	failReports map[string]time.Time
+	// link is the outbound connection of the bus, connecting is set while it is dialed
+	link       *clusterLink
+	connecting bool
+}
+
+func (n *clusterNode) is(flag int) bool {
+	return n.flags&flag != 0
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+func (n *clusterNode) busAddr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.cport))
+}
+
+// flagNames return the flags shown by CLUSTER NODES
+func (n *clusterNode) flagNames() string {
+	names := make([]string, 0, 2)
+	for _, f := range nodeFlagNames {
+		if n.is(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "noflags"
+	}
+	return strings.Join(names, ",")
+}
+
+// cluster is the cluster state of the node
+type cluster struct {
+	mu     sync.RWMutex
+	dbs    *Databases
+	myself *clusterNode
+	nodes  map[string]*clusterNode
+	// slots are the owners of the slots, migrating and importing the target and source of the slots being resharded
+	slots     [clusterSlots]*clusterNode
+	migrating [clusterSlots]*clusterNode
+	importing [clusterSlots]*clusterNode
+	// currentEpoch is the greatest config epoch seen in the cluster
+	currentEpoch int64
+	// forgotten are the nodes removed by CLUSTER FORGET until when they can be added again
+	forgotten map[string]time.Time
+	ok        bool
+	// configPath is the cluster config file, configDirty is set when it has to be saved
+	configPath  string
+	configDirty bool
+	nodeTimeout time.Duration
+	// statsSent and statsReceived count the bus messages by type
+	statsSent     map[string]int64
+	statsReceived map[string]int64
+	// slotKeys are the keys of db 0 by slot
+	slotKeys *slotIndex
+}
+
+// slotIndex keep the keys of a db by slot, so that the keys of a slot are found without scanning the db
+type slotIndex struct {
+	slots [clusterSlots]struct {
+		mu   sync.Mutex
+		keys map[string]struct{}
+	}
+}
+
+func (s *slotIndex) add(key string) {
+	slot := &s.slots[keySlot([]byte(key))]
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.keys == nil {
+		slot.keys = make(map[string]struct{})
+	}
+	slot.keys[key] = struct{}{}
+}
+
+func (s *slotIndex) remove(key string) {
+	slot := &s.slots[keySlot([]byte(key))]
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	delete(slot.keys, key)
+}
+
+// keys return the keys in slot
+func (s *slotIndex) keys(slot int) []string {
+	ks := &s.slots[slot]
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	res := make([]string, 0, len(ks.keys))
+	for key := range ks.keys {
+		res = append(res, key)
+	}
+	return res
+}
+
+// ClusterConfigPath return the path of the cluster config file given by dir and cluster-config-file
+func ClusterConfigPath() string {
+	return filepath.Join(config.Configures.Dir, config.Configures.ClusterConfigFile)
+}
+
+// OpenCluster enable the cluster mode, the state of the node is loaded from path which is created if it does not exist
+func (dbs *Databases) OpenCluster(path string) error {
+	c := &cluster{
+		dbs:           dbs,
+		nodes:         make(map[string]*clusterNode),
+		forgotten:     make(map[string]time.Time),
+		configPath:    path,
+		nodeTimeout:   time.Duration(config.Configures.ClusterNodeTimeout) * time.Millisecond,
+		statsSent:     make(map[string]int64),
+		statsReceived: make(map[string]int64),
+		slotKeys:      &slotIndex{},
+	}
+	dbs.Get(0).db.setIndex(c.slotKeys)
+	content, err := os.ReadFile(path)
+	if err == nil {
+		if err = c.loadConfig(string(content)); err != nil {
+			return fmt.Errorf("load the cluster config file %s: %w", path, err)
+		}
+		log.Printf("Node configuration loaded, I'm %s", c.myself.id)
+	} else if errors.Is(err, os.ErrNotExist) {
+		c.myself = &clusterNode{
+			id:          newReplID(),
+			flags:       nodeMyself | nodeMaster,
+			failReports: make(map[string]time.Time),
+		}
+		c.nodes[c.myself.id] = c.myself
+		log.Printf("No cluster configuration found, I'm %s", c.myself.id)
+	} else {
+		return err
+	}
+	// the address of the node comes from the config, the ip is learned from the bus if the server listens on all interfaces
+	c.myself.ip = config.Configures.Host
+	if ip := net.ParseIP(c.myself.ip); ip != nil && ip.IsUnspecified() {
+		c.myself.ip = ""
+	}
+	c.myself.port = config.Configures.Port
+	c.myself.cport = config.Configures.Port + clusterBusPortOffset
+	c.updateState()
+	if err = c.saveConfig(); err != nil {
+		return err
+	}
+	dbs.cluster = c
+	// a replica resumes the replication of its master
+	if master, ok := c.nodes[c.myself.masterID]; ok && c.myself.is(nodeReplica) {
+		dbs.ReplicaOf(master.ip, master.port)
+	}
+	return nil
+}
+
+// loadConfig restore the nodes, slots and epoch from the content of the cluster config file
+func (c *cluster) loadConfig(content string) error {
+	type slotRef struct {
+		slot int
+		id   string
+	}
+	var owners, migrating, importing []slotRef
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					c.currentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return fmt.Errorf("invalid line: %s", line)
+		}
+		n := &clusterNode{id: fields[0], failReports: make(map[string]time.Time)}
+		if err := n.parseAddr(fields[1]); err != nil {
+			return err
+		}
+		for _, name := range strings.Split(fields[2], ",") {
+			for _, f := range nodeFlagNames {
+				if f.name == name {
+					n.flags |= f.flag
+				}
+			}
+		}
+		// a handshake is not resumed, and the failures are detected again
+		if n.is(nodeHandshake) {
+			continue
+		}
+		n.flags &^= nodePFail | nodeFail
+		if fields[3] != "-" {
+			n.masterID = fields[3]
+		}
+		n.configEpoch, _ = strconv.ParseInt(fields[6], 10, 64)
+		n.pongReceived = time.Now()
+		for _, s := range fields[8:] {
+			if strings.HasPrefix(s, "[") {
+				// [slot->-id] is a migrating slot and [slot-<-id] an importing one
+				s = strings.Trim(s, "[]")
+				if i := strings.Index(s, "->-"); i > 0 {
+					slot, _ := strconv.Atoi(s[:i])
+					migrating = append(migrating, slotRef{slot, s[i+3:]})
+				} else if i = strings.Index(s, "-<-"); i > 0 {
+					slot, _ := strconv.Atoi(s[:i])
+					importing = append(importing, slotRef{slot, s[i+3:]})
+				}
+				continue
+			}
+			start, end, err := parseSlotRange(s)
+			if err != nil {
+				return err
+			}
+			for slot := start; slot <= end; slot++ {
+				owners = append(owners, slotRef{slot, n.id})
+			}
+		}
+		c.nodes[n.id] = n
+		if n.is(nodeMyself) {
+			c.myself = n
+		}
+	}
+	if c.myself == nil {
+		return errors.New("myself is not in the file")
+	}
+	for _, ref := range owners {
+		c.slots[ref.slot] = c.nodes[ref.id]
+	}
+	for _, ref := range migrating {
+		c.migrating[ref.slot] = c.nodes[ref.id]
+	}
+	for _, ref := range importing {
+		c.importing[ref.slot] = c.nodes[ref.id]
+	}
+	return nil
+}
+
+// parseAddr parse the address of the node given as ip:port@cport
+func (n *clusterNode) parseAddr(addr string) error {
+	// a hostname may follow the address after a comma
+	addr, _, _ = strings.Cut(addr, ",")
+	hostPort, cport, ok := strings.Cut(addr, "@")
+	host, port, err := net.SplitHostPort(hostPort)
+	if !ok || err != nil {
+		return fmt.Errorf("invalid address: %s", addr)
+	}
+	n.ip = host
+	if n.port, err = strconv.Atoi(port); err != nil {
+		return fmt.Errorf("invalid address: %s", addr)
+	}
+	if n.cport, err = strconv.Atoi(cport); err != nil {
+		return fmt.Errorf("invalid address: %s", addr)
+	}
+	return nil
+}
+
+// parseSlotRange parse a slot or a range of slots start-end
+func parseSlotRange(s string) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		endStr = startStr
+	}
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 || start >= clusterSlots {
+		return 0, 0, fmt.Errorf("invalid slot: %s", s)
+	}
+	end, err := strconv.Atoi(endStr)
+	if err != nil || end < start || end >= clusterSlots {
+		return 0, 0, fmt.Errorf("invalid slot: %s", s)
+	}
+	return start, end, nil
+}
+
+// saveConfig write the state of the node into the cluster config file, mu should be held
+func (c *cluster) saveConfig() error {
+	content := c.nodesDescription(true)
+	content += fmt.Sprintf("vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+	tmp := filepath.Join(filepath.Dir(c.configPath), fmt.Sprintf("temp-nodes-%d.conf", os.Getpid()))
+	err := os.WriteFile(tmp, []byte(content), 0644)
+	if err == nil {
+		err = os.Rename(tmp, c.configPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("Save the cluster config error: %s", err.Error())
+		return err
+	}
+	c.configDirty = false
+	return nil
+}
+
+// sortedNodes return the nodes ordered by id, mu should be held
+func (c *cluster) sortedNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].id < nodes[j].id
+	})
+	return nodes
+}
+
+// slotRanges return the ranges of slots owned by n, mu should be held
+func (c *cluster) slotRanges(n *clusterNode) [][2]int {
+	ranges := make([][2]int, 0)
+	start := -1
+	for slot := 0; slot <= clusterSlots; slot++ {
+		if slot < clusterSlots && c.slots[slot] == n {
+			if start < 0 {
+				start = slot
+			}
+			continue
+		}
+		if start >= 0 {
+			ranges = append(ranges, [2]int{start, slot - 1})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// replicasOf return the known replicas of the master n, mu should be held
+func (c *cluster) replicasOf(n *clusterNode) []*clusterNode {
+	replicas := make([]*clusterNode, 0)
+	for _, node := range c.sortedNodes() {
+		if node.is(nodeReplica) && node.masterID == n.id {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// nodeLine return the line of n in CLUSTER NODES, mu should be held
+func (c *cluster) nodeLine(n *clusterNode, withResharding bool) string {
+	var b strings.Builder
+	masterID := n.masterID
+	if masterID == "" {
+		masterID = "-"
+	}
+	var pingSent, pongReceived int64
+	if !n.pingSent.IsZero() {
+		pingSent = n.pingSent.UnixMilli()
+	}
+	if !n.pongReceived.IsZero() && !n.is(nodeMyself) {
+		pongReceived = n.pongReceived.UnixMilli()
+	}
+	linkState := "disconnected"
+	if n.is(nodeMyself) || n.link != nil {
+		linkState = "connected"
+	}
+	fmt.Fprintf(&b, "%s %s:%d@%d %s %s %d %d %d %s", n.id, n.ip, n.port, n.cport, n.flagNames(), masterID,
+		pingSent, pongReceived, n.configEpoch, linkState)
+	for _, r := range c.slotRanges(n) {
+		if r[0] == r[1] {
+			fmt.Fprintf(&b, " %d", r[0])
+		} else {
+			fmt.Fprintf(&b, " %d-%d", r[0], r[1])
+		}
+	}
+	if withResharding && n.is(nodeMyself) {
+		for slot := 0; slot < clusterSlots; slot++ {
+			if c.migrating[slot] != nil {
+				fmt.Fprintf(&b, " [%d->-%s]", slot, c.migrating[slot].id)
+			} else if c.importing[slot] != nil {
+				fmt.Fprintf(&b, " [%d-<-%s]", slot, c.importing[slot].id)
+			}
+		}
+	}
+	return b.String()
+}
+
+// nodesDescription return the reply of CLUSTER NODES, mu should be held
+func (c *cluster) nodesDescription(withResharding bool) string {
+	var b strings.Builder
+	for _, n := range c.sortedNodes() {
+		b.WriteString(c.nodeLine(n, withResharding))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// size return the number of masters serving at least one slot, mu should be held
+func (c *cluster) size() int {
+	masters := make(map[*clusterNode]struct{})
+	for _, n := range c.slots {
+		if n != nil {
+			masters[n] = struct{}{}
+		}
+	}
+	return len(masters)
+}
+
+// updateState compute whether the cluster is ok: every slot is served by a node which is not failing
+// mu should be held
+func (c *cluster) updateState() {
+	ok := true
+	for _, n := range c.slots {
+		if n == nil || n.is(nodeFail) {
+			ok = false
+			break
+		}
+	}
+	if ok != c.ok {
+		if ok {
+			log.Printf("Cluster state changed: ok")
+		} else {
+			log.Printf("Cluster state changed: fail")
+		}
+		c.ok = ok
+	}
+}
+
+// masterOf return the node whose slots n serves, n itself if it is a master, mu should be held
+func (c *cluster) masterOf(n *clusterNode) *clusterNode {
+	if n.is(nodeReplica) {
+		if master, ok := c.nodes[n.masterID]; ok {
+			return master
+		}
+	}
+	return n
+}
+
+// keysInSlot return the keys of db 0 in slot, at most limit of them unless limit is negative
+func (c *cluster) keysInSlot(slot int, limit int) []string {
+	db := c.dbs.Get(0)
+	res := make([]string, 0)
+	for _, key := range c.slotKeys.keys(slot) {
+		if limit >= 0 && len(res) >= limit {
+			break
+		}
+		if db.CheckTTL(key) {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// redirect return the reply redirecting a command of client on keys to the node serving them,
+// nil if the node serves the command
+func (c *cluster) redirect(cmd *command, keys []string, client *Client) data.RedisData {
+	// ASKING is valid for the next command only
+	asking := client.asking || cmd.has(cmdAsking)
+	client.asking = false
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := keySlot([]byte(keys[0]))
+	for _, key := range keys[1:] {
+		if keySlot([]byte(key)) != slot {
+			return data.MakeErrorData("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.ok {
+		return data.MakeErrorData("CLUSTERDOWN The cluster is down")
+	}
+	owner := c.slots[slot]
+	if owner == nil {
+		return data.MakeErrorData("CLUSTERDOWN Hash slot not served")
+	}
+	if owner == c.myself {
+		target := c.migrating[slot]
+		if target == nil {
+			return nil
+		}
+		// the keys which are not here any more were moved to the target
+		missing := c.missingKeys(keys)
+		if missing == 0 {
+			return nil
+		}
+		if missing < len(keys) {
+			return data.MakeErrorData("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return data.MakeErrorData(fmt.Sprintf("ASK %d %s", slot, target.addr()))
+	}
+	if c.importing[slot] != nil && asking {
+		if len(keys) > 1 && c.missingKeys(keys) > 0 {
+			return data.MakeErrorData("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return nil
+	}
+	// a replica serves the reads on the slots of its master to the clients which sent READONLY, and the shard
+	// channels to all clients
+	if (client.readOnly || cmd.has(cmdShardChannels)) && !cmd.has(cmdWrite) && c.masterOf(c.myself) == owner {
+		return nil
+	}
+	return data.MakeErrorData(fmt.Sprintf("MOVED %d %s", slot, owner.addr()))
+}
+
+// missingKeys return how many keys do not exist in db 0
+func (c *cluster) missingKeys(keys []string) int {
+	db := c.dbs.Get(0)
+	missing := 0
+	for _, key := range keys {
+		if !db.CheckTTL(key) {
+			missing++
+		} else if _, ok := db.db.Get(key); !ok {
+			missing++
+		}
+	}
+	return missing
+}
+
+// rejectInCluster return an error reply if the command can not be used in cluster mode
+func (dbs *Databases) rejectInCluster(cmdName string) data.RedisData {
+	if dbs.cluster == nil {
+		return nil
+	}
+	return data.MakeErrorData(fmt.Sprintf("ERR %s is not allowed in cluster mode", strings.ToUpper(cmdName)))
+}
+
+// parseSlot parse a slot argument
+func parseSlot(arg []byte) (int, data.RedisData) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, data.MakeErrorData("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+func bulkString(s string) data.RedisData {
+	return data.MakeBulkData([]byte(s))
+}
+
+// clusterServer manage the cluster
+// CLUSTER INFO | MYID | NODES | SLOTS | SHARDS | REPLICAS node-id | KEYSLOT key | COUNTKEYSINSLOT slot |
+// GETKEYSINSLOT slot count | MEET ip port [cport] | ADDSLOTS slot [slot ...] | ADDSLOTSRANGE start end [start end ...] |
+// DELSLOTS slot [slot ...] | DELSLOTSRANGE start end [start end ...] | FLUSHSLOTS |
+// SETSLOT slot IMPORTING node-id | MIGRATING node-id | STABLE | NODE node-id | REPLICATE node-id | FORGET node-id |
+// COUNT-FAILURE-REPORTS node-id | BUMPEPOCH | SAVECONFIG
+func clusterServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "cluster" {
+		return data.MakeErrorData("Server Error")
+	}
+	c := db.dbs.cluster
+	if c == nil {
+		return data.MakeErrorData("ERR This instance has cluster support disabled")
+	}
+	sub := strings.ToLower(string(cmd[1]))
+	args := cmd[2:]
+	wrongArgs := data.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'cluster|%s' command", sub))
+
+	switch sub {
+	case "info", "myid", "nodes", "slots", "shards", "flushslots", "bumpepoch", "saveconfig":
+		if len(args) != 0 {
+			return wrongArgs
+		}
+	case "replicas", "slaves", "keyslot", "countkeysinslot", "replicate", "forget", "count-failure-reports":
+		if len(args) != 1 {
+			return wrongArgs
+		}
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return wrongArgs
+		}
+	case "meet":
+		if len(args) != 2 && len(args) != 3 {
+			return wrongArgs
+		}
+	case "addslots", "delslots":
+		if len(args) == 0 {
+			return wrongArgs
+		}
+	case "addslotsrange", "delslotsrange":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return wrongArgs
+		}
+	case "setslot":
+		if len(args) < 2 {
+			return wrongArgs
+		}
+	default:
+		return data.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", string(cmd[1])))
+	}
+
+	switch sub {
+	case "keyslot":
+		return data.MakeIntData(int64(keySlot(args[0])))
+	case "countkeysinslot":
+		slot, err := strconv.Atoi(string(args[0]))
+		if err != nil || slot < 0 || slot >= clusterSlots {
+			return data.MakeErrorData("ERR Invalid slot")
+		}
+		return data.MakeIntData(int64(len(c.keysInSlot(slot, -1))))
+	case "getkeysinslot":
+		slot, err := strconv.Atoi(string(args[0]))
+		if err != nil || slot < 0 || slot >= clusterSlots {
+			return data.MakeErrorData("ERR Invalid slot")
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return data.MakeErrorData("ERR Invalid number of keys")
+		}
+		keys := c.keysInSlot(slot, count)
+		res := make([]data.RedisData, 0, len(keys))
+		for _, key := range keys {
+			res = append(res, bulkString(key))
+		}
+		return data.MakeArrayData(res)
+	case "replicate":
+		return c.replicate(string(args[0]))
+	}
+
+	if sub == "info" || sub == "myid" || sub == "nodes" || sub == "slots" || sub == "shards" ||
+		sub == "replicas" || sub == "slaves" || sub == "count-failure-reports" {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+	} else {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+	switch sub {
+	case "info":
+		return c.info()
+	case "myid":
+		return bulkString(c.myself.id)
+	case "nodes":
+		return bulkString(c.nodesDescription(false))
+	case "slots":
+		return c.slotsReply()
+	case "shards":
+		return c.shardsReply()
+	case "replicas", "slaves":
+		n, ok := c.nodes[string(args[0])]
+		if !ok {
+			return data.MakeErrorData(fmt.Sprintf("ERR Unknown node %s", string(args[0])))
+		}
+		if n.is(nodeReplica) {
+			return data.MakeErrorData("ERR The specified node is not a master")
+		}
+		res := make([]data.RedisData, 0)
+		for _, replica := range c.replicasOf(n) {
+			res = append(res, bulkString(c.nodeLine(replica, false)))
+		}
+		return data.MakeArrayData(res)
+	case "count-failure-reports":
+		n, ok := c.nodes[string(args[0])]
+		if !ok {
+			return data.MakeErrorData(fmt.Sprintf("ERR Unknown node %s", string(args[0])))
+		}
+		return data.MakeIntData(int64(c.failureReports(n)))
+	case "meet":
+		return c.meet(args)
+	case "addslots", "delslots", "addslotsrange", "delslotsrange":
+		return c.changeSlots(sub, args)
+	case "flushslots":
+		if db.dbs.Get(0).db.Len() != 0 {
+			return data.MakeErrorData("ERR DB must be empty to perform CLUSTER FLUSHSLOTS.")
+		}
+		for slot := range c.slots {
+			if c.slots[slot] == c.myself {
+				c.slots[slot] = nil
+			}
+		}
+		c.slotsChanged()
+	case "setslot":
+		return c.setSlot(args)
+	case "forget":
+		id := string(args[0])
+		n, ok := c.nodes[id]
+		switch {
+		case !ok:
+			return data.MakeErrorData(fmt.Sprintf("ERR Unknown node %s", id))
+		case n == c.myself:
+			return data.MakeErrorData("ERR I tried hard but I can't forget myself...")
+		case c.myself.is(nodeReplica) && c.myself.masterID == id:
+			return data.MakeErrorData("ERR Can't forget my master!")
+		}
+		c.forgotten[id] = time.Now().Add(clusterForgetTTL)
+		c.removeNode(n)
+		c.slotsChanged()
+	case "bumpepoch":
+		if c.bumpEpoch() {
+			return data.MakeStringData(fmt.Sprintf("BUMPED %d", c.myself.configEpoch))
+		}
+		return data.MakeStringData(fmt.Sprintf("STILL %d", c.myself.configEpoch))
+	case "saveconfig":
+		if err := c.saveConfig(); err != nil {
+			return data.MakeErrorData("ERR error saving the cluster node config: " + err.Error())
+		}
+	}
+	return data.MakeStringData("OK")
+}
+
+// info return the reply of CLUSTER INFO, mu should be held
+func (c *cluster) info() data.RedisData {
+	state := "fail"
+	if c.ok {
+		state = "ok"
+	}
+	assigned, pfail, fail := 0, 0, 0
+	for _, n := range c.slots {
+		if n == nil {
+			continue
+		}
+		assigned++
+		if n.is(nodeFail) {
+			fail++
+		} else if n.is(nodePFail) {
+			pfail++
+		}
+	}
+	var sent, received int64
+	for _, n := range c.statsSent {
+		sent += n
+	}
+	for _, n := range c.statsReceived {
+		received += n
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", assigned-pfail-fail)
+	fmt.Fprintf(&b, "cluster_slots_pfail:%d\r\n", pfail)
+	fmt.Fprintf(&b, "cluster_slots_fail:%d\r\n", fail)
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(c.nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", c.size())
+	fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", c.currentEpoch)
+	fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", c.masterOf(c.myself).configEpoch)
+	fmt.Fprintf(&b, "cluster_stats_messages_sent:%d\r\n", sent)
+	fmt.Fprintf(&b, "cluster_stats_messages_received:%d\r\n", received)
+	for _, typ := range clusterMessageTypes {
+		if c.statsSent[typ] > 0 {
+			fmt.Fprintf(&b, "cluster_stats_messages_%s_sent:%d\r\n", typ, c.statsSent[typ])
+		}
+	}
+	for _, typ := range clusterMessageTypes {
+		if c.statsReceived[typ] > 0 {
+			fmt.Fprintf(&b, "cluster_stats_messages_%s_received:%d\r\n", typ, c.statsReceived[typ])
+		}
+	}
+	return bulkString(b.String())
+}
+
+// slotsReply return the reply of CLUSTER SLOTS: for every range of slots, its start, its end, the master and the
+// replicas serving it, mu should be held
+func (c *cluster) slotsReply() data.RedisData {
+	res := make([]data.RedisData, 0)
+	for _, n := range c.sortedNodes() {
+		if n.is(nodeReplica) {
+			continue
+		}
+		for _, r := range c.slotRanges(n) {
+			entry := []data.RedisData{data.MakeIntData(int64(r[0])), data.MakeIntData(int64(r[1])), nodeEndpoint(n)}
+			for _, replica := range c.replicasOf(n) {
+				if !replica.is(nodeFail) {
+					entry = append(entry, nodeEndpoint(replica))
+				}
+			}
+			res = append(res, data.MakeArrayData(entry))
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+func nodeEndpoint(n *clusterNode) data.RedisData {
+	return data.MakeArrayData([]data.RedisData{
+		bulkString(n.ip),
+		data.MakeIntData(int64(n.port)),
+		bulkString(n.id),
+		data.MakeEmptyArrayData(),
+	})
+}
+
+// shardsReply return the reply of CLUSTER SHARDS: for every master, its slots and the nodes of its shard
+// mu should be held
+func (c *cluster) shardsReply() data.RedisData {
+	res := make([]data.RedisData, 0)
+	for _, master := range c.sortedNodes() {
+		if master.is(nodeReplica) || master.is(nodeHandshake) {
+			continue
+		}
+		slots := make([]data.RedisData, 0)
+		for _, r := range c.slotRanges(master) {
+			slots = append(slots, data.MakeIntData(int64(r[0])), data.MakeIntData(int64(r[1])))
+		}
+		nodes := []data.RedisData{c.shardNode(master)}
+		for _, replica := range c.replicasOf(master) {
+			nodes = append(nodes, c.shardNode(replica))
+		}
+		res = append(res, data.MakeMapData().
+			Add(bulkString("slots"), data.MakeArrayData(slots)).
+			Add(bulkString("nodes"), data.MakeArrayData(nodes)))
+	}
+	return data.MakeArrayData(res)
+}
+
+// shardNode describe a node in CLUSTER SHARDS, mu should be held
+func (c *cluster) shardNode(n *clusterNode) data.RedisData {
+	role := "master"
+	if n.is(nodeReplica) {
+		role = "replica"
+	}
+	health := "online"
+	if n.is(nodeFail) || n.is(nodePFail) {
+		health = "fail"
+	}
+	var offset int64
+	if n.is(nodeMyself) {
+		r := c.dbs.repl
+		r.mu.Lock()
+		offset = r.offset
+		r.mu.Unlock()
+	}
+	return data.MakeMapData().
+		Add(bulkString("id"), bulkString(n.id)).
+		Add(bulkString("port"), data.MakeIntData(int64(n.port))).
+		Add(bulkString("ip"), bulkString(n.ip)).
+		Add(bulkString("endpoint"), bulkString(n.ip)).
+		Add(bulkString("role"), bulkString(role)).
+		Add(bulkString("replication-offset"), data.MakeIntData(offset)).
+		Add(bulkString("health"), bulkString(health))
+}
+
+// meet start a handshake with the node at ip and port, mu should be held
+func (c *cluster) meet(args [][]byte) data.RedisData {
+	ip := net.ParseIP(string(args[0]))
+	port, err := strconv.Atoi(string(args[1]))
+	if ip == nil || err != nil || port <= 0 || port >= 65536 {
+		return data.MakeErrorData(fmt.Sprintf("ERR Invalid node address specified: %s:%s", string(args[0]), string(args[1])))
+	}
+	cport := port + clusterBusPortOffset
+	if len(args) == 3 {
+		cport, err = strconv.Atoi(string(args[2]))
+		if err != nil || cport <= 0 || cport >= 65536 {
+			return data.MakeErrorData(fmt.Sprintf("ERR Invalid node address specified: %s:%s", string(args[0]), string(args[1])))
+		}
+	}
+	c.startHandshake(ip.String(), port, cport, true)
+	return data.MakeStringData("OK")
+}
+
+// startHandshake add a node at the address, it is given its real id by its first PONG. It returns false if a
+// handshake with this address is already in progress. mu should be held.
+func (c *cluster) startHandshake(ip string, port, cport int, meet bool) bool {
+	for _, n := range c.nodes {
+		if n.is(nodeHandshake) && n.ip == ip && n.port == port && n.cport == cport {
+			return false
+		}
+	}
+	n := &clusterNode{
+		id:          newReplID(),
+		ip:          ip,
+		port:        port,
+		cport:       cport,
+		flags:       nodeHandshake,
+		created:     time.Now(),
+		failReports: make(map[string]time.Time),
+	}
+	if meet {
+		n.flags |= nodeMeet
+	}
+	c.nodes[n.id] = n
+	return true
+}
+
+// changeSlots run ADDSLOTS, DELSLOTS, ADDSLOTSRANGE and DELSLOTSRANGE, mu should be held
+func (c *cluster) changeSlots(sub string, args [][]byte) data.RedisData {
+	add := strings.HasPrefix(sub, "add")
+	slots := make([]int, 0, len(args))
+	if strings.HasSuffix(sub, "range") {
+		for i := 0; i < len(args); i += 2 {
+			start, errRes := parseSlot(args[i])
+			if errRes != nil {
+				return errRes
+			}
+			end, errRes := parseSlot(args[i+1])
+			if errRes != nil {
+				return errRes
+			}
+			if end < start {
+				return data.MakeErrorData(fmt.Sprintf("ERR start slot number %d is greater than end slot number %d", start, end))
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+	} else {
+		for _, arg := range args {
+			slot, errRes := parseSlot(arg)
+			if errRes != nil {
+				return errRes
+			}
+			slots = append(slots, slot)
+		}
+	}
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if seen[slot] {
+			return data.MakeErrorData(fmt.Sprintf("ERR Slot %d specified multiple times", slot))
+		}
+		seen[slot] = true
+		if add && c.slots[slot] != nil {
+			return data.MakeErrorData(fmt.Sprintf("ERR Slot %d is already busy", slot))
+		}
+		if !add && c.slots[slot] == nil {
+			return data.MakeErrorData(fmt.Sprintf("ERR Slot %d is already unassigned", slot))
+		}
+	}
+	for _, slot := range slots {
+		if add {
+			c.slots[slot] = c.myself
+			// the slot is not imported any more once it is assigned
+			c.importing[slot] = nil
+		} else {
+			c.slots[slot] = nil
+		}
+	}
+	c.slotsChanged()
+	return data.MakeStringData("OK")
+}
+
+// setSlot run CLUSTER SETSLOT slot IMPORTING node-id | MIGRATING node-id | STABLE | NODE node-id, mu should be held
+func (c *cluster) setSlot(args [][]byte) data.RedisData {
+	if c.myself.is(nodeReplica) {
+		return data.MakeErrorData("ERR Please use SETSLOT only with masters.")
+	}
+	slot, errRes := parseSlot(args[0])
+	if errRes != nil {
+		return errRes
+	}
+	action := strings.ToLower(string(args[1]))
+	var n *clusterNode
+	switch action {
+	case "stable":
+		if len(args) != 2 {
+			return data.MakeErrorData("ERR syntax error")
+		}
+	case "importing", "migrating", "node":
+		if len(args) != 3 {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		var ok bool
+		if n, ok = c.nodes[string(args[2])]; !ok {
+			return data.MakeErrorData(fmt.Sprintf("ERR I don't know about node %s", string(args[2])))
+		}
+		if n.is(nodeReplica) {
+			return data.MakeErrorData("ERR Target node is not a master")
+		}
+	default:
+		return data.MakeErrorData("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+
+	switch action {
+	case "migrating":
+		if c.slots[slot] != c.myself {
+			return data.MakeErrorData(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if n == c.myself {
+			return data.MakeErrorData("ERR Can't MIGRATE to myself")
+		}
+		c.migrating[slot] = n
+	case "importing":
+		if c.slots[slot] == c.myself {
+			return data.MakeErrorData(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if n == c.myself {
+			return data.MakeErrorData("ERR Can't IMPORT from myself")
+		}
+		c.importing[slot] = n
+	case "stable":
+		c.migrating[slot] = nil
+		c.importing[slot] = nil
+	case "node":
+		if c.slots[slot] == c.myself && n != c.myself && len(c.keysInSlot(slot, 1)) > 0 {
+			return data.MakeErrorData(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		if n != c.myself {
+			c.migrating[slot] = nil
+		}
+		if n == c.myself && c.importing[slot] != nil {
+			// the slot is taken over with a new epoch so that the other nodes accept the change
+			c.importing[slot] = nil
+			c.currentEpoch++
+			c.myself.configEpoch = c.currentEpoch
+			log.Printf("configEpoch updated after importing slot %d: %d", slot, c.myself.configEpoch)
+		}
+		c.slots[slot] = n
+	}
+	c.slotsChanged()
+	return data.MakeStringData("OK")
+}
+
+// slotsChanged save the config and tell the other nodes about the change, mu should be held
+func (c *cluster) slotsChanged() {
+	c.updateState()
+	_ = c.saveConfig()
+	c.broadcast(msgPong)
+}
+
+// replicate make the node a replica of the master id
+func (c *cluster) replicate(id string) data.RedisData {
+	c.mu.Lock()
+	n, ok := c.nodes[id]
+	switch {
+	case !ok:
+		c.mu.Unlock()
+		return data.MakeErrorData(fmt.Sprintf("ERR Unknown node %s", id))
+	case n == c.myself:
+		c.mu.Unlock()
+		return data.MakeErrorData("ERR Can't replicate myself")
+	case n.is(nodeReplica):
+		c.mu.Unlock()
+		return data.MakeErrorData("ERR I can only replicate a master, not a replica.")
+	}
+	if c.myself.is(nodeMaster) && (len(c.slotRanges(c.myself)) > 0 || c.dbs.Get(0).db.Len() != 0) {
+		c.mu.Unlock()
+		return data.MakeErrorData("ERR To set a master the node must be empty and without assigned slots.")
+	}
+	c.myself.flags = c.myself.flags&^nodeMaster | nodeReplica
+	c.myself.masterID = n.id
+	for slot := range c.slots {
+		c.migrating[slot] = nil
+		c.importing[slot] = nil
+	}
+	c.updateState()
+	_ = c.saveConfig()
+	c.broadcast(msgPong)
+	host, port := n.ip, n.port
+	c.mu.Unlock()
+
+	c.dbs.ReplicaOf(host, port)
+	return data.MakeStringData("OK")
+}
+
+// removeNode delete n from the known nodes, mu should be held
+func (c *cluster) removeNode(n *clusterNode) {
+	for slot := range c.slots {
+		if c.slots[slot] == n {
+			c.slots[slot] = nil
+		}
+		if c.migrating[slot] == n {
+			c.migrating[slot] = nil
+		}
+		if c.importing[slot] == n {
+			c.importing[slot] = nil
+		}
+	}
+	for _, node := range c.nodes {
+		delete(node.failReports, n.id)
+	}
+	if n.link != nil {
+		n.link.close()
+		n.link = nil
+	}
+	delete(c.nodes, n.id)
+}
+
+// bumpEpoch give the node a config epoch greater than the epochs of the other nodes, unless it already has the
+// greatest one. It returns whether the epoch changed. mu should be held.
+func (c *cluster) bumpEpoch() bool {
+	maxEpoch := int64(0)
+	for _, n := range c.nodes {
+		if n != c.myself && n.configEpoch > maxEpoch {
+			maxEpoch = n.configEpoch
+		}
+	}
+	if c.myself.configEpoch != 0 && c.myself.configEpoch > maxEpoch {
+		return false
+	}
+	c.currentEpoch++
+	c.myself.configEpoch = c.currentEpoch
+	_ = c.saveConfig()
+	return true
+}
+
+// askingCluster allow the next command of the client on a slot being imported by the node
+// ASKING
+func askingCluster(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "asking" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || db.dbs.cluster == nil {
+		return data.MakeErrorData("ERR This instance has cluster support disabled")
+	}
+	client.asking = true
+	return data.MakeStringData("OK")
+}
+
+// readOnlyCluster let the client read the slots of the master of the replica it is connected to
+// READONLY
+func readOnlyCluster(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "readonly" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || db.dbs.cluster == nil {
+		return data.MakeErrorData("ERR This instance has cluster support disabled")
+	}
+	client.readOnly = true
+	return data.MakeStringData("OK")
+}
+
+// readWriteCluster cancel READONLY
+// READWRITE
+func readWriteCluster(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "readwrite" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || db.dbs.cluster == nil {
+		return data.MakeErrorData("ERR This instance has cluster support disabled")
+	}
+	client.readOnly = false
+	return data.MakeStringData("OK")
+}
+
+func RegisterClusterCommands() {
+	RegisterCommand("cluster", clusterServer, -2, 0, 0, 0, 0)
+	RegisterCommand("asking", askingCluster, 1, cmdFast, 0, 0, 0)
+	RegisterCommand("readonly", readOnlyCluster, 1, cmdFast, 0, 0, 0)
+	RegisterCommand("readwrite", readWriteCluster, 1, cmdFast, 0, 0, 0)
+}
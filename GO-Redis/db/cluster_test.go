@@ -0,0 +1,97 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint16
+	}{
+		// the check value of CRC-16/XMODEM, which the cluster specification uses
+		{"123456789", 0x31c3},
+		{"", 0},
+		{"a", 0x7c87},
+	}
+	for _, tt := range tests {
+		if got := crc16([]byte(tt.in)); got != tt.want {
+			t.Fatalf("crc16(%q) = %#04x, want %#04x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		// hashed is the part of key hashed into the slot
+		hashed string
+	}{
+		{"plain key", "foo", "foo"},
+		{"empty key", "", ""},
+		{"hash tag", "{user1000}.following", "user1000"},
+		{"hash tag in the middle", "foo{bar}zap", "bar"},
+		{"first hash tag only", "foo{bar}{zap}", "bar"},
+		{"empty hash tag hashes the whole key", "foo{}{bar}", "foo{}{bar}"},
+		{"nested braces", "foo{{bar}}zap", "{bar"},
+		{"no closing brace", "foo{bar", "foo{bar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := int(crc16([]byte(tt.hashed))) % clusterSlots
+			if got := keySlot([]byte(tt.key)); got != want {
+				t.Fatalf("keySlot(%q) = %d, want %d", tt.key, got, want)
+			}
+		})
+	}
+
+	// slots given by CLUSTER KEYSLOT of redis
+	for key, want := range map[string]int{"foo": 12182, "bar": 5061, "{user1000}.followers": 3443} {
+		if got := keySlot([]byte(key)); got != want {
+			t.Fatalf("keySlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestSlotIndex(t *testing.T) {
+	m := NewConcurrentMap(16)
+	m.Set("before", 1)
+	index := &slotIndex{}
+	m.setIndex(index)
+	m.Set("{t}a", 1)
+	m.Set("{t}b", 1)
+	m.SetIfNotExist("{t}c", 1)
+	m.Set("{t}a", 2)
+	m.Delete("{t}b")
+	m.Delete("missing")
+
+	slotKeys := func(key string) string {
+		keys := index.keys(keySlot([]byte(key)))
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"keys set before the index", "before", "before"},
+		{"keys sharing a hash tag", "t", "{t}a,{t}c"},
+		{"slot without keys", "foo", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slotKeys(tt.key); got != tt.want {
+				t.Fatalf("keys of the slot of %q = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	m.Clear()
+	if got := slotKeys("t"); got != "" {
+		t.Fatalf("keys of the slot of %q after Clear = %q, want none", "t", got)
+	}
+}
@@ -0,0 +1,575 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The nodes of a cluster talk on the cluster bus, a tcp port at the port of the server plus 10000. Every node
+// connects to every other node and sends it a PING each second, which is answered by a PONG. Both carry the
+// state of the sender: its role, its epochs and the slots it serves, and a gossip section about the other nodes
+// it knows. A node learns the other nodes from the gossip, and a node flagged as failing by a majority of the
+// masters is marked FAIL and broadcasted. The messages are RESP arrays:
+//
+//	type sender-id port cport role master-id current-epoch config-epoch slots-bitmap [gossip ...]
+//
+// where the gossip is a list of id ip port cport flags. A FAIL message carries the id of the failing node instead,
+// and a PUBLISH message the channel and the message published on the sender.
+
+const (
+	clusterBusPortOffset = 10000
+	// clusterCronPeriod is how often the links, the pings and the failures are checked
+	clusterCronPeriod = 100 * time.Millisecond
+	// clusterPingPeriod is how often a node is sent a PING
+	clusterPingPeriod  = time.Second
+	clusterDialTimeout = time.Second
+	// clusterLinkQueue is the number of messages waiting to be sent on a link, the next ones are dropped
+	clusterLinkQueue = 64
+)
+
+// types of the bus messages
+const (
+	msgPing    = "ping"
+	msgPong    = "pong"
+	msgMeet    = "meet"
+	msgFail    = "fail"
+	msgPublish = "publish"
+	// msgPublishShard is a message of a shard channel, it is only sent to the nodes of the shard
+	msgPublishShard = "publishshard"
+)
+
+var clusterMessageTypes = []string{msgPing, msgPong, msgMeet, msgFail, msgPublish, msgPublishShard}
+
+// clusterMessageHeader is the number of fields of a message before the gossip section
+const clusterMessageHeader = 9
+
+// clusterLink is a bus connection, the messages are written by its own goroutine so that a slow node does not
+// block the others
+type clusterLink struct {
+	conn      net.Conn
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClusterLink(conn net.Conn) *clusterLink {
+	link := &clusterLink{
+		conn: conn,
+		out:  make(chan []byte, clusterLinkQueue),
+		done: make(chan struct{}),
+	}
+	go link.writeLoop()
+	return link
+}
+
+func (l *clusterLink) send(msg []byte) {
+	select {
+	case l.out <- msg:
+	default:
+		// the node does not read its messages, they are sent again by the next PING anyway
+	}
+}
+
+func (l *clusterLink) writeLoop() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case msg := <-l.out:
+			if _, err := l.conn.Write(msg); err != nil {
+				l.close()
+				return
+			}
+		}
+	}
+}
+
+func (l *clusterLink) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		_ = l.conn.Close()
+	})
+}
+
+// readClusterMessage read a message of the bus
+func readClusterMessage(parser *data.Parser) ([][]byte, error) {
+	msg, err := parser.ReadCommand()
+	if err != nil {
+		return nil, err
+	}
+	return msg.ToCommand(), nil
+}
+
+// ServeClusterBus accept the connections of the other nodes on listener and keep the links with them,
+// until ctx is done
+func (dbs *Databases) ServeClusterBus(ctx context.Context, listener net.Listener) {
+	c := dbs.cluster
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	go c.serveCron(ctx)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Accept cluster bus connection error: %s", err.Error())
+			continue
+		}
+		go c.serveLink(ctx, conn)
+	}
+}
+
+// serveLink process the messages of a connection opened by another node, its PINGs are answered on it
+func (c *cluster) serveLink(ctx context.Context, conn net.Conn) {
+	link := newClusterLink(conn)
+	defer link.close()
+	stop := context.AfterFunc(ctx, link.close)
+	defer stop()
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	localIP, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	parser := data.NewParser(bufio.NewReader(conn))
+	for {
+		msg, err := readClusterMessage(parser)
+		if err != nil {
+			return
+		}
+		c.process(msg, link, nil, remoteIP, localIP)
+	}
+}
+
+// connect open the link with n, and process the replies of n on it
+func (c *cluster) connect(n *clusterNode, addr string) {
+	conn, err := net.DialTimeout("tcp", addr, clusterDialTimeout)
+	c.mu.Lock()
+	n.connecting = false
+	if err != nil || c.nodes[n.id] != n {
+		c.mu.Unlock()
+		if err == nil {
+			_ = conn.Close()
+		}
+		return
+	}
+	link := newClusterLink(conn)
+	n.link = link
+	c.ping(n)
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if n.link == link {
+			n.link = nil
+		}
+		c.mu.Unlock()
+		link.close()
+	}()
+	parser := data.NewParser(bufio.NewReader(conn))
+	for {
+		msg, err := readClusterMessage(parser)
+		if err != nil {
+			return
+		}
+		c.process(msg, link, n, "", "")
+	}
+}
+
+// serveCron run the periodic tasks of the bus until ctx is done
+func (c *cluster) serveCron(ctx context.Context) {
+	ticker := time.NewTicker(clusterCronPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			for _, n := range c.nodes {
+				if n.link != nil {
+					n.link.close()
+				}
+			}
+			c.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+		c.cron()
+	}
+}
+
+// cron connect the nodes without link, ping them and detect the failing ones
+func (c *cluster) cron() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, until := range c.forgotten {
+		if now.After(until) {
+			delete(c.forgotten, id)
+		}
+	}
+	handshakeTimeout := c.nodeTimeout
+	if handshakeTimeout < time.Second {
+		handshakeTimeout = time.Second
+	}
+	for _, n := range c.sortedNodes() {
+		if n == c.myself {
+			continue
+		}
+		if n.is(nodeHandshake) && now.Sub(n.created) > handshakeTimeout {
+			log.Printf("Handshake with node %s at %s timed out", n.id, n.busAddr())
+			c.removeNode(n)
+			continue
+		}
+		switch {
+		case n.link == nil:
+			if !n.connecting {
+				n.connecting = true
+				// a node which is never reached is detected as failing from now on
+				if n.pingSent.IsZero() {
+					n.pingSent = now
+				}
+				go c.connect(n, n.busAddr())
+			}
+		case !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.nodeTimeout/2:
+			// the PONG is late, the link may be broken and is connected again
+			n.link.close()
+			n.link = nil
+		case n.pingSent.IsZero() && now.Sub(n.pongReceived) >= clusterPingPeriod:
+			c.ping(n)
+		}
+
+		if !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.nodeTimeout && !n.is(nodePFail|nodeFail|nodeHandshake) {
+			log.Printf("*** NODE %s possibly failing", n.id)
+			n.flags |= nodePFail
+		}
+		for id, reported := range n.failReports {
+			if now.Sub(reported) > c.nodeTimeout*2 {
+				delete(n.failReports, id)
+			}
+		}
+		c.markFailing(n)
+	}
+	c.updateState()
+	if c.configDirty {
+		_ = c.saveConfig()
+	}
+}
+
+// ping send a PING to n, or a MEET if it was added by CLUSTER MEET, mu should be held
+func (c *cluster) ping(n *clusterNode) {
+	typ := msgPing
+	if n.is(nodeMeet) {
+		typ = msgMeet
+	}
+	c.send(n.link, typ, c.makeMessage(typ))
+	if n.pingSent.IsZero() {
+		n.pingSent = time.Now()
+	}
+}
+
+func (c *cluster) send(link *clusterLink, typ string, msg []byte) {
+	c.statsSent[typ]++
+	link.send(msg)
+}
+
+// broadcast send a message to all the connected nodes, mu should be held
+func (c *cluster) broadcast(typ string, body ...[]byte) {
+	msg := c.makeMessage(typ, body...)
+	for _, n := range c.nodes {
+		if n.link != nil && !n.is(nodeHandshake) {
+			c.send(n.link, typ, msg)
+		}
+	}
+}
+
+// broadcastShard send a message to the connected nodes serving the same slots as the server, its master and the
+// replicas of its master. mu should be held.
+func (c *cluster) broadcastShard(typ string, body ...[]byte) {
+	msg := c.makeMessage(typ, body...)
+	master := c.masterOf(c.myself)
+	for _, n := range append(c.replicasOf(master), master) {
+		if n != c.myself && n.link != nil && !n.is(nodeHandshake) {
+			c.send(n.link, typ, msg)
+		}
+	}
+}
+
+// makeMessage encode a message of the bus, body is sent instead of the gossip. mu should be held.
+func (c *cluster) makeMessage(typ string, body ...[]byte) []byte {
+	me := c.myself
+	role, masterID := "master", "-"
+	bitmap := make([]byte, clusterSlots/8)
+	if me.is(nodeReplica) {
+		role, masterID = "slave", me.masterID
+	} else {
+		for slot, n := range c.slots {
+			if n == me {
+				bitmap[slot/8] |= 1 << (slot % 8)
+			}
+		}
+	}
+	msg := [][]byte{
+		[]byte(typ),
+		[]byte(me.id),
+		[]byte(strconv.Itoa(me.port)),
+		[]byte(strconv.Itoa(me.cport)),
+		[]byte(role),
+		[]byte(masterID),
+		[]byte(strconv.FormatInt(c.currentEpoch, 10)),
+		[]byte(strconv.FormatInt(me.configEpoch, 10)),
+		bitmap,
+	}
+	if len(body) > 0 {
+		msg = append(msg, body...)
+	} else {
+		for _, n := range c.nodes {
+			if n == me || n.is(nodeHandshake) || n.ip == "" {
+				continue
+			}
+			msg = append(msg, []byte(n.id), []byte(n.ip), []byte(strconv.Itoa(n.port)),
+				[]byte(strconv.Itoa(n.cport)), []byte(n.flagNames()))
+		}
+	}
+	return appendRESPCommand(nil, msg)
+}
+
+// process apply a message received on link. outNode is the node of an outbound link, nil for a link opened by
+// another node, whose remote and local ips are given.
+func (c *cluster) process(msg [][]byte, link *clusterLink, outNode *clusterNode, remoteIP, localIP string) {
+	if len(msg) < clusterMessageHeader || len(msg[8]) != clusterSlots/8 {
+		return
+	}
+	typ, id := string(msg[0]), string(msg[1])
+	port, _ := strconv.Atoi(string(msg[2]))
+	cport, _ := strconv.Atoi(string(msg[3]))
+	role, masterID := string(msg[4]), string(msg[5])
+	currentEpoch, _ := strconv.ParseInt(string(msg[6]), 10, 64)
+	configEpoch, _ := strconv.ParseInt(string(msg[7]), 10, 64)
+	bitmap := msg[8]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statsReceived[typ]++
+	if typ == msgPing || typ == msgMeet {
+		defer func() {
+			c.send(link, msgPong, c.makeMessage(msgPong))
+		}()
+	}
+	if c.myself.ip == "" && localIP != "" {
+		c.myself.ip = localIP
+		c.configDirty = true
+	}
+	if currentEpoch > c.currentEpoch {
+		c.currentEpoch = currentEpoch
+		c.configDirty = true
+	}
+
+	sender := c.nodes[id]
+	if sender == c.myself {
+		return
+	}
+	if outNode != nil && outNode.is(nodeHandshake) {
+		// the first PONG of a node in handshake gives its id
+		if typ != msgPong || c.nodes[outNode.id] != outNode {
+			return
+		}
+		if sender != nil {
+			c.removeNode(outNode)
+			return
+		}
+		delete(c.nodes, outNode.id)
+		outNode.id = id
+		outNode.flags &^= nodeHandshake | nodeMeet
+		c.nodes[id] = outNode
+		sender = outNode
+		c.configDirty = true
+		log.Printf("Handshake with node %s at %s completed", id, outNode.busAddr())
+	} else if outNode != nil && outNode != sender {
+		// another node answers at this address
+		return
+	}
+	if sender == nil {
+		if typ != msgMeet {
+			return
+		}
+		sender = &clusterNode{
+			id:          id,
+			ip:          remoteIP,
+			port:        port,
+			cport:       cport,
+			failReports: make(map[string]time.Time),
+		}
+		c.nodes[id] = sender
+		c.configDirty = true
+		log.Printf("Node %s at %s met us", id, sender.busAddr())
+	}
+	if outNode != nil && typ == msgPong {
+		sender.pingSent = time.Time{}
+		sender.pongReceived = time.Now()
+		if sender.is(nodePFail | nodeFail) {
+			log.Printf("Clear FAIL state for node %s: it is reachable again", sender.id)
+			sender.flags &^= nodePFail | nodeFail
+			c.configDirty = true
+		}
+	}
+
+	if role == "slave" {
+		if !sender.is(nodeReplica) {
+			sender.flags = sender.flags&^nodeMaster | nodeReplica
+			for slot := range c.slots {
+				if c.slots[slot] == sender {
+					c.slots[slot] = nil
+				}
+			}
+			c.configDirty = true
+		}
+		if sender.masterID != masterID {
+			sender.masterID = masterID
+			c.configDirty = true
+		}
+	} else if !sender.is(nodeMaster) {
+		sender.flags = sender.flags&^nodeReplica | nodeMaster
+		sender.masterID = ""
+		c.configDirty = true
+	}
+	if sender.configEpoch != configEpoch {
+		sender.configEpoch = configEpoch
+		c.configDirty = true
+	}
+	if sender.is(nodeMaster) {
+		c.updateSlots(sender, bitmap)
+		c.handleEpochCollision(sender)
+	}
+
+	switch typ {
+	case msgPublish:
+		if len(msg) >= clusterMessageHeader+2 {
+			c.dbs.pubsub.publish(string(msg[clusterMessageHeader]), msg[clusterMessageHeader+1])
+		}
+	case msgPublishShard:
+		if len(msg) >= clusterMessageHeader+2 {
+			c.dbs.shardPubsub.publish(string(msg[clusterMessageHeader]), msg[clusterMessageHeader+1])
+		}
+	case msgFail:
+		if len(msg) > clusterMessageHeader {
+			failing := c.nodes[string(msg[clusterMessageHeader])]
+			if failing != nil && failing != c.myself && !failing.is(nodeFail) {
+				log.Printf("FAIL message received from %s about %s", sender.id, failing.id)
+				failing.flags = failing.flags&^nodePFail | nodeFail
+				c.configDirty = true
+			}
+		}
+	default:
+		c.processGossip(sender, msg[clusterMessageHeader:])
+	}
+	c.updateState()
+}
+
+// updateSlots give to sender the slots it claims in bitmap, unless they are served by a node with a greater
+// config epoch or imported by the server. mu should be held.
+func (c *cluster) updateSlots(sender *clusterNode, bitmap []byte) {
+	for slot := 0; slot < clusterSlots; slot++ {
+		if bitmap[slot/8]&(1<<(slot%8)) == 0 {
+			continue
+		}
+		owner := c.slots[slot]
+		if owner == sender || c.importing[slot] != nil {
+			continue
+		}
+		if owner == nil || owner.configEpoch < sender.configEpoch {
+			if owner == c.myself {
+				log.Printf("Slot %d is now served by %s", slot, sender.id)
+				c.migrating[slot] = nil
+			}
+			c.slots[slot] = sender
+			c.configDirty = true
+		}
+	}
+}
+
+// handleEpochCollision give the server a new config epoch when it has the same as sender, so that the slot
+// conflicts can be solved. The node with the smaller id keeps its epoch. mu should be held.
+func (c *cluster) handleEpochCollision(sender *clusterNode) {
+	if sender.configEpoch != c.myself.configEpoch || !c.myself.is(nodeMaster) || c.myself.id < sender.id {
+		return
+	}
+	c.currentEpoch++
+	c.myself.configEpoch = c.currentEpoch
+	c.configDirty = true
+	log.Printf("configEpoch collision with node %s, configEpoch set to %d", sender.id, c.myself.configEpoch)
+}
+
+// processGossip apply the gossip section of a message: the failure reports of sender, and the nodes to meet
+// mu should be held
+func (c *cluster) processGossip(sender *clusterNode, gossip [][]byte) {
+	for i := 0; i+5 <= len(gossip); i += 5 {
+		id, ip := string(gossip[i]), string(gossip[i+1])
+		flags := string(gossip[i+4])
+		n := c.nodes[id]
+		if n == c.myself {
+			continue
+		}
+		if n != nil {
+			// only the masters report failures
+			if sender.is(nodeMaster) {
+				if strings.Contains(flags, "fail") {
+					n.failReports[sender.id] = time.Now()
+				} else {
+					delete(n.failReports, sender.id)
+				}
+			}
+			continue
+		}
+		if _, ok := c.forgotten[id]; ok || ip == "" || strings.Contains(flags, "fail") {
+			continue
+		}
+		port, err := strconv.Atoi(string(gossip[i+2]))
+		if err != nil {
+			continue
+		}
+		cport, err := strconv.Atoi(string(gossip[i+3]))
+		if err != nil {
+			continue
+		}
+		if c.startHandshake(ip, port, cport, false) {
+			log.Printf("Start handshake with node %s at %s:%d learned from %s", id, ip, cport, sender.id)
+		}
+	}
+}
+
+// failureReports return the number of masters which reported n as failing, mu should be held
+func (c *cluster) failureReports(n *clusterNode) int {
+	count := 0
+	for id, reported := range n.failReports {
+		if reporter, ok := c.nodes[id]; ok && reporter.is(nodeMaster) && time.Since(reported) <= c.nodeTimeout*2 {
+			count++
+		}
+	}
+	return count
+}
+
+// markFailing mark n as FAIL when a majority of the masters sees it failing, and tell the other nodes
+// mu should be held
+func (c *cluster) markFailing(n *clusterNode) {
+	if !n.is(nodePFail) || n.is(nodeFail) {
+		return
+	}
+	reports := c.failureReports(n)
+	if c.myself.is(nodeMaster) {
+		reports++
+	}
+	if reports < c.size()/2+1 {
+		return
+	}
+	log.Printf("Marking node %s as failing (quorum reached)", n.id)
+	n.flags = n.flags&^nodePFail | nodeFail
+	c.configDirty = true
+	c.broadcast(msgFail, []byte(n.id))
+}
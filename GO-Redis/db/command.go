@@ -3,7 +3,9 @@ package db
 import (
 	"GO-Redis/data"
 	"context"
+	"fmt"
 	"net"
+	"strconv"
 	"strings"
 )
 
@@ -11,15 +13,304 @@ type cmdBytes = [][]byte
 
 var cmdTable = make(map[string]*command)
 
+// cmdFlag describe how a command behaves, a command may have several flags
+type cmdFlag uint
+
+const (
+	// cmdWrite commands may modify the dataset, they are counted by the save rules and propagated to the aof and replicas
+	cmdWrite cmdFlag = 1 << iota
+	// cmdReadonly commands only read the dataset
+	cmdReadonly
+	// cmdDenyOOM commands may increase the memory usage
+	cmdDenyOOM
+	// cmdBlocking commands may block the client until a key is ready
+	cmdBlocking
+	// cmdAdmin commands manage the server
+	cmdAdmin
+	// cmdFast commands run in O(1) or O(log(N))
+	cmdFast
+	// cmdMovableKeys commands have keys at positions given by their arguments, see registerKeysFunc
+	cmdMovableKeys
+	// cmdAsking commands are served on a slot being imported in cluster mode, as if ASKING was sent before
+	cmdAsking
+	// cmdShardChannels commands have shard channels instead of keys, a replica serves them on the slots of its master
+	cmdShardChannels
+)
+
+// cmdFlagNames are the names of the flags in the order of their bits
+var cmdFlagNames = []string{"write", "readonly", "denyoom", "blocking", "admin", "fast", "movablekeys", "asking", "shardchannels"}
+
 type cmdExecutor func(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData
 type command struct {
 	Executor cmdExecutor
+	// Arity is the number of arguments including the command name, -N means at least N
+	Arity int
+	Flags cmdFlag
+	// FirstKey, LastKey and KeyStep give the positions of the keys, LastKey -1 means the last argument,
+	// -2 the one before it. A FirstKey of 0 means the command has no key.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// keysFunc return the positions of the keys of commands with cmdMovableKeys
+	keysFunc func(cmd [][]byte) []int
 }
 
-func RegisterCommand(cmdName string, executor cmdExecutor) {
+// RegisterCommand add a command to cmdTable with its arity, flags and the positions of its keys
+func RegisterCommand(cmdName string, executor cmdExecutor, arity int, flags cmdFlag, firstKey, lastKey, keyStep int) {
 	cmdTable[cmdName] = &command{
 		Executor: executor,
+		Arity:    arity,
+		Flags:    flags,
+		FirstKey: firstKey,
+		LastKey:  lastKey,
+		KeyStep:  keyStep,
+	}
+}
+
+// registerKeysFunc set how to find the keys of a command with cmdMovableKeys
+func registerKeysFunc(cmdName string, keysFunc func(cmd [][]byte) []int) {
+	cmdTable[cmdName].keysFunc = keysFunc
+}
+
+// numKeysFunc return a keysFunc for commands giving the number of their keys at index, followed by the keys
+// The positions before index in fixed are keys too, like the destination of ZUNIONSTORE
+func numKeysFunc(index int, fixed ...int) func(cmd [][]byte) []int {
+	return func(cmd [][]byte) []int {
+		res := append([]int(nil), fixed...)
+		if index >= len(cmd) {
+			return res
+		}
+		numKeys, err := strconv.Atoi(string(cmd[index]))
+		if err != nil || numKeys <= 0 {
+			return res
+		}
+		for i := index + 1; i <= index+numKeys && i < len(cmd); i++ {
+			res = append(res, i)
+		}
+		return res
+	}
+}
+
+// has report whether the command has the flag
+func (c *command) has(flag cmdFlag) bool {
+	return c.Flags&flag != 0
+}
+
+// checkArity report whether cmd has the number of arguments required by the command
+func (c *command) checkArity(cmd [][]byte) bool {
+	if c.Arity >= 0 {
+		return len(cmd) == c.Arity
+	}
+	return len(cmd) >= -c.Arity
+}
+
+// keyPositions return the positions of the keys in cmd
+func (c *command) keyPositions(cmd [][]byte) []int {
+	if c.keysFunc != nil {
+		return c.keysFunc(cmd)
+	}
+	if c.FirstKey <= 0 {
+		return nil
+	}
+	last := c.LastKey
+	if last < 0 {
+		last += len(cmd)
+	}
+	res := make([]int, 0, (last-c.FirstKey)/c.KeyStep+1)
+	for i := c.FirstKey; i <= last && i < len(cmd); i += c.KeyStep {
+		res = append(res, i)
+	}
+	return res
+}
+
+// keys return the keys in cmd
+func (c *command) keys(cmd [][]byte) []string {
+	positions := c.keyPositions(cmd)
+	res := make([]string, len(positions))
+	for i, pos := range positions {
+		res[i] = string(cmd[pos])
+	}
+	return res
+}
+
+// commandGroups are the groups of the commands shown by COMMAND DOCS, they are also their acl categories
+var commandGroups = map[string][]string{
+	"generic":    {"del", "exists", "keys", "expire", "expireat", "persist", "ttl", "rename", "move", "getver", "dump", "restore", "restore-asking", "migrate"},
+	"string":     {"set", "get", "getrange", "setrange", "mget", "mset", "setex", "setnx", "strlen", "incr", "incrby", "decr", "decrby", "incrbyfloat", "append", "getv", "delifeq"},
+	"list":       {"llen", "lindex", "lpos", "lpop", "rpop", "lpush", "lpushx", "rpush", "rpushx", "lpushifver", "rpushifver", "lset", "lrem", "ltrim", "lrange", "lmove", "rpoplpush", "blmove", "brpoplpush", "lmpop", "blmpop", "blpop", "brpop"},
+	"hash":       {"hset", "hmset", "hsetnx", "hget", "hmget", "hdel", "hexists", "hlen", "hstrlen", "hkeys", "hvals", "hgetall", "hincrby", "hincrbyfloat", "hrandfield", "hscan"},
+	"set":        {"sadd", "srem", "sismember", "smismember", "smembers", "scard", "spop", "srandmember", "smove", "sinter", "sunion", "sdiff", "sinterstore", "sunionstore", "sdiffstore", "sintercard"},
+	"sorted-set": {"zadd", "zincrby", "zrem", "zscore", "zmscore", "zcard", "zcount", "zlexcount", "zrank", "zrevrank", "zrange", "zrevrange", "zrangebyscore", "zrevrangebyscore", "zrangebylex", "zrevrangebylex", "zrangestore", "zremrangebyrank", "zremrangebyscore", "zremrangebylex", "zpopmin", "zpopmax", "bzpopmin", "bzpopmax", "zmpop", "bzmpop", "zunionstore", "zinterstore"},
+	"stream":     {"xadd", "xlen", "xrange", "xrevrange", "xdel", "xtrim", "xsetid", "xread"},
+	"connection": {"ping", "hello", "select"},
+	"server":     {"dbsize", "flushdb", "flushall", "swapdb", "save", "bgsave", "lastsave", "bgrewriteaof", "command", "replicaof", "slaveof", "psync", "sync", "replconf", "role", "wait"},
+	"cluster":    {"cluster", "asking", "readonly", "readwrite"},
+	"pubsub":     {"subscribe", "unsubscribe", "psubscribe", "punsubscribe", "publish", "pubsub", "ssubscribe", "sunsubscribe", "spublish"},
+}
+
+// groupCategories are the acl categories of the command groups
+var groupCategories = map[string]string{
+	"generic":    "@keyspace",
+	"string":     "@string",
+	"list":       "@list",
+	"hash":       "@hash",
+	"set":        "@set",
+	"sorted-set": "@sortedset",
+	"stream":     "@stream",
+	"connection": "@connection",
+	"pubsub":     "@pubsub",
+}
+
+// commandGroup return the group of the command, empty if it is unknown
+func commandGroup(cmdName string) string {
+	for group, names := range commandGroups {
+		for _, name := range names {
+			if name == cmdName {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+// flagNames return the names of the flags of the command
+func (c *command) flagNames() []string {
+	res := make([]string, 0)
+	for i, name := range cmdFlagNames {
+		if c.Flags&(1<<i) != 0 {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// aclCategories return the acl categories of the command derived from its flags and group
+func (c *command) aclCategories(cmdName string) []string {
+	res := make([]string, 0)
+	if c.has(cmdWrite) {
+		res = append(res, "@write")
+	}
+	if c.has(cmdReadonly) {
+		res = append(res, "@read")
+	}
+	if category, ok := groupCategories[commandGroup(cmdName)]; ok {
+		res = append(res, category)
+	}
+	if c.has(cmdAdmin) {
+		res = append(res, "@admin", "@dangerous")
+	}
+	if c.has(cmdFast) {
+		res = append(res, "@fast")
+	} else {
+		res = append(res, "@slow")
+	}
+	if c.has(cmdBlocking) {
+		res = append(res, "@blocking")
+	}
+	return res
+}
+
+// ExecCommand find the executor of cmd in cmdTable and run it on db
+// cmd[0] is the command name and is case-insensitive
+func (db *DB) ExecCommand(ctx context.Context, cmd [][]byte, conn net.Conn) data.RedisData {
+	if len(cmd) == 0 {
+		return nil
+	}
+	c, errRes := lookupCommand(cmd)
+	if errRes != nil {
+		return errRes
+	}
+	if errRes := rejectInSubscribedMode(cmd, conn); errRes != nil {
+		return errRes
+	}
+	if db.dbs != nil && db.dbs.cluster != nil {
+		// the commands of the master and of the aof are not redirected
+		if client, ok := conn.(*Client); ok && client.Conn != nil && !client.master {
+			if res := db.dbs.cluster.redirect(c, c.keys(cmd), client); res != nil {
+				return res
+			}
+		}
+	}
+	if c.has(cmdBlocking) {
+		// the replies of the commands pipelined before must not wait for the client to be unblocked
+		if client, ok := conn.(*Client); ok && client.Conn != nil {
+			if err := client.Flush(); err != nil {
+				return nil
+			}
+		}
+	}
+	if c.has(cmdWrite) && db.dbs != nil {
+		if db.dbs.repl.rejectWrite(conn) {
+			return data.MakeErrorData(errReadOnlyReplica)
+		}
+		defer db.dbs.lockWrite()()
+		db.dbs.beforeWrite(db, writtenKeys(c, cmd))
+	}
+	return db.execCommand(ctx, c, cmd, conn)
+}
+
+// lockWrite take writeMu for a write command and return the function releasing it
+// The writes are serialized only while they are propagated, otherwise they share writeMu and run in parallel.
+// Propagation is started with writeMu held exclusively, so it never starts in the middle of a shared write.
+func (dbs *Databases) lockWrite() func() {
+	dbs.writeMu.RLock()
+	if !dbs.propagating() {
+		return dbs.writeMu.RUnlock
+	}
+	dbs.writeMu.RUnlock()
+	dbs.writeMu.Lock()
+	dbs.serialized = true
+	return func() {
+		dbs.serialized = false
+		dbs.writeMu.Unlock()
+	}
+}
+
+// releaseWrite let the other writes run while a write command is blocked, it returns the function taking writeMu back
+// serialized is only written with writeMu held exclusively, so a shared holder reads it safely.
+func (dbs *Databases) releaseWrite() func() {
+	if !dbs.serialized {
+		dbs.writeMu.RUnlock()
+		return dbs.writeMu.RLock
+	}
+	dbs.serialized = false
+	dbs.writeMu.Unlock()
+	return func() {
+		dbs.writeMu.Lock()
+		dbs.serialized = true
+	}
+}
+
+// lookupCommand return the command of cmd, or the error reply if it is unknown or has a wrong number of arguments
+func lookupCommand(cmd [][]byte) (*command, data.RedisData) {
+	cmdName := strings.ToLower(string(cmd[0]))
+	c, ok := cmdTable[cmdName]
+	if !ok {
+		return nil, data.MakeErrorData(fmt.Sprintf("ERR unknown command '%s'", string(cmd[0])))
+	}
+	if !c.checkArity(cmd) {
+		return nil, data.MakeWrongNumberArgs(cmdName)
+	}
+	return c, nil
+}
+
+// execCommand run c and propagate it if it is a write, writeMu should be held for a write command, see lockWrite
+func (db *DB) execCommand(ctx context.Context, c *command, cmd [][]byte, conn net.Conn) data.RedisData {
+	if c.has(cmdReadonly) {
+		db.notifyKeyMisses(c, cmd)
+	}
+	res := c.Executor(ctx, db, cmd, conn)
+	if c.has(cmdWrite) && db.dbs != nil {
+		if _, isErr := res.(*data.ErrorData); !isErr {
+			db.dbs.AddDirty(1)
+			// blocking commands are propagated by blockPop when they pop
+			if !c.has(cmdBlocking) {
+				db.propagate(cmd, res)
+			}
+		}
+		db.dbs.flushPropagated()
 	}
+	return res
 }
 
 func MakeCommandBytes(input string) cmdBytes {
@@ -21,6 +21,14 @@ type ConcurrentMap struct {
 	table []*shard
 	size  int
 	count int64
+	// index is told the keys added and deleted, nil if the map has no index
+	index keyIndex
+}
+
+// keyIndex is kept up to date with the keys of a ConcurrentMap, it is called with the shard of the key locked
+type keyIndex interface {
+	add(key string)
+	remove(key string)
 }
 
 func NewConcurrentMap(size int) *ConcurrentMap {
@@ -53,8 +61,11 @@ func (m *ConcurrentMap) Set(key string, value any) int {
 	defer shard.rwMu.Unlock()
 
 	if _, OK := shard.item[key]; OK == false {
-		m.count++
+		atomic.AddInt64(&m.count, 1)
 		added = 1
+		if m.index != nil {
+			m.index.add(key)
+		}
 	}
 	shard.item[key] = value
 	return added
@@ -80,8 +91,11 @@ func (m *ConcurrentMap) SetIfNotExist(key string, value any) int {
 	defer shard.rwMu.Unlock()
 
 	if _, OK := shard.item[key]; OK == false {
-		m.count++
+		atomic.AddInt64(&m.count, 1)
 		shard.item[key] = value
+		if m.index != nil {
+			m.index.add(key)
+		}
 		return 1
 	}
 	return 0
@@ -107,7 +121,10 @@ func (m *ConcurrentMap) Delete(key string) bool {
 
 	if _, OK := shard.item[key]; OK == true {
 		delete(shard.item, key)
-		m.count--
+		atomic.AddInt64(&m.count, -1)
+		if m.index != nil {
+			m.index.remove(key)
+		}
 		return true
 	} else {
 		return false
@@ -118,19 +135,44 @@ func (m *ConcurrentMap) Len() int64 {
 	return atomic.LoadInt64(&m.count)
 }
 
+// Clear remove all items shard by shard, readers always see a valid table
 func (m *ConcurrentMap) Clear() {
-	*m = *NewConcurrentMap(m.size)
+	for _, shard := range m.table {
+		shard.rwMu.Lock()
+		atomic.AddInt64(&m.count, -int64(len(shard.item)))
+		if m.index != nil {
+			for key := range shard.item {
+				m.index.remove(key)
+			}
+		}
+		shard.item = make(map[string]any)
+		shard.rwMu.Unlock()
+	}
+}
+
+// setIndex keep index up to date with the keys of the map, the keys already stored are added to it
+func (m *ConcurrentMap) setIndex(index keyIndex) {
+	for _, shard := range m.table {
+		shard.rwMu.Lock()
+	}
+	for _, shard := range m.table {
+		for key := range shard.item {
+			index.add(key)
+		}
+	}
+	m.index = index
+	for _, shard := range m.table {
+		shard.rwMu.Unlock()
+	}
 }
 
 // Keys return all stored keys in the concurrent map
 func (m *ConcurrentMap) Keys() []string {
-	keys := make([]string, m.count)
-	i := 0
+	keys := make([]string, 0, m.Len())
 	for _, shard := range m.table {
 		shard.rwMu.RLock()
 		for key := range shard.item {
-			keys[i] = key
-			i++
+			keys = append(keys, key)
 		}
 		shard.rwMu.RUnlock()
 	}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// implements the connection commands of redis
+
+const (
+	serverName    = "redis"
+	serverVersion = "7.0.0"
+)
+
+func RegisterConnectionCommands() {
+	RegisterCommand("hello", helloConnection, -1, cmdFast, 0, 0, 0)
+	RegisterCommand("select", selectConnection, 2, cmdFast, 0, 0, 0)
+}
+
+// selectConnection change the db used by the following commands of the connection
+func selectConnection(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "select" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok {
+		return data.MakeErrorData("ERR SELECT is only available on client connections")
+	}
+	index, err := strconv.Atoi(string(cmd[1]))
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	if index != 0 && db.dbs.cluster != nil {
+		return data.MakeErrorData("ERR SELECT is not allowed in cluster mode")
+	}
+	if db.dbs.Get(index) == nil {
+		return data.MakeErrorData("ERR DB index is out of range")
+	}
+	client.dbIndex = index
+	return data.MakeStringData("OK")
+}
+
+// helloConnection switch the protocol version of the connection and reply the server information
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloConnection(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hello" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok {
+		return data.MakeErrorData("ERR HELLO is only available on client connections")
+	}
+
+	protocol := client.protocol
+	if len(cmd) > 1 {
+		ver, err := strconv.Atoi(string(cmd[1]))
+		if err != nil {
+			return data.MakeErrorData("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != data.RESP2 && ver != data.RESP3 {
+			return data.MakeErrorData("NOPROTO sorry, this protocol version is not supported.")
+		}
+		protocol = ver
+	}
+
+	var name string
+	var setName bool
+	for i := 2; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "auth":
+			// there is no password configured, the default user accepts any password
+			if i+2 >= len(cmd) {
+				return data.MakeErrorData("ERR Syntax error in HELLO option 'auth'")
+			}
+			i += 2
+		case "setname":
+			if i+1 >= len(cmd) {
+				return data.MakeErrorData("ERR Syntax error in HELLO option 'setname'")
+			}
+			i++
+			name = string(cmd[i])
+			if strings.ContainsAny(name, " \n") {
+				return data.MakeErrorData("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			setName = true
+		default:
+			return data.MakeErrorData("ERR Syntax error in HELLO option '" + string(cmd[i]) + "'")
+		}
+	}
+
+	client.protocol = protocol
+	if setName {
+		client.name = name
+	}
+
+	// the mode and the role follow the cluster and replication state of the server
+	mode, role := "standalone", "master"
+	if db.dbs != nil {
+		if db.dbs.cluster != nil {
+			mode = "cluster"
+		}
+		if db.dbs.repl.isReplica() {
+			role = "replica"
+		}
+	}
+	return data.MakeMapData().
+		Add(data.MakeBulkData([]byte("server")), data.MakeBulkData([]byte(serverName))).
+		Add(data.MakeBulkData([]byte("version")), data.MakeBulkData([]byte(serverVersion))).
+		Add(data.MakeBulkData([]byte("proto")), data.MakeIntData(int64(protocol))).
+		Add(data.MakeBulkData([]byte("id")), data.MakeIntData(client.id)).
+		Add(data.MakeBulkData([]byte("mode")), data.MakeBulkData([]byte(mode))).
+		Add(data.MakeBulkData([]byte("role")), data.MakeBulkData([]byte(role))).
+		Add(data.MakeBulkData([]byte("modules")), data.MakeEmptyArrayData())
+}
@@ -3,6 +3,8 @@ package db
 import (
 	"GO-Redis/config"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,6 +12,46 @@ type DB struct {
 	db      *ConcurrentMap
 	ttlKeys *ConcurrentMap
 	locks   *Locks
+	// versions keep the version of each key, see touchKey
+	versions *ConcurrentMap
+	// index is the number of this db used by SELECT, dbs is the group it belongs to
+	index int
+	dbs   *Databases
+	// id is the index the db was created at, unlike index it is not changed by SWAPDB
+	id int
+}
+
+// Databases hold all logical databases of the server
+// A client selects one of them by index and its commands are executed on it
+type Databases struct {
+	dbs []*DB
+	mu  sync.RWMutex
+	// persist is the rdb saving state
+	persist persistence
+	// aof is the append only file, nil if it is disabled
+	aof *aof
+	// writeMu is held by the write commands, exclusively while they are propagated so that they are propagated
+	// in the same order as they are applied, shared otherwise. pending is guarded by it.
+	// serialized is set while a write command holds it exclusively.
+	writeMu    sync.RWMutex
+	serialized bool
+	pending    []propagatedCommand
+	// repl is the replication state
+	repl *replication
+	// version is the last version given to a key of any db
+	version atomic.Int64
+	// cluster is the cluster state, nil if the cluster mode is disabled
+	cluster *cluster
+	pubsub  *pubSub
+	// shardPubsub is the registry of the shard channels
+	shardPubsub *shardPubSub
+	// notifyFlags are the classes of keyspace events to publish, see notify-keyspace-events
+	notifyFlags int
+	// blocking record the clients blocked on keys of each db index
+	blocking []*blockingKeys
+	// dumps are the dumps of the dataset running for a background save, rewrite or full resync, see datasetDump
+	dumpsMu sync.Mutex
+	dumps   map[*datasetDump]struct{}
 }
 
 type TTLInfo struct {
@@ -27,12 +69,106 @@ type TTLInfo struct {
 // locks is used to lock a key for db to ensure some atomic operations
 func NewDB() *DB {
 	return &DB{
-		db:      NewConcurrentMap(config.Configures.ShardNumber),
-		ttlKeys: NewConcurrentMap(config.Configures.ShardNumber),
-		locks:   NewLocks(config.Configures.ShardNumber * 2),
+		db:       NewConcurrentMap(config.Configures.ShardNumber),
+		ttlKeys:  NewConcurrentMap(config.Configures.ShardNumber),
+		locks:    NewLocks(config.Configures.ShardNumber * 2),
+		versions: NewConcurrentMap(config.Configures.ShardNumber),
+	}
+}
+
+// NewDatabases create num empty logical databases indexed from 0
+func NewDatabases(num int) *Databases {
+	dbs := &Databases{
+		dbs:         make([]*DB, num),
+		blocking:    make([]*blockingKeys, num),
+		repl:        newReplication(),
+		pubsub:      newPubSub(),
+		shardPubsub: newShardPubSub(config.Configures.ShardNumber),
+	}
+	dbs.notifyFlags, _ = parseNotifyKeyspaceEvents(config.Configures.NotifyKeyspaceEvents)
+	for i := 0; i < num; i++ {
+		db := NewDB()
+		db.index = i
+		db.id = i
+		db.dbs = dbs
+		dbs.dbs[i] = db
+		dbs.blocking[i] = newBlockingKeys(i)
+	}
+	return dbs
+}
+
+// Get return the db at index, nil if the index is out of range
+func (dbs *Databases) Get(index int) *DB {
+	dbs.mu.RLock()
+	defer dbs.mu.RUnlock()
+	if index < 0 || index >= len(dbs.dbs) {
+		return nil
+	}
+	return dbs.dbs[index]
+}
+
+func (dbs *Databases) Len() int {
+	return len(dbs.dbs)
+}
+
+// Swap exchange the dbs at index i and j, clients selecting i will see the data of j and vice versa
+// The clients blocked on i and j stay there, they are served at once if the swapped db has the keys they wait for
+func (dbs *Databases) Swap(i, j int) {
+	dbs.mu.Lock()
+	dbs.dbs[i], dbs.dbs[j] = dbs.dbs[j], dbs.dbs[i]
+	dbs.dbs[i].index = i
+	dbs.dbs[j].index = j
+	dbs.mu.Unlock()
+
+	for _, index := range []int{i, j} {
+		db := dbs.Get(index)
+		for _, key := range dbs.blocking[index].keys() {
+			db.signalKeyReady(key)
+		}
 	}
 }
 
+// Index return the number of the db used by SELECT
+func (db *DB) Index() int {
+	if db.dbs == nil {
+		return db.index
+	}
+	db.dbs.mu.RLock()
+	defer db.dbs.mu.RUnlock()
+	return db.index
+}
+
+// Flush delete all keys of db and cancel their TTL tasks
+func (db *DB) Flush() {
+	db.locks.LockAll()
+	defer db.locks.UnLockAll()
+	for _, ttl := range db.ttlKeys.KeyValues() {
+		close(ttl.(*TTLInfo).cancel)
+	}
+	db.ttlKeys.Clear()
+	db.db.Clear()
+	db.versions.Clear()
+}
+
+// setKey store val in key and give key a new version, the new event is fired if key did not exist
+// The caller should hold the lock of key.
+func (db *DB) setKey(key string, val any) {
+	if db.db.Set(key, val) == 1 {
+		db.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	db.touchKey(key)
+}
+
+// removeKey delete key and its version, return true if it existed
+// The caller should hold the lock of key.
+func (db *DB) removeKey(key string) bool {
+	if !db.db.Delete(key) {
+		return false
+	}
+	db.versions.Delete(key)
+	return true
+}
+
 // CheckTTL check ttl keys and delete expired keys
 // return false if key is expired, else true.
 // Attention: Don't lock this function because it has called locks.Lock(key) for atomic deleting expired key.
@@ -50,8 +186,13 @@ func (db *DB) CheckTTL(key string) bool {
 	// if it should expire
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
-	db.db.Delete(key)
+	deleted := db.db.Delete(key)
 	db.ttlKeys.Delete(key)
+	db.versions.Delete(key)
+	// the key may have been deleted by another check meanwhile
+	if deleted {
+		db.notifyKeyspaceEvent(notifyExpired, "expired", key)
+	}
 	return false
 }
 
@@ -110,6 +110,19 @@ func (lock *Locks) UnLockMulti(keys []string) {
 	}
 }
 
+// LockAll lock every key of the db, the locks are taken in the same order as LockMulti
+func (lock *Locks) LockAll() {
+	for _, l := range lock.locks {
+		l.Lock()
+	}
+}
+
+func (lock *Locks) UnLockAll() {
+	for _, l := range lock.locks {
+		l.Unlock()
+	}
+}
+
 func (lock *Locks) RLockMulti(keys []string) {
 	positions := lock.sortedLockPositions(keys)
 	if positions == nil {
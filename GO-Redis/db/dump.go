@@ -0,0 +1,364 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// implements DUMP, RESTORE and MIGRATE
+// A dump payload is the value in the rdb format: its type and its encoding, followed by the rdb version as 2
+// bytes and the crc64 of all bytes before as 8 bytes, both little endian. So payloads can be exchanged with redis.
+
+var errBadDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+// dumpValue return the dump payload of val, the lock of its key should be held
+func dumpValue(val any) ([]byte, error) {
+	t, ok := rdbObjectType(val)
+	if !ok {
+		return nil, fmt.Errorf("ERR can not dump a value of type %T", val)
+	}
+	e := &rdbEncoder{}
+	e.writeByte(t)
+	e.writeObject(val)
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, rdbVersion)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, rdbChecksum(0, e.buf))
+	return e.buf, nil
+}
+
+// loadDumpPayload check the version and the checksum of payload and return its value
+func loadDumpPayload(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, errBadDumpPayload
+	}
+	body, footer := payload[:len(payload)-10], payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbMaxVersion ||
+		binary.LittleEndian.Uint64(footer[2:]) != rdbChecksum(0, payload[:len(payload)-8]) {
+		return nil, errBadDumpPayload
+	}
+	d := &rdbDecoder{buf: body}
+	t, err := d.readByte()
+	if err != nil {
+		return nil, errors.New("ERR Bad data format")
+	}
+	val, err := d.readObject(t)
+	if err != nil || d.pos != len(body) {
+		return nil, errors.New("ERR Bad data format")
+	}
+	return val, nil
+}
+
+// dumpKey return the dump payload of the value of a key, nil if the key does not exist
+// DUMP key
+func dumpKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "dump" {
+		return data.MakeErrorData("Server Error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeBulkData(nil)
+	}
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+	val, ok := db.db.Get(key)
+	if !ok {
+		return data.MakeBulkData(nil)
+	}
+	payload, err := dumpValue(val)
+	if err != nil {
+		return data.MakeErrorData(err.Error())
+	}
+	return data.MakeBulkData(payload)
+}
+
+// restoreKey create a key from a dump payload, ttl is in milliseconds and 0 means no ttl
+// With ABSTTL the ttl is a unix time in milliseconds. IDLETIME and FREQ are checked but not used, as the keys
+// are not evicted.
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func restoreKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "restore" && cmdName != "restore-asking" {
+		return data.MakeErrorData("Server Error")
+	}
+	key := string(cmd[1])
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	var replace, absTTL, idleTime, freq bool
+	for i := 4; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime":
+			if i+1 >= len(cmd) || freq {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			i++
+			if v, err := strconv.ParseInt(string(cmd[i]), 10, 64); err != nil || v < 0 {
+				return data.MakeErrorData("ERR Invalid IDLETIME value, must be >= 0")
+			}
+			idleTime = true
+		case "freq":
+			if i+1 >= len(cmd) || idleTime {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			i++
+			if v, err := strconv.ParseInt(string(cmd[i]), 10, 64); err != nil || v < 0 || v > 255 {
+				return data.MakeErrorData("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			freq = true
+		default:
+			return data.MakeErrorData("ERR syntax error")
+		}
+	}
+	if ttl < 0 {
+		return data.MakeErrorData("ERR Invalid TTL value, must be >= 0")
+	}
+	val, err := loadDumpPayload(cmd[3])
+	if err != nil {
+		return data.MakeErrorData(err.Error())
+	}
+
+	db.CheckTTL(key)
+	defer db.signalKeyReady(key)
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+	if _, ok := db.db.Get(key); ok && !replace {
+		return data.MakeErrorData("BUSYKEY Target key name already exists.")
+	}
+	var expireAt int64
+	if ttl > 0 {
+		now := time.Now().UnixMilli()
+		if !absTTL {
+			ttl += now
+		}
+		// a key restored with a ttl in the past is expired at once
+		if ttl <= now {
+			if db.removeKey(key) {
+				db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+			}
+			db.DeleteTTL(key)
+			return data.MakeStringData("OK")
+		}
+		// the ttls are kept in seconds, rounded up so that the key does not expire before its ttl
+		expireAt = (ttl + 999) / 1000
+	}
+	db.setKey(key, val)
+	db.DeleteTTL(key)
+	if expireAt > 0 {
+		db.SetTTL(key, expireAt)
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, "restore", key)
+	return data.MakeStringData("OK")
+}
+
+// migrateKeyPositions return the positions of the keys of MIGRATE, the key or the keys after KEYS
+func migrateKeyPositions(cmd [][]byte) []int {
+	if len(cmd) < 6 {
+		return nil
+	}
+	if len(cmd[3]) > 0 {
+		return []int{3}
+	}
+	for i := 6; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "auth":
+			i++
+		case "auth2":
+			i += 2
+		case "keys":
+			res := make([]int, 0, len(cmd)-i-1)
+			for j := i + 1; j < len(cmd); j++ {
+				res = append(res, j)
+			}
+			return res
+		}
+	}
+	return nil
+}
+
+// isCopyMigrate report whether MIGRATE has the COPY option
+func isCopyMigrate(cmd [][]byte) bool {
+	for i := 6; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "copy":
+			return true
+		case "auth":
+			i++
+		case "auth2":
+			i += 2
+		case "keys":
+			return false
+		}
+	}
+	return false
+}
+
+// migrateItem is a key sent by MIGRATE
+type migrateItem struct {
+	key     string
+	payload []byte
+	// ttl is the remaining ttl in milliseconds, 0 if the key has none
+	ttl int64
+}
+
+// migrateKeys move keys to the db of another instance with RESTORE. The keys are deleted once the target restored
+// all of them, they are kept if it replied an error for one. With COPY they are never deleted, and with REPLACE the
+// keys of the target are replaced. timeout is the max milliseconds of each io with the target.
+// MIGRATE host port key | "" destination-db timeout [COPY] [REPLACE] [AUTH password | AUTH2 username password] [KEYS key [key ...]]
+func migrateKeys(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "migrate" {
+		return data.MakeErrorData("Server Error")
+	}
+	dbIndex, err := strconv.Atoi(string(cmd[4]))
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(string(cmd[5]), 10, 64)
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	var copyKeys, replace bool
+	var auth [][]byte
+	keys := []string{string(cmd[3])}
+	for i := 6; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			auth = [][]byte{[]byte("auth"), cmd[i+1]}
+			i++
+		case "auth2":
+			if i+2 >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			auth = [][]byte{[]byte("auth"), cmd[i+1], cmd[i+2]}
+			i += 2
+		case "keys":
+			if len(cmd[3]) != 0 {
+				return data.MakeErrorData("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = keys[:0]
+			for _, key := range cmd[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(cmd)
+		default:
+			return data.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	for _, key := range keys {
+		db.CheckTTL(key)
+	}
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+	now := time.Now().UnixMilli()
+	items := make([]migrateItem, 0, len(keys))
+	for _, key := range keys {
+		val, ok := db.db.Get(key)
+		if !ok {
+			continue
+		}
+		payload, err := dumpValue(val)
+		if err != nil {
+			return data.MakeErrorData(err.Error())
+		}
+		item := migrateItem{key: key, payload: payload}
+		if at := db.expireAt(key); at > 0 {
+			item.ttl = max(at*1000-now, 1)
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return data.MakeStringData("NOKEY")
+	}
+
+	// the target imports the slot of the keys while they are migrated in cluster mode
+	restore := []byte("restore")
+	if db.dbs.cluster != nil {
+		restore = []byte("restore-asking")
+	}
+	buf := make([]byte, 0)
+	if auth != nil {
+		buf = appendRESPCommand(buf, auth)
+	}
+	buf = appendRESPCommand(buf, [][]byte{[]byte("select"), []byte(strconv.Itoa(dbIndex))})
+	for _, item := range items {
+		args := [][]byte{restore, []byte(item.key), []byte(strconv.FormatInt(item.ttl, 10)), item.payload}
+		if replace {
+			args = append(args, []byte("replace"))
+		}
+		buf = appendRESPCommand(buf, args)
+	}
+
+	ioTimeout := time.Duration(timeout) * time.Millisecond
+	target, err := net.DialTimeout("tcp", net.JoinHostPort(string(cmd[1]), string(cmd[2])), ioTimeout)
+	if err != nil {
+		return data.MakeErrorData("IOERR error or timeout connecting to the client")
+	}
+	defer func() {
+		_ = target.Close()
+	}()
+	_ = target.SetWriteDeadline(time.Now().Add(ioTimeout))
+	if _, err = target.Write(buf); err != nil {
+		return data.MakeErrorData("IOERR error or timeout writing to target instance")
+	}
+
+	parser := data.NewParser(bufio.NewReader(target))
+	// readReply return the error reply of MIGRATE if the target replied an error, and whether it is an io error
+	readReply := func() (data.RedisData, bool) {
+		_ = target.SetReadDeadline(time.Now().Add(ioTimeout))
+		res, err := parser.ReadValue()
+		if err != nil {
+			return data.MakeErrorData("IOERR error or timeout reading to target instance"), true
+		}
+		if errData, ok := res.(*data.ErrorData); ok {
+			return data.MakeErrorData("ERR Target instance replied with error: " + errData.String()), false
+		}
+		return nil, false
+	}
+	replies := len(items) + 1
+	if auth != nil {
+		replies++
+	}
+	var firstErr data.RedisData
+	for i := 0; i < replies; i++ {
+		errRes, ioErr := readReply()
+		if ioErr {
+			return errRes
+		}
+		if firstErr == nil {
+			firstErr = errRes
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if !copyKeys {
+		for _, item := range items {
+			db.removeKey(item.key)
+			db.DeleteTTL(item.key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", item.key)
+		}
+	}
+	return data.MakeStringData("OK")
+}
@@ -0,0 +1,614 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// getHash return the hash stored in key, nil if key does not exist
+// the caller should hold the lock of key
+func getHash(db *DB, key string) (*Hash, data.RedisData) {
+	tem, ok := db.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	hash, ok := tem.(*Hash)
+	if !ok {
+		return nil, data.MakeWrongType()
+	}
+	return hash, nil
+}
+
+// getOrCreateHash return the hash stored in key and create an empty one if key does not exist
+// the caller should hold the lock of key
+func getOrCreateHash(db *DB, key string) (*Hash, data.RedisData) {
+	hash, err := getHash(db, key)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		hash = NewHash()
+		db.setKey(key, hash)
+	}
+	return hash, nil
+}
+
+// removeEmptyHash delete key when its hash has no field
+func removeEmptyHash(db *DB, key string, hash *Hash) {
+	if hash != nil && hash.Len() == 0 {
+		db.removeKey(key)
+		db.DeleteTTL(key)
+	}
+}
+
+func hSetHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "hset" && cmdName != "hmset" {
+		log.Printf("hSetHash Function: cmdName is not hset or hmset")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd)&1 != 0 {
+		return data.MakeWrongNumberArgs(cmdName)
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	hash, err := getOrCreateHash(db, key)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for i := 2; i < len(cmd); i += 2 {
+		if hash.Set(string(cmd[i]), cmd[i+1]) {
+			count++
+		}
+	}
+	db.touchKey(key)
+
+	// HMSET is the deprecated form of HSET and replies OK
+	if cmdName == "hmset" {
+		return data.MakeStringData("OK")
+	}
+	return data.MakeIntData(int64(count))
+}
+
+func hSetNxHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hsetnx" {
+		log.Printf("hSetNxHash Function: cmdName is not hsetnx")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	hash, err := getOrCreateHash(db, key)
+	if err != nil {
+		return err
+	}
+
+	field := string(cmd[2])
+	if hash.Exist(field) {
+		return data.MakeIntData(0)
+	}
+	hash.Set(field, cmd[3])
+	db.touchKey(key)
+	return data.MakeIntData(1)
+}
+
+func hGetHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hget" {
+		log.Printf("hGetHash Function: cmdName is not hget")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeBulkData(nil)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		return data.MakeBulkData(nil)
+	}
+
+	val, ok := hash.Get(string(cmd[2]))
+	if !ok {
+		return data.MakeBulkData(nil)
+	}
+	return data.MakeBulkData(val)
+}
+
+func hMGetHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hmget" {
+		log.Printf("hMGetHash Function: cmdName is not hmget")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+
+	res := make([]data.RedisData, 0, len(cmd)-2)
+	for _, field := range cmd[2:] {
+		if hash == nil {
+			res = append(res, data.MakeBulkData(nil))
+			continue
+		}
+		val, ok := hash.Get(string(field))
+		if !ok {
+			res = append(res, data.MakeBulkData(nil))
+		} else {
+			res = append(res, data.MakeBulkData(val))
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+func hDelHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hdel" {
+		log.Printf("hDelHash Function: cmdName is not hdel")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		return data.MakeIntData(0)
+	}
+	defer removeEmptyHash(db, key, hash)
+
+	count := 0
+	for _, field := range cmd[2:] {
+		if hash.Del(string(field)) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.touchKey(key)
+	}
+	return data.MakeIntData(int64(count))
+}
+
+func hExistsHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hexists" {
+		log.Printf("hExistsHash Function: cmdName is not hexists")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+	if hash == nil || !hash.Exist(string(cmd[2])) {
+		return data.MakeIntData(0)
+	}
+	return data.MakeIntData(1)
+}
+
+func hLenHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hlen" {
+		log.Printf("hLenHash Function: cmdName is not hlen")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		return data.MakeIntData(0)
+	}
+	return data.MakeIntData(int64(hash.Len()))
+}
+
+func hStrLenHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hstrlen" {
+		log.Printf("hStrLenHash Function: cmdName is not hstrlen")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		return data.MakeIntData(0)
+	}
+	val, _ := hash.Get(string(cmd[2]))
+	return data.MakeIntData(int64(len(val)))
+}
+
+// hKeysHash implements HKEYS, HVALS and HGETALL which all return the whole hash
+func hKeysHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "hkeys" && cmdName != "hvals" && cmdName != "hgetall" {
+		log.Printf("hKeysHash Function: cmdName is not hkeys, hvals or hgetall")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, err := getHash(db, key)
+	if err != nil {
+		return err
+	}
+
+	switch cmdName {
+	case "hgetall":
+		// a map for RESP3 clients, flattened to field value pairs for RESP2 clients
+		res := data.MakeMapData()
+		if hash != nil {
+			for field, val := range hash.Table() {
+				res.Add(data.MakeBulkData([]byte(field)), data.MakeBulkData(val))
+			}
+		}
+		return res
+	case "hkeys":
+		if hash == nil {
+			return data.MakeEmptyArrayData()
+		}
+		res := make([]data.RedisData, 0, hash.Len())
+		for _, field := range hash.Keys() {
+			res = append(res, data.MakeBulkData([]byte(field)))
+		}
+		return data.MakeArrayData(res)
+	default:
+		if hash == nil {
+			return data.MakeEmptyArrayData()
+		}
+		res := make([]data.RedisData, 0, hash.Len())
+		for _, val := range hash.Values() {
+			res = append(res, data.MakeBulkData(val))
+		}
+		return data.MakeArrayData(res)
+	}
+}
+
+func hIncrByHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hincrby" {
+		log.Printf("hIncrByHash Function: cmdName is not hincrby")
+		return data.MakeErrorData("server error")
+	}
+	incr, err := strconv.ParseInt(string(cmd[3]), 10, 64)
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	hash, errData := getOrCreateHash(db, key)
+	if errData != nil {
+		return errData
+	}
+
+	field := string(cmd[2])
+	var intVal int64
+	if val, ok := hash.Get(field); ok {
+		intVal, err = strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			removeEmptyHash(db, key, hash)
+			return data.MakeErrorData("ERR hash value is not an integer")
+		}
+	}
+	if (incr > 0 && intVal > math.MaxInt64-incr) || (incr < 0 && intVal < math.MinInt64-incr) {
+		removeEmptyHash(db, key, hash)
+		return data.MakeErrorData("ERR increment or decrement would overflow")
+	}
+
+	intVal += incr
+	hash.Set(field, []byte(strconv.FormatInt(intVal, 10)))
+	db.touchKey(key)
+	return data.MakeIntData(intVal)
+}
+
+func hIncrByFloatHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hincrbyfloat" {
+		log.Printf("hIncrByFloatHash Function: cmdName is not hincrbyfloat")
+		return data.MakeErrorData("server error")
+	}
+	incr, err := strconv.ParseFloat(string(cmd[3]), 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		return data.MakeErrorData("ERR value is not a valid float")
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	hash, errData := getOrCreateHash(db, key)
+	if errData != nil {
+		return errData
+	}
+
+	field := string(cmd[2])
+	var floatVal float64
+	if val, ok := hash.Get(field); ok {
+		floatVal, err = strconv.ParseFloat(string(val), 64)
+		if err != nil {
+			removeEmptyHash(db, key, hash)
+			return data.MakeErrorData("ERR hash value is not a float")
+		}
+	}
+
+	floatVal += incr
+	if math.IsNaN(floatVal) || math.IsInf(floatVal, 0) {
+		removeEmptyHash(db, key, hash)
+		return data.MakeErrorData("ERR increment would produce NaN or Infinity")
+	}
+
+	res := []byte(strconv.FormatFloat(floatVal, 'f', -1, 64))
+	hash.Set(field, res)
+	db.touchKey(key)
+	return data.MakeBulkData(res)
+}
+
+// maxRandomCount is the greatest number of elements a negative count of HRANDFIELD or SRANDMEMBER can ask for,
+// the reply is built in memory so a greater count is refused instead of exhausting it
+const maxRandomCount = 1 << 20
+
+// hRandFieldHash return random fields of a hash
+// HRANDFIELD key [count [WITHVALUES]]
+func hRandFieldHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hrandfield" {
+		log.Printf("hRandFieldHash Function: cmdName is not hrandfield")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd) > 4 {
+		return data.MakeWrongNumberArgs("hrandfield")
+	}
+
+	var count int
+	var err error
+	withCount := len(cmd) >= 3
+	withValues := false
+	if withCount {
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil {
+			return data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if count < -maxRandomCount {
+			return data.MakeErrorData("ERR value is out of range")
+		}
+	}
+	if len(cmd) == 4 {
+		if strings.ToLower(string(cmd[3])) != "withvalues" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		withValues = true
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, errData := getHash(db, key)
+	if errData != nil {
+		return errData
+	}
+
+	if !withCount {
+		if hash == nil {
+			return data.MakeBulkData(nil)
+		}
+		return data.MakeBulkData([]byte(hash.Random(1)[0]))
+	}
+	if hash == nil {
+		return data.MakeEmptyArrayData()
+	}
+
+	fields := hash.Random(count)
+	res := make([]data.RedisData, 0, len(fields))
+	for _, field := range fields {
+		res = append(res, data.MakeBulkData([]byte(field)))
+		if withValues {
+			val, _ := hash.Get(field)
+			res = append(res, data.MakeBulkData(val))
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+// hScanHash iterate the fields of a hash
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+// The cursor is the scan hash of the next field, see Hash.Scan, so a full iteration returns every field that
+// exists during the whole scan even if other fields are added or deleted meanwhile
+func hScanHash(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "hscan" {
+		log.Printf("hScanHash Function: cmdName is not hscan")
+		return data.MakeErrorData("server error")
+	}
+	scan, errData := parseScanArgs(cmd[2:], true)
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	hash, errData := getHash(db, key)
+	if errData != nil {
+		return errData
+	}
+	if hash == nil {
+		return makeScanReply(0, nil)
+	}
+
+	next, fields := hash.Scan(scan.cursor, scan.count)
+	matched := scan.match(fields)
+	res := make([]data.RedisData, 0, len(matched)*2)
+	for _, field := range matched {
+		res = append(res, data.MakeBulkData([]byte(field)))
+		if !scan.noValues {
+			val, _ := hash.Get(field)
+			res = append(res, data.MakeBulkData(val))
+		}
+	}
+	return makeScanReply(next, res)
+}
+
+// scanArgs is the parsed arguments of the SCAN family commands
+type scanArgs struct {
+	cursor   int
+	pattern  string
+	count    int
+	noValues bool
+}
+
+// parseScanArgs parse "cursor [MATCH pattern] [COUNT count]", and [NOVALUES] if allowNoValues is set
+func parseScanArgs(args [][]byte, allowNoValues bool) (*scanArgs, data.RedisData) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 63)
+	if err != nil {
+		return nil, data.MakeErrorData("ERR invalid cursor")
+	}
+	scan := &scanArgs{
+		cursor:  int(cursor),
+		pattern: "*",
+		count:   10,
+	}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			if i+1 >= len(args) {
+				return nil, data.MakeErrorData("ERR syntax error")
+			}
+			i++
+			scan.pattern = string(args[i])
+		case "count":
+			if i+1 >= len(args) {
+				return nil, data.MakeErrorData("ERR syntax error")
+			}
+			i++
+			scan.count, err = strconv.Atoi(string(args[i]))
+			if err != nil {
+				return nil, data.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			if scan.count < 1 {
+				return nil, data.MakeErrorData("ERR syntax error")
+			}
+		case "novalues":
+			if !allowNoValues {
+				return nil, data.MakeErrorData("ERR syntax error")
+			}
+			scan.noValues = true
+		default:
+			return nil, data.MakeErrorData("ERR syntax error")
+		}
+	}
+	return scan, nil
+}
+
+// scanHash return the position of elem in the order of a scan, a positive 52 bits hash
+// It fits in the mantissa of a float64, so that it is kept exactly as the score of a skip list
+func scanHash(elem string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(elem))
+	return int(max(h.Sum64()>>12, 1))
+}
+
+// match return the elements matching the pattern
+func (scan *scanArgs) match(elems []string) []string {
+	matched := make([]string, 0)
+	for _, elem := range elems {
+		if scan.pattern == "*" || PattenMatch(scan.pattern, elem) {
+			matched = append(matched, elem)
+		}
+	}
+	return matched
+}
+
+func makeScanReply(cursor int, items []data.RedisData) data.RedisData {
+	if items == nil {
+		items = make([]data.RedisData, 0)
+	}
+	return data.MakeArrayData([]data.RedisData{
+		data.MakeBulkData([]byte(strconv.Itoa(cursor))),
+		data.MakeArrayData(items),
+	})
+}
+
+func RegisterHashCommands() {
+	RegisterCommand("hset", hSetHash, -4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("hmset", hSetHash, -4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("hsetnx", hSetNxHash, 4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("hget", hGetHash, 3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("hmget", hMGetHash, -3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("hdel", hDelHash, -3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("hexists", hExistsHash, 3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("hlen", hLenHash, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("hstrlen", hStrLenHash, 3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("hkeys", hKeysHash, 2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("hvals", hKeysHash, 2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("hgetall", hKeysHash, 2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("hincrby", hIncrByHash, 4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("hincrbyfloat", hIncrByFloatHash, 4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("hrandfield", hRandFieldHash, -2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("hscan", hScanHash, -3, cmdReadonly, 1, 1, 1)
+}
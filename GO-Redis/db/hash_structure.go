@@ -0,0 +1,121 @@
+package db
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Hash is a map of fields and values stored in a key
+type Hash struct {
+	table map[string][]byte
+	// order keeps the fields sorted by their scanHash for HSCAN
+	order *skipList
+}
+
+func NewHash() *Hash {
+	return &Hash{
+		table: make(map[string][]byte),
+		order: newSkipList(),
+	}
+}
+
+// Set set the value of field, return true if the field is new
+func (h *Hash) Set(field string, val []byte) bool {
+	_, ok := h.table[field]
+	h.table[field] = val
+	if !ok {
+		h.order.insert(float64(scanHash(field)), field)
+	}
+	return !ok
+}
+
+func (h *Hash) Get(field string) ([]byte, bool) {
+	val, ok := h.table[field]
+	return val, ok
+}
+
+// Del remove field, return true if it existed
+func (h *Hash) Del(field string) bool {
+	if _, ok := h.table[field]; !ok {
+		return false
+	}
+	delete(h.table, field)
+	h.order.delete(float64(scanHash(field)), field)
+	return true
+}
+
+func (h *Hash) Exist(field string) bool {
+	_, ok := h.table[field]
+	return ok
+}
+
+func (h *Hash) Len() int {
+	return len(h.table)
+}
+
+func (h *Hash) Keys() []string {
+	keys := make([]string, 0, len(h.table))
+	for field := range h.table {
+		keys = append(keys, field)
+	}
+	return keys
+}
+
+func (h *Hash) Values() [][]byte {
+	values := make([][]byte, 0, len(h.table))
+	for _, val := range h.table {
+		values = append(values, val)
+	}
+	return values
+}
+
+func (h *Hash) Table() map[string][]byte {
+	return h.table
+}
+
+// Random return count random fields
+// If count is positive, the fields are distinct and at most Len() fields are returned
+// If count is negative, -count fields are returned and the same field may appear several times
+func (h *Hash) Random(count int) []string {
+	keys := h.Keys()
+	if count >= 0 {
+		if count > len(keys) {
+			count = len(keys)
+		}
+		rand.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+		return keys[:count]
+	}
+	res := make([]string, 0)
+	if len(keys) == 0 {
+		return res
+	}
+	for i := 0; i < -count; i++ {
+		res = append(res, keys[rand.Intn(len(keys))])
+	}
+	return res
+}
+
+func (h *Hash) Clear() {
+	h.table = make(map[string][]byte)
+	h.order = newSkipList()
+}
+
+// Scan visit count fields from cursor, return the next cursor and the visited fields
+// The fields are visited in the order of their scanHash and the cursor is the hash of the next one, so that adding
+// or deleting fields does not move the others. The fields sharing a hash are visited together, so more than count
+// fields may be visited. The next cursor is 0 when the iteration is finished.
+func (h *Hash) Scan(cursor, count int) (int, []string) {
+	fields := make([]string, 0, min(count, h.Len()))
+	x := h.order.firstInScoreRange(&ScoreRange{Min: float64(cursor), Max: math.Inf(1)})
+	last := 0.0
+	for ; x != nil && (len(fields) < count || x.Score == last); x = x.Next() {
+		fields = append(fields, x.Member)
+		last = x.Score
+	}
+	if x == nil {
+		return 0, fields
+	}
+	return int(x.Score), fields
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// scanAll iterate h from the cursor 0 with count, calling step after each call, and return every visited field
+func scanAll(t *testing.T, h *Hash, count int, step func()) map[string]int {
+	t.Helper()
+	visited := make(map[string]int)
+	cursor := 0
+	for calls := 0; ; calls++ {
+		if calls > h.Len()+100 {
+			t.Fatalf("the scan does not end")
+		}
+		next, fields := h.Scan(cursor, count)
+		for _, field := range fields {
+			visited[field]++
+		}
+		if next == 0 {
+			return visited
+		}
+		if next <= cursor {
+			t.Fatalf("Scan(%d, %d) returned the cursor %d", cursor, count, next)
+		}
+		cursor = next
+		if step != nil {
+			step()
+		}
+	}
+}
+
+func TestHashScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		count int
+	}{
+		{"empty", 0, 10},
+		{"one call", 5, 10},
+		{"one field per call", 50, 1},
+		{"several calls", 1000, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHash()
+			for i := 0; i < tt.size; i++ {
+				h.Set("f"+strconv.Itoa(i), []byte("v"))
+			}
+			visited := scanAll(t, h, tt.count, nil)
+			if len(visited) != tt.size {
+				t.Fatalf("%d fields visited, want %d", len(visited), tt.size)
+			}
+			for field, n := range visited {
+				if n != 1 {
+					t.Fatalf("field %q visited %d times", field, n)
+				}
+			}
+		})
+	}
+}
+
+func TestHashScanWhileModified(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 1000; i++ {
+		h.Set("f"+strconv.Itoa(i), []byte("v"))
+	}
+	// the odd fields are deleted and new fields are added during the scan
+	i, added := 1, 0
+	visited := scanAll(t, h, 10, func() {
+		if i < 1000 {
+			h.Del("f" + strconv.Itoa(i))
+			i += 2
+		}
+		h.Set("new"+strconv.Itoa(added), []byte("v"))
+		added++
+	})
+	for i := 0; i < 1000; i += 2 {
+		if visited["f"+strconv.Itoa(i)] != 1 {
+			t.Fatalf("field f%d visited %d times, want once", i, visited["f"+strconv.Itoa(i)])
+		}
+	}
+
+	// the order is kept by Del and Clear
+	fields := h.Keys()
+	sort.Slice(fields, func(i, j int) bool {
+		hi, hj := scanHash(fields[i]), scanHash(fields[j])
+		return hi < hj || (hi == hj && fields[i] < fields[j])
+	})
+	_, got := h.Scan(0, h.Len())
+	if len(got) != len(fields) {
+		t.Fatalf("Scan(0, %d) visited %d fields, want %d", h.Len(), len(got), len(fields))
+	}
+	for j := range fields {
+		if got[j] != fields[j] {
+			t.Fatalf("field %d = %q, want %q", j, got[j], fields[j])
+		}
+	}
+	h.Clear()
+	if next, got := h.Scan(0, 10); next != 0 || len(got) != 0 {
+		t.Fatalf("Scan(0, 10) after Clear = %d, %v, want 0 and no field", next, got)
+	}
+}
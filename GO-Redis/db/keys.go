@@ -14,37 +14,45 @@ import (
 // implements the keys commands of redis
 
 func RegisterKeyCommands() {
-	RegisterCommand("ping", pingKeys)
-	RegisterCommand("del", deleteKey)
-	RegisterCommand("exists", existsKey)
-	RegisterCommand("keys", keysKey)
-	RegisterCommand("expire", expireKey)
-	RegisterCommand("persist", persistKey)
-	RegisterCommand("ttl", ttlKey)
+	RegisterCommand("ping", pingKeys, -1, cmdFast, 0, 0, 0)
+	RegisterCommand("del", deleteKey, -2, cmdWrite, 1, -1, 1)
+	RegisterCommand("exists", existsKey, -2, cmdReadonly|cmdFast, 1, -1, 1)
+	RegisterCommand("keys", keysKey, 2, cmdReadonly, 0, 0, 0)
+	RegisterCommand("expire", expireKey, -3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("expireat", expireAtKey, -3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("persist", persistKey, 2, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("ttl", ttlKey, 2, cmdReadonly|cmdFast, 1, 1, 1)
 	//RegisterCommand("type", typeKey)
-	RegisterCommand("rename", renameKey)
+	RegisterCommand("rename", renameKey, 3, cmdWrite, 1, 2, 1)
+	RegisterCommand("move", moveKey, 3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("getver", getVerKey, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("dump", dumpKey, 2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("restore", restoreKey, -4, cmdWrite|cmdDenyOOM, 1, 1, 1)
+	RegisterCommand("restore-asking", restoreKey, -4, cmdWrite|cmdDenyOOM|cmdAsking, 1, 1, 1)
+	RegisterCommand("migrate", migrateKeys, -6, cmdWrite|cmdMovableKeys, 3, 3, 1)
+	registerKeysFunc("migrate", migrateKeyPositions)
 }
 
 func deleteKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "delete" {
-		log.Printf("deleteKey Function: cmdName is not delete")
-		return data.MakeErrorData("protocol Error: cmdName is not delete")
-	}
-	// if already expires
-	if db.CheckTTL(string(cmd[1])) {
-		return data.MakeIntData(int64(0))
+	if strings.ToLower(cmdName) != "del" {
+		log.Printf("deleteKey Function: cmdName is not del")
+		return data.MakeErrorData("protocol Error: cmdName is not del")
 	}
 	// record the number of delete keys
 	count := 0
 	for _, key := range cmd[1:] {
 		cur := string(key)
+		// an expired key is deleted by the check and not counted
+		if !db.CheckTTL(cur) {
+			continue
+		}
 		db.locks.Lock(cur)
-		ok := db.db.Delete(cur)
-		if ok {
+		if db.removeKey(cur) {
 			count += 1
+			db.notifyKeyspaceEvent(notifyGeneric, "del", cur)
 		}
-		db.ttlKeys.Delete(cur)
+		db.DeleteTTL(cur)
 		db.locks.UnLock(cur)
 	}
 	return data.MakeIntData(int64(count))
@@ -52,8 +60,8 @@ func deleteKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 
 func existsKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "exists" || len(cmd) < 2 {
-		log.Println("existsKey Function: cmdName is not exists or command args number is invalid")
+	if strings.ToLower(cmdName) != "exists" {
+		log.Println("existsKey Function: cmdName is not exists")
 		return data.MakeErrorData("Protocol error: cmdName is not exists")
 	}
 	count := 0
@@ -72,8 +80,8 @@ func existsKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 }
 
 func keysKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
-	if strings.ToLower(string(cmd[0])) != "keys" || len(cmd) != 2 {
-		log.Printf("keysKey Function: cmdName is not keys or cmd length is not 2")
+	if strings.ToLower(string(cmd[0])) != "keys" {
+		log.Printf("keysKey Function: cmdName is not keys")
 		return data.MakeErrorData(fmt.Sprintf("error: keys function get invalid command %s %s", string(cmd[0]), string(cmd[1])))
 	}
 	res := make([]data.RedisData, 0)
@@ -92,7 +100,7 @@ func keysKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Redi
 // expireKey 续约等操作的实现
 func expireKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "expire" || len(cmd) < 3 || len(cmd) > 4 {
+	if strings.ToLower(cmdName) != "expire" || len(cmd) > 4 {
 		log.Printf("expireKey Function: cmdName is not expire or command args number is invalid")
 		return data.MakeErrorData("error: cmdName is not expire or command args number is invalid")
 	}
@@ -102,7 +110,28 @@ func expireKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 		log.Printf("expireKey Function: cmd[2] %s is not int", string(cmd[2]))
 		return data.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
 	}
-	ttl := time.Now().Unix() + value
+	return expireAtKeyGeneric(db, cmd, time.Now().Unix()+value)
+}
+
+// expireAtKey set the ttl of a key to an absolute unix time in seconds
+// EXPIREAT key unix-time-seconds [NX | XX | GT | LT]
+func expireAtKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "expireat" {
+		log.Printf("expireAtKey Function: cmdName is not expireat")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd) > 4 {
+		return data.MakeWrongNumberArgs("expireat")
+	}
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	return expireAtKeyGeneric(db, cmd, ttl)
+}
+
+// expireAtKeyGeneric set the ttl of cmd[1] to the unix time ttl under the condition cmd[3]
+func expireAtKeyGeneric(db *DB, cmd [][]byte, ttl int64) data.RedisData {
 	var op string
 	if len(cmd) == 4 {
 		op = strings.ToLower(string(cmd[3]))
@@ -121,7 +150,7 @@ func expireKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 			res = db.SetTTL(key, ttl)
 		}
 	case "xx":
-		if _, OK := db.ttlKeys.Get(key); !OK {
+		if _, OK := db.ttlKeys.Get(key); OK {
 			res = db.SetTTL(key, ttl)
 		}
 	case "gt":
@@ -135,13 +164,15 @@ func expireKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	default:
 		if op != "" {
 			log.Printf("expireKey Function: opt %s is not nx, xx, gt or lt", op)
-			return data.MakeErrorData(fmt.Sprintf(fmt.Sprintf("ERROR Unsupported option %s, except nx, xx, gt, lt", op)))
+			return data.MakeErrorData(fmt.Sprintf("ERROR Unsupported option %s, except nx, xx, gt, lt", op))
 		}
 		res = db.SetTTL(key, ttl)
 	}
 	var count int
 	if res {
 		count = 1
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
 	} else {
 		count = 0
 	}
@@ -151,9 +182,9 @@ func expireKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 // persisKey 删除kv的ttl，而不删除kv，从而使得其永久化
 func persistKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "persist" || len(cmd) != 2 {
-		log.Printf("persistKey Function: cmdName is not persist or command args number is invalid")
-		return data.MakeErrorData("error: cmdName is not persist or command args number is invalid")
+	if strings.ToLower(cmdName) != "persist" {
+		log.Printf("persistKey Function: cmdName is not persist")
+		return data.MakeErrorData("error: cmdName is not persist")
 	}
 	key := string(cmd[1])
 	if !db.CheckTTL(key) {
@@ -166,6 +197,8 @@ func persistKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 	var count int
 	if res {
 		count = 1
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "persist", key)
 	} else {
 		count = 0
 	}
@@ -175,9 +208,9 @@ func persistKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 // ttlKey 获取指定键的剩余生存时间（TTL）的 "ttl" 命令
 func ttlKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "ttl" || len(cmd) != 2 {
-		log.Printf("ttlKey Function: cmdName is not ttl or command args number is invalid")
-		return data.MakeErrorData("error: cmdName is not ttl or command args number is invalid")
+	if strings.ToLower(cmdName) != "ttl" {
+		log.Printf("ttlKey Function: cmdName is not ttl")
+		return data.MakeErrorData("error: cmdName is not ttl")
 	}
 	key := string(cmd[1])
 	if !db.CheckTTL(key) {
@@ -222,9 +255,9 @@ func ttlKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Redis
 
 func renameKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	cmdName := string(cmd[0])
-	if strings.ToLower(cmdName) != "rename" || len(cmd) != 3 {
-		log.Printf("renameKey Function: cmdName is not rename or command args number is invalid")
-		return data.MakeErrorData("error: cmdName is not rename or command args number is invalid")
+	if strings.ToLower(cmdName) != "rename" {
+		log.Printf("renameKey Function: cmdName is not rename")
+		return data.MakeErrorData("error: cmdName is not rename")
 	}
 	oldName, newName := string(cmd[1]), string(cmd[2])
 	if db.CheckTTL(oldName) {
@@ -242,24 +275,115 @@ func renameKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 		return data.MakeErrorData(fmt.Sprintf("error: %s not exist", oldTTL))
 	}
 	// 先删除老的
-	db.db.Delete(oldName)
+	db.removeKey(oldName)
 	db.ttlKeys.Delete(oldName)
 
 	// 再删除新的
-	db.db.Delete(newName)
+	db.removeKey(newName)
 	db.ttlKeys.Delete(newName)
 
 	// 再设置新的
-	db.db.Set(newName, oldValue)
+	db.setKey(newName, oldValue)
 	db.ttlKeys.Set(newName, oldTTL)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_from", oldName)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_to", newName)
 
 	return data.MakeStringData("OK")
 }
 
+// moveKey move a key and its ttl to another db, nothing is done if the key already exists in the target db
+func moveKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "move" {
+		log.Printf("moveKey Function: cmdName is not move")
+		return data.MakeErrorData("server error")
+	}
+	if errRes := db.dbs.rejectInCluster("move"); errRes != nil {
+		return errRes
+	}
+	index, err := strconv.Atoi(string(cmd[2]))
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	target := db.dbs.Get(index)
+	if target == nil {
+		return data.MakeErrorData("ERR DB index is out of range")
+	}
+	if target == db {
+		return data.MakeErrorData("ERR source and destination objects are the same")
+	}
+
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+	target.CheckTTL(key)
+
+	// always lock the db created first to avoid deadlock with a reverse move, the indexes may be swapped meanwhile
+	defer target.signalKeyReady(key)
+	first, second := db, target
+	if first.id > second.id {
+		first, second = second, first
+	}
+	first.locks.Lock(key)
+	defer first.locks.UnLock(key)
+	second.locks.Lock(key)
+	defer second.locks.UnLock(key)
+
+	val, ok := db.db.Get(key)
+	if !ok {
+		return data.MakeIntData(0)
+	}
+	if _, ok = target.db.Get(key); ok {
+		return data.MakeIntData(0)
+	}
+
+	var expireAt int64
+	if ttl, ok := db.ttlKeys.Get(key); ok {
+		expireAt = ttl.(*TTLInfo).value
+	}
+	db.DeleteTTL(key)
+	db.removeKey(key)
+
+	target.setKey(key, val)
+	if expireAt != 0 {
+		target.SetTTL(key, expireAt)
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, "move_from", key)
+	target.notifyKeyspaceEvent(notifyGeneric, "move_to", key)
+	return data.MakeIntData(1)
+}
+
+// getVerKey return the version of a key of any type, nil if it does not exist
+// GETVER key
+func getVerKey(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getver" {
+		return data.MakeErrorData("Server Error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeBulkData(nil)
+	}
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+	v := db.keyVersion(key)
+	if v == 0 {
+		return data.MakeBulkData(nil)
+	}
+	return data.MakeIntData(v)
+}
+
 func pingKeys(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	if len(cmd) > 2 {
 		return data.MakeErrorData("error: wrong number of arguments for 'ping' command")
 	}
+	// a subscribed RESP2 client gets PING as a message, so that it is not mistaken for a published one
+	if client, ok := conn.(*Client); ok && client.sub.subscriptions() > 0 && client.protocol == data.RESP2 {
+		msg := []byte{}
+		if len(cmd) == 2 {
+			msg = cmd[1]
+		}
+		return data.MakeArrayData([]data.RedisData{data.MakeBulkData([]byte("pong")), data.MakeBulkData(msg)})
+	}
 	// default reply
 	if len(cmd) == 1 {
 		return data.MakeStringData("PONG")
@@ -301,14 +425,10 @@ func PattenMatch(pattern, src string) bool {
 			if patPos == patLen {
 				return true
 			}
-			for srcPos < srcLen {
-				for srcPos < srcLen && src[srcPos] != pattern[patPos] {
-					srcPos++
-				}
-				if PattenMatch(pattern[patPos+1:], src[srcPos+1:]) {
+			// try to match the rest of pattern from every position of src
+			for ; srcPos < srcLen; srcPos++ {
+				if PattenMatch(pattern[patPos:], src[srcPos:]) {
 					return true
-				} else {
-					srcPos++
 				}
 			}
 			return false
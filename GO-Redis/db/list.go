@@ -6,7 +6,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math"
 	"net"
 	"strconv"
 	"strings"
@@ -19,10 +18,6 @@ func lLenList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) != 2 {
-		return data.MakeErrorData("wrong number of arguments for 'llen' command")
-	}
-
 	key := string(cmd[1])
 
 	db.locks.RLock(key)
@@ -49,10 +44,6 @@ func lIndexList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) != 3 {
-		return data.MakeErrorData("wrong number of arguments for 'lindex' command")
-	}
-
 	key := string(cmd[1])
 	index, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
@@ -76,39 +67,38 @@ func lIndexList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	resNode := typeVal.Index(index)
+	res := typeVal.Index(index)
 
-	if resNode == nil {
+	if res == nil {
 		return data.MakeBulkData(nil)
 	}
 
-	return data.MakeBulkData(resNode.Val)
+	return data.MakeBulkData(res)
 }
 
+// lPosList return the positions of the elements matching elem
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
 func lPosList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lpos" {
 		log.Printf("lPosList Function: cmdName is not lpos")
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) < 3 || len(cmd)&1 != 1 {
-		return data.MakeErrorData("wrong number of arguments for 'lpos' command")
+	if len(cmd)&1 != 1 {
+		return data.MakeWrongNumberArgs("lpos")
 	}
 
-	var rank, count, maxLen, reverse bool
-	var rankVal, countVal, maxLenVal int
-	var key string
-	var elem []byte
+	var count bool
+	rankVal, countVal, maxLenVal := 1, 0, 0
 	var err error
 
-	var pos int
-	elem = cmd[2]
+	key := string(cmd[1])
+	elem := cmd[2]
 
 	// handle params
 	for i := 3; i < len(cmd); i += 2 {
 		switch strings.ToLower(string(cmd[i])) {
 		case "rank":
-			rank = true
 			rankVal, err = strconv.Atoi(string(cmd[i+1]))
 			if err != nil || rankVal == 0 {
 				return data.MakeErrorData("rank value should 1,2,3... or -1,-2,-3...")
@@ -120,7 +110,6 @@ func lPosList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 				return data.MakeErrorData("count value is not an positive integer")
 			}
 		case "maxlen":
-			maxLen = true
 			maxLenVal, err = strconv.Atoi(string(cmd[i+1]))
 			if err != nil || maxLenVal < 0 {
 				return data.MakeErrorData("maxlen value is not an positive integer")
@@ -130,8 +119,13 @@ func lPosList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		}
 	}
 
+	var notFound data.RedisData = data.MakeBulkData(nil)
+	if count {
+		notFound = data.MakeEmptyArrayData()
+	}
+
 	if !db.CheckTTL(key) {
-		return data.MakeBulkData(nil)
+		return notFound
 	}
 
 	db.locks.RLock(key)
@@ -139,7 +133,7 @@ func lPosList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 
 	tem, ok := db.db.Get(key)
 	if !ok {
-		return data.MakeBulkData(nil)
+		return notFound
 	}
 
 	list, ok := tem.(*List)
@@ -147,134 +141,46 @@ func lPosList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		return data.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	if list.Len == 0 {
-		return data.MakeBulkData(nil)
-	}
-
-	if maxLen && maxLenVal == 0 {
-		maxLenVal = list.Len
+	// a negative rank searches from the tail, and the first |rank|-1 matches are skipped
+	reverse := rankVal < 0
+	skip := rankVal - 1
+	start := 0
+	if reverse {
+		skip = -rankVal - 1
+		start = list.Len - 1
 	}
 
-	// normally pos without options
-	if !rank && !count && !maxLen {
-		pos := list.Pos(elem)
-		if pos == -1 {
-			return data.MakeBulkData(nil)
-		} else {
-			return data.MakeIntData(int64(pos))
-		}
-	}
-
-	// handle options
-	var now *ListNode
-	if rank {
-		if rankVal > 0 {
-			pos = -1
-			for now = list.Head.Next; now != list.Tail; now = now.Next {
-				pos++
-				if bytes.Equal(now.Val, elem) {
-					rankVal--
-				}
-				if maxLen {
-					maxLenVal--
-					if maxLenVal == 0 {
-						break
-					}
-				}
-				if rankVal == 0 {
-					break
-				}
-			}
-		} else {
-			reverse = true
-			pos = list.Len
-			for now = list.Tail.Prev; now != list.Head; now = now.Prev {
-				pos--
-				if bytes.Equal(now.Val, elem) {
-					rankVal++
-				}
-				if maxLen {
-					maxLenVal--
-					if maxLenVal == 0 {
-						break
-					}
-				}
-				if rankVal == 0 {
-					break
-				}
-			}
+	res := make([]data.RedisData, 0)
+	compared := 0
+	list.ForEach(start, reverse, func(index int, val []byte) bool {
+		if maxLenVal > 0 && compared >= maxLenVal {
+			return false
 		}
-	} else {
-		now = list.Head.Next
-		pos = 0
-		if maxLen {
-			maxLenVal--
+		compared++
+		if !bytes.Equal(val, elem) {
+			return true
 		}
-	}
-
-	// when rank is out of range, return nil
-	if (rank && rankVal != 0) || now == list.Tail || now == list.Head {
-		return data.MakeBulkData(nil)
-	}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		res = append(res, data.MakeIntData(int64(index)))
+		// COUNT 0 means all matches
+		return count && (countVal == 0 || len(res) < countVal)
+	})
 
-	res := make([]data.RedisData, 0)
 	if !count {
-		// if count is not set, return first find pos inside maxLen range
-		for ; now != list.Tail; now = now.Next {
-			if bytes.Equal(now.Val, elem) {
-				return data.MakeIntData(int64(pos))
-			}
-			pos++
-			if maxLen {
-				if maxLenVal <= 0 {
-					break
-				}
-				maxLenVal--
-			}
-		}
-		return data.MakeBulkData(nil)
-	} else {
-		if !reverse {
-			for ; now != list.Tail && countVal != 0; now = now.Next {
-				if bytes.Equal(now.Val, elem) {
-					res = append(res, data.MakeIntData(int64(pos)))
-					countVal--
-				}
-				pos++
-				if maxLen {
-					if maxLenVal <= 0 {
-						break
-					}
-					maxLenVal--
-				}
-			}
-		} else {
-			for ; now != list.Head && countVal != 0; now = now.Prev {
-				if bytes.Equal(now.Val, elem) {
-					res = append(res, data.MakeIntData(int64(pos)))
-					countVal--
-				}
-				pos--
-				if maxLen {
-					if maxLenVal <= 0 {
-						break
-					}
-					maxLenVal--
-				}
-			}
+		if len(res) == 0 {
+			return data.MakeBulkData(nil)
 		}
+		return res[0]
 	}
-
-	if len(res) == 0 {
-		return data.MakeBulkData(nil)
-	}
-
 	return data.MakeArrayData(res)
 }
 
 func lPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
-	if len(cmd) != 2 && len(cmd) != 3 {
-		return data.MakeErrorData("wrong number of arguments for 'lpop' command")
+	if len(cmd) > 3 {
+		return data.MakeWrongNumberArgs("lpop")
 	}
 
 	var cnt int
@@ -307,9 +213,13 @@ func lPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 
 	// remove the key when list is empty
 	defer func() {
+		db.touchKey(key)
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyList, "lpop", key)
 		if list.Len == 0 {
-			db.db.Delete(key)
+			db.removeKey(key)
 			db.DeleteTTL(key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 		}
 	}()
 
@@ -317,8 +227,9 @@ func lPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 	if cnt == 0 {
 		e := list.LPop()
 		if e == nil {
-			return data.MakeBulkData(e.Val)
+			return data.MakeBulkData(nil)
 		}
+		return data.MakeBulkData(e)
 	}
 
 	// return cnt number elements as array
@@ -328,7 +239,7 @@ func lPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		if e == nil {
 			break
 		}
-		res = append(res, data.MakeBulkData(e.Val))
+		res = append(res, data.MakeBulkData(e))
 	}
 
 	return data.MakeArrayData(res)
@@ -339,8 +250,8 @@ func rPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		log.Printf("rPopList: command is not rpop")
 		return data.MakeErrorData("server error")
 	}
-	if len(cmd) != 2 && len(cmd) != 3 {
-		return data.MakeErrorData("wrong number of arguments for 'rpop' command")
+	if len(cmd) > 3 {
+		return data.MakeWrongNumberArgs("rpop")
 	}
 
 	var cnt int
@@ -371,9 +282,13 @@ func rPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 	}
 
 	defer func() {
+		db.touchKey(key)
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyList, "rpop", key)
 		if list.Len == 0 {
-			db.db.Delete(key)
+			db.removeKey(key)
 			db.DeleteTTL(key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 		}
 	}()
 
@@ -383,7 +298,7 @@ func rPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		if e == nil {
 			return data.MakeBulkData(nil)
 		}
-		return data.MakeBulkData(e.Val)
+		return data.MakeBulkData(e)
 	}
 
 	// return cnt number elements as array
@@ -393,24 +308,24 @@ func rPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		if e == nil {
 			break
 		}
-		res = append(res, data.MakeBulkData(e.Val))
+		res = append(res, data.MakeBulkData(e))
 	}
 
 	return data.MakeArrayData(res)
 }
 
+// lPushList insert the elements at the head of the list
+// LPUSH key element [element ...]
 func lPushList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	if strings.ToLower(string(cmd[0])) != "lpush" {
 		log.Printf("lPushList Function : cmdName is not lpush")
 		return data.MakeErrorData("server error")
 	}
-	if len(cmd) < 3 {
-		return data.MakeErrorData("wrong number of arguments for 'lpush' command")
-	}
-
 	key := string(cmd[1])
 	db.CheckTTL(key)
 
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
@@ -420,7 +335,7 @@ func lPushList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	// no such key, create a list
 	if !ok {
 		list = NewList()
-		db.db.Set(key, list)
+		db.setKey(key, list)
 	} else {
 		// try to assert it as a List
 		list, ok = tem.(*List)
@@ -433,6 +348,8 @@ func lPushList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	for i := 2; i < len(cmd); i++ {
 		list.LPush(cmd[i])
 	}
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
 
 	// return the length of the list
 	return data.MakeIntData(int64(list.Len))
@@ -443,13 +360,11 @@ func lPushXList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		log.Printf("lPushXList Function: cmdName is not lpushx")
 		return data.MakeErrorData("Server Error")
 	}
-	if len(cmd) < 3 {
-		return data.MakeErrorData("wrong number of arguments for 'lpushx' command")
-	}
-
 	key := string(cmd[1])
 	db.CheckTTL(key)
 
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
@@ -466,21 +381,23 @@ func lPushXList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 	for i := 2; i < len(cmd); i++ {
 		list.LPush(cmd[i])
 	}
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
 	return data.MakeIntData(int64(list.Len))
 }
 
+// rPushList insert the elements at the tail of the list
+// RPUSH key element [element ...]
 func rPushList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	if strings.ToLower(string(cmd[0])) != "rpush" {
 		log.Printf("rPushList Function: cmdName is not rpush")
 		return data.MakeErrorData("server error")
 	}
-	if len(cmd) < 3 {
-		return data.MakeErrorData("wrong number of arguments for 'rpush' command")
-	}
-
 	key := string(cmd[1])
 	db.CheckTTL(key)
 
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
@@ -488,7 +405,7 @@ func rPushList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	tem, ok := db.db.Get(key)
 	if !ok {
 		list = NewList()
-		db.db.Set(key, list)
+		db.setKey(key, list)
 	} else {
 		list, ok = tem.(*List)
 		if !ok {
@@ -498,6 +415,8 @@ func rPushList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	for i := 2; i < len(cmd); i++ {
 		list.RPush(cmd[i])
 	}
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
 
 	return data.MakeIntData(int64(list.Len))
 }
@@ -508,13 +427,11 @@ func rPushXList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) < 3 {
-		return data.MakeErrorData("wrong number of arguments for 'rpushX' command")
-	}
-
 	key := string(cmd[1])
 	db.CheckTTL(key)
 
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
@@ -531,6 +448,8 @@ func rPushXList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 	for i := 2; i < len(cmd); i++ {
 		list.RPush(cmd[i])
 	}
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
 
 	return data.MakeIntData(int64(list.Len))
 }
@@ -540,10 +459,6 @@ func lSetList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		log.Printf("lSetList Function: cmdName is not lset")
 		return data.MakeErrorData("server error")
 	}
-	if len(cmd) != 4 {
-		return data.MakeErrorData("wrong number of arguments for 'lset' command")
-	}
-
 	index, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
 		return data.MakeErrorData("index must be an integer")
@@ -572,6 +487,8 @@ func lSetList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 	if !success {
 		return data.MakeErrorData("index out of range")
 	}
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, "lset", key)
 
 	return data.MakeStringData("OK")
 }
@@ -582,10 +499,6 @@ func lRemList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) != 4 {
-		return data.MakeErrorData("wrong number of arguments for 'lrem' command")
-	}
-
 	count, err := strconv.Atoi(string(cmd[2]))
 	if err != nil {
 		return data.MakeErrorData("count must be an integer")
@@ -611,12 +524,17 @@ func lRemList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Red
 
 	defer func() {
 		if list.Len == 0 {
-			db.db.Delete(key)
+			db.removeKey(key)
 			db.DeleteTTL(key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 		}
 	}()
 
 	res := list.RemoveElement(cmd[3], count)
+	if res > 0 {
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyList, "lrem", key)
+	}
 
 	return data.MakeIntData(int64(res))
 }
@@ -627,10 +545,6 @@ func lTrimList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) != 4 {
-		return data.MakeErrorData("wrong number of arguments for 'ltrim' command")
-	}
-
 	start, err1 := strconv.Atoi(string((cmd[2])))
 	end, err2 := strconv.Atoi(string(cmd[3]))
 
@@ -658,12 +572,15 @@ func lTrimList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 
 	defer func() {
 		if list.Len == 0 {
-			db.db.Delete(key)
+			db.removeKey(key)
 			db.DeleteTTL(key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 		}
 	}()
 
 	list.Trim(start, end)
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, "ltrim", key)
 
 	return data.MakeStringData("OK")
 }
@@ -674,10 +591,6 @@ func lRangeList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("serve error")
 	}
 
-	if len(cmd) != 4 {
-		return data.MakeErrorData("wrong number of arguments for 'lrange' command")
-	}
-
 	start, err1 := strconv.Atoi(string(cmd[2]))
 	end, err2 := strconv.Atoi(string(cmd[3]))
 
@@ -716,26 +629,30 @@ func lRangeList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 	return data.MakeArrayData(res)
 }
 
+// lMoveList pop an element from src and push it into des atomically
+// LMOVE source destination LEFT | RIGHT LEFT | RIGHT
+// RPOPLPUSH source destination
 func lMoveList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
-	if strings.ToLower(string(cmd[0])) != "lmove" {
-		log.Printf("lMoveList Function : cmdName is not lmove")
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "lmove" && cmdName != "rpoplpush" {
+		log.Printf("lMoveList Function : cmdName is not lmove or rpoplpush")
 		return data.MakeErrorData("server error")
 	}
 
-	if len(cmd) != 5 {
-		return data.MakeErrorData("wrong number of arguments for 'lmove' command")
+	var srcDrc, desDrc string
+	if cmdName == "rpoplpush" {
+		srcDrc, desDrc = "right", "left"
+	} else {
+		srcDrc = strings.ToLower(string(cmd[3]))
+		desDrc = strings.ToLower(string(cmd[4]))
 	}
-
-	src := string(cmd[1])
-	des := string(cmd[2])
-
-	srcDrc := strings.ToLower(string(cmd[3]))
-	desDrc := strings.ToLower(string(cmd[4]))
-
 	if (srcDrc != "left" && srcDrc != "right") || (desDrc != "left" && desDrc != "right") {
 		return data.MakeErrorData("options must be left or right")
 	}
 
+	src := string(cmd[1])
+	des := string(cmd[2])
+
 	if !db.CheckTTL(src) {
 		return data.MakeBulkData(nil)
 	}
@@ -744,43 +661,92 @@ func lMoveList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 
 	keys := []string{src, des}
 
+	defer db.signalKeyReady(des)
 	db.locks.LockMulti(keys)
 	defer db.locks.UnLockMulti(keys)
 
-	srcTem, ok := db.db.Get(src)
-	if !ok {
+	res, _ := moveList(db, src, des, srcDrc, desDrc)
+	return res
+}
+
+// pushIfVerList push the elements like LPUSH or RPUSH, only if the key still has version
+// A nil reply means nothing is pushed because the key is written since the client read its version.
+// LPUSHIFVER key version element [element ...]
+// RPUSHIFVER key version element [element ...]
+func pushIfVerList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "lpushifver" && cmdName != "rpushifver" {
+		log.Printf("pushIfVerList Function : cmdName is not lpushifver or rpushifver")
+		return data.MakeErrorData("server error")
+	}
+	version, errRes := parseVersion(cmd[2])
+	if errRes != nil {
+		return errRes
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	if db.keyVersion(key) != version {
 		return data.MakeBulkData(nil)
 	}
 
-	srcList, ok := srcTem.(*List)
+	var list *List
+	tem, ok := db.db.Get(key)
 	if !ok {
-		return data.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		list = NewList()
+		db.setKey(key, list)
+	} else {
+		list, ok = tem.(*List)
+		if !ok {
+			return data.MakeWrongType()
+		}
 	}
-
-	defer func() {
-		if srcList.Len == 0 {
-			db.db.Delete(src)
-			db.DeleteTTL(src)
+	for i := 3; i < len(cmd); i++ {
+		if cmdName == "lpushifver" {
+			list.LPush(cmd[i])
+		} else {
+			list.RPush(cmd[i])
 		}
-	}()
+	}
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyList, strings.TrimSuffix(cmdName, "ifver"), key)
+	return data.MakeIntData(int64(list.Len))
+}
+
+// moveList pop an element from srcDrc of src and push it into desDrc of des
+// false is returned if src does not exist or is empty, the caller should hold the locks of src and des
+func moveList(db *DB, src, des, srcDrc, desDrc string) (data.RedisData, bool) {
+	srcTem, ok := db.db.Get(src)
+	if !ok {
+		return data.MakeBulkData(nil), false
+	}
 
+	srcList, ok := srcTem.(*List)
+	if !ok {
+		return data.MakeWrongType(), true
+	}
 	if srcList.Len == 0 {
-		return data.MakeBulkData(nil)
+		return data.MakeBulkData(nil), false
 	}
 
 	desTem, ok := db.db.Get(des)
 	if !ok {
 		desTem = NewList()
-		db.db.Set(des, desTem)
+		db.setKey(des, desTem)
 	}
 
 	desList, ok := desTem.(*List)
 	if !ok {
-		return data.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		return data.MakeWrongType(), true
 	}
 
 	// pop from src
-	var popElem *ListNode
+	var popElem []byte
 	if srcDrc == "left" {
 		popElem = srcList.LPop()
 	} else {
@@ -789,108 +755,288 @@ func lMoveList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 
 	// insert to des
 	if desDrc == "left" {
-		desList.LPush(popElem.Val)
+		desList.LPush(popElem)
 	} else {
-		desList.RPush(popElem.Val)
+		desList.RPush(popElem)
+	}
+	db.touchKey(src)
+	db.touchKey(des)
+	db.notifyKeyspaceEvent(notifyList, listEvent(srcDrc, "pop"), src)
+	db.notifyKeyspaceEvent(notifyList, listEvent(desDrc, "push"), des)
+
+	if srcList.Len == 0 {
+		db.removeKey(src)
+		db.DeleteTTL(src)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", src)
+	}
+	return data.MakeBulkData(popElem), true
+}
+
+// blMoveList is the blocking version of LMOVE and RPOPLPUSH, it blocks until src is pushed
+// BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout
+// BRPOPLPUSH source destination timeout
+func blMoveList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "blmove" && cmdName != "brpoplpush" {
+		log.Printf("blMoveList Function : cmdName is not blmove or brpoplpush")
+		return data.MakeErrorData("server error")
+	}
+
+	var srcDrc, desDrc string
+	if cmdName == "brpoplpush" {
+		srcDrc, desDrc = "right", "left"
+	} else {
+		srcDrc = strings.ToLower(string(cmd[3]))
+		desDrc = strings.ToLower(string(cmd[4]))
+	}
+	if (srcDrc != "left" && srcDrc != "right") || (desDrc != "left" && desDrc != "right") {
+		return data.MakeErrorData("options must be left or right")
+	}
+	timeout, errData := parseBlockTimeout(cmd[len(cmd)-1])
+	if errData != nil {
+		return errData
+	}
+
+	src := string(cmd[1])
+	des := string(cmd[2])
+	if errData := checkListType(db, src); errData != nil {
+		return errData
+	}
+
+	// served is the db which served the client, the one at its index even if SWAPDB moved db meanwhile
+	served := db
+	res, ok := db.blockPop(ctx, cmd, []string{src}, []string{src, des}, timeout, func(db *DB, key string) (data.RedisData, bool) {
+		served = db
+		return moveList(db, src, des, srcDrc, desDrc)
+	})
+	if !ok {
+		return data.MakeBulkData(nil)
+	}
+	// the element pushed into des may serve other blocked clients
+	served.signalKeyReady(des)
+	return res
+}
+
+// lmPopList pop elements from the first non-empty list of keys
+// LMPOP numkeys key [key ...] LEFT | RIGHT [COUNT count]
+// BLMPOP timeout numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func lmPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "lmpop" && cmdName != "blmpop" {
+		log.Printf("lmPopList Function : cmdName is not lmpop or blmpop")
+		return data.MakeErrorData("server error")
+	}
+	args := cmd[1:]
+	blocking := cmdName == "blmpop"
+	var timeout time.Duration
+	if blocking {
+		if len(args) == 0 {
+			return data.MakeWrongNumberArgs(cmdName)
+		}
+		var errData data.RedisData
+		timeout, errData = parseBlockTimeout(args[0])
+		if errData != nil {
+			return errData
+		}
+		args = args[1:]
+	}
+	if len(args) < 3 {
+		return data.MakeWrongNumberArgs(cmdName)
+	}
+
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return data.MakeErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys+1 >= len(args) {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	keys := make([]string, 0, numKeys)
+	for _, key := range args[1 : 1+numKeys] {
+		keys = append(keys, string(key))
+	}
+	args = args[1+numKeys:]
+
+	direction := strings.ToLower(string(args[0]))
+	if direction != "left" && direction != "right" {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	count := 1
+	if len(args) > 1 {
+		if len(args) != 3 || strings.ToLower(string(args[1])) != "count" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		count, err = strconv.Atoi(string(args[2]))
+		if err != nil || count <= 0 {
+			return data.MakeErrorData("ERR count should be greater than 0")
+		}
+	}
+
+	for _, key := range keys {
+		if errData := checkListType(db, key); errData != nil {
+			return errData
+		}
+	}
+
+	pop := func(db *DB, key string) (data.RedisData, bool) {
+		popped := popList(db, key, direction, count)
+		if len(popped) == 0 {
+			return nil, false
+		}
+		elems := make([]data.RedisData, 0, len(popped))
+		for _, elem := range popped {
+			elems = append(elems, data.MakeBulkData(elem))
+		}
+		return data.MakeArrayData([]data.RedisData{data.MakeBulkData([]byte(key)), data.MakeArrayData(elems)}), true
 	}
 
-	return data.MakeBulkData(popElem.Val)
+	if blocking {
+		res, ok := db.blockPop(ctx, cmd, keys, keys, timeout, pop)
+		if !ok {
+			return data.MakeArrayData(nil)
+		}
+		return res
+	}
+
+	for _, key := range keys {
+		db.CheckTTL(key)
+	}
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+	for _, key := range keys {
+		if res, ok := pop(db, key); ok {
+			return res
+		}
+	}
+	return data.MakeArrayData(nil)
 }
 
 func blPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	// at least 3 args like "BLPOP" key timeout"
-	if len(cmd) < 3 {
-		return data.MakeErrorData("ERROR wrong number of arguments for 'blpop' command")
-	}
 	return bXPopList(ctx, db, cmd, "left")
 }
 
 func brPopList(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
 	// at least 3 args like "BRPOP" key timeout"
-	if len(cmd) < 3 {
-		return data.MakeErrorData("ERROR wrong number of arguments for 'brpop' command")
-	}
 	return bXPopList(ctx, db, cmd, "right")
 }
 
+// bXPopList pop an element from the first non-empty list of keys, or block until one of them is pushed
+// Blocked clients are served by the pushes in the order they blocked
 func bXPopList(ctx context.Context, db *DB, cmd [][]byte, direction string) data.RedisData {
 	// last arg is block timeout
-	timeout, err := strconv.Atoi(string(cmd[len(cmd)-1]))
-	if err != nil {
-		return data.MakeErrorData("ERROR timeout is not a float or out of range")
+	timeout, errData := parseBlockTimeout(cmd[len(cmd)-1])
+	if errData != nil {
+		return errData
 	}
-	var timer *time.Timer
-	if timeout == 0 {
-		timer = time.NewTimer(math.MaxInt)
-	} else {
-		timer = time.NewTimer(time.Duration(timeout) * time.Second)
-	}
-
-	// query interval (this could be narrowed down to query more frequently but will use more CPU resource)
-	ticker := time.NewTicker(100 * time.Millisecond)
 
 	// retrieve all list Names
 	keyBytes := cmd[1 : len(cmd)-1]
 	keyStrings := make([]string, 0, len(keyBytes))
 	for _, bStr := range keyBytes {
-		keyStrings = append(keyStrings, string(bStr))
-	}
-	left := "left"
-	right := "right"
-
-	// block (will return inside the infinite loop)
-	for {
-		select {
-		// time to query keys
-		case <-ticker.C:
-			for _, key := range keyStrings {
-				// lock db actions
-				db.locks.Lock(key)
-				// get key
-				tmp, ok := db.db.Get(key)
-				// key exist
-				if ok {
-					// assert is List
-					list, isList := tmp.(*List)
-					if isList {
-						// Pop from list
-						var node *ListNode
-						if direction == left {
-							node = list.LPop()
-						} else if direction == right {
-							node = list.RPop()
-						}
-						if node != nil {
-							// find a value. need to manually release the lock since we are leaving this scope
-							db.locks.UnLock(key)
-							return data.MakeArrayData([]data.RedisData{data.MakeStringData(key), data.MakeBulkData(node.Val)})
-						}
-					}
-				}
-				// will finally release the lock here if nothing available
-				db.locks.UnLock(key)
-			}
-		// timeout
-		case <-timer.C:
-			return data.MakeBulkData(nil)
+		key := string(bStr)
+		if errData := checkListType(db, key); errData != nil {
+			return errData
+		}
+		keyStrings = append(keyStrings, key)
+	}
+
+	res, ok := db.blockPop(ctx, cmd, keyStrings, keyStrings, timeout, func(db *DB, key string) (data.RedisData, bool) {
+		popped := popList(db, key, direction, 1)
+		if len(popped) == 0 {
+			return nil, false
+		}
+		return data.MakeArrayData([]data.RedisData{data.MakeBulkData([]byte(key)), data.MakeBulkData(popped[0])}), true
+	})
+	if !ok {
+		return data.MakeArrayData(nil)
+	}
+	return res
+}
+
+// popList pop up to count elements from the left or right of the list stored in key
+// nil is returned if key is not a list or is empty, the caller should hold the lock of key
+func popList(db *DB, key string, direction string, count int) [][]byte {
+	tem, ok := db.db.Get(key)
+	if !ok {
+		return nil
+	}
+	list, ok := tem.(*List)
+	if !ok {
+		return nil
+	}
+	elems := make([][]byte, 0)
+	for len(elems) < count {
+		var elem []byte
+		if direction == "left" {
+			elem = list.LPop()
+		} else {
+			elem = list.RPop()
 		}
+		if elem == nil {
+			break
+		}
+		elems = append(elems, elem)
+	}
+	if len(elems) > 0 {
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyList, listEvent(direction, "pop"), key)
 	}
+	if list.Len == 0 {
+		db.removeKey(key)
+		db.DeleteTTL(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+	return elems
 }
+
+// listEvent return the keyspace event of a pop or a push at the left or right of a list, as lpop or rpush
+func listEvent(direction string, op string) string {
+	if direction == "left" {
+		return "l" + op
+	}
+	return "r" + op
+}
+
+// checkListType return a wrong type error if key holds a value other than a list
+func checkListType(db *DB, key string) data.RedisData {
+	db.CheckTTL(key)
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+	tem, ok := db.db.Get(key)
+	if !ok {
+		return nil
+	}
+	if _, ok = tem.(*List); !ok {
+		return data.MakeWrongType()
+	}
+	return nil
+}
+
 func RegisterListCommands() {
-	RegisterCommand("llen", lLenList)
-	RegisterCommand("lindex", lIndexList)
-	RegisterCommand("lpos", lPosList)
-	RegisterCommand("lpop", lPopList)
-	RegisterCommand("rpop", rPopList)
-	RegisterCommand("lpush", lPushList)
-	RegisterCommand("lpushx", lPushXList)
-	RegisterCommand("rpush", rPushList)
-	RegisterCommand("rpushx", rPushXList)
-	RegisterCommand("lset", lSetList)
-	RegisterCommand("lrem", lRemList)
-	RegisterCommand("ltrim", lTrimList)
-	RegisterCommand("lrange", lRangeList)
-	RegisterCommand("lmove", lMoveList)
-	RegisterCommand("blpop", blPopList)
-	RegisterCommand("brpop", brPopList)
+	RegisterCommand("llen", lLenList, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("lindex", lIndexList, 3, cmdReadonly, 1, 1, 1)
+	RegisterCommand("lpos", lPosList, -3, cmdReadonly, 1, 1, 1)
+	RegisterCommand("lpop", lPopList, -2, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("rpop", rPopList, -2, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("lpush", lPushList, -3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("lpushx", lPushXList, -3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("rpush", rPushList, -3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("rpushx", rPushXList, -3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("lpushifver", pushIfVerList, -4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("rpushifver", pushIfVerList, -4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("lset", lSetList, 4, cmdWrite|cmdDenyOOM, 1, 1, 1)
+	RegisterCommand("lrem", lRemList, 4, cmdWrite, 1, 1, 1)
+	RegisterCommand("ltrim", lTrimList, 4, cmdWrite, 1, 1, 1)
+	RegisterCommand("lrange", lRangeList, 4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("lmove", lMoveList, 5, cmdWrite|cmdDenyOOM, 1, 2, 1)
+	RegisterCommand("rpoplpush", lMoveList, 3, cmdWrite|cmdDenyOOM, 1, 2, 1)
+	RegisterCommand("blmove", blMoveList, 6, cmdWrite|cmdDenyOOM|cmdBlocking, 1, 2, 1)
+	RegisterCommand("brpoplpush", blMoveList, 4, cmdWrite|cmdDenyOOM|cmdBlocking, 1, 2, 1)
+	RegisterCommand("lmpop", lmPopList, -4, cmdWrite|cmdMovableKeys, 0, 0, 0)
+	registerKeysFunc("lmpop", numKeysFunc(1))
+	RegisterCommand("blmpop", lmPopList, -5, cmdWrite|cmdBlocking|cmdMovableKeys, 0, 0, 0)
+	registerKeysFunc("blmpop", numKeysFunc(2))
+	RegisterCommand("blpop", blPopList, -3, cmdWrite|cmdBlocking, 1, -2, 1)
+	RegisterCommand("brpop", brPopList, -3, cmdWrite|cmdBlocking, 1, -2, 1)
 }
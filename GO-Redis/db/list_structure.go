@@ -1,206 +1,370 @@
 package db
 
 import (
+	"GO-Redis/config"
 	"bytes"
-	"context"
-	"github.com/google/uuid"
 )
 
-type Out chan<- []byte
+const (
+	// listSizeSafetyLimit is the max bytes of a node when list-max-listpack-size limits the number of elements
+	listSizeSafetyLimit = 8192
+	// listMinCompressBytes is the min bytes of a node worth compressing
+	listMinCompressBytes = 48
+	defaultListFill      = -2
+)
+
+// listOptimizationLevel is the max bytes of a node for list-max-listpack-size -1 to -5
+var listOptimizationLevel = []int{4096, 8192, 16384, 32768, 65536}
 
+// List is a quicklist: a doubly linked list of nodes, each node packs a chunk of elements into a listpack
+// Nodes deeper than compressDepth from both ends are compressed with lzf
 type List struct {
-	Head *ListNode
-	Tail *ListNode
-	Len  int
-	// register subscription functions
-	LSubscriptions map[string]Out
-	RSubscriptions map[string]Out
+	head  *ListNode
+	tail  *ListNode
+	Len   int
+	nodes int
+	// fill is list-max-listpack-size, a positive fill limits the elements of a node and a negative one limits its bytes
+	fill          int
+	compressDepth int
 }
 
+// ListNode hold a chunk of elements as a raw listpack or a compressed one
 type ListNode struct {
-	Prev *ListNode
-	Next *ListNode
-	Val  []byte
+	prev *ListNode
+	next *ListNode
+	// lp is nil when the node is compressed
+	lp         []byte
+	compressed []byte
+	// size is the bytes of the raw listpack
+	size  int
+	count int
 }
 
 func NewList() *List {
-	head := &ListNode{}
-	tail := &ListNode{}
-	head.Next = tail
-	tail.Prev = head
+	fill, depth := defaultListFill, 0
+	if config.Configures != nil {
+		fill, depth = config.Configures.ListMaxListpackSize, config.Configures.ListCompressDepth
+	}
+	if fill == 0 || fill < -len(listOptimizationLevel) {
+		fill = defaultListFill
+	}
 	return &List{
-		Head:           head,
-		Tail:           tail,
-		Len:            0,
-		LSubscriptions: make(map[string]Out, 1),
-		RSubscriptions: make(map[string]Out, 1),
+		fill:          fill,
+		compressDepth: depth,
+	}
+}
+
+func newListNode(val []byte) *ListNode {
+	lp := lpAppendEntry(nil, val)
+	return &ListNode{
+		lp:    lp,
+		size:  len(lp),
+		count: 1,
+	}
+}
+
+// entries return the raw listpack of n
+// A compressed node is decompressed into a new slice and n is not changed, so it is safe for readers
+func (n *ListNode) entries() []byte {
+	if n.compressed == nil {
+		return n.lp
 	}
+	// the data is compressed by ourselves, it can not be corrupted
+	lp, _ := lzfDecompress(n.compressed, n.size)
+	return lp
 }
 
-// AddSubscribe add one function to the subscription list
-func (l *List) AddSubscribe(ctx context.Context, out chan<- []byte, direction string) bool {
-	// check
-	if direction != "R" && direction != "L" {
+// decompress keep n as a raw listpack, it should be called before n is modified
+func (n *ListNode) decompress() {
+	if n.compressed == nil {
+		return
+	}
+	n.lp, _ = lzfDecompress(n.compressed, n.size)
+	n.compressed = nil
+}
+
+func (n *ListNode) compress() {
+	if n.compressed != nil || n.size < listMinCompressBytes {
+		return
+	}
+	compressed := lzfCompress(n.lp)
+	// keep the node raw if compression does not save enough
+	if compressed == nil || len(compressed)+8 > n.size {
+		return
+	}
+	n.compressed = compressed
+	n.lp = nil
+}
+
+func (n *ListNode) insert(off int, val []byte) {
+	if off == len(n.lp) {
+		n.lp = lpAppendEntry(n.lp, val)
+	} else {
+		n.lp = lpInsert(n.lp, off, val)
+	}
+	n.size = len(n.lp)
+	n.count++
+}
+
+// remove delete count entries in [off, end)
+func (n *ListNode) remove(off, end, count int) {
+	n.lp = lpDelete(n.lp, off, end)
+	n.size = len(n.lp)
+	n.count -= count
+}
+
+// split move the entries from off to a new node, idx is the index of the entry at off
+func (n *ListNode) split(off int, idx int) *ListNode {
+	right := &ListNode{
+		lp:    append([]byte(nil), n.lp[off:]...),
+		count: n.count - idx,
+	}
+	right.size = len(right.lp)
+	n.lp = n.lp[:off:off]
+	n.size = off
+	n.count = idx
+	return right
+}
+
+// allowInsert tell whether val can be added into n without exceeding list-max-listpack-size
+func (l *List) allowInsert(n *ListNode, val []byte) bool {
+	if n == nil {
 		return false
 	}
-	if direction == "R" {
-		// directly return the value if any element is available
-		if l.Len > 0 {
-			go func() {
-				out <- l.RPop().Val
-			}()
-			return true
-		}
-		subID := uuid.NewString()
-		l.RSubscriptions[subID] = out
-
-		//clean up func
-		go func() {
-			select {
-			case <-ctx.Done():
-				delete(l.RSubscriptions, subID)
-			}
-		}()
-		return true
+	size := n.size + lpEntrySize(val)
+	if l.fill > 0 {
+		return n.count < l.fill && size <= listSizeSafetyLimit
 	}
+	return size <= listOptimizationLevel[-l.fill-1]
+}
 
-	if direction == "L" {
-		// directly return the value if any element is available
-		if l.Len > 0 {
-			go func() {
-				out <- l.LPop().Val
-			}()
-			return true
-		}
-		subID := uuid.NewString()
-		l.LSubscriptions[subID] = out
+// linkAfter insert node after at, node becomes the head if at is nil
+func (l *List) linkAfter(at *ListNode, node *ListNode) {
+	node.prev = at
+	if at == nil {
+		node.next = l.head
+		l.head = node
+	} else {
+		node.next = at.next
+		at.next = node
+	}
+	if node.next == nil {
+		l.tail = node
+	} else {
+		node.next.prev = node
+	}
+	l.nodes++
+}
 
-		// clean up func
-		go func() {
-			select {
-			case <-ctx.Done():
-				delete(l.LSubscriptions, subID)
+func (l *List) unlink(node *ListNode) {
+	if node.prev == nil {
+		l.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+	if node.next == nil {
+		l.tail = node.prev
+	} else {
+		node.next.prev = node.prev
+	}
+	node.prev, node.next = nil, nil
+	l.nodes--
+}
 
-			}
-		}()
-		return true
+// compressEnds keep the compressDepth nodes at both ends raw, and compress the nodes right behind them
+// The nodes between are never touched by pushes and pops, so they stay compressed
+func (l *List) compressEnds() {
+	if l.compressDepth <= 0 {
+		return
+	}
+	front, back := l.head, l.tail
+	for i := 0; i < l.compressDepth && front != nil; i++ {
+		front.decompress()
+		back.decompress()
+		front, back = front.next, back.prev
+	}
+	if l.nodes > l.compressDepth*2 {
+		front.compress()
+		back.compress()
 	}
-	return false
 }
 
-func (l *List) Index(index int) *ListNode {
-	var node *ListNode
-	if index < 0 {
-		if -index > l.Len {
-			return nil
+// compressNode compress n after it is modified, unless it is within compressDepth nodes from either end
+func (l *List) compressNode(n *ListNode) {
+	if l.compressDepth <= 0 {
+		return
+	}
+	front, back := l.head, l.tail
+	for i := 0; i < l.compressDepth && front != nil; i++ {
+		if front == n || back == n {
+			return
 		}
-		node = l.Tail.Prev
-		for i := -1; i > index; i-- {
-			node = node.Prev
+		front, back = front.next, back.prev
+	}
+	n.compress()
+}
+
+// findNode return the node holding the index-th element and the index of the element in the node
+// index should be within [0, Len)
+func (l *List) findNode(index int) (*ListNode, int) {
+	if index < l.Len/2 {
+		n := l.head
+		for index >= n.count {
+			index -= n.count
+			n = n.next
 		}
-	} else {
-		if index >= l.Len {
-			return nil
+		return n, index
+	}
+	n := l.tail
+	index = l.Len - 1 - index
+	for index >= n.count {
+		index -= n.count
+		n = n.prev
+	}
+	return n, n.count - 1 - index
+}
+
+func copyBytes(val []byte) []byte {
+	res := make([]byte, len(val))
+	copy(res, val)
+	return res
+}
+
+// ForEach call fn with the elements from the start-th one to the tail, or to the head if reverse is set
+// It stops when fn returns false. val refers to the list and should be copied if it is kept
+func (l *List) ForEach(start int, reverse bool, fn func(index int, val []byte) bool) {
+	if start < 0 || start >= l.Len {
+		return
+	}
+	n, i := l.findNode(start)
+	lp := n.entries()
+	off := lpSeek(lp, n.count, i)
+	index := start
+	for {
+		val, next := lpGet(lp, off)
+		if !fn(index, val) {
+			return
 		}
-		node = l.Head.Next
-		for i := 0; i < index; i++ {
-			node = node.Next
+		if !reverse {
+			index++
+			off = next
+			if off == len(lp) {
+				if n = n.next; n == nil {
+					return
+				}
+				lp, off = n.entries(), 0
+			}
+		} else {
+			index--
+			if off == 0 {
+				if n = n.prev; n == nil {
+					return
+				}
+				lp = n.entries()
+				off = len(lp)
+			}
+			off = lpPrev(lp, off)
 		}
 	}
-	return node
+}
+
+// Index return the element at index, a negative index counts from the tail. nil is returned if it is out of range
+func (l *List) Index(index int) []byte {
+	if index < 0 {
+		index += l.Len
+	}
+	if index < 0 || index >= l.Len {
+		return nil
+	}
+	n, i := l.findNode(index)
+	lp := n.entries()
+	val, _ := lpGet(lp, lpSeek(lp, n.count, i))
+	return copyBytes(val)
 }
 
 func (l *List) Pos(val []byte) int {
-	pos := 0
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if bytes.Equal(now.Val, val) {
-			return pos
+	pos := -1
+	l.ForEach(0, false, func(index int, elem []byte) bool {
+		if bytes.Equal(elem, val) {
+			pos = index
+			return false
 		}
-		pos++
-	}
-	return -1
+		return true
+	})
+	return pos
 }
 
-func (l *List) LPop() *ListNode {
+// LPop remove and return the first element, nil if the list is empty
+func (l *List) LPop() []byte {
 	if l.Len == 0 {
 		return nil
 	}
-	node := l.Head.Next
-	l.Head.Next = node.Next
-	node.Next.Prev = l.Head
-	node.Prev = nil
-	node.Next = nil
+	n := l.head
+	n.decompress()
+	val, next := lpGet(n.lp, 0)
+	res := copyBytes(val)
+	n.remove(0, next, 1)
 	l.Len--
-	return node
+	if n.count == 0 {
+		l.unlink(n)
+	}
+	l.compressEnds()
+	return res
 }
 
-func (l *List) RPop() *ListNode {
+// RPop remove and return the last element, nil if the list is empty
+func (l *List) RPop() []byte {
 	if l.Len == 0 {
 		return nil
 	}
-	node := l.Tail.Prev
-	l.Tail.Prev = node.Prev
-	node.Prev.Next = l.Tail
-	node.Prev = nil
-	node.Next = nil
+	n := l.tail
+	n.decompress()
+	off := lpPrev(n.lp, len(n.lp))
+	val, _ := lpGet(n.lp, off)
+	res := copyBytes(val)
+	n.remove(off, len(n.lp), 1)
 	l.Len--
-	return node
+	if n.count == 0 {
+		l.unlink(n)
+	}
+	l.compressEnds()
+	return res
 }
 
 func (l *List) LPush(val []byte) {
-	node := &ListNode{Prev: l.Head, Next: l.Head.Next, Val: val}
-	l.Head.Next = node
-	node.Next.Prev = node
-	l.Len++
-	// check subscription
-	if len(l.LSubscriptions) > 0 {
-		// LPOP and return the Names to chan
-		for _, out := range l.LSubscriptions {
-			val := l.LPop().Val
-			out <- val
-			return
-		}
+	if l.allowInsert(l.head, val) {
+		l.head.decompress()
+		l.head.insert(0, val)
+	} else {
+		l.linkAfter(nil, newListNode(val))
 	}
+	l.Len++
+	l.compressEnds()
 }
 
 func (l *List) RPush(val []byte) {
-	node := &ListNode{Prev: l.Tail.Prev, Next: l.Tail, Val: val}
-	l.Tail.Prev = node
-	node.Prev.Next = node
-	l.Len++
-	// check subscriptions
-	if len(l.LSubscriptions) > 0 {
-		// RPop and return the Names to chan
-		for _, out := range l.LSubscriptions {
-			val := l.LPop().Val
-			out <- val
-			return
-		}
+	if l.allowInsert(l.tail, val) {
+		l.tail.decompress()
+		l.tail.insert(len(l.tail.lp), val)
+	} else {
+		l.linkAfter(l.tail, newListNode(val))
 	}
+	l.Len++
+	l.compressEnds()
 }
 
 func (l *List) Set(index int, val []byte) bool {
 	if index < 0 {
-		if -index > l.Len {
-			return false
-		}
-		node := l.Tail
-		for node != l.Head && index < 0 {
-			node = node.Prev
-			index++
-		}
-		node.Val = val
-	} else {
-		if index >= l.Len {
-			return false
-		}
-		node := l.Head
-		for node != l.Tail && index >= 0 {
-			node = node.Next
-			index--
-		}
-		node.Val = val
+		index += l.Len
 	}
+	if index < 0 || index >= l.Len {
+		return false
+	}
+	n, i := l.findNode(index)
+	n.decompress()
+	n.lp = lpReplace(n.lp, lpSeek(n.lp, n.count, i), val)
+	n.size = len(n.lp)
+	l.compressNode(n)
 	return true
 }
 
@@ -225,97 +389,155 @@ func (l *List) Range(start, end int) [][]byte {
 	}
 
 	res := make([][]byte, 0, end-start+1)
-	node := l.Head
-	for i := 0; i <= end; i++ {
-		node = node.Next
-		if i >= start {
-			res = append(res, node.Val)
-		}
-	}
+	l.ForEach(start, false, func(index int, val []byte) bool {
+		res = append(res, copyBytes(val))
+		return index < end
+	})
 	return res
 }
 
+// InsertBefore insert val before the first tar, return the position of val or -1 if tar is not found
 func (l *List) InsertBefore(val []byte, tar []byte) int {
-	pos := 0
-	ok := false
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if bytes.Equal(now.Val, tar) {
-			ok = true
-			node := &ListNode{Prev: now.Prev, Next: now, Val: val}
-			node.Prev = node
-			node.Prev.Next = node
-			break
-		}
-		pos++
+	pos := l.Pos(tar)
+	if pos == -1 {
+		return -1
 	}
-	if ok {
-		return pos
-	}
-	return -1
+	l.insertAt(pos, val)
+	return pos
 }
 
+// InsertAfter insert val after the first tar, return the position of val or -1 if tar is not found
 func (l *List) InsertAfter(val []byte, tar []byte) int {
-	pos := 0
-	ok := false
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if bytes.Equal(now.Val, tar) {
-			ok = true
-			node := &ListNode{Prev: now, Next: now.Next, Val: val}
-			node.Next = node
-			node.Next.Prev = node
-			break
-		}
-		pos++
+	pos := l.Pos(tar)
+	if pos == -1 {
+		return -1
 	}
-	if ok {
-		return pos + 1
-	}
-	return -1
+	l.insertAt(pos+1, val)
+	return pos + 1
 }
 
-func (l *List) RemoveElement(val []byte, count int) int {
-	if l.Len == 0 {
-		return 0
+// insertAt insert val so that it becomes the index-th element
+func (l *List) insertAt(index int, val []byte) {
+	if index == 0 {
+		l.LPush(val)
+		return
+	}
+	if index == l.Len {
+		l.RPush(val)
+		return
+	}
+	n, i := l.findNode(index)
+	n.decompress()
+	off := lpSeek(n.lp, n.count, i)
+	if l.allowInsert(n, val) {
+		n.insert(off, val)
+		l.compressNode(n)
+	} else {
+		// n is full, put val into a node of its own between the two halves of n
+		node := newListNode(val)
+		if i == 0 {
+			l.linkAfter(n.prev, node)
+		} else {
+			right := n.split(off, i)
+			l.linkAfter(n, right)
+			l.linkAfter(n, node)
+			l.compressNode(right)
+		}
+		l.compressNode(n)
+		l.compressNode(node)
 	}
+	l.Len++
+	l.compressEnds()
+}
 
-	if count == 0 {
-		count = l.Len - 1
+// RemoveElement remove count elements equal to val from the head, or from the tail if count is negative
+// All elements equal to val are removed if count is 0
+func (l *List) RemoveElement(val []byte, count int) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
 	}
 
 	removed := 0
-	var tem *ListNode
 	if count >= 0 {
-		for now := l.Head.Next; now != l.Tail && removed < count; {
-			if bytes.Equal(now.Val, val) {
-				tem = now.Next
-				now.Prev.Next = now.Next
-				now.Next.Prev = now.Prev
-				now.Prev = nil
-				now.Next = nil
-				removed++
-				now = tem
-			} else {
-				now = now.Next
+		for n := l.head; n != nil && (limit == 0 || removed < limit); {
+			next := n.next
+			n.decompress()
+			for off := 0; off < len(n.lp) && (limit == 0 || removed < limit); {
+				elem, end := lpGet(n.lp, off)
+				if bytes.Equal(elem, val) {
+					n.remove(off, end, 1)
+					removed++
+				} else {
+					off = end
+				}
 			}
+			l.settleNode(n)
+			n = next
 		}
 	} else {
-		for now := l.Tail.Prev; now != l.Head && removed < -count; {
-			if bytes.Equal(now.Val, val) {
-				tem = now.Prev
-				now.Prev.Next = now.Next
-				now.Next.Prev = now.Prev
-				now.Prev = nil
-				now.Next = nil
-				removed++
-				now = tem
-			} else {
-				now = now.Prev
+		for n := l.tail; n != nil && removed < limit; {
+			prev := n.prev
+			n.decompress()
+			for end := len(n.lp); end > 0 && removed < limit; {
+				off := lpPrev(n.lp, end)
+				elem, _ := lpGet(n.lp, off)
+				if bytes.Equal(elem, val) {
+					n.remove(off, end, 1)
+					removed++
+				}
+				end = off
 			}
+			l.settleNode(n)
+			n = prev
 		}
 	}
+	l.Len -= removed
+	l.compressEnds()
 	return removed
 }
 
+// settleNode unlink n if it becomes empty, or compress it again
+func (l *List) settleNode(n *ListNode) {
+	if n.count == 0 {
+		l.unlink(n)
+	} else {
+		l.compressNode(n)
+	}
+}
+
+// removeRange remove count elements from the index-th one
+func (l *List) removeRange(index int, count int) {
+	if count <= 0 {
+		return
+	}
+	n, i := l.findNode(index)
+	l.Len -= count
+	for count > 0 {
+		next := n.next
+		if i == 0 && count >= n.count {
+			count -= n.count
+			l.unlink(n)
+		} else {
+			num := n.count - i
+			if num > count {
+				num = count
+			}
+			n.decompress()
+			off := lpSeek(n.lp, n.count, i)
+			end := off
+			for j := 0; j < num; j++ {
+				_, end = lpGet(n.lp, end)
+			}
+			n.remove(off, end, num)
+			count -= num
+			l.compressNode(n)
+		}
+		n, i = next, 0
+	}
+	l.compressEnds()
+}
+
 func (l *List) Trim(start, end int) {
 	if l.Len == 0 {
 		return
@@ -339,48 +561,13 @@ func (l *List) Trim(start, end int) {
 		end = l.Len - 1
 	}
 
-	var startNode, endNode *ListNode
-	position := 0
-	for now := l.Head.Next; now != l.Tail; now = now.Next {
-		if position == start {
-			startNode = now
-		}
-		if position == end {
-			endNode = now
-			break
-		}
-		position++
-	}
-
-	// remove discarded nodes for gc
-	l.Head.Next.Prev = nil
-	l.Tail.Prev.Next = nil
-	if startNode.Prev != nil {
-		startNode.Prev.Next = nil
-	}
-	if endNode.Next != nil {
-		endNode.Next.Prev = nil
-	}
-
-	// link trimmed nodes to head and tail
-	l.Head.Next = startNode
-	startNode.Prev = l.Head
-	l.Tail.Prev = endNode
-	endNode.Next = l.Tail
-	l.Len = end - start + 1
+	l.removeRange(end+1, l.Len-end-1)
+	l.removeRange(0, start)
 }
 
 func (l *List) Clear() {
-	if l.Len == 0 {
-		return
-	}
-	first := l.Head.Next
-	last := l.Tail.Prev
-
-	l.Head.Next = l.Tail
-	l.Tail.Prev = l.Head
+	l.head = nil
+	l.tail = nil
 	l.Len = 0
-
-	first.Prev = nil
-	last.Next = nil
+	l.nodes = 0
 }
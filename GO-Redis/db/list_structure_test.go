@@ -0,0 +1,210 @@
+package db
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkList compare l with the model and check the nodes against fill and compressDepth
+// It returns the number of compressed nodes
+func checkList(t *testing.T, l *List, model [][]byte) int {
+	t.Helper()
+	if l.Len != len(model) {
+		t.Fatalf("Len = %d, want %d", l.Len, len(model))
+	}
+	got := l.Range(0, -1)
+	for i := range model {
+		if !bytes.Equal(got[i], model[i]) {
+			t.Fatalf("element %d = %q, want %q", i, got[i], model[i])
+		}
+	}
+	nodes, count, compressed := 0, 0, 0
+	for n := l.head; n != nil; n = n.next {
+		if n.count == 0 {
+			t.Fatalf("node %d is empty", nodes)
+		}
+		if n.count != lpCount(n.entries()) {
+			t.Fatalf("node %d count = %d, want %d", nodes, n.count, lpCount(n.entries()))
+		}
+		if l.fill > 0 && n.count > l.fill {
+			t.Fatalf("node %d holds %d elements, want at most %d", nodes, n.count, l.fill)
+		}
+		// the nodes within compressDepth from both ends must be raw
+		fromTail := l.nodes - 1 - nodes
+		if n.compressed != nil && (l.compressDepth == 0 || nodes < l.compressDepth || fromTail < l.compressDepth) {
+			t.Fatalf("node %d of %d is compressed with compress depth %d", nodes, l.nodes, l.compressDepth)
+		}
+		if n.compressed != nil {
+			compressed++
+		}
+		nodes++
+		count += n.count
+	}
+	if nodes != l.nodes || count != l.Len {
+		t.Fatalf("%d nodes with %d elements, want %d nodes with %d elements", nodes, count, l.nodes, l.Len)
+	}
+	return compressed
+}
+
+// lpCount return the number of entries of lp
+func lpCount(lp []byte) int {
+	count := 0
+	for off := 0; off < len(lp); count++ {
+		_, off = lpGet(lp, off)
+	}
+	return count
+}
+
+func TestListModel(t *testing.T) {
+	tests := []struct {
+		name          string
+		fill          int
+		compressDepth int
+	}{
+		{"one element per node", 1, 0},
+		{"small nodes", 4, 0},
+		{"small nodes compressed", 4, 1},
+		{"small nodes compressed deeper", 3, 2},
+		{"byte limited nodes", -1, 0},
+		{"byte limited nodes compressed", -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			l := &List{fill: tt.fill, compressDepth: tt.compressDepth}
+			var model [][]byte
+			compressed := 0
+			for i := 0; i < 3000; i++ {
+				// long and repeated elements so that the nodes are worth compressing
+				val := []byte(strings.Repeat(strconv.Itoa(r.Intn(20)), 1+r.Intn(100)))
+				switch op := r.Intn(10); {
+				case op < 2:
+					l.LPush(val)
+					model = append([][]byte{val}, model...)
+				case op < 4:
+					l.RPush(val)
+					model = append(model, val)
+				case op == 4:
+					got := l.LPop()
+					if len(model) == 0 {
+						if got != nil {
+							t.Fatalf("LPop() = %q on an empty list", got)
+						}
+						break
+					}
+					if !bytes.Equal(got, model[0]) {
+						t.Fatalf("LPop() = %q, want %q", got, model[0])
+					}
+					model = model[1:]
+				case op == 5:
+					got := l.RPop()
+					if len(model) == 0 {
+						if got != nil {
+							t.Fatalf("RPop() = %q on an empty list", got)
+						}
+						break
+					}
+					if !bytes.Equal(got, model[len(model)-1]) {
+						t.Fatalf("RPop() = %q, want %q", got, model[len(model)-1])
+					}
+					model = model[:len(model)-1]
+				case op == 6:
+					if len(model) == 0 {
+						break
+					}
+					index := r.Intn(len(model))
+					l.Set(index, val)
+					model[index] = val
+				case op == 7:
+					if len(model) == 0 {
+						break
+					}
+					pivot := model[r.Intn(len(model))]
+					pos := 0
+					for !bytes.Equal(model[pos], pivot) {
+						pos++
+					}
+					if r.Intn(2) == 0 {
+						l.InsertBefore(val, pivot)
+					} else {
+						l.InsertAfter(val, pivot)
+						pos++
+					}
+					model = append(model[:pos], append([][]byte{val}, model[pos:]...)...)
+				case op == 8:
+					count := r.Intn(5) - 2
+					removed := l.RemoveElement(val, count)
+					want := 0
+					if count >= 0 {
+						for j := 0; j < len(model); j++ {
+							if bytes.Equal(model[j], val) && (count == 0 || want < count) {
+								model = append(model[:j], model[j+1:]...)
+								want++
+								j--
+							}
+						}
+					} else {
+						for j := len(model) - 1; j >= 0 && want < -count; j-- {
+							if bytes.Equal(model[j], val) {
+								model = append(model[:j], model[j+1:]...)
+								want++
+							}
+						}
+					}
+					if removed != want {
+						t.Fatalf("RemoveElement(%q, %d) = %d, want %d", val, count, removed, want)
+					}
+				case op == 9:
+					// trim rarely, so that the list keeps growing
+					if r.Intn(10) != 0 || len(model) == 0 {
+						break
+					}
+					start, end := r.Intn(len(model)), len(model)-1-r.Intn(len(model))
+					l.Trim(start, end)
+					if start > end {
+						model = nil
+					} else {
+						model = append([][]byte(nil), model[start:end+1]...)
+					}
+				}
+				compressed += checkList(t, l, model)
+			}
+			if tt.compressDepth > 0 && compressed == 0 {
+				t.Fatalf("no node was ever compressed")
+			}
+		})
+	}
+}
+
+func TestListIndex(t *testing.T) {
+	l := &List{fill: 2, compressDepth: 1}
+	for i := 0; i < 10; i++ {
+		l.RPush([]byte(strconv.Itoa(i)))
+	}
+	tests := []struct {
+		index int
+		want  []byte
+	}{
+		{0, []byte("0")},
+		{5, []byte("5")},
+		{9, []byte("9")},
+		{-1, []byte("9")},
+		{-10, []byte("0")},
+		{10, nil},
+		{-11, nil},
+	}
+	for _, tt := range tests {
+		if got := l.Index(tt.index); !bytes.Equal(got, tt.want) {
+			t.Fatalf("Index(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+	if pos := l.Pos([]byte("7")); pos != 7 {
+		t.Fatalf("Pos(7) = %d, want 7", pos)
+	}
+	if pos := l.Pos([]byte("missing")); pos != -1 {
+		t.Fatalf("Pos(missing) = %d, want -1", pos)
+	}
+}
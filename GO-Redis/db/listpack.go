@@ -0,0 +1,117 @@
+package db
+
+import "encoding/binary"
+
+// A listpack packs a sequence of elements into one byte slice
+// Each entry is encoded as <length><data><backlen>
+//   - length is the uvarint length of data
+//   - backlen is the size of length and data, stored backwards in 7 bits groups so the entries can be walked from the end
+// The functions below take the listpack and the offset of an entry, the offset of the first entry is 0
+
+// lpEntrySize return the bytes val takes in a listpack
+func lpEntrySize(val []byte) int {
+	size := uvarintSize(uint64(len(val))) + len(val)
+	return size + uvarintSize(uint64(size))
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// lpAppendEntry append the encoding of val to buf
+func lpAppendEntry(buf []byte, val []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(val)))
+	buf = append(buf, val...)
+	size := uvarintSize(uint64(len(val))) + len(val)
+
+	// the lowest group is the last byte, a set high bit means more groups on its left
+	var groups [binary.MaxVarintLen64]byte
+	n := 0
+	for {
+		b := byte(size & 0x7f)
+		size >>= 7
+		if size != 0 {
+			b |= 0x80
+		}
+		groups[n] = b
+		n++
+		if size == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, groups[i])
+	}
+	return buf
+}
+
+// lpGet return the data of the entry at off and the offset of the next entry
+// The data refers to lp and should be copied before lp is modified
+func lpGet(lp []byte, off int) ([]byte, int) {
+	length, n := binary.Uvarint(lp[off:])
+	start := off + n
+	end := start + int(length)
+	return lp[start:end], end + uvarintSize(uint64(end-off))
+}
+
+// lpPrev return the offset of the entry ending at off
+func lpPrev(lp []byte, off int) int {
+	size, shift := 0, 0
+	for i := off - 1; ; i-- {
+		b := lp[i]
+		size |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return i - size
+		}
+	}
+}
+
+// lpSeek return the offset of the index-th entry of lp which has count entries
+// A negative index counts from the end
+func lpSeek(lp []byte, count int, index int) int {
+	if index < 0 {
+		index += count
+	}
+	if index < count/2 {
+		off := 0
+		for i := 0; i < index; i++ {
+			_, off = lpGet(lp, off)
+		}
+		return off
+	}
+	off := len(lp)
+	for i := count; i > index; i-- {
+		off = lpPrev(lp, off)
+	}
+	return off
+}
+
+// lpInsert insert val as a new entry at off
+func lpInsert(lp []byte, off int, val []byte) []byte {
+	entry := lpAppendEntry(make([]byte, 0, lpEntrySize(val)), val)
+	res := make([]byte, 0, len(lp)+len(entry))
+	res = append(res, lp[:off]...)
+	res = append(res, entry...)
+	return append(res, lp[off:]...)
+}
+
+// lpDelete remove the entries in [off, end)
+func lpDelete(lp []byte, off int, end int) []byte {
+	return append(lp[:off], lp[end:]...)
+}
+
+// lpReplace replace the data of the entry at off by val
+func lpReplace(lp []byte, off int, val []byte) []byte {
+	_, end := lpGet(lp, off)
+	entry := lpAppendEntry(make([]byte, 0, lpEntrySize(val)), val)
+	res := make([]byte, 0, len(lp)-(end-off)+len(entry))
+	res = append(res, lp[:off]...)
+	res = append(res, entry...)
+	return append(res, lp[end:]...)
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// lpBuild return a listpack of vals
+func lpBuild(vals [][]byte) []byte {
+	var lp []byte
+	for _, val := range vals {
+		lp = lpAppendEntry(lp, val)
+	}
+	return lp
+}
+
+func TestListpackRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		vals [][]byte
+	}{
+		{"empty entry", [][]byte{{}}},
+		{"small entries", [][]byte{[]byte("a"), []byte("bc"), []byte("def")}},
+		{"binary entries", [][]byte{{0, 0x80, 0xff}, []byte("\r\n")}},
+		// the length and the backlen take 2 bytes from 128 bytes and 3 bytes from 16384 bytes
+		{"two bytes length", [][]byte{bytes.Repeat([]byte("x"), 127), bytes.Repeat([]byte("y"), 128), []byte("z")}},
+		{"three bytes length", [][]byte{[]byte("a"), bytes.Repeat([]byte("x"), 16384), []byte("b")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lp := lpBuild(tt.vals)
+			size := 0
+			for _, val := range tt.vals {
+				size += lpEntrySize(val)
+			}
+			if len(lp) != size {
+				t.Fatalf("len(lp) = %d, want the sum of lpEntrySize %d", len(lp), size)
+			}
+			// walk forward
+			off := 0
+			for i, want := range tt.vals {
+				var got []byte
+				got, off = lpGet(lp, off)
+				if !bytes.Equal(got, want) {
+					t.Fatalf("entry %d = %q, want %q", i, got, want)
+				}
+			}
+			if off != len(lp) {
+				t.Fatalf("the last entry ends at %d, want %d", off, len(lp))
+			}
+			// walk backward
+			off = len(lp)
+			for i := len(tt.vals) - 1; i >= 0; i-- {
+				off = lpPrev(lp, off)
+				if got, _ := lpGet(lp, off); !bytes.Equal(got, tt.vals[i]) {
+					t.Fatalf("entry %d walking backward = %q, want %q", i, got, tt.vals[i])
+				}
+			}
+			if off != 0 {
+				t.Fatalf("the first entry walking backward is at %d, want 0", off)
+			}
+			// seek from both ends
+			for i, want := range tt.vals {
+				if got, _ := lpGet(lp, lpSeek(lp, len(tt.vals), i)); !bytes.Equal(got, want) {
+					t.Fatalf("lpSeek(%d) = %q, want %q", i, got, want)
+				}
+				if got, _ := lpGet(lp, lpSeek(lp, len(tt.vals), i-len(tt.vals))); !bytes.Equal(got, want) {
+					t.Fatalf("lpSeek(%d) = %q, want %q", i-len(tt.vals), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestListpackEdit(t *testing.T) {
+	long := strings.Repeat("l", 200)
+	tests := []struct {
+		name string
+		edit func(lp []byte) []byte
+		want []string
+	}{
+		{"insert first", func(lp []byte) []byte { return lpInsert(lp, 0, []byte("x")) }, []string{"x", "a", "b", "c"}},
+		{"insert middle", func(lp []byte) []byte { return lpInsert(lp, lpSeek(lp, 3, 1), []byte(long)) }, []string{"a", long, "b", "c"}},
+		{"insert last", func(lp []byte) []byte { return lpInsert(lp, len(lp), []byte("x")) }, []string{"a", "b", "c", "x"}},
+		{"delete one", func(lp []byte) []byte { return lpDelete(lp, lpSeek(lp, 3, 1), lpSeek(lp, 3, 2)) }, []string{"a", "c"}},
+		{"delete all", func(lp []byte) []byte { return lpDelete(lp, 0, len(lp)) }, []string{}},
+		{"replace with longer", func(lp []byte) []byte { return lpReplace(lp, lpSeek(lp, 3, 1), []byte(long)) }, []string{"a", long, "c"}},
+		{"replace with empty", func(lp []byte) []byte { return lpReplace(lp, 0, nil) }, []string{"", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lp := tt.edit(lpBuild([][]byte{[]byte("a"), []byte("b"), []byte("c")}))
+			got := make([]string, 0)
+			for off := 0; off < len(lp); {
+				var val []byte
+				val, off = lpGet(lp, off)
+				got = append(got, string(val))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || len(got) != len(tt.want) {
+				t.Fatalf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import "errors"
+
+// lzf is the compression format used by redis for list nodes and rdb strings
+// A compressed stream is a sequence of
+//   - literal runs: 000LLLLL followed by L+1 bytes copied as is
+//   - back references: LLLOOOOO [LLLLLLLL] OOOOOOOO copying L+2 bytes from O+1 bytes back,
+//     the extra length byte is only present when LLL is 7
+
+const (
+	lzfHashLog   = 14
+	lzfHashSize  = 1 << lzfHashLog
+	lzfMaxLit    = 1 << 5
+	lzfMaxOffset = 1 << 13
+	lzfMaxRef    = (1 << 8) + (1 << 3)
+)
+
+var errLzfCorrupted = errors.New("lzf: corrupted compressed data")
+
+// lzfCompress compress in, nil is returned if the result is not smaller than in
+func lzfCompress(in []byte) []byte {
+	n := len(in)
+	if n < 4 {
+		return nil
+	}
+	var htab [lzfHashSize]int
+	out := make([]byte, 0, n)
+	// litPos is the position of the control byte of the current literal run
+	out = append(out, 0)
+	litPos, lit := 0, 0
+
+	ip := 0
+	for ip+2 < n {
+		h := lzfHash(in[ip], in[ip+1], in[ip+2])
+		ref := htab[h] - 1
+		htab[h] = ip + 1
+		if ref >= 0 && ip-ref-1 < lzfMaxOffset && in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+			off := ip - ref - 1
+			length := 3
+			for ip+length < n && length < lzfMaxRef && in[ref+length] == in[ip+length] {
+				length++
+			}
+
+			// close the literal run before the back reference
+			if lit == 0 {
+				out = out[:len(out)-1]
+			} else {
+				out[litPos] = byte(lit - 1)
+			}
+			l := length - 2
+			if l < 7 {
+				out = append(out, byte(l<<5)|byte(off>>8))
+			} else {
+				out = append(out, byte(7<<5)|byte(off>>8), byte(l-7))
+			}
+			out = append(out, byte(off))
+			if len(out) >= n {
+				return nil
+			}
+
+			// index the positions inside the match so that later data can refer to them
+			for i := ip + 1; i < ip+length && i+2 < n; i++ {
+				htab[lzfHash(in[i], in[i+1], in[i+2])] = i + 1
+			}
+			ip += length
+			out = append(out, 0)
+			litPos, lit = len(out)-1, 0
+			continue
+		}
+
+		out = append(out, in[ip])
+		ip++
+		lit++
+		if lit == lzfMaxLit {
+			out[litPos] = lzfMaxLit - 1
+			out = append(out, 0)
+			litPos, lit = len(out)-1, 0
+		}
+		if len(out) >= n {
+			return nil
+		}
+	}
+
+	for ; ip < n; ip++ {
+		out = append(out, in[ip])
+		lit++
+		if lit == lzfMaxLit {
+			out[litPos] = lzfMaxLit - 1
+			out = append(out, 0)
+			litPos, lit = len(out)-1, 0
+		}
+	}
+	if lit == 0 {
+		out = out[:len(out)-1]
+	} else {
+		out[litPos] = byte(lit - 1)
+	}
+	if len(out) >= n {
+		return nil
+	}
+	return out
+}
+
+func lzfHash(a, b, c byte) int {
+	v := uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+	return int(((v >> (3*8 - lzfHashLog)) - v*5) & (lzfHashSize - 1))
+}
+
+// lzfDecompress decompress in whose original length is outLen
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	ip := 0
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < lzfMaxLit {
+			// literal run
+			length := ctrl + 1
+			if ip+length > len(in) || len(out)+length > outLen {
+				return nil, errLzfCorrupted
+			}
+			out = append(out, in[ip:ip+length]...)
+			ip += length
+			continue
+		}
+
+		// back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errLzfCorrupted
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errLzfCorrupted
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1 - int(in[ip])
+		ip++
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, errLzfCorrupted
+		}
+		// the reference may overlap the bytes being written, so copy byte by byte
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errLzfCorrupted
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestLzfRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name string
+		in   []byte
+		// compressible inputs must get smaller
+		compressible bool
+	}{
+		{"too short", []byte("abc"), false},
+		{"repeated byte", bytes.Repeat([]byte("a"), 1000), true},
+		{"repeated pattern", bytes.Repeat([]byte("hello world "), 100), true},
+		{"long back reference", append(bytes.Repeat([]byte("0123456789"), 50), bytes.Repeat([]byte("x"), 300)...), true},
+		{"far back reference", append(append(append([]byte{}, random[:2000]...), random[:2000]...), random[:2000]...), true},
+		{"random", random, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := lzfCompress(tt.in)
+			if compressed == nil {
+				if tt.compressible {
+					t.Fatalf("lzfCompress() = nil, want a compressed stream")
+				}
+				return
+			}
+			if len(compressed) >= len(tt.in) {
+				t.Fatalf("lzfCompress() returned %d bytes for %d bytes", len(compressed), len(tt.in))
+			}
+			out, err := lzfDecompress(compressed, len(tt.in))
+			if err != nil {
+				t.Fatalf("lzfDecompress() error = %v", err)
+			}
+			if !bytes.Equal(out, tt.in) {
+				t.Fatalf("lzfDecompress() does not return the input")
+			}
+		})
+	}
+}
+
+func TestLzfDecompressCorrupted(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []byte
+		outLen int
+	}{
+		{"truncated literal", []byte{4, 'a', 'b'}, 5},
+		{"literal longer than the output", []byte{2, 'a', 'b', 'c'}, 2},
+		{"reference before the start", []byte{0, 'a', 0x20, 5}, 4},
+		{"truncated reference", []byte{0, 'a', 0x20}, 4},
+		{"shorter than the output", []byte{1, 'a', 'b'}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := lzfDecompress(tt.in, tt.outLen); err == nil {
+				t.Fatalf("lzfDecompress() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"context"
+	"os"
+	"testing"
+)
+
+// TestMain give the tests the default configuration and register the commands, as the server does on startup
+func TestMain(m *testing.M) {
+	config.Configures = &config.Config{
+		ShardNumber:         16,
+		Databases:           16,
+		ListMaxListpackSize: -2,
+		ReplicaReadOnly:     true,
+		ReplBacklogSize:     1024 * 1024,
+		Others:              make(map[string]any),
+	}
+	RegisterKeyCommands()
+	RegisterStringCommands()
+	RegisterListCommands()
+	RegisterHashCommands()
+	RegisterSetCommands()
+	RegisterSortedSetCommands()
+	RegisterStreamCommands()
+	RegisterConnectionCommands()
+	RegisterServerCommands()
+	RegisterReplicationCommands()
+	RegisterClusterCommands()
+	RegisterPubSubCommands()
+	os.Exit(m.Run())
+}
+
+// execString run the command given as arguments on db and return its reply in RESP2
+func execString(db *DB, args ...string) string {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	return string(data.ToProtocol(db.ExecCommand(context.Background(), cmd, nil), data.RESP2).ToBytes())
+}
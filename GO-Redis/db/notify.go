@@ -0,0 +1,112 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// implements the keyspace notifications of redis
+// The events enabled by notify-keyspace-events are published to __keyspace@<db>__:<key> with the event as
+// message, and to __keyevent@<db>__:<event> with the key as message. They are only published on this server,
+// as redis does in cluster mode.
+
+// the classes of notify-keyspace-events, each is enabled by a character
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e, never fired as keys are never evicted
+	notifyStream               // t
+	notifyModule               // d, never fired as there is no module
+	notifyKeyMiss              // m
+	notifyNew                  // n
+	// notifyAll is the classes enabled by A, all but the key misses and the new keys
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset | notifyExpired |
+		notifyEvicted | notifyStream | notifyModule
+)
+
+// parseNotifyKeyspaceEvents return the classes enabled by the characters of s, false if one is unknown
+func parseNotifyKeyspaceEvents(s string) (int, bool) {
+	flags := 0
+	for _, c := range s {
+		switch c {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZset
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 't':
+			flags |= notifyStream
+		case 'd':
+			flags |= notifyModule
+		case 'm':
+			flags |= notifyKeyMiss
+		case 'n':
+			flags |= notifyNew
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		default:
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// notifyKeyMisses fire the keymiss event of the keys read by c which do not exist
+// EXISTS only tests its keys and fires no event, as in redis.
+func (db *DB) notifyKeyMisses(c *command, cmd [][]byte) {
+	if db.dbs == nil || db.dbs.notifyFlags&notifyKeyMiss == 0 || strings.ToLower(string(cmd[0])) == "exists" {
+		return
+	}
+	for _, key := range c.keys(cmd) {
+		if !db.CheckTTL(key) {
+			db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+			continue
+		}
+		db.locks.RLock(key)
+		_, ok := db.db.Get(key)
+		db.locks.RUnLock(key)
+		if !ok {
+			db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+		}
+	}
+}
+
+// notifyKeyspaceEvent publish event on key if its class is enabled
+func (db *DB) notifyKeyspaceEvent(class int, event string, key string) {
+	if db.dbs == nil {
+		return
+	}
+	flags := db.dbs.notifyFlags
+	if flags&class == 0 || flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return
+	}
+	index := strconv.Itoa(db.Index())
+	if flags&notifyKeyspace != 0 {
+		db.dbs.pubsub.publish("__keyspace@"+index+"__:"+key, []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		db.dbs.pubsub.publish("__keyevent@"+index+"__:"+event, []byte(key))
+	}
+}
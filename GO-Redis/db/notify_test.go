@@ -0,0 +1,26 @@
+package db
+
+import "testing"
+
+func TestParseNotifyKeyspaceEvents(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		flags int
+		ok    bool
+	}{
+		{"disabled", "", 0, true},
+		{"all keyspace events", "KA", notifyKeyspace | notifyAll, true},
+		{"evicted and module classes", "Eed", notifyKeyevent | notifyEvicted | notifyModule, true},
+		{"new and key misses are not in A", "EAnm", notifyKeyevent | notifyAll | notifyNew | notifyKeyMiss, true},
+		{"unknown class", "Ky", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, ok := parseNotifyKeyspaceEvents(tt.s)
+			if flags != tt.flags || ok != tt.ok {
+				t.Fatalf("parseNotifyKeyspaceEvents(%q) = %b, %v, want %b, %v", tt.s, flags, ok, tt.flags, tt.ok)
+			}
+		})
+	}
+}
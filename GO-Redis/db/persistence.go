@@ -0,0 +1,536 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bgSaveRetryDelay is how long to wait before a save rule triggers BGSAVE again after a failure
+const bgSaveRetryDelay = 5 * time.Second
+
+var errBgSaveInProgress = errors.New("ERR Background save already in progress")
+
+// persistence record the rdb saving state of the dbs
+type persistence struct {
+	// dirty is the number of writes since the last successful save
+	dirty atomic.Int64
+	// lastSave is the unix time of the last successful save
+	lastSave atomic.Int64
+
+	mu sync.Mutex
+	// bgSaving is set while a background save is writing the file
+	bgSaving      bool
+	bgSaveWG      sync.WaitGroup
+	lastBgSaveOK  bool
+	lastBgSaveTry time.Time
+}
+
+// RDBPath return the path of the rdb file given by dir and dbfilename
+func RDBPath() string {
+	return filepath.Join(config.Configures.Dir, config.Configures.DBFilename)
+}
+
+// AddDirty count writes for the save rules
+func (dbs *Databases) AddDirty(n int64) {
+	dbs.persist.dirty.Add(n)
+}
+
+// LastSave return the unix time of the last successful save
+func (dbs *Databases) LastSave() int64 {
+	return dbs.persist.lastSave.Load()
+}
+
+// datasetDump dumps the dataset as it was when the dump started, for a background save, rewrite or full resync
+// The keys are dumped one by one without stopping the writers: a write command first dumps the keys it writes
+// which are not dumped yet, see beforeWrite, so that the dump never sees a change made after the start.
+type datasetDump struct {
+	dbs *Databases
+	mu  sync.Mutex
+	// indexes are the indexes of the dbs when the dump started
+	indexes map[*DB]int
+	// dumped are the keys of each db which are dumped or were written after the start
+	dumped map[*DB]map[string]struct{}
+	// done is set once every key is dumped
+	done bool
+	// dump encode a key which is neither missing nor expired, expireAt is its expire time in unix seconds,
+	// 0 if it has none. It is called with mu and the lock of key held.
+	dump func(index int, key string, val any, expireAt int64)
+}
+
+// startDump start a dump of the dataset calling dump for each key
+// writeMu should be held exclusively, so that the dump starts between two writes.
+func (dbs *Databases) startDump(dump func(index int, key string, val any, expireAt int64)) *datasetDump {
+	dbs.mu.RLock()
+	d := &datasetDump{
+		dbs:     dbs,
+		indexes: make(map[*DB]int, len(dbs.dbs)),
+		dumped:  make(map[*DB]map[string]struct{}, len(dbs.dbs)),
+		dump:    dump,
+	}
+	for i, db := range dbs.dbs {
+		d.indexes[db] = i
+		d.dumped[db] = make(map[string]struct{})
+	}
+	dbs.mu.RUnlock()
+
+	dbs.dumpsMu.Lock()
+	defer dbs.dumpsMu.Unlock()
+	if dbs.dumps == nil {
+		dbs.dumps = make(map[*datasetDump]struct{})
+	}
+	dbs.dumps[d] = struct{}{}
+	return d
+}
+
+// dumpKey dump key unless it is already dumped, d.mu should be held
+func (d *datasetDump) dumpKey(db *DB, key string) {
+	if d.done {
+		return
+	}
+	index, ok := d.indexes[db]
+	if !ok {
+		return
+	}
+	if _, ok = d.dumped[db][key]; ok {
+		return
+	}
+	d.dumped[db][key] = struct{}{}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+	val, ok := db.db.Get(key)
+	if !ok {
+		return
+	}
+	at := db.expireAt(key)
+	if at > 0 && at <= time.Now().Unix() {
+		return
+	}
+	d.dump(index, key, val, at)
+}
+
+// dumpKeys dump keys of db before they are written
+func (d *datasetDump) dumpKeys(db *DB, keys []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range keys {
+		d.dumpKey(db, key)
+	}
+}
+
+// dumpAll dump all the keys which are not dumped yet, it is used before the writes which change a whole db
+func (d *datasetDump) dumpAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for db := range d.indexes {
+		for _, key := range db.db.Keys() {
+			d.dumpKey(db, key)
+		}
+	}
+	d.done = true
+}
+
+// run dump the dataset key by key until every key is dumped
+func (d *datasetDump) run() {
+	for db := range d.indexes {
+		for _, key := range db.db.Keys() {
+			d.mu.Lock()
+			d.dumpKey(db, key)
+			done := d.done
+			d.mu.Unlock()
+			if done {
+				break
+			}
+		}
+	}
+	d.mu.Lock()
+	d.done = true
+	d.mu.Unlock()
+
+	d.dbs.dumpsMu.Lock()
+	delete(d.dbs.dumps, d)
+	d.dbs.dumpsMu.Unlock()
+}
+
+// writtenKeys return the keys the write command cmd may write, nil if it may write keys of any db
+// MOVE also writes its key in another db, FLUSHDB or SWAPDB have no key but write a whole db.
+func writtenKeys(c *command, cmd [][]byte) []string {
+	keys := c.keys(cmd)
+	if len(keys) == 0 || strings.ToLower(string(cmd[0])) == "move" {
+		return nil
+	}
+	return keys
+}
+
+// beforeWrite dump keys of db before they are written for the running dumps of the dataset, see datasetDump
+// All the keys not dumped yet are dumped when keys is nil. It should be called with writeMu held.
+func (dbs *Databases) beforeWrite(db *DB, keys []string) {
+	dbs.dumpsMu.Lock()
+	if len(dbs.dumps) == 0 {
+		dbs.dumpsMu.Unlock()
+		return
+	}
+	dumps := make([]*datasetDump, 0, len(dbs.dumps))
+	for d := range dbs.dumps {
+		dumps = append(dumps, d)
+	}
+	dbs.dumpsMu.Unlock()
+
+	for _, d := range dumps {
+		if keys == nil {
+			d.dumpAll()
+		} else {
+			d.dumpKeys(db, keys)
+		}
+	}
+}
+
+// rdbSnapshot is the dataset dumped by a save in rdb format, see datasetDump
+type rdbSnapshot struct {
+	*datasetDump
+	// entries are the keys encoded for each db index
+	entries map[int]*rdbEntries
+	// err is the first error of encoding
+	err error
+	// dirty is the dirty count covered by the snapshot
+	dirty int64
+}
+
+// rdbEntries are the encoded keys of a db
+type rdbEntries struct {
+	rdbEncoder
+	keys    int
+	expires int
+}
+
+// newRDBSnapshot start the dump of the dataset in rdb format, writeMu should be held exclusively
+func newRDBSnapshot(dbs *Databases) *rdbSnapshot {
+	s := &rdbSnapshot{
+		entries: make(map[int]*rdbEntries),
+		dirty:   dbs.persist.dirty.Load(),
+	}
+	s.datasetDump = dbs.startDump(func(index int, key string, val any, expireAt int64) {
+		if s.err != nil {
+			return
+		}
+		entries, ok := s.entries[index]
+		if !ok {
+			entries = &rdbEntries{}
+			s.entries[index] = entries
+		}
+		if err := entries.writeEntry(key, val, expireAt*1000); err != nil {
+			s.err = err
+			return
+		}
+		entries.keys++
+		if expireAt > 0 {
+			entries.expires++
+		}
+	})
+	return s
+}
+
+// encode dump the dataset and return the rdb file content, aux are extra fields written in the header
+func (s *rdbSnapshot) encode(aux map[string]string) ([]byte, error) {
+	s.run()
+	if s.err != nil {
+		return nil, s.err
+	}
+	e := &rdbEncoder{}
+	e.writeHeader()
+	for key, val := range aux {
+		e.writeAux(key, val)
+	}
+	indexes := make([]int, 0, len(s.entries))
+	for index := range s.entries {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		entries := s.entries[index]
+		e.writeByte(rdbOpcodeSelectDB)
+		e.writeLength(uint64(index))
+		e.writeByte(rdbOpcodeResizeDB)
+		e.writeLength(uint64(entries.keys))
+		e.writeLength(uint64(entries.expires))
+		e.buf = append(e.buf, entries.buf...)
+	}
+	e.writeEOF()
+	return e.buf, nil
+}
+
+// snapshot encode all dbs into rdb format, it returns the file content and the dirty count it covers
+// The dataset is dumped as it is when snapshot is called without stopping the writers, see rdbSnapshot.
+// aux are extra fields written in the header.
+func (dbs *Databases) snapshot(aux map[string]string) ([]byte, int64, error) {
+	dbs.writeMu.Lock()
+	s := newRDBSnapshot(dbs)
+	dbs.writeMu.Unlock()
+	content, err := s.encode(aux)
+	return content, s.dirty, err
+}
+
+// writeRDBFile write content into a temp file and rename it to path, so path is never half written
+func writeRDBFile(path string, content []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// Save write a snapshot of all dbs into the rdb file and block until it is done
+func (dbs *Databases) Save() error {
+	p := &dbs.persist
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bgSaving {
+		return errBgSaveInProgress
+	}
+	content, dirty, err := dbs.snapshot(nil)
+	if err == nil {
+		err = writeRDBFile(RDBPath(), content)
+	}
+	if err != nil {
+		log.Printf("Save rdb error: %s", err.Error())
+		return err
+	}
+	p.dirty.Add(-dirty)
+	p.lastSave.Store(time.Now().Unix())
+	log.Printf("DB saved on disk")
+	return nil
+}
+
+// BGSave take a snapshot of all dbs and write it into the rdb file in background
+// The dataset is dumped as it is when BGSave is called, see rdbSnapshot, the writers are not stopped meanwhile.
+func (dbs *Databases) BGSave() error {
+	p := &dbs.persist
+	p.mu.Lock()
+	if p.bgSaving {
+		p.mu.Unlock()
+		return errBgSaveInProgress
+	}
+	p.bgSaving = true
+	p.lastBgSaveTry = time.Now()
+	p.bgSaveWG.Add(1)
+	p.mu.Unlock()
+
+	dbs.writeMu.Lock()
+	s := newRDBSnapshot(dbs)
+	dbs.writeMu.Unlock()
+	go func() {
+		content, err := s.encode(nil)
+		if err == nil {
+			err = writeRDBFile(RDBPath(), content)
+		}
+		dbs.finishBGSave(s.dirty, err)
+	}()
+	return nil
+}
+
+func (dbs *Databases) finishBGSave(dirty int64, err error) {
+	p := &dbs.persist
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.bgSaveWG.Done()
+	p.bgSaving = false
+	p.lastBgSaveOK = err == nil
+	if err != nil {
+		log.Printf("Background saving error: %s", err.Error())
+		return
+	}
+	p.dirty.Add(-dirty)
+	p.lastSave.Store(time.Now().Unix())
+	log.Printf("Background saving terminated with success")
+}
+
+// ServeSaveRules start BGSAVE when a save rule of the config is satisfied, until ctx is done
+func (dbs *Databases) ServeSaveRules(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p := &dbs.persist
+		p.mu.Lock()
+		// a failed save is retried after a delay instead of every second
+		canSave := !p.bgSaving && (p.lastBgSaveOK || time.Since(p.lastBgSaveTry) >= bgSaveRetryDelay)
+		p.mu.Unlock()
+		if !canSave {
+			continue
+		}
+
+		dirty := p.dirty.Load()
+		elapsed := time.Now().Unix() - p.lastSave.Load()
+		for _, rule := range config.Configures.SaveRules {
+			if dirty >= int64(rule.Changes) && elapsed >= int64(rule.Seconds) {
+				log.Printf("%d changes in %d seconds. Saving...", rule.Changes, rule.Seconds)
+				_ = dbs.BGSave()
+				break
+			}
+		}
+	}
+}
+
+// Shutdown stop the replication, close the append only file, wait for the background save and save the dbs
+// if any save rule is configured
+func (dbs *Databases) Shutdown() {
+	dbs.stopReplication()
+	dbs.closeAOF()
+	dbs.persist.bgSaveWG.Wait()
+	if len(config.Configures.SaveRules) > 0 {
+		_ = dbs.Save()
+	}
+}
+
+// LoadRDB load the keys in the rdb file at path into dbs, nothing is done if the file does not exist
+func (dbs *Databases) LoadRDB(path string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	keys, _, err := dbs.loadRDB(content)
+	if err != nil {
+		return fmt.Errorf("load rdb %s: %w", path, err)
+	}
+	dbs.persist.lastSave.Store(time.Now().Unix())
+	log.Printf("DB loaded from disk: %d keys in %.3f seconds", keys, time.Since(start).Seconds())
+	return nil
+}
+
+// loadRDB load the keys in content into dbs, it returns the number of keys and the aux fields of the header
+func (dbs *Databases) loadRDB(content []byte) (int, map[string]string, error) {
+	if len(content) < 9 || string(content[:5]) != "REDIS" {
+		return 0, nil, errors.New("wrong signature trying to load DB from file")
+	}
+	version, err := strconv.Atoi(string(content[5:9]))
+	if err != nil || version < 1 || version > rdbMaxVersion {
+		return 0, nil, fmt.Errorf("can't handle RDB format version %s", content[5:9])
+	}
+
+	d := &rdbDecoder{buf: content, pos: 9}
+	db := dbs.Get(0)
+	var expireAt int64
+	keys := 0
+	aux := make(map[string]string)
+	now := time.Now().UnixMilli()
+	for {
+		t, err := d.readByte()
+		if err != nil {
+			return keys, aux, err
+		}
+		switch t {
+		case rdbOpcodeEOF:
+			// version 5 and later end with a checksum, 0 means the checksum is disabled
+			if version >= 5 {
+				sum, err := d.readBytes(8)
+				if err != nil {
+					return keys, aux, err
+				}
+				expected := binary.LittleEndian.Uint64(sum)
+				if expected != 0 && expected != rdbChecksum(0, content[:d.pos-8]) {
+					return keys, aux, errors.New("wrong RDB checksum")
+				}
+			}
+			return keys, aux, nil
+		case rdbOpcodeSelectDB:
+			index, err := d.readPlainLength()
+			if err != nil {
+				return keys, aux, err
+			}
+			if db = dbs.Get(int(index)); db == nil {
+				return keys, aux, fmt.Errorf("data file was created with a server configured to handle more than %d databases", dbs.Len())
+			}
+		case rdbOpcodeResizeDB:
+			if _, err = d.readPlainLength(); err == nil {
+				_, err = d.readPlainLength()
+			}
+		case rdbOpcodeExpireTimeMs:
+			var p []byte
+			if p, err = d.readBytes(8); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint64(p))
+			}
+		case rdbOpcodeExpireTime:
+			var p []byte
+			if p, err = d.readBytes(4); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint32(p)) * 1000
+			}
+		case rdbOpcodeAux:
+			var key, val []byte
+			if key, err = d.readString(); err == nil {
+				if val, err = d.readString(); err == nil {
+					aux[string(key)] = string(val)
+				}
+			}
+		case rdbOpcodeFunction2:
+			// functions are not supported, skip the library code
+			_, err = d.readString()
+		case rdbOpcodeIdle:
+			_, err = d.readPlainLength()
+		case rdbOpcodeFreq:
+			_, err = d.readByte()
+		case rdbOpcodeSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = d.readPlainLength()
+			}
+		case rdbOpcodeModuleAux:
+			return keys, aux, errors.New("modules are not supported")
+		default:
+			var key []byte
+			var val any
+			if key, err = d.readString(); err == nil {
+				val, err = d.readObject(t)
+			}
+			if err != nil {
+				return keys, aux, err
+			}
+			if expireAt == 0 || expireAt > now {
+				db.db.Set(string(key), val)
+				db.touchKey(string(key))
+				if expireAt > 0 {
+					// ttl is kept in seconds, round up so that the key never expires earlier
+					db.SetTTL(string(key), (expireAt+999)/1000)
+				}
+				keys++
+			}
+			expireAt = 0
+		}
+		if err != nil {
+			return keys, aux, err
+		}
+	}
+}
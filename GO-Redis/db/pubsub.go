@@ -0,0 +1,417 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// implements the pub/sub commands of redis
+// A client which subscribes gets a subscriber, with a queue of messages and a goroutine writing them to the
+// connection. Publishing never waits for a subscriber: the bytes of the messages which are not written to the
+// connection yet are counted, and a subscriber whose bytes overcome PubSubOutputBufferLimit is too slow to keep up
+// with the messages and is disconnected, as redis does when the pubsub output buffer limit is reached.
+
+// pubSub is the registry of the subscribers of the channels and the patterns
+type pubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// subscriber is the subscription state of a client
+// channels, patterns and shardChannels are only used by the goroutine executing the commands of the client
+type subscriber struct {
+	client        *Client
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+	// mu guards queue, queued, the bytes of the messages in queue, and writing, the bytes of the messages serve has
+	// taken from queue and not written to the connection yet
+	mu      sync.Mutex
+	queue   []data.RedisData
+	queued  int
+	writing int
+	// wake hands the queue over to serve when a message is queued
+	wake     chan struct{}
+	done     chan struct{}
+	dropOnce sync.Once
+	stopOnce sync.Once
+}
+
+// subscriberOf return the subscriber of client, it is created on the first subscription
+func subscriberOf(client *Client) *subscriber {
+	if client.sub == nil {
+		client.sub = &subscriber{
+			client:        client,
+			channels:      make(map[string]struct{}),
+			patterns:      make(map[string]struct{}),
+			shardChannels: make(map[string]struct{}),
+			wake:          make(chan struct{}, 1),
+			done:          make(chan struct{}),
+		}
+		go client.sub.serve()
+	}
+	return client.sub
+}
+
+// subscriptions return the number of channels and patterns the client is subscribed to
+func (s *subscriber) subscriptions() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// shardSubscriptions return the number of shard channels the client is subscribed to
+func (s *subscriber) shardSubscriptions() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.shardChannels)
+}
+
+// deliver queue msg of size bytes for the client, it returns false if the client is not reached
+func (s *subscriber) deliver(msg data.RedisData, size int) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	s.mu.Lock()
+	limit := config.Configures.PubSubOutputBufferLimit
+	if limit > 0 && s.queued+s.writing+size > limit {
+		s.mu.Unlock()
+		s.drop()
+		return false
+	}
+	s.queue = append(s.queue, msg)
+	s.queued += size
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// drop disconnect a client which does not read its messages fast enough
+func (s *subscriber) drop() {
+	s.dropOnce.Do(func() {
+		log.Printf("Client %s closed for overcoming of the pubsub output buffer limit of %d bytes", s.client.RemoteAddr(), config.Configures.PubSubOutputBufferLimit)
+		_ = s.client.Conn.Close()
+	})
+}
+
+// serve write the queued messages to the client until it is released
+func (s *subscriber) serve() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+		// the messages queued meanwhile are sent in the same write
+		s.mu.Lock()
+		msgs := s.queue
+		s.queue, s.writing, s.queued = nil, s.queued, 0
+		s.mu.Unlock()
+		for _, msg := range msgs {
+			if err := s.client.WriteReply(msg); err != nil {
+				return
+			}
+		}
+		if err := s.client.Flush(); err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.writing = 0
+		s.mu.Unlock()
+	}
+}
+
+func (s *subscriber) stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// ReleaseClient remove the subscriptions of a disconnected client
+func (dbs *Databases) ReleaseClient(client *Client) {
+	s := client.sub
+	if s == nil {
+		return
+	}
+	ps := dbs.pubsub
+	ps.mu.Lock()
+	for channel := range s.channels {
+		ps.remove(ps.channels, channel, s)
+	}
+	for pattern := range s.patterns {
+		ps.remove(ps.patterns, pattern, s)
+	}
+	ps.mu.Unlock()
+	for channel := range s.shardChannels {
+		dbs.shardPubsub.unsubscribe(channel, s)
+	}
+	s.stop()
+}
+
+// remove delete s from the subscribers of name in registry, mu should be held
+func (ps *pubSub) remove(registry map[string]map[*subscriber]struct{}, name string, s *subscriber) {
+	subs := registry[name]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(registry, name)
+	}
+}
+
+// publish send message to the subscribers of channel and of the patterns matching it
+// It returns the number of subscribers reached.
+func (ps *pubSub) publish(channel string, message []byte) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	receivers := 0
+	if subs := ps.channels[channel]; len(subs) > 0 {
+		msg := data.MakePushData([]data.RedisData{
+			data.MakeBulkData([]byte("message")),
+			data.MakeBulkData([]byte(channel)),
+			data.MakeBulkData(message),
+		})
+		size := len(msg.ToBytes())
+		for s := range subs {
+			if s.deliver(msg, size) {
+				receivers++
+			}
+		}
+	}
+	for pattern, subs := range ps.patterns {
+		if !PattenMatch(pattern, channel) {
+			continue
+		}
+		msg := data.MakePushData([]data.RedisData{
+			data.MakeBulkData([]byte("pmessage")),
+			data.MakeBulkData([]byte(pattern)),
+			data.MakeBulkData([]byte(channel)),
+			data.MakeBulkData(message),
+		})
+		size := len(msg.ToBytes())
+		for s := range subs {
+			if s.deliver(msg, size) {
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// subscribeReply return the confirmation of a (un)subscription, count is the number of subscriptions left
+func subscribeReply(kind string, name []byte, count int) data.RedisData {
+	return data.MakePushData([]data.RedisData{
+		data.MakeBulkData([]byte(kind)),
+		data.MakeBulkData(name),
+		data.MakeIntData(int64(count)),
+	})
+}
+
+// subscribedModeCommands are the commands a RESP2 client can run while it is subscribed
+var subscribedModeCommands = map[string]bool{
+	"subscribe": true, "unsubscribe": true, "psubscribe": true, "punsubscribe": true,
+	"ssubscribe": true, "sunsubscribe": true, "ping": true, "quit": true, "reset": true,
+}
+
+// rejectInSubscribedMode return an error reply if cmd can not be run by a RESP2 client while it is subscribed,
+// because its replies could not be told apart from the messages
+func rejectInSubscribedMode(cmd [][]byte, conn net.Conn) data.RedisData {
+	client, ok := conn.(*Client)
+	if !ok || client.sub.subscriptions()+client.sub.shardSubscriptions() == 0 || client.protocol != data.RESP2 {
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmd[0]))
+	if subscribedModeCommands[cmdName] {
+		return nil
+	}
+	return data.MakeErrorData(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", cmdName))
+}
+
+// subscribePubSub subscribe the client to channels, or to patterns with PSUBSCRIBE
+// The confirmations are written by the executor, before any message of the channels can be sent.
+// SUBSCRIBE channel [channel ...] | PSUBSCRIBE pattern [pattern ...]
+func subscribePubSub(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "subscribe" && cmdName != "psubscribe" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || client.Conn == nil {
+		return data.MakeErrorData(fmt.Sprintf("ERR %s is only available on client connections", strings.ToUpper(cmdName)))
+	}
+	s := subscriberOf(client)
+	ps := db.dbs.pubsub
+	registry, subscribed := ps.channels, s.channels
+	if cmdName == "psubscribe" {
+		registry, subscribed = ps.patterns, s.patterns
+	}
+	for _, arg := range cmd[1:] {
+		name := string(arg)
+		_, ok := subscribed[name]
+		subscribed[name] = struct{}{}
+		if err := client.WriteReply(subscribeReply(cmdName, arg, s.subscriptions())); err != nil {
+			return nil
+		}
+		if !ok {
+			ps.mu.Lock()
+			if registry[name] == nil {
+				registry[name] = make(map[*subscriber]struct{})
+			}
+			registry[name][s] = struct{}{}
+			ps.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// unsubscribePubSub unsubscribe the client from the channels, or from all of them if none is given
+// PUNSUBSCRIBE does the same with the patterns.
+// UNSUBSCRIBE [channel [channel ...]] | PUNSUBSCRIBE [pattern [pattern ...]]
+func unsubscribePubSub(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "unsubscribe" && cmdName != "punsubscribe" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || client.Conn == nil {
+		return data.MakeErrorData(fmt.Sprintf("ERR %s is only available on client connections", strings.ToUpper(cmdName)))
+	}
+	s := subscriberOf(client)
+	ps := db.dbs.pubsub
+	registry, subscribed := ps.channels, s.channels
+	if cmdName == "punsubscribe" {
+		registry, subscribed = ps.patterns, s.patterns
+	}
+	names := cmd[1:]
+	if len(names) == 0 {
+		for name := range subscribed {
+			names = append(names, []byte(name))
+		}
+		if len(names) == 0 {
+			return subscribeReply(cmdName, nil, s.subscriptions())
+		}
+	}
+	for _, arg := range names {
+		name := string(arg)
+		if _, ok := subscribed[name]; ok {
+			delete(subscribed, name)
+			ps.mu.Lock()
+			ps.remove(registry, name, s)
+			ps.mu.Unlock()
+		}
+		if err := client.WriteReply(subscribeReply(cmdName, arg, s.subscriptions())); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// publishPubSub send a message to the subscribers of a channel and return how many received it
+// In cluster mode the message is also sent to the other nodes on the bus.
+// PUBLISH channel message
+func publishPubSub(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "publish" {
+		return data.MakeErrorData("Server Error")
+	}
+	receivers := db.dbs.pubsub.publish(string(cmd[1]), cmd[2])
+	if c := db.dbs.cluster; c != nil {
+		c.mu.Lock()
+		c.broadcast(msgPublish, cmd[1], cmd[2])
+		c.mu.Unlock()
+	}
+	return data.MakeIntData(int64(receivers))
+}
+
+// pubSubServer inspect the pub/sub state
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel [channel ...]] | NUMPAT |
+// SHARDCHANNELS [pattern] | SHARDNUMSUB [shardchannel [shardchannel ...]]
+func pubSubServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "pubsub" {
+		return data.MakeErrorData("Server Error")
+	}
+	sub := strings.ToLower(string(cmd[1]))
+	switch {
+	case sub == "shardchannels" && len(cmd) <= 3:
+		var pattern []byte
+		if len(cmd) == 3 {
+			pattern = cmd[2]
+		}
+		return channelsReply(db.dbs.shardPubsub.channels(pattern))
+	case sub == "shardnumsub":
+		sps := db.dbs.shardPubsub
+		res := make([]data.RedisData, 0, 2*(len(cmd)-2))
+		for _, channel := range cmd[2:] {
+			res = append(res, data.MakeBulkData(channel), data.MakeIntData(int64(sps.numSub(string(channel)))))
+		}
+		return data.MakeArrayData(res)
+	case sub == "shardchannels":
+		return data.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'pubsub|%s' command", sub))
+	}
+
+	ps := db.dbs.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	switch {
+	case sub == "channels" && len(cmd) <= 3:
+		names := make([]string, 0)
+		for channel := range ps.channels {
+			if len(cmd) == 2 || PattenMatch(string(cmd[2]), channel) {
+				names = append(names, channel)
+			}
+		}
+		return channelsReply(names)
+	case sub == "numsub":
+		res := make([]data.RedisData, 0, 2*(len(cmd)-2))
+		for _, channel := range cmd[2:] {
+			res = append(res, data.MakeBulkData(channel), data.MakeIntData(int64(len(ps.channels[string(channel)]))))
+		}
+		return data.MakeArrayData(res)
+	case sub == "numpat" && len(cmd) == 2:
+		return data.MakeIntData(int64(len(ps.patterns)))
+	case sub == "channels" || sub == "numpat":
+		return data.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'pubsub|%s' command", sub))
+	}
+	return data.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try PUBSUB HELP.", string(cmd[1])))
+}
+
+// channelsReply return the sorted names of channels
+func channelsReply(names []string) data.RedisData {
+	sort.Strings(names)
+	res := make([]data.RedisData, 0, len(names))
+	for _, name := range names {
+		res = append(res, data.MakeBulkData([]byte(name)))
+	}
+	return data.MakeArrayData(res)
+}
+
+func RegisterPubSubCommands() {
+	RegisterCommand("subscribe", subscribePubSub, -2, 0, 0, 0, 0)
+	RegisterCommand("unsubscribe", unsubscribePubSub, -1, 0, 0, 0, 0)
+	RegisterCommand("psubscribe", subscribePubSub, -2, 0, 0, 0, 0)
+	RegisterCommand("punsubscribe", unsubscribePubSub, -1, 0, 0, 0, 0)
+	RegisterCommand("publish", publishPubSub, 3, cmdFast, 0, 0, 0)
+	RegisterCommand("pubsub", pubSubServer, -2, 0, 0, 0, 0)
+	RegisterCommand("ssubscribe", subscribeShardPubSub, -2, cmdShardChannels, 1, -1, 1)
+	RegisterCommand("sunsubscribe", unsubscribeShardPubSub, -1, cmdShardChannels, 1, -1, 1)
+	RegisterCommand("spublish", publishShardPubSub, 3, cmdFast|cmdShardChannels, 1, 1, 1)
+}
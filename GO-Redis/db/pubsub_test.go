@@ -0,0 +1,151 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscribe connect a client subscribed to channel on db through a pipe and return its replies
+// The replies the client reads are sent to the chan, which is closed when the connection is closed. If
+// reading is set, the client keeps reading, otherwise it stops after the confirmation of the subscription.
+func subscribe(t *testing.T, db *DB, channel string, reading bool) <-chan string {
+	t.Helper()
+	conn, remote := net.Pipe()
+	client := NewClient(conn)
+	replies := make(chan string, 1<<16)
+	go func() {
+		defer close(replies)
+		parser := data.NewParser(bufio.NewReader(remote))
+		for {
+			res, err := parser.ReadValue()
+			if err != nil {
+				return
+			}
+			replies <- string(res.ToBytes())
+			if !reading {
+				// wait for the server to close the connection
+				_, _ = remote.Read(make([]byte, 1))
+				return
+			}
+		}
+	}()
+	db.ExecCommand(context.Background(), [][]byte{[]byte("subscribe"), []byte(channel)}, client)
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	t.Cleanup(func() {
+		db.dbs.ReleaseClient(client)
+		_ = conn.Close()
+		_ = remote.Close()
+	})
+	return replies
+}
+
+// receive read n messages from replies and return their payloads
+func receive(t *testing.T, replies <-chan string, n int) []string {
+	t.Helper()
+	msgs := make([]string, 0, n)
+	for len(msgs) < n {
+		select {
+		case reply, ok := <-replies:
+			if !ok {
+				t.Fatalf("the connection is closed after %d messages, want %d", len(msgs), n)
+			}
+			if strings.Contains(reply, "subscribe") {
+				continue
+			}
+			// the payload is the last bulk of the message
+			fields := strings.Split(strings.TrimSuffix(reply, "\r\n"), "\r\n")
+			msgs = append(msgs, fields[len(fields)-1])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d messages received, want %d", len(msgs), n)
+		}
+	}
+	return msgs
+}
+
+func setPubSubOutputBufferLimit(t *testing.T, limit int) {
+	old := config.Configures.PubSubOutputBufferLimit
+	config.Configures.PubSubOutputBufferLimit = limit
+	t.Cleanup(func() {
+		config.Configures.PubSubOutputBufferLimit = old
+	})
+}
+
+func TestPubSubBurst(t *testing.T) {
+	// a burst of messages is queued for a subscriber reading them, far more than it can read in the meantime
+	setPubSubOutputBufferLimit(t, 32*1024*1024)
+	const burst = 5000
+	tests := []struct {
+		name    string
+		channel string
+		events  string
+		publish func(db *DB, i int)
+	}{
+		{
+			name:    "publish",
+			channel: "c",
+			publish: func(db *DB, i int) {
+				execString(db, "publish", "c", strconv.Itoa(i))
+			},
+		},
+		{
+			name:    "keyspace notifications",
+			channel: "__keyevent@0__:set",
+			events:  "E$",
+			publish: func(db *DB, i int) {
+				execString(db, "set", strconv.Itoa(i), "v")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDatabases(1).Get(0)
+			db.dbs.notifyFlags, _ = parseNotifyKeyspaceEvents(tt.events)
+			replies := subscribe(t, db, tt.channel, true)
+			for i := 0; i < burst; i++ {
+				tt.publish(db, i)
+			}
+			for i, msg := range receive(t, replies, burst) {
+				if msg != strconv.Itoa(i) {
+					t.Fatalf("message %d = %q, want %q", i, msg, strconv.Itoa(i))
+				}
+			}
+		})
+	}
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	// a subscriber which does not read is disconnected once its pending messages overcome the limit
+	setPubSubOutputBufferLimit(t, 16*1024)
+	db := NewDatabases(1).Get(0)
+	replies := subscribe(t, db, "c", false)
+	payload := strings.Repeat("x", 1024)
+	reached := 0
+	for i := 0; i < 100; i++ {
+		if execString(db, "publish", "c", payload) == ":1\r\n" {
+			reached++
+		}
+	}
+	if reached == 0 || reached > 17 {
+		t.Fatalf("%d messages delivered before the subscriber is dropped, want between 1 and 17", reached)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-replies:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the slow subscriber is not disconnected")
+		}
+	}
+}
@@ -0,0 +1,1104 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"math"
+	"strconv"
+	"time"
+)
+
+// implements the redis rdb file format
+// A file is "REDIS" + 4 digits version, followed by aux fields, dbs and their keys, an EOF opcode and the crc64 of all bytes before
+
+const (
+	rdbVersion = 9
+	// rdbMaxVersion is the newest version that can be loaded
+	rdbMaxVersion = 12
+
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZSet             = 3
+	rdbTypeHash             = 4
+	rdbTypeZSet2            = 5
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZSetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZSetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+
+	rdbOpcodeSlotInfo     = 0xF4
+	rdbOpcodeFunction2    = 0xF5
+	rdbOpcodeModuleAux    = 0xF7
+	rdbOpcodeIdle         = 0xF8
+	rdbOpcodeFreq         = 0xF9
+	rdbOpcodeAux          = 0xFA
+	rdbOpcodeResizeDB     = 0xFB
+	rdbOpcodeExpireTimeMs = 0xFC
+	rdbOpcodeExpireTime   = 0xFD
+	rdbOpcodeSelectDB     = 0xFE
+	rdbOpcodeEOF          = 0xFF
+
+	// the two highest bits of the first byte of a length
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdbEncVal   = 3
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+
+	// special encodings of strings
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+
+	// quicklist 2 node containers
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+
+	// flags of the entries of a stream listpack
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+)
+
+// crc64Table is the Jones polynomial used by redis in its reflected form
+var crc64Table = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+var errRDBCorrupted = errors.New("rdb: unexpected end of file or corrupted data")
+
+// rdbChecksum compute the crc64 of redis which neither inverts the input nor the output
+func rdbChecksum(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
+
+// rdbEncoder append the rdb encoding of values into buf
+type rdbEncoder struct {
+	buf []byte
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *rdbEncoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.buf = append(e.buf, byte(n))
+	case n < 1<<14:
+		e.buf = append(e.buf, byte(n>>8)|rdb14BitLen<<6, byte(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, rdb32BitLen)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, rdb64BitLen)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+// writeString write s as an integer if it is a small integer, compressed if it is long enough, or as is
+func (e *rdbEncoder) writeString(s []byte) {
+	if len(s) <= 11 {
+		if v, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(v, 10) == string(s) {
+			switch {
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				e.buf = append(e.buf, rdbEncVal<<6|rdbEncInt8, byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				e.buf = append(e.buf, rdbEncVal<<6|rdbEncInt16)
+				e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(v))
+			default:
+				e.buf = append(e.buf, rdbEncVal<<6|rdbEncInt32)
+				e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(v))
+			}
+			return
+		}
+	}
+	if len(s) > 20 {
+		if compressed := lzfCompress(s); compressed != nil {
+			e.buf = append(e.buf, rdbEncVal<<6|rdbEncLZF)
+			e.writeLength(uint64(len(compressed)))
+			e.writeLength(uint64(len(s)))
+			e.buf = append(e.buf, compressed...)
+			return
+		}
+	}
+	e.writeLength(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *rdbEncoder) writeDouble(f float64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(f))
+}
+
+func (e *rdbEncoder) writeAux(key, val string) {
+	e.writeByte(rdbOpcodeAux)
+	e.writeString([]byte(key))
+	e.writeString([]byte(val))
+}
+
+func (e *rdbEncoder) writeHeader() {
+	e.buf = append(e.buf, fmt.Sprintf("REDIS%04d", rdbVersion)...)
+	e.writeAux("redis-ver", serverVersion)
+	e.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// writeEntry write a key with its value and expire time in unix milliseconds, 0 means no expire time
+func (e *rdbEncoder) writeEntry(key string, val any, expireAt int64) error {
+	t, ok := rdbObjectType(val)
+	if !ok {
+		return fmt.Errorf("rdb: can not save key %s of type %T", key, val)
+	}
+
+	if expireAt > 0 {
+		e.writeByte(rdbOpcodeExpireTimeMs)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(expireAt))
+	}
+	e.writeByte(t)
+	e.writeString([]byte(key))
+	e.writeObject(val)
+	return nil
+}
+
+// rdbObjectType return the type val is saved as, false if it can not be saved
+func rdbObjectType(val any) (byte, bool) {
+	switch val.(type) {
+	case []byte:
+		return rdbTypeString, true
+	case *List:
+		return rdbTypeList, true
+	case *Set:
+		return rdbTypeSet, true
+	case *SortedSet:
+		return rdbTypeZSet2, true
+	case *Hash:
+		return rdbTypeHash, true
+	case *Stream:
+		return rdbTypeStreamListpacks, true
+	}
+	return 0, false
+}
+
+// writeObject write val without its type, it is read back by readObject
+func (e *rdbEncoder) writeObject(val any) {
+	switch v := val.(type) {
+	case []byte:
+		e.writeString(v)
+	case *List:
+		e.writeLength(uint64(v.Len))
+		v.ForEach(0, false, func(index int, elem []byte) bool {
+			e.writeString(elem)
+			return true
+		})
+	case *Set:
+		e.writeLength(uint64(v.Len()))
+		for member := range v.table {
+			e.writeString([]byte(member))
+		}
+	case *SortedSet:
+		// from the highest score so that the loader always inserts at the head
+		e.writeLength(uint64(v.Len()))
+		for node := v.Last(); node != nil; node = node.Prev() {
+			e.writeString([]byte(node.Member))
+			e.writeDouble(node.Score)
+		}
+	case *Hash:
+		e.writeLength(uint64(v.Len()))
+		for field, value := range v.table {
+			e.writeString([]byte(field))
+			e.writeString(value)
+		}
+	case *Stream:
+		e.writeStream(v)
+	}
+}
+
+// writeStream write a stream as rdbTypeStreamListpacks
+// Each node is saved as a listpack keyed by the id of its first entry, the master entry, followed by the length,
+// the last id and the consumer groups, of which there are none.
+func (e *rdbEncoder) writeStream(s *Stream) {
+	e.writeLength(uint64(len(s.nodes)))
+	for _, n := range s.nodes {
+		master := n.first()
+		key := binary.BigEndian.AppendUint64(nil, master.Ms)
+		e.writeString(binary.BigEndian.AppendUint64(key, master.Seq))
+
+		// the master entry: count, deleted, the master fields and a terminating 0
+		masterFields := n.entries[0].Fields
+		lp := newListpackWriter()
+		lp.appendInt(int64(len(n.entries)))
+		lp.appendInt(0)
+		lp.appendInt(int64(len(masterFields) / 2))
+		for i := 0; i < len(masterFields); i += 2 {
+			lp.appendString(masterFields[i])
+		}
+		lp.appendInt(0)
+		for _, entry := range n.entries {
+			sameFields := sameStreamFields(masterFields, entry.Fields)
+			flags := int64(0)
+			if sameFields {
+				flags = streamItemFlagSameFields
+			}
+			lp.appendInt(flags)
+			lp.appendInt(int64(entry.ID.Ms - master.Ms))
+			lp.appendInt(int64(entry.ID.Seq - master.Seq))
+			numFields := len(entry.Fields) / 2
+			if sameFields {
+				for i := 1; i < len(entry.Fields); i += 2 {
+					lp.appendString(entry.Fields[i])
+				}
+				lp.appendInt(int64(numFields + 3))
+				continue
+			}
+			lp.appendInt(int64(numFields))
+			for _, field := range entry.Fields {
+				lp.appendString(field)
+			}
+			lp.appendInt(int64(2*numFields + 4))
+		}
+		e.writeString(lp.bytes())
+	}
+	e.writeLength(uint64(s.Len()))
+	e.writeLength(s.LastID().Ms)
+	e.writeLength(s.LastID().Seq)
+	e.writeLength(0)
+}
+
+// sameStreamFields report whether the field value pairs of fields have the fields of master in the same order
+func sameStreamFields(master, fields [][]byte) bool {
+	if len(master) != len(fields) {
+		return false
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if string(master[i]) != string(fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeEOF write the EOF opcode and the checksum of the file
+func (e *rdbEncoder) writeEOF() {
+	e.writeByte(rdbOpcodeEOF)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, rdbChecksum(0, e.buf))
+}
+
+// rdbDecoder read rdb encoded values from buf
+type rdbDecoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, errRDBCorrupted
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *rdbDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errRDBCorrupted
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// readLength read a length, encoded is true if the length is the type of a special encoded string
+func (d *rdbDecoder) readLength() (uint64, bool, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case rdb6BitLen:
+		return uint64(b & 0x3f), false, nil
+	case rdb14BitLen:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case rdbEncVal:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case rdb32BitLen:
+		p, err := d.readBytes(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+	case rdb64BitLen:
+		p, err := d.readBytes(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(p), false, nil
+	}
+	return 0, false, fmt.Errorf("rdb: unknown length encoding %d", b)
+}
+
+func (d *rdbDecoder) readPlainLength() (uint64, error) {
+	n, encoded, err := d.readLength()
+	if err == nil && encoded {
+		err = errRDBCorrupted
+	}
+	return n, err
+}
+
+// readString read a string, the result is a copy and can be kept
+func (d *rdbDecoder) readString() ([]byte, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		s, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		return copyBytes(s), nil
+	}
+
+	var v int64
+	switch n {
+	case rdbEncInt8:
+		b, err := d.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		v = int64(int8(b[0]))
+	case rdbEncInt16:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		v = int64(int16(binary.LittleEndian.Uint16(b)))
+	case rdbEncInt32:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		v = int64(int32(binary.LittleEndian.Uint32(b)))
+	case rdbEncLZF:
+		clen, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		length, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := d.readBytes(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(length))
+	default:
+		return nil, fmt.Errorf("rdb: unknown string encoding %d", n)
+	}
+	return []byte(strconv.FormatInt(v, 10)), nil
+}
+
+// readOldDouble read a double of the old zset type, which is stored as a string
+func (d *rdbDecoder) readOldDouble() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.readBytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+func (d *rdbDecoder) readDouble() (float64, error) {
+	b, err := d.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readObject read a value of type t
+func (d *rdbDecoder) readObject(t byte) (any, error) {
+	switch t {
+	case rdbTypeString:
+		return d.readString()
+	case rdbTypeList:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		list := NewList()
+		for i := uint64(0); i < n; i++ {
+			elem, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			list.RPush(elem)
+		}
+		return list, nil
+	case rdbTypeSet:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		set := NewSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			set.Add(string(member))
+		}
+		return set, nil
+	case rdbTypeZSet, rdbTypeZSet2:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		zs := NewSortedSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if t == rdbTypeZSet {
+				score, err = d.readOldDouble()
+			} else {
+				score, err = d.readDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zs.Add(string(member), score)
+		}
+		return zs, nil
+	case rdbTypeHash:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		hash := NewHash()
+		for i := uint64(0); i < n; i++ {
+			field, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(string(field), value)
+		}
+		return hash, nil
+	case rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZSetZiplist, rdbTypeHashZiplist,
+		rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries [][]byte
+		switch t {
+		case rdbTypeSetIntset:
+			entries, err = parseIntset(blob)
+		case rdbTypeListZiplist, rdbTypeZSetZiplist, rdbTypeHashZiplist:
+			entries, err = parseZiplist(blob)
+		default:
+			entries, err = parseListpack(blob)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return makeObjectFromEntries(t, entries)
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		list := NewList()
+		for i := uint64(0); i < n; i++ {
+			container := uint64(quicklistNodePacked)
+			if t == rdbTypeListQuicklist2 {
+				if container, err = d.readPlainLength(); err != nil {
+					return nil, err
+				}
+			}
+			blob, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			if container == quicklistNodePlain {
+				list.RPush(blob)
+				continue
+			}
+			var entries [][]byte
+			if t == rdbTypeListQuicklist {
+				entries, err = parseZiplist(blob)
+			} else {
+				entries, err = parseListpack(blob)
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				list.RPush(entry)
+			}
+		}
+		return list, nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return d.readStream(t)
+	}
+	return nil, fmt.Errorf("rdb: unsupported object type %d", t)
+}
+
+// readStream read a stream saved as one of the stream listpacks types
+// The deleted entries of the listpacks are skipped, the consumer groups are read and dropped.
+func (d *rdbDecoder) readStream(t byte) (*Stream, error) {
+	stream := NewStream()
+	nodes, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errRDBCorrupted
+		}
+		master := StreamID{Ms: binary.BigEndian.Uint64(key), Seq: binary.BigEndian.Uint64(key[8:])}
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := parseListpack(blob)
+		if err != nil {
+			return nil, err
+		}
+		if err = addStreamListpack(stream, master, elems); err != nil {
+			return nil, err
+		}
+	}
+
+	// length, last id, and since rdbTypeStreamListpacks2 the first id, the max deleted id and the entries added
+	fields := 3
+	if t != rdbTypeStreamListpacks {
+		fields += 5
+	}
+	values := make([]uint64, fields)
+	for i := range values {
+		if values[i], err = d.readPlainLength(); err != nil {
+			return nil, err
+		}
+	}
+	if values[0] != uint64(stream.Len()) {
+		return nil, errRDBCorrupted
+	}
+	last := StreamID{Ms: values[1], Seq: values[2]}
+	if l := stream.Last(); l != nil && last.Less(l.ID) {
+		return nil, errRDBCorrupted
+	}
+	stream.SetLastID(last)
+
+	groups, err := d.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err = d.skipStreamGroup(t); err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+// skipStreamGroup read a consumer group of a stream, they are not supported
+func (d *rdbDecoder) skipStreamGroup(t byte) error {
+	if _, err := d.readString(); err != nil {
+		return err
+	}
+	// last id, and the entries read since rdbTypeStreamListpacks2
+	n := 2
+	if t != rdbTypeStreamListpacks {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		if _, err := d.readPlainLength(); err != nil {
+			return err
+		}
+	}
+	// the pending entries: a raw id, a delivery time and a delivery count
+	pending, err := d.readPlainLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < pending; i++ {
+		if _, err = d.readBytes(16 + 8); err != nil {
+			return err
+		}
+		if _, err = d.readPlainLength(); err != nil {
+			return err
+		}
+	}
+	// the consumers: a name, the seen time, the active time since rdbTypeStreamListpacks3 and their pending ids
+	consumers, err := d.readPlainLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		if _, err = d.readString(); err != nil {
+			return err
+		}
+		times := uint64(8)
+		if t == rdbTypeStreamListpacks3 {
+			times += 8
+		}
+		if _, err = d.readBytes(times); err != nil {
+			return err
+		}
+		if pending, err = d.readPlainLength(); err != nil {
+			return err
+		}
+		if _, err = d.readBytes(16 * pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addStreamListpack add the entries of a stream listpack whose master entry has the id master
+// <count><deleted><master fields count><master field>...<0> is followed by the entries
+// <flags><ms diff><seq diff>[<fields count>]<field or value>...<lp count>, with only the values for
+// the entries having the master fields.
+func addStreamListpack(stream *Stream, master StreamID, elems [][]byte) error {
+	p := 0
+	next := func() ([]byte, error) {
+		if p >= len(elems) {
+			return nil, errRDBCorrupted
+		}
+		p++
+		return elems[p-1], nil
+	}
+	nextInt := func() (int64, error) {
+		elem, err := next()
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.ParseInt(string(elem), 10, 64)
+		if err != nil {
+			return 0, errRDBCorrupted
+		}
+		return v, nil
+	}
+
+	var header [3]int64
+	for i := range header {
+		v, err := nextInt()
+		if err != nil {
+			return err
+		}
+		header[i] = v
+	}
+	if header[2] < 0 || int(header[2]) > len(elems) {
+		return errRDBCorrupted
+	}
+	masterFields := make([][]byte, header[2])
+	for i := range masterFields {
+		field, err := next()
+		if err != nil {
+			return err
+		}
+		masterFields[i] = field
+	}
+	if _, err := next(); err != nil {
+		return err
+	}
+
+	for p < len(elems) {
+		var entry [3]int64
+		for i := range entry {
+			v, err := nextInt()
+			if err != nil {
+				return err
+			}
+			entry[i] = v
+		}
+		flags := entry[0]
+		id := StreamID{Ms: master.Ms + uint64(entry[1]), Seq: master.Seq + uint64(entry[2])}
+		var fields [][]byte
+		if flags&streamItemFlagSameFields != 0 {
+			fields = make([][]byte, 0, 2*len(masterFields))
+			for _, field := range masterFields {
+				value, err := next()
+				if err != nil {
+					return err
+				}
+				fields = append(fields, field, value)
+			}
+		} else {
+			n, err := nextInt()
+			if err != nil {
+				return err
+			}
+			if n < 0 || int(n) > len(elems) {
+				return errRDBCorrupted
+			}
+			fields = make([][]byte, 2*n)
+			for i := range fields {
+				if fields[i], err = next(); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := next(); err != nil {
+			return err
+		}
+		if flags&streamItemFlagDeleted != 0 {
+			continue
+		}
+		if l := stream.Last(); l != nil && !l.ID.Less(id) {
+			return errRDBCorrupted
+		}
+		stream.Add(id, fields)
+	}
+	return nil
+}
+
+// makeObjectFromEntries build the value of an encoded type from the elements of its ziplist, listpack or intset
+func makeObjectFromEntries(t byte, entries [][]byte) (any, error) {
+	switch t {
+	case rdbTypeListZiplist:
+		list := NewList()
+		for _, entry := range entries {
+			list.RPush(entry)
+		}
+		return list, nil
+	case rdbTypeSetIntset, rdbTypeSetListpack:
+		set := NewSet()
+		for _, entry := range entries {
+			set.Add(string(entry))
+		}
+		return set, nil
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		if len(entries)%2 != 0 {
+			return nil, errRDBCorrupted
+		}
+		zs := NewSortedSet()
+		for i := 0; i < len(entries); i += 2 {
+			score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+			if err != nil {
+				return nil, errRDBCorrupted
+			}
+			zs.Add(string(entries[i]), score)
+		}
+		return zs, nil
+	default:
+		if len(entries)%2 != 0 {
+			return nil, errRDBCorrupted
+		}
+		hash := NewHash()
+		for i := 0; i < len(entries); i += 2 {
+			hash.Set(string(entries[i]), entries[i+1])
+		}
+		return hash, nil
+	}
+}
+
+// parseIntset return the integers of an intset as strings
+// <encoding uint32><length uint32><contents>, encoding is the bytes of each integer
+func parseIntset(blob []byte) ([][]byte, error) {
+	if len(blob) < 8 {
+		return nil, errRDBCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(blob))
+	length := int(binary.LittleEndian.Uint32(blob[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(blob)-8 != width*length {
+		return nil, errRDBCorrupted
+	}
+	entries := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		p := blob[8+i*width:]
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		default:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		entries = append(entries, []byte(strconv.FormatInt(v, 10)))
+	}
+	return entries, nil
+}
+
+// parseZiplist return the elements of a redis ziplist
+// <zlbytes uint32><zltail uint32><zllen uint16><entry>...<0xFF>, each entry is <prevlen><encoding><data>
+func parseZiplist(blob []byte) ([][]byte, error) {
+	if len(blob) < 11 {
+		return nil, errRDBCorrupted
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(blob[8:]))
+	p := 10
+	for {
+		if p >= len(blob) {
+			return nil, errRDBCorrupted
+		}
+		if blob[p] == 0xFF {
+			return entries, nil
+		}
+		// skip prevlen
+		if blob[p] < 254 {
+			p++
+		} else {
+			p += 5
+		}
+		if p >= len(blob) {
+			return nil, errRDBCorrupted
+		}
+
+		enc := blob[p]
+		var length, intLen int
+		switch {
+		case enc>>6 == 0:
+			length, p = int(enc&0x3f), p+1
+		case enc>>6 == 1:
+			if p+2 > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			length, p = int(enc&0x3f)<<8|int(blob[p+1]), p+2
+		case enc == 0x80:
+			if p+5 > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			length, p = int(binary.BigEndian.Uint32(blob[p+1:])), p+5
+		case enc == 0xC0:
+			intLen, p = 2, p+1
+		case enc == 0xD0:
+			intLen, p = 4, p+1
+		case enc == 0xE0:
+			intLen, p = 8, p+1
+		case enc == 0xF0:
+			intLen, p = 3, p+1
+		case enc == 0xFE:
+			intLen, p = 1, p+1
+		case enc >= 0xF1 && enc <= 0xFD:
+			// the value 0 to 12 is stored in the encoding
+			entries = append(entries, []byte(strconv.Itoa(int(enc&0x0f)-1)))
+			p++
+			continue
+		default:
+			return nil, errRDBCorrupted
+		}
+
+		if intLen > 0 {
+			if p+intLen > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			entries = append(entries, []byte(strconv.FormatInt(readLittleEndianInt(blob[p:p+intLen]), 10)))
+			p += intLen
+			continue
+		}
+		if length < 0 || p+length > len(blob) {
+			return nil, errRDBCorrupted
+		}
+		entries = append(entries, copyBytes(blob[p:p+length]))
+		p += length
+	}
+}
+
+// parseListpack return the elements of a redis listpack
+// <total bytes uint32><elements uint16><entry>...<0xFF>, each entry is <encoding><data><backlen>
+func parseListpack(blob []byte) ([][]byte, error) {
+	if len(blob) < 7 {
+		return nil, errRDBCorrupted
+	}
+	entries := make([][]byte, 0, binary.LittleEndian.Uint16(blob[4:]))
+	p := 6
+	for {
+		if p >= len(blob) {
+			return nil, errRDBCorrupted
+		}
+		enc := blob[p]
+		if enc == 0xFF {
+			return entries, nil
+		}
+
+		start := p
+		var entry []byte
+		var length, intLen int
+		switch {
+		case enc&0x80 == 0:
+			// 7 bits unsigned integer
+			entry, p = []byte(strconv.Itoa(int(enc))), p+1
+		case enc&0xC0 == 0x80:
+			length, p = int(enc&0x3f), p+1
+		case enc&0xE0 == 0xC0:
+			// 13 bits signed integer
+			if p+2 > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			v := int(enc&0x1f)<<8 | int(blob[p+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			entry, p = []byte(strconv.Itoa(v)), p+2
+		case enc&0xF0 == 0xE0:
+			if p+2 > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			length, p = int(enc&0x0f)<<8|int(blob[p+1]), p+2
+		case enc == 0xF0:
+			if p+5 > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			length, p = int(binary.LittleEndian.Uint32(blob[p+1:])), p+5
+		case enc == 0xF1:
+			intLen, p = 2, p+1
+		case enc == 0xF2:
+			intLen, p = 3, p+1
+		case enc == 0xF3:
+			intLen, p = 4, p+1
+		case enc == 0xF4:
+			intLen, p = 8, p+1
+		default:
+			return nil, errRDBCorrupted
+		}
+
+		switch {
+		case entry != nil:
+		case intLen > 0:
+			if p+intLen > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			entry = []byte(strconv.FormatInt(readLittleEndianInt(blob[p:p+intLen]), 10))
+			p += intLen
+		default:
+			if length < 0 || p+length > len(blob) {
+				return nil, errRDBCorrupted
+			}
+			entry = copyBytes(blob[p : p+length])
+			p += length
+		}
+		entries = append(entries, entry)
+
+		// skip backlen, its size depends on the size of encoding and data
+		p += len(appendListpackBacklen(nil, p-start))
+	}
+}
+
+// appendListpackBacklen append the backlen of a listpack entry of size bytes, it is read from its last byte to
+// walk the listpack backward, 7 bits per byte
+func appendListpackBacklen(buf []byte, size int) []byte {
+	switch {
+	case size <= 127:
+		return append(buf, byte(size))
+	case size < 16383:
+		return append(buf, byte(size>>7), byte(size&127|128))
+	case size < 2097151:
+		return append(buf, byte(size>>14), byte(size>>7&127|128), byte(size&127|128))
+	case size < 268435455:
+		return append(buf, byte(size>>21), byte(size>>14&127|128), byte(size>>7&127|128), byte(size&127|128))
+	}
+	return append(buf, byte(size>>28), byte(size>>21&127|128), byte(size>>14&127|128), byte(size>>7&127|128), byte(size&127|128))
+}
+
+// listpackWriter build a redis listpack, see parseListpack
+type listpackWriter struct {
+	buf   []byte
+	count int
+}
+
+func newListpackWriter() *listpackWriter {
+	return &listpackWriter{buf: make([]byte, 6)}
+}
+
+// appendString append s, as an integer if it is the decimal form of one
+func (w *listpackWriter) appendString(s []byte) {
+	if v, err := strconv.ParseInt(string(s), 10, 64); err == nil && strconv.FormatInt(v, 10) == string(s) {
+		w.appendInt(v)
+		return
+	}
+	start := len(w.buf)
+	switch n := len(s); {
+	case n < 1<<6:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n < 1<<12:
+		w.buf = append(w.buf, 0xE0|byte(n>>8), byte(n))
+	default:
+		w.buf = append(w.buf, 0xF0)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, s...)
+	w.buf = appendListpackBacklen(w.buf, len(w.buf)-start)
+	w.count++
+}
+
+// appendInt append v with the smallest integer encoding
+func (w *listpackWriter) appendInt(v int64) {
+	start := len(w.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		w.buf = append(w.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		w.buf = append(w.buf, 0xC0|byte(v>>8)&0x1f, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		w.buf = append(w.buf, 0xF1, byte(v), byte(v>>8))
+	case v >= -1<<23 && v < 1<<23:
+		w.buf = append(w.buf, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		w.buf = append(w.buf, 0xF3)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(v))
+	default:
+		w.buf = append(w.buf, 0xF4)
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, uint64(v))
+	}
+	w.buf = appendListpackBacklen(w.buf, len(w.buf)-start)
+	w.count++
+}
+
+// bytes terminate the listpack and return it, the count of the header saturates at 65535 as in redis
+func (w *listpackWriter) bytes() []byte {
+	w.buf = append(w.buf, 0xFF)
+	binary.LittleEndian.PutUint32(w.buf, uint32(len(w.buf)))
+	binary.LittleEndian.PutUint16(w.buf[4:], uint16(min(w.count, math.MaxUint16)))
+	return w.buf
+}
+
+// readLittleEndianInt read a signed little endian integer of 1 to 8 bytes
+func readLittleEndianInt(p []byte) int64 {
+	var v uint64
+	for i := len(p) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(p[i])
+	}
+	// sign extend
+	shift := 64 - 8*len(p)
+	return int64(v<<shift) >> shift
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRDBRoundTrip(t *testing.T) {
+	long := strings.Repeat("abcdefgh", 100)
+	type check struct {
+		cmd  []string
+		want string
+	}
+	tests := []struct {
+		name string
+		// setup are run on db 1, so that the db index is saved too
+		setup [][]string
+		// checks are run on db 1 of the loaded dbs
+		checks []check
+	}{
+		{
+			name:  "strings",
+			setup: [][]string{{"set", "s", "hello"}, {"set", "empty", ""}, {"set", "long", long}},
+			checks: []check{
+				{[]string{"get", "s"}, "$5\r\nhello\r\n"},
+				{[]string{"get", "empty"}, "$0\r\n\r\n"},
+				{[]string{"get", "long"}, "$800\r\n" + long + "\r\n"},
+			},
+		},
+		{
+			name:  "integer strings",
+			setup: [][]string{{"set", "i8", "-12"}, {"set", "i16", "3000"}, {"set", "i32", "-70000"}, {"set", "i64", "9223372036854775807"}, {"set", "padded", "007"}},
+			checks: []check{
+				{[]string{"get", "i8"}, "$3\r\n-12\r\n"},
+				{[]string{"get", "i16"}, "$4\r\n3000\r\n"},
+				{[]string{"get", "i32"}, "$6\r\n-70000\r\n"},
+				{[]string{"get", "i64"}, "$19\r\n9223372036854775807\r\n"},
+				{[]string{"get", "padded"}, "$3\r\n007\r\n"},
+			},
+		},
+		{
+			name:  "list",
+			setup: [][]string{{"rpush", "l", "a", "1", long, ""}},
+			checks: []check{
+				{[]string{"lrange", "l", "0", "-1"}, "*4\r\n$1\r\na\r\n$1\r\n1\r\n$800\r\n" + long + "\r\n$0\r\n\r\n"},
+			},
+		},
+		{
+			name:  "hash",
+			setup: [][]string{{"hset", "h", "f1", "v1", "f2", long}},
+			checks: []check{
+				{[]string{"hlen", "h"}, ":2\r\n"},
+				{[]string{"hget", "h", "f1"}, "$2\r\nv1\r\n"},
+				{[]string{"hget", "h", "f2"}, "$800\r\n" + long + "\r\n"},
+			},
+		},
+		{
+			name:  "set",
+			setup: [][]string{{"sadd", "st", "a", "b", "1"}},
+			checks: []check{
+				{[]string{"scard", "st"}, ":3\r\n"},
+				{[]string{"sismember", "st", "a"}, ":1\r\n"},
+				{[]string{"sismember", "st", "1"}, ":1\r\n"},
+			},
+		},
+		{
+			name:  "sorted set",
+			setup: [][]string{{"zadd", "z", "1.5", "a", "-2", "b", "inf", "c"}},
+			checks: []check{
+				{[]string{"zrange", "z", "0", "-1", "withscores"}, "*6\r\n$1\r\nb\r\n$2\r\n-2\r\n$1\r\na\r\n$3\r\n1.5\r\n$1\r\nc\r\n$3\r\ninf\r\n"},
+			},
+		},
+		{
+			name:  "stream",
+			setup: [][]string{{"xadd", "x", "1-1", "f", "v"}, {"xadd", "x", "1-2", "f", "w"}, {"xadd", "x", "2-0", "g", "1"}},
+			checks: []check{
+				{[]string{"xlen", "x"}, ":3\r\n"},
+				{[]string{"xrange", "x", "-", "+"}, "*3\r\n" +
+					"*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n" +
+					"*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nf\r\n$1\r\nw\r\n" +
+					"*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\ng\r\n$1\r\n1\r\n"},
+			},
+		},
+		{
+			name:  "expire",
+			setup: [][]string{{"set", "ttl", "v"}, {"expire", "ttl", "1000"}, {"set", "persist", "v"}},
+			checks: []check{
+				{[]string{"get", "ttl"}, "$1\r\nv\r\n"},
+				{[]string{"persist", "persist"}, ":0\r\n"},
+				{[]string{"persist", "ttl"}, ":1\r\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbs := NewDatabases(2)
+			for _, cmd := range tt.setup {
+				execString(dbs.Get(1), cmd...)
+			}
+			content, _, err := dbs.snapshot(map[string]string{"aof-base": "0"})
+			if err != nil {
+				t.Fatalf("snapshot() error = %v", err)
+			}
+
+			loaded := NewDatabases(2)
+			keys, aux, err := loaded.loadRDB(content)
+			if err != nil {
+				t.Fatalf("loadRDB() error = %v", err)
+			}
+			if keys != len(dbs.Get(1).db.Keys()) {
+				t.Fatalf("loadRDB() loaded %d keys, want %d", keys, len(dbs.Get(1).db.Keys()))
+			}
+			if aux["aof-base"] != "0" || aux["redis-ver"] != serverVersion {
+				t.Fatalf("aux = %v, want aof-base 0 and redis-ver %s", aux, serverVersion)
+			}
+			if n := loaded.Get(0).db.Len(); n != 0 {
+				t.Fatalf("db 0 has %d keys, want 0", n)
+			}
+			for _, c := range tt.checks {
+				if got := execString(loaded.Get(1), c.cmd...); got != c.want {
+					t.Fatalf("%q = %q, want %q", c.cmd, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRDBExpiredKeys(t *testing.T) {
+	dbs := NewDatabases(1)
+	execString(dbs.Get(0), "set", "gone", "v")
+	execString(dbs.Get(0), "set", "kept", "v")
+	// an expired key the expire task has not deleted yet
+	dbs.Get(0).ttlKeys.Set("gone", &TTLInfo{value: time.Now().Unix() - 10})
+	content, _, err := dbs.snapshot(nil)
+	if err != nil {
+		t.Fatalf("snapshot() error = %v", err)
+	}
+	loaded := NewDatabases(1)
+	if keys, _, err := loaded.loadRDB(content); err != nil || keys != 1 {
+		t.Fatalf("loadRDB() = %d, %v, want 1 key", keys, err)
+	}
+	if got := execString(loaded.Get(0), "exists", "gone", "kept"); got != ":1\r\n" {
+		t.Fatalf("exists gone kept = %q, want %q", got, ":1\r\n")
+	}
+}
+
+func TestRDBLoadErrors(t *testing.T) {
+	dbs := NewDatabases(1)
+	execString(dbs.Get(0), "set", "k", "v")
+	content, _, err := dbs.snapshot(nil)
+	if err != nil {
+		t.Fatalf("snapshot() error = %v", err)
+	}
+	corrupt := func(f func(p []byte)) []byte {
+		p := append([]byte(nil), content...)
+		f(p)
+		return p
+	}
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"empty", nil},
+		{"wrong signature", corrupt(func(p []byte) { p[0] = 'X' })},
+		{"unknown version", corrupt(func(p []byte) { copy(p[5:9], "9999") })},
+		{"wrong checksum", corrupt(func(p []byte) { p[len(p)-1] ^= 0xff })},
+		{"truncated", content[:len(content)-10]},
+		{"more databases than configured", corrupt(func(p []byte) {
+			// select db 5 instead of db 0, and disable the checksum so that it does not fail first
+			i := strings.IndexByte(string(p[9:]), rdbOpcodeSelectDB) + 9
+			p[i+1] = 5
+			copy(p[len(p)-8:], make([]byte, 8))
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := NewDatabases(1).loadRDB(tt.content); err == nil {
+				t.Fatalf("loadRDB() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestRDBSnapshotDuringWrites(t *testing.T) {
+	setup := [][]string{{"set", "s", "v"}, {"hset", "h", "f", "v"}, {"rpush", "l", "a", "b"}, {"set", "ttl", "v", "ex", "100"}}
+	// reads are run on db 0 and db 1 of the dbs when the snapshot starts and of the loaded dbs
+	reads := [][]string{{"get", "s"}, {"hgetall", "h"}, {"lrange", "l", "0", "-1"}, {"ttl", "ttl"}, {"exists", "new"}}
+	tests := []struct {
+		name string
+		// writes are run on db 0 after the snapshot starts, before it is encoded
+		writes [][]string
+	}{
+		{"set", [][]string{{"set", "s", "changed"}, {"set", "new", "v"}}},
+		{"in place changes", [][]string{{"hset", "h", "f", "changed", "g", "v"}, {"rpush", "l", "c"}, {"lpop", "l"}}},
+		{"deletes", [][]string{{"del", "s", "h"}, {"persist", "ttl"}}},
+		{"move", [][]string{{"move", "s", "1"}}},
+		{"whole dbs", [][]string{{"swapdb", "0", "1"}, {"flushall"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbs := NewDatabases(2)
+			for _, cmd := range setup {
+				execString(dbs.Get(0), cmd...)
+			}
+			execString(dbs.Get(1), "set", "other", "v")
+			var want []string
+			for i := 0; i < 2; i++ {
+				for _, cmd := range reads {
+					want = append(want, execString(dbs.Get(i), cmd...))
+				}
+			}
+
+			dbs.writeMu.Lock()
+			s := newRDBSnapshot(dbs)
+			dbs.writeMu.Unlock()
+			for _, cmd := range tt.writes {
+				execString(dbs.Get(0), cmd...)
+			}
+			content, err := s.encode(nil)
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+
+			loaded := NewDatabases(2)
+			if keys, _, err := loaded.loadRDB(content); err != nil || keys != 5 {
+				t.Fatalf("loadRDB() = %d, %v, want 5 keys", keys, err)
+			}
+			for i := 0; i < 2; i++ {
+				for j, cmd := range reads {
+					if got := execString(loaded.Get(i), cmd...); got != want[i*len(reads)+j] {
+						t.Fatalf("db %d: %q = %q, want %q", i, cmd, got, want[i*len(reads)+j])
+					}
+				}
+			}
+			if len(dbs.dumps) != 0 {
+				t.Fatalf("%d dumps are still running after encode", len(dbs.dumps))
+			}
+		})
+	}
+}
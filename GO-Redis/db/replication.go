@@ -0,0 +1,866 @@
+package db
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A replica connects to its master like a client and sends PSYNC with the replication id and offset it has.
+// The master continues from that offset if it is still in its backlog, otherwise it sends a rdb snapshot of the
+// dataset. Then every write command is streamed to the replica in the same format as the aof.
+// The offset is the number of bytes of the stream since the replication id was created. A replica relays the
+// stream of its master unchanged into its own backlog, so the offsets are the same along a chain of replicas.
+
+const (
+	// replPingPeriod is how often the master sends a PING to its replicas to keep the links alive
+	replPingPeriod = 10 * time.Second
+	// replRetryDelay is how long a replica waits before connecting to its master again after an error
+	replRetryDelay  = time.Second
+	replDialTimeout = 5 * time.Second
+	// replSendChunk is the max number of bytes written to a replica at once
+	replSendChunk = 64 * 1024
+	// replAckPeriod is how often a replica acknowledges the offset it applied
+	replAckPeriod = time.Second
+)
+
+// states of the link of a replica with its master
+const (
+	replStateConnect    = "connect"
+	replStateConnecting = "connecting"
+	replStateSync       = "sync"
+	replStateConnected  = "connected"
+)
+
+const errReadOnlyReplica = "READONLY You can't write against a read only replica."
+
+// replication is the replication state of the server, both as a master and as a replica
+type replication struct {
+	mu sync.Mutex
+	// replID identifies the stream, replID2 is the id of the previous master, valid until secondOffset
+	replID       string
+	replID2      string
+	secondOffset int64
+	// offset is the number of bytes of the stream, backlog keeps the last backlogLen of them in a ring.
+	// The backlog is created when the first replica connects, nothing is fed before.
+	offset     int64
+	backlog    []byte
+	backlogLen int64
+	// changed is closed and replaced when the stream grows
+	changed chan struct{}
+	// selectedDB is the db selected in the stream, -1 forces a SELECT before the next command
+	selectedDB int
+	replicas   map[*replicaLink]struct{}
+	// ackChanged is closed and replaced when a replica acknowledges an offset
+	ackChanged chan struct{}
+
+	// roleMu serializes the role changes
+	roleMu sync.Mutex
+	// masterHost and masterPort are the master of the server, masterHost is empty on a master
+	masterHost string
+	masterPort int
+	linkState  string
+	// masterClient executes the stream of the master, it is kept across reconnections with the db it selected
+	masterClient *Client
+	stopLink     context.CancelFunc
+	linkDone     chan struct{}
+}
+
+// replicaLink is a replica connected to the server
+type replicaLink struct {
+	client *Client
+	cancel context.CancelFunc
+	// ackOffset is the last offset the replica acknowledged with REPLCONF ACK
+	ackOffset int64
+}
+
+func newReplication() *replication {
+	return &replication{
+		replID:       newReplID(),
+		secondOffset: -1,
+		changed:      make(chan struct{}),
+		selectedDB:   -1,
+		replicas:     make(map[*replicaLink]struct{}),
+		ackChanged:   make(chan struct{}),
+	}
+}
+
+// newReplID return a random id of 40 hex characters
+func newReplID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// isReplica report whether the server replicates a master
+func (r *replication) isReplica() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.masterHost != ""
+}
+
+// rejectWrite report whether a write command of conn must be refused because the server is a read only replica
+func (r *replication) rejectWrite(conn net.Conn) bool {
+	if client, ok := conn.(*Client); ok && client.master {
+		return false
+	}
+	return config.Configures.ReplicaReadOnly && r.isReplica()
+}
+
+// feeding report whether the write commands are fed into the backlog
+func (r *replication) feeding() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.backlog != nil && r.masterHost == ""
+}
+
+// feed append the commands to the stream of a master, a replica only relays the stream of its master
+func (r *replication) feed(cmds []propagatedCommand) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.backlog == nil || r.masterHost != "" {
+		return
+	}
+	var buf []byte
+	for _, c := range cmds {
+		buf = appendSelected(buf, &r.selectedDB, c.index, c.cmd)
+	}
+	r.appendBacklog(buf)
+}
+
+// feedRaw append p to the stream as it is
+func (r *replication) feedRaw(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appendBacklog(p)
+}
+
+// createBacklog allocate the backlog, the stream is empty at the current offset
+func (r *replication) createBacklog() {
+	r.backlog = make([]byte, config.Configures.ReplBacklogSize)
+	r.backlogLen = 0
+}
+
+// appendBacklog add p to the stream, the oldest bytes are overwritten when the backlog is full
+func (r *replication) appendBacklog(p []byte) {
+	if r.backlog == nil {
+		r.offset += int64(len(p))
+		return
+	}
+	size := int64(len(r.backlog))
+	for len(p) > 0 {
+		n := copy(r.backlog[r.offset%size:], p)
+		p = p[n:]
+		r.offset += int64(n)
+		r.backlogLen = min(r.backlogLen+int64(n), size)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// readBacklog return a copy of at most replSendChunk bytes of the stream following offset from
+// ok is false if these bytes are no longer in the backlog
+func (r *replication) readBacklog(from int64) ([]byte, bool) {
+	if r.backlog == nil || from < r.offset-r.backlogLen || from > r.offset {
+		return nil, false
+	}
+	size := int64(len(r.backlog))
+	start := from % size
+	n := min(r.offset-from, size-start, replSendChunk)
+	return append([]byte(nil), r.backlog[start:start+n]...), true
+}
+
+// canContinue report whether a replica of the stream replID can continue after offset from the backlog
+func (r *replication) canContinue(replID string, offset int64) bool {
+	if replID != r.replID && (replID != r.replID2 || offset > r.secondOffset) {
+		return false
+	}
+	return r.backlog != nil && offset >= r.offset-r.backlogLen && offset <= r.offset
+}
+
+// ack record the offset acknowledged by the replica client
+func (r *replication) ack(client *Client, offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for link := range r.replicas {
+		if link.client == client && offset > link.ackOffset {
+			link.ackOffset = offset
+			close(r.ackChanged)
+			r.ackChanged = make(chan struct{})
+		}
+	}
+}
+
+// ackedReplicas return the number of replicas which acknowledged offset
+func (r *replication) ackedReplicas(offset int64) int {
+	n := 0
+	for link := range r.replicas {
+		if link.ackOffset >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// waitReplicas block until numReplicas replicas acknowledge the current offset, timeout passes or ctx is done.
+// A timeout of 0 waits forever. It returns the number of replicas which acknowledged the offset.
+func (dbs *Databases) waitReplicas(ctx context.Context, numReplicas int, timeout time.Duration) int {
+	r := dbs.repl
+	// writeMu keeps the GETACK out of a snapshot being sent to a replica
+	dbs.writeMu.Lock()
+	r.mu.Lock()
+	offset := r.offset
+	acked := r.ackedReplicas(offset)
+	if acked < numReplicas && len(r.replicas) > 0 {
+		// ask the replicas to acknowledge now instead of at their next periodic ack
+		r.appendBacklog(appendRESPCommand(nil, [][]byte{[]byte("replconf"), []byte("getack"), []byte("*")}))
+	}
+	r.mu.Unlock()
+	dbs.writeMu.Unlock()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		r.mu.Lock()
+		acked = r.ackedReplicas(offset)
+		changed := r.ackChanged
+		r.mu.Unlock()
+		if acked >= numReplicas {
+			return acked
+		}
+		select {
+		case <-changed:
+		case <-timer:
+			return acked
+		case <-ctx.Done():
+			return acked
+		}
+	}
+}
+
+// sendAcks send the offset applied by the replica to its master periodically until ctx is done
+func (r *replication) sendAcks(ctx context.Context, client *Client) {
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+	for {
+		if err := r.sendAck(client); err != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendAck send REPLCONF ACK with the offset applied by the replica to the master through client
+func (r *replication) sendAck(client *Client) error {
+	r.mu.Lock()
+	offset := r.offset
+	r.mu.Unlock()
+	return client.WriteRaw(appendRESPCommand(nil, [][]byte{[]byte("replconf"), []byte("ack"), []byte(strconv.FormatInt(offset, 10))}))
+}
+
+// shiftReplID start a new stream after the server stops replicating its master
+// The replicas of the old master can still continue from the backlog with the old id.
+func (r *replication) shiftReplID() {
+	r.replID2 = r.replID
+	r.secondOffset = r.offset
+	r.replID = newReplID()
+	r.selectedDB = -1
+}
+
+// disconnectReplicas close the connections of all replicas, they reconnect and resynchronize
+func (r *replication) disconnectReplicas() {
+	for link := range r.replicas {
+		link.cancel()
+		_ = link.client.Close()
+		delete(r.replicas, link)
+	}
+}
+
+// syncReplica start streaming to the replica client, from offset if it can continue with the backlog,
+// or after a rdb snapshot otherwise. psync is false for the old SYNC command, which only receives the snapshot.
+func (dbs *Databases) syncReplica(ctx context.Context, client *Client, replID string, offset int64, psync bool) data.RedisData {
+	r := dbs.repl
+	// the writes are stopped, so that the snapshot starts at the offset
+	dbs.writeMu.Lock()
+	r.mu.Lock()
+	if r.masterHost != "" && r.linkState != replStateConnected {
+		r.mu.Unlock()
+		dbs.writeMu.Unlock()
+		return data.MakeErrorData("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	if r.backlog == nil {
+		r.createBacklog()
+	}
+	var reply []byte
+	// snapshot is the dataset sent before the stream on a full resynchronization
+	var snapshot *rdbSnapshot
+	streamDB := 0
+	if psync && r.canContinue(replID, offset) {
+		reply = fmt.Appendf(reply, "+CONTINUE %s\r\n", r.replID)
+		log.Printf("Partial resynchronization of replica %s accepted from offset %d", client.RemoteAddr(), offset)
+	} else {
+		if r.masterClient != nil {
+			streamDB = r.masterClient.DBIndex()
+		}
+		snapshot = newRDBSnapshot(dbs)
+		offset = r.offset
+		// the replica starts without a selected db
+		if r.masterHost == "" {
+			r.selectedDB = -1
+		}
+		if psync {
+			reply = fmt.Appendf(reply, "+FULLRESYNC %s %d\r\n", r.replID, offset)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	link := &replicaLink{client: client, cancel: cancel}
+	r.replicas[link] = struct{}{}
+	r.mu.Unlock()
+	dbs.writeMu.Unlock()
+
+	if snapshot != nil {
+		// the dataset is dumped without stopping the writers, their commands wait in the backlog meanwhile
+		content, err := snapshot.encode(map[string]string{"repl-stream-db": strconv.Itoa(streamDB)})
+		if err != nil {
+			r.removeReplica(link)
+			return data.MakeErrorData("ERR " + err.Error())
+		}
+		reply = fmt.Appendf(reply, "$%d\r\n", len(content))
+		reply = append(reply, content...)
+		log.Printf("Full resynchronization of replica %s at offset %d with %d bytes", client.RemoteAddr(), offset, len(content))
+	}
+	if err := client.WriteRaw(reply); err != nil {
+		r.removeReplica(link)
+		return nil
+	}
+	go r.streamTo(ctx, link, offset)
+	// nothing is replied to a replica
+	return nil
+}
+
+// streamTo write the stream after offset to the replica until it is disconnected
+func (r *replication) streamTo(ctx context.Context, link *replicaLink, offset int64) {
+	defer r.removeReplica(link)
+	for {
+		r.mu.Lock()
+		chunk, ok := r.readBacklog(offset)
+		changed := r.changed
+		r.mu.Unlock()
+		if !ok {
+			log.Printf("Replica %s is too far behind the backlog, disconnecting it", link.client.RemoteAddr())
+			_ = link.client.Close()
+			return
+		}
+		if len(chunk) > 0 {
+			if err := link.client.WriteRaw(chunk); err != nil {
+				return
+			}
+			offset += int64(len(chunk))
+			continue
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *replication) removeReplica(link *replicaLink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link.cancel()
+	delete(r.replicas, link)
+}
+
+// ReplicaOf make the server a replica of the master at host and port, or a master if host is empty
+// It returns false if the server already replicates this master.
+func (dbs *Databases) ReplicaOf(host string, port int) bool {
+	r := dbs.repl
+	r.roleMu.Lock()
+	defer r.roleMu.Unlock()
+	r.mu.Lock()
+	if host == r.masterHost && port == r.masterPort {
+		r.mu.Unlock()
+		return false
+	}
+	r.mu.Unlock()
+	dbs.stopReplication()
+
+	// a master with a backlog starts feeding it, no write may be in flight without being serialized
+	dbs.writeMu.Lock()
+	defer dbs.writeMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if host == "" {
+		log.Printf("Stop replicating %s:%d, the server is now a master", r.masterHost, r.masterPort)
+		r.shiftReplID()
+		r.linkState = ""
+		r.masterClient = nil
+	} else {
+		log.Printf("Replicating the master %s:%d", host, port)
+		ctx, cancel := context.WithCancel(context.Background())
+		r.stopLink, r.linkDone = cancel, make(chan struct{})
+		r.linkState = replStateConnect
+		go dbs.replicateMaster(ctx, host, port, r.linkDone)
+	}
+	r.masterHost, r.masterPort = host, port
+	r.disconnectReplicas()
+	return true
+}
+
+// stopReplication close the link with the master and wait for it to stop
+func (dbs *Databases) stopReplication() {
+	r := dbs.repl
+	r.mu.Lock()
+	stop, done := r.stopLink, r.linkDone
+	r.stopLink, r.linkDone = nil, nil
+	r.mu.Unlock()
+	if stop != nil {
+		stop()
+		<-done
+	}
+}
+
+// replicateMaster synchronize with the master and apply its stream until ctx is done, reconnecting after errors
+func (dbs *Databases) replicateMaster(ctx context.Context, host string, port int, done chan struct{}) {
+	defer close(done)
+	for {
+		err := dbs.syncWithMaster(ctx, host, port)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Replication with the master %s:%d error: %s", host, port, err.Error())
+		dbs.repl.setLinkState(replStateConnect)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replRetryDelay):
+		}
+	}
+}
+
+func (r *replication) setLinkState(state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.linkState = state
+}
+
+// streamReader keep the bytes read from the master until they are taken, so that the stream is relayed unchanged
+type streamReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.buf = append(s.buf, p[:n]...)
+	return n, err
+}
+
+// take remove and return the bytes read except the last unread ones still buffered after them
+func (s *streamReader) take(unread int) []byte {
+	n := len(s.buf) - unread
+	p := append([]byte(nil), s.buf[:n]...)
+	s.buf = append(s.buf[:0], s.buf[n:]...)
+	return p
+}
+
+// syncWithMaster run the handshake with the master, then apply its stream until an error happens
+func (dbs *Databases) syncWithMaster(ctx context.Context, host string, port int) error {
+	r := dbs.repl
+	r.setLinkState(replStateConnecting)
+	dialer := &net.Dialer{Timeout: replDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	// a blocked read is stopped by closing the connection
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	stream := &streamReader{r: conn}
+	reader := bufio.NewReader(stream)
+	parser := data.NewParser(reader)
+	call := func(args ...string) (data.RedisData, error) {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		if _, err := conn.Write(appendRESPCommand(nil, cmd)); err != nil {
+			return nil, err
+		}
+		return parser.ReadValue()
+	}
+
+	res, err := call("ping")
+	if err != nil {
+		return err
+	}
+	if errData, ok := res.(*data.ErrorData); ok {
+		return fmt.Errorf("master replied to PING: %s", errData.String())
+	}
+	// the master may not know these options, their errors are ignored
+	if _, err = call("replconf", "listening-port", strconv.Itoa(config.Configures.Port)); err != nil {
+		return err
+	}
+	if _, err = call("replconf", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	replID, offset := r.replID, r.offset
+	r.mu.Unlock()
+	res, err = call("psync", replID, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+	reply, ok := res.(*data.StringData)
+	if !ok {
+		return fmt.Errorf("unexpected reply to PSYNC: %s", res.String())
+	}
+	fields := strings.Fields(reply.Data())
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad offset in the reply to PSYNC: %s", reply.Data())
+		}
+		r.setLinkState(replStateSync)
+		content, err := readSyncPayload(reader)
+		if err != nil {
+			return err
+		}
+		if err = dbs.loadSyncPayload(content, fields[1], offset); err != nil {
+			return err
+		}
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		r.mu.Lock()
+		if len(fields) == 2 && fields[1] != r.replID {
+			// the master was promoted, its replicas resynchronize with the new id
+			r.replID2, r.secondOffset, r.replID = r.replID, r.offset, fields[1]
+			r.disconnectReplicas()
+		}
+		if r.masterClient == nil {
+			r.masterClient = newMasterClient(0)
+		}
+		r.mu.Unlock()
+		log.Printf("Partial resynchronization with the master %s:%d from offset %d", host, port, offset)
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %s", reply.Data())
+	}
+	stream.take(reader.Buffered())
+
+	r.mu.Lock()
+	r.linkState = replStateConnected
+	client := r.masterClient
+	// acks are written to the master through the client executing its stream
+	client.Conn = conn
+	r.mu.Unlock()
+	log.Printf("Connected to the master %s:%d", host, port)
+	ackCtx, stopAcks := context.WithCancel(ctx)
+	defer stopAcks()
+	go r.sendAcks(ackCtx, client)
+	for {
+		cmd, err := parser.ReadCommand()
+		if err != nil {
+			return err
+		}
+		dbs.applyMasterCommand(ctx, client, cmd.ToCommand(), stream.take(reader.Buffered()))
+	}
+}
+
+// readSyncPayload read the rdb snapshot sent by the master as $<length>\r\n<content>
+// The master may send newlines to keep the link alive while it prepares the snapshot.
+func readSyncPayload(reader *bufio.Reader) ([]byte, error) {
+	var line string
+	for line == "" {
+		s, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(s, "\r\n")
+	}
+	if line[0] == '-' {
+		return nil, fmt.Errorf("master aborted the synchronization: %s", line[1:])
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+	if line[0] != '$' || err != nil || n < 0 {
+		return nil, fmt.Errorf("bad synchronization payload header: %s", line)
+	}
+	content := make([]byte, n)
+	if _, err = io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// loadSyncPayload replace the dataset with the rdb snapshot of the master, the stream restarts at offset
+func (dbs *Databases) loadSyncPayload(content []byte, replID string, offset int64) error {
+	r := dbs.repl
+	dbs.writeMu.Lock()
+	// the running dumps keep the dataset replaced
+	dbs.beforeWrite(nil, nil)
+	for i := 0; i < dbs.Len(); i++ {
+		dbs.Get(i).Flush()
+	}
+	start := time.Now()
+	keys, aux, err := dbs.loadRDB(content)
+	if err != nil {
+		dbs.writeMu.Unlock()
+		return fmt.Errorf("load the rdb of the master: %w", err)
+	}
+	streamDB, _ := strconv.Atoi(aux["repl-stream-db"])
+
+	r.mu.Lock()
+	r.replID, r.replID2, r.secondOffset = replID, "", -1
+	r.offset = offset
+	r.createBacklog()
+	r.masterClient = newMasterClient(streamDB)
+	// the replicas of this server have an older dataset
+	r.disconnectReplicas()
+	r.mu.Unlock()
+	dbs.writeMu.Unlock()
+	log.Printf("Loaded the rdb of the master: %d keys in %.3f seconds", keys, time.Since(start).Seconds())
+
+	if dbs.aof != nil {
+		if err = dbs.BGRewriteAOF(); err != nil {
+			log.Printf("Rewrite the append only file after the synchronization error: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func newMasterClient(dbIndex int) *Client {
+	client := NewClient(nil)
+	client.master = true
+	client.dbIndex = dbIndex
+	return client
+}
+
+// applyMasterCommand execute a command of the master stream and relay raw, the bytes it was read from
+func (dbs *Databases) applyMasterCommand(ctx context.Context, client *Client, cmd [][]byte, raw []byte) {
+	dbs.writeMu.Lock()
+	dbs.serialized = true
+	defer func() {
+		dbs.serialized = false
+		dbs.writeMu.Unlock()
+	}()
+	if len(cmd) > 0 {
+		if c, errRes := lookupCommand(cmd); errRes != nil {
+			log.Printf("Apply %s of the master error: %s", string(cmd[0]), errRes.String())
+		} else if res := dbs.Get(client.DBIndex()).execCommand(ctx, c, cmd, client); res != nil {
+			if errData, ok := res.(*data.ErrorData); ok {
+				log.Printf("Apply %s of the master error: %s", string(cmd[0]), errData.String())
+			}
+		}
+	}
+	dbs.repl.feedRaw(raw)
+}
+
+// ServeReplication send a PING to the replicas periodically, until ctx is done
+func (dbs *Databases) ServeReplication(ctx context.Context) {
+	ticker := time.NewTicker(replPingPeriod)
+	defer ticker.Stop()
+	ping := appendRESPCommand(nil, [][]byte{[]byte("ping")})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		dbs.writeMu.Lock()
+		r := dbs.repl
+		r.mu.Lock()
+		if r.masterHost == "" && len(r.replicas) > 0 {
+			r.appendBacklog(ping)
+		}
+		r.mu.Unlock()
+		dbs.writeMu.Unlock()
+	}
+}
+
+// replicaOfServer change the master of the server
+// REPLICAOF host port | REPLICAOF NO ONE
+func replicaOfServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "replicaof" && cmdName != "slaveof" {
+		return data.MakeErrorData("Server Error")
+	}
+	if client, ok := conn.(*Client); ok && client.master {
+		return data.MakeErrorData("ERR Command is not valid when client is a replica.")
+	}
+	host, port, err := config.ParseReplicaOf([]string{string(cmd[1]), string(cmd[2])})
+	if err != nil {
+		return data.MakeErrorData("ERR Invalid master port")
+	}
+	if !db.dbs.ReplicaOf(host, port) && host != "" {
+		return data.MakeStringData("OK Already connected to specified master")
+	}
+	return data.MakeStringData("OK")
+}
+
+// pSyncServer start the replication stream on the connection of a replica
+// PSYNC replicationid offset, offset is the first byte the replica needs, "?" and -1 ask for a full resynchronization
+func pSyncServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "psync" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || client.Conn == nil {
+		return data.MakeErrorData("ERR PSYNC is only valid on a client connection")
+	}
+	offset, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	return db.dbs.syncReplica(ctx, client, string(cmd[1]), offset-1, true)
+}
+
+// syncServer start the replication stream with a full resynchronization, it is the PSYNC of old replicas
+// SYNC
+func syncServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sync" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || client.Conn == nil {
+		return data.MakeErrorData("ERR SYNC is only valid on a client connection")
+	}
+	return db.dbs.syncReplica(ctx, client, "", 0, false)
+}
+
+// replConfServer set the options of a replica connection
+// REPLCONF option value [option value ...]
+func replConfServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "replconf" {
+		return data.MakeErrorData("Server Error")
+	}
+	if len(cmd)%2 != 1 {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	client, _ := conn.(*Client)
+	for i := 1; i < len(cmd); i += 2 {
+		switch strings.ToLower(string(cmd[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil {
+				return data.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			if client != nil {
+				client.replicaPort = port
+			}
+		case "capa", "ip-address":
+		case "ack":
+			// acknowledgements are not replied
+			offset, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err == nil && client != nil {
+				db.dbs.repl.ack(client, offset)
+			}
+			return nil
+		case "getack":
+			// the master asks its replica for an ack, it is sent instead of a reply
+			if client != nil && client.master {
+				_ = db.dbs.repl.sendAck(client)
+			}
+			return nil
+		default:
+			return data.MakeErrorData(fmt.Sprintf("ERR Unrecognized REPLCONF option: %s", string(cmd[i])))
+		}
+	}
+	return data.MakeStringData("OK")
+}
+
+// roleServer return the replication role of the server
+// ROLE
+func roleServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "role" {
+		return data.MakeErrorData("Server Error")
+	}
+	r := db.dbs.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.masterHost != "" {
+		return data.MakeArrayData([]data.RedisData{
+			data.MakeBulkData([]byte("slave")),
+			data.MakeBulkData([]byte(r.masterHost)),
+			data.MakeIntData(int64(r.masterPort)),
+			data.MakeBulkData([]byte(r.linkState)),
+			data.MakeIntData(r.offset),
+		})
+	}
+	replicas := make([]data.RedisData, 0, len(r.replicas))
+	for link := range r.replicas {
+		host, _, _ := net.SplitHostPort(link.client.RemoteAddr().String())
+		replicas = append(replicas, data.MakeArrayData([]data.RedisData{
+			data.MakeBulkData([]byte(host)),
+			data.MakeBulkData([]byte(strconv.Itoa(link.client.replicaPort))),
+			data.MakeBulkData([]byte(strconv.FormatInt(link.ackOffset, 10))),
+		}))
+	}
+	return data.MakeArrayData([]data.RedisData{
+		data.MakeBulkData([]byte("master")),
+		data.MakeIntData(r.offset),
+		data.MakeArrayData(replicas),
+	})
+}
+
+// waitServer block the client until its previous writes are acknowledged by numreplicas replicas or timeout
+// milliseconds pass, 0 waits forever. It returns the number of replicas which acknowledged them.
+// WAIT numreplicas timeout
+func waitServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "wait" {
+		return data.MakeErrorData("Server Error")
+	}
+	if db.dbs.repl.isReplica() {
+		return data.MakeErrorData("ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+	}
+	numReplicas, err := strconv.Atoi(string(cmd[1]))
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return data.MakeErrorData("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return data.MakeErrorData("ERR timeout is negative")
+	}
+	return data.MakeIntData(int64(db.dbs.waitReplicas(ctx, numReplicas, time.Duration(timeout)*time.Millisecond)))
+}
+
+func RegisterReplicationCommands() {
+	RegisterCommand("replicaof", replicaOfServer, 3, cmdAdmin, 0, 0, 0)
+	RegisterCommand("slaveof", replicaOfServer, 3, cmdAdmin, 0, 0, 0)
+	RegisterCommand("psync", pSyncServer, -3, cmdAdmin, 0, 0, 0)
+	RegisterCommand("sync", syncServer, 1, cmdAdmin, 0, 0, 0)
+	RegisterCommand("replconf", replConfServer, -1, cmdAdmin, 0, 0, 0)
+	RegisterCommand("role", roleServer, 1, cmdFast, 0, 0, 0)
+	RegisterCommand("wait", waitServer, 3, 0, 0, 0, 0)
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// implements the server commands of redis
+
+func RegisterServerCommands() {
+	RegisterCommand("dbsize", dbSizeServer, 1, cmdReadonly|cmdFast, 0, 0, 0)
+	RegisterCommand("flushdb", flushDBServer, -1, cmdWrite, 0, 0, 0)
+	RegisterCommand("flushall", flushAllServer, -1, cmdWrite, 0, 0, 0)
+	RegisterCommand("swapdb", swapDBServer, 3, cmdWrite|cmdFast, 0, 0, 0)
+	RegisterCommand("save", saveServer, 1, cmdAdmin, 0, 0, 0)
+	RegisterCommand("bgsave", bgSaveServer, -1, cmdAdmin, 0, 0, 0)
+	RegisterCommand("lastsave", lastSaveServer, 1, cmdFast, 0, 0, 0)
+	RegisterCommand("bgrewriteaof", bgRewriteAOFServer, 1, cmdAdmin, 0, 0, 0)
+	RegisterCommand("command", commandServer, -1, 0, 0, 0, 0)
+}
+
+// dbSizeServer return the number of keys in the selected db
+func dbSizeServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "dbsize" {
+		return data.MakeErrorData("Server Error")
+	}
+	return data.MakeIntData(db.db.Len())
+}
+
+// flushDBServer delete all keys of the selected db
+// FLUSHDB [ASYNC | SYNC], the db is always flushed synchronously
+func flushDBServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "flushdb" {
+		return data.MakeErrorData("Server Error")
+	}
+	if err := checkFlushMode(cmd); err != nil {
+		return err
+	}
+	db.Flush()
+	return data.MakeStringData("OK")
+}
+
+// flushAllServer delete all keys of every db
+// FLUSHALL [ASYNC | SYNC], the dbs are always flushed synchronously
+func flushAllServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "flushall" {
+		return data.MakeErrorData("Server Error")
+	}
+	if err := checkFlushMode(cmd); err != nil {
+		return err
+	}
+	for i := 0; i < db.dbs.Len(); i++ {
+		db.dbs.Get(i).Flush()
+	}
+	return data.MakeStringData("OK")
+}
+
+func checkFlushMode(cmd [][]byte) data.RedisData {
+	if len(cmd) > 2 {
+		return data.MakeWrongNumberArgs(strings.ToLower(string(cmd[0])))
+	}
+	if len(cmd) == 2 {
+		mode := strings.ToLower(string(cmd[1]))
+		if mode != "async" && mode != "sync" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+	}
+	return nil
+}
+
+// swapDBServer exchange the data of two dbs, clients connected to one db will see the data of the other
+func swapDBServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "swapdb" {
+		return data.MakeErrorData("Server Error")
+	}
+	if errRes := db.dbs.rejectInCluster("swapdb"); errRes != nil {
+		return errRes
+	}
+	first, err := strconv.Atoi(string(cmd[1]))
+	if err != nil {
+		return data.MakeErrorData("ERR invalid first DB index")
+	}
+	second, err := strconv.Atoi(string(cmd[2]))
+	if err != nil {
+		return data.MakeErrorData("ERR invalid second DB index")
+	}
+	if db.dbs.Get(first) == nil || db.dbs.Get(second) == nil {
+		return data.MakeErrorData("ERR DB index is out of range")
+	}
+	if first != second {
+		db.dbs.Swap(first, second)
+	}
+	return data.MakeStringData("OK")
+}
+
+// saveServer write the rdb file synchronously, all clients are blocked until it is done
+func saveServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "save" {
+		return data.MakeErrorData("Server Error")
+	}
+	if err := db.dbs.Save(); err != nil {
+		if err == errBgSaveInProgress {
+			return data.MakeErrorData(err.Error())
+		}
+		return data.MakeErrorData("ERR " + err.Error())
+	}
+	return data.MakeStringData("OK")
+}
+
+// bgSaveServer write the rdb file in background
+// BGSAVE [SCHEDULE], with SCHEDULE a background save in progress is not an error and the next save is left to the save rules
+func bgSaveServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bgsave" {
+		return data.MakeErrorData("Server Error")
+	}
+	if len(cmd) > 2 {
+		return data.MakeWrongNumberArgs("bgsave")
+	}
+	schedule := false
+	if len(cmd) == 2 {
+		if strings.ToLower(string(cmd[1])) != "schedule" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		schedule = true
+	}
+	if err := db.dbs.BGSave(); err != nil {
+		if err == errBgSaveInProgress {
+			if schedule {
+				return data.MakeStringData("Background saving scheduled")
+			}
+			return data.MakeErrorData(err.Error())
+		}
+		return data.MakeErrorData("ERR " + err.Error())
+	}
+	return data.MakeStringData("Background saving started")
+}
+
+// lastSaveServer return the unix time of the last successful save
+func lastSaveServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "lastsave" {
+		return data.MakeErrorData("Server Error")
+	}
+	return data.MakeIntData(db.dbs.LastSave())
+}
+
+// bgRewriteAOFServer rewrite the append only file in background
+func bgRewriteAOFServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "bgrewriteaof" {
+		return data.MakeErrorData("Server Error")
+	}
+	if err := db.dbs.BGRewriteAOF(); err != nil {
+		return data.MakeErrorData(err.Error())
+	}
+	return data.MakeStringData("Background append only file rewriting started")
+}
+
+// commandServer describe the commands in cmdTable
+// COMMAND [COUNT | INFO [command-name ...] | DOCS [command-name ...] | LIST [FILTERBY PATTERN pattern | ACLCAT category] | GETKEYS command [arg ...]]
+func commandServer(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "command" {
+		return data.MakeErrorData("Server Error")
+	}
+	if len(cmd) == 1 {
+		res := make([]data.RedisData, 0, len(cmdTable))
+		for _, name := range sortedCommandNames() {
+			res = append(res, makeCommandInfo(name, cmdTable[name]))
+		}
+		return data.MakeArrayData(res)
+	}
+
+	sub := strings.ToLower(string(cmd[1]))
+	switch sub {
+	case "count":
+		if len(cmd) != 2 {
+			return data.MakeErrorData("ERR wrong number of arguments for 'command|count' command")
+		}
+		return data.MakeIntData(int64(len(cmdTable)))
+	case "info", "docs":
+		names := make([]string, 0, len(cmd)-2)
+		for _, name := range cmd[2:] {
+			names = append(names, strings.ToLower(string(name)))
+		}
+		if len(names) == 0 {
+			names = sortedCommandNames()
+		}
+		if sub == "docs" {
+			// unknown commands are skipped by DOCS
+			res := data.MakeMapData()
+			for _, name := range names {
+				if _, ok := cmdTable[name]; ok {
+					res.Add(data.MakeBulkData([]byte(name)), makeCommandDocs(name))
+				}
+			}
+			return res
+		}
+		res := make([]data.RedisData, 0, len(names))
+		for _, name := range names {
+			if c, ok := cmdTable[name]; ok {
+				res = append(res, makeCommandInfo(name, c))
+			} else {
+				res = append(res, data.MakeArrayData(nil))
+			}
+		}
+		return data.MakeArrayData(res)
+	case "list":
+		return commandListServer(cmd)
+	case "getkeys":
+		if len(cmd) < 3 {
+			return data.MakeErrorData("ERR wrong number of arguments for 'command|getkeys' command")
+		}
+		args := cmd[2:]
+		c, ok := cmdTable[strings.ToLower(string(args[0]))]
+		if !ok {
+			return data.MakeErrorData("ERR Invalid command specified")
+		}
+		if !c.checkArity(args) {
+			return data.MakeErrorData("ERR Invalid number of arguments specified for command")
+		}
+		positions := c.keyPositions(args)
+		if len(positions) == 0 {
+			return data.MakeErrorData("ERR The command has no key arguments")
+		}
+		res := make([]data.RedisData, 0, len(positions))
+		for _, i := range positions {
+			res = append(res, data.MakeBulkData(args[i]))
+		}
+		return data.MakeArrayData(res)
+	}
+	return data.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", string(cmd[1])))
+}
+
+// commandListServer return the names of the commands
+// COMMAND LIST [FILTERBY PATTERN pattern | ACLCAT category]
+func commandListServer(cmd [][]byte) data.RedisData {
+	var filter func(name string, c *command) bool
+	switch {
+	case len(cmd) == 2:
+	case len(cmd) == 5 && strings.ToLower(string(cmd[2])) == "filterby":
+		value := string(cmd[4])
+		switch strings.ToLower(string(cmd[3])) {
+		case "pattern":
+			filter = func(name string, c *command) bool {
+				return PattenMatch(value, name)
+			}
+		case "aclcat":
+			category := "@" + strings.ToLower(value)
+			filter = func(name string, c *command) bool {
+				for _, cat := range c.aclCategories(name) {
+					if cat == category {
+						return true
+					}
+				}
+				return false
+			}
+		case "module":
+			// there is no module
+			return data.MakeEmptyArrayData()
+		default:
+			return data.MakeErrorData("ERR syntax error")
+		}
+	default:
+		return data.MakeErrorData("ERR syntax error")
+	}
+	res := make([]data.RedisData, 0)
+	for _, name := range sortedCommandNames() {
+		if filter == nil || filter(name, cmdTable[name]) {
+			res = append(res, data.MakeBulkData([]byte(name)))
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(cmdTable))
+	for name := range cmdTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// makeCommandInfo return the reply of COMMAND INFO for a command:
+// name, arity, flags, first key, last key, key step, acl categories, tips, key specs and subcommands
+func makeCommandInfo(name string, c *command) data.RedisData {
+	flags := make([]data.RedisData, 0)
+	for _, flag := range c.flagNames() {
+		flags = append(flags, data.MakeStringData(flag))
+	}
+	categories := make([]data.RedisData, 0)
+	for _, category := range c.aclCategories(name) {
+		categories = append(categories, data.MakeStringData(category))
+	}
+	return data.MakeArrayData([]data.RedisData{
+		data.MakeBulkData([]byte(name)),
+		data.MakeIntData(int64(c.Arity)),
+		data.MakeSetData(flags),
+		data.MakeIntData(int64(c.FirstKey)),
+		data.MakeIntData(int64(c.LastKey)),
+		data.MakeIntData(int64(c.KeyStep)),
+		data.MakeSetData(categories),
+		data.MakeEmptyArrayData(),
+		data.MakeEmptyArrayData(),
+		data.MakeEmptyArrayData(),
+	})
+}
+
+// makeCommandDocs return the reply of COMMAND DOCS for a command, only its group is documented
+func makeCommandDocs(name string) data.RedisData {
+	docs := data.MakeMapData()
+	if group := commandGroup(name); group != "" {
+		docs.Add(data.MakeBulkData([]byte("group")), data.MakeBulkData([]byte(group)))
+	}
+	return docs
+}
@@ -0,0 +1,504 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// getSet return the set stored in key, nil if key does not exist
+// the caller should hold the lock of key
+func getSet(db *DB, key string) (*Set, data.RedisData) {
+	tem, ok := db.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	set, ok := tem.(*Set)
+	if !ok {
+		return nil, data.MakeWrongType()
+	}
+	return set, nil
+}
+
+// removeEmptySet delete key when its set has no member
+func removeEmptySet(db *DB, key string, set *Set) {
+	if set != nil && set.Len() == 0 {
+		db.removeKey(key)
+		db.DeleteTTL(key)
+	}
+}
+
+// storeSet replace the value of key by set, key is deleted if set is empty
+// the caller should hold the lock of key
+func storeSet(db *DB, key string, set *Set) {
+	db.DeleteTTL(key)
+	if set.Len() == 0 {
+		db.removeKey(key)
+		return
+	}
+	db.setKey(key, set)
+}
+
+func makeMembersReply(members []string) data.RedisData {
+	res := make([]data.RedisData, 0, len(members))
+	for _, member := range members {
+		res = append(res, data.MakeBulkData([]byte(member)))
+	}
+	return data.MakeArrayData(res)
+}
+
+// makeSetReply reply the members as a set for RESP3 clients and as an array for RESP2 clients
+func makeSetReply(members []string) data.RedisData {
+	res := make([]data.RedisData, 0, len(members))
+	for _, member := range members {
+		res = append(res, data.MakeBulkData([]byte(member)))
+	}
+	return data.MakeSetData(res)
+}
+
+func sAddSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sadd" {
+		log.Printf("sAddSet Function: cmdName is not sadd")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		set = NewSet()
+		db.setKey(key, set)
+	}
+
+	count := 0
+	for _, member := range cmd[2:] {
+		if set.Add(string(member)) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.touchKey(key)
+	}
+	return data.MakeIntData(int64(count))
+}
+
+func sRemSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "srem" {
+		log.Printf("sRemSet Function: cmdName is not srem")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		return data.MakeIntData(0)
+	}
+	defer removeEmptySet(db, key, set)
+
+	count := 0
+	for _, member := range cmd[2:] {
+		if set.Remove(string(member)) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.touchKey(key)
+	}
+	return data.MakeIntData(int64(count))
+}
+
+func sIsMemberSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sismember" {
+		log.Printf("sIsMemberSet Function: cmdName is not sismember")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if set == nil || !set.Has(string(cmd[2])) {
+		return data.MakeIntData(0)
+	}
+	return data.MakeIntData(1)
+}
+
+func sMIsMemberSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "smismember" {
+		log.Printf("sMIsMemberSet Function: cmdName is not smismember")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+
+	res := make([]data.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if set != nil && set.Has(string(member)) {
+			res = append(res, data.MakeIntData(1))
+		} else {
+			res = append(res, data.MakeIntData(0))
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+func sMembersSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "smembers" {
+		log.Printf("sMembersSet Function: cmdName is not smembers")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		return makeSetReply(nil)
+	}
+	return makeSetReply(set.Members())
+}
+
+func sCardSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "scard" {
+		log.Printf("sCardSet Function: cmdName is not scard")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		return data.MakeIntData(0)
+	}
+	return data.MakeIntData(int64(set.Len()))
+}
+
+// sPopSet remove and return random members
+// SPOP key [count]
+func sPopSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "spop" {
+		log.Printf("sPopSet Function: cmdName is not spop")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd) > 3 {
+		return data.MakeWrongNumberArgs("spop")
+	}
+
+	count := -1
+	if len(cmd) == 3 {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil || count < 0 {
+			return data.MakeErrorData("ERR value is out of range, must be positive")
+		}
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if set == nil {
+		if count < 0 {
+			return data.MakeBulkData(nil)
+		}
+		return makeSetReply(nil)
+	}
+	defer removeEmptySet(db, key, set)
+
+	if count != 0 {
+		db.touchKey(key)
+	}
+	if count < 0 {
+		return data.MakeBulkData([]byte(set.Pop(1)[0]))
+	}
+	return makeSetReply(set.Pop(count))
+}
+
+// sRandMemberSet return random members without removing them
+// SRANDMEMBER key [count]
+func sRandMemberSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "srandmember" {
+		log.Printf("sRandMemberSet Function: cmdName is not srandmember")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd) > 3 {
+		return data.MakeWrongNumberArgs("srandmember")
+	}
+
+	var count int
+	withCount := len(cmd) == 3
+	if withCount {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil {
+			return data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if count < -maxRandomCount {
+			return data.MakeErrorData("ERR value is out of range")
+		}
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	set, err := getSet(db, key)
+	if err != nil {
+		return err
+	}
+	if !withCount {
+		if set == nil {
+			return data.MakeBulkData(nil)
+		}
+		return data.MakeBulkData([]byte(set.Random(1)[0]))
+	}
+	if set == nil {
+		return data.MakeEmptyArrayData()
+	}
+	return makeMembersReply(set.Random(count))
+}
+
+// sMoveSet move member from the source set to the destination set atomically
+// SMOVE source destination member
+func sMoveSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "smove" {
+		log.Printf("sMoveSet Function: cmdName is not smove")
+		return data.MakeErrorData("server error")
+	}
+	src, des := string(cmd[1]), string(cmd[2])
+	member := string(cmd[3])
+	if !db.CheckTTL(src) {
+		return data.MakeIntData(0)
+	}
+	db.CheckTTL(des)
+
+	keys := []string{src, des}
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+
+	srcSet, err := getSet(db, src)
+	if err != nil {
+		return err
+	}
+	desSet, err := getSet(db, des)
+	if err != nil {
+		return err
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return data.MakeIntData(0)
+	}
+	if src == des {
+		return data.MakeIntData(1)
+	}
+
+	srcSet.Remove(member)
+	db.touchKey(src)
+	removeEmptySet(db, src, srcSet)
+	if desSet == nil {
+		desSet = NewSet()
+		db.setKey(des, desSet)
+	}
+	desSet.Add(member)
+	db.touchKey(des)
+	return data.MakeIntData(1)
+}
+
+// collectSets read the sets stored in keys, nil is put for keys not exist
+// the caller should hold the locks of keys
+func collectSets(db *DB, keys []string) ([]*Set, data.RedisData) {
+	sets := make([]*Set, 0, len(keys))
+	for _, key := range keys {
+		set, err := getSet(db, key)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// setOperation compute SINTER, SUNION or SDIFF of the sets stored in keys
+func setOperation(cmdName string, sets []*Set) *Set {
+	switch cmdName {
+	case "sinter", "sinterstore", "sintercard":
+		return Intersect(sets...)
+	case "sunion", "sunionstore":
+		return Union(sets...)
+	default:
+		return Diff(sets...)
+	}
+}
+
+// sOperationSet implements SINTER, SUNION and SDIFF
+// SINTER key [key ...]
+func sOperationSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "sinter" && cmdName != "sunion" && cmdName != "sdiff" {
+		log.Printf("sOperationSet Function: cmdName is not sinter, sunion or sdiff")
+		return data.MakeErrorData("server error")
+	}
+	keys := make([]string, 0, len(cmd)-1)
+	for _, key := range cmd[1:] {
+		keys = append(keys, string(key))
+		db.CheckTTL(string(key))
+	}
+
+	db.locks.RLockMulti(keys)
+	defer db.locks.RUnLockMulti(keys)
+
+	sets, err := collectSets(db, keys)
+	if err != nil {
+		return err
+	}
+	return makeSetReply(setOperation(cmdName, sets).Members())
+}
+
+// sOperationStoreSet implements SINTERSTORE, SUNIONSTORE and SDIFFSTORE
+// SINTERSTORE destination key [key ...]
+func sOperationStoreSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "sinterstore" && cmdName != "sunionstore" && cmdName != "sdiffstore" {
+		log.Printf("sOperationStoreSet Function: cmdName is not sinterstore, sunionstore or sdiffstore")
+		return data.MakeErrorData("server error")
+	}
+	des := string(cmd[1])
+	srcKeys := make([]string, 0, len(cmd)-2)
+	for _, key := range cmd[2:] {
+		srcKeys = append(srcKeys, string(key))
+		db.CheckTTL(string(key))
+	}
+	db.CheckTTL(des)
+
+	keys := append([]string{des}, srcKeys...)
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+
+	sets, err := collectSets(db, srcKeys)
+	if err != nil {
+		return err
+	}
+	res := setOperation(cmdName, sets)
+	storeSet(db, des, res)
+	return data.MakeIntData(int64(res.Len()))
+}
+
+// sInterCardSet return the cardinality of the intersection
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sInterCardSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sintercard" {
+		log.Printf("sInterCardSet Function: cmdName is not sintercard")
+		return data.MakeErrorData("server error")
+	}
+	numKeys, err := strconv.Atoi(string(cmd[1]))
+	if err != nil || numKeys <= 0 {
+		return data.MakeErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(cmd)-2 {
+		return data.MakeErrorData("ERR Number of keys can't be greater than number of args")
+	}
+
+	limit := 0
+	rest := cmd[2+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToLower(string(rest[0])) != "limit" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		limit, err = strconv.Atoi(string(rest[1]))
+		if err != nil || limit < 0 {
+			return data.MakeErrorData("ERR LIMIT can't be negative")
+		}
+	}
+
+	keys := make([]string, 0, numKeys)
+	for _, key := range cmd[2 : 2+numKeys] {
+		keys = append(keys, string(key))
+		db.CheckTTL(string(key))
+	}
+
+	db.locks.RLockMulti(keys)
+	defer db.locks.RUnLockMulti(keys)
+
+	sets, errData := collectSets(db, keys)
+	if errData != nil {
+		return errData
+	}
+	card := Intersect(sets...).Len()
+	if limit > 0 && card > limit {
+		card = limit
+	}
+	return data.MakeIntData(int64(card))
+}
+
+func RegisterSetCommands() {
+	RegisterCommand("sadd", sAddSet, -3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("srem", sRemSet, -3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("sismember", sIsMemberSet, 3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("smismember", sMIsMemberSet, -3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("smembers", sMembersSet, 2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("scard", sCardSet, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("spop", sPopSet, -2, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("srandmember", sRandMemberSet, -2, cmdReadonly, 1, 1, 1)
+	RegisterCommand("smove", sMoveSet, 4, cmdWrite|cmdFast, 1, 2, 1)
+	RegisterCommand("sinter", sOperationSet, -2, cmdReadonly, 1, -1, 1)
+	RegisterCommand("sunion", sOperationSet, -2, cmdReadonly, 1, -1, 1)
+	RegisterCommand("sdiff", sOperationSet, -2, cmdReadonly, 1, -1, 1)
+	RegisterCommand("sinterstore", sOperationStoreSet, -3, cmdWrite|cmdDenyOOM, 1, -1, 1)
+	RegisterCommand("sunionstore", sOperationStoreSet, -3, cmdWrite|cmdDenyOOM, 1, -1, 1)
+	RegisterCommand("sdiffstore", sOperationStoreSet, -3, cmdWrite|cmdDenyOOM, 1, -1, 1)
+	RegisterCommand("sintercard", sInterCardSet, -3, cmdReadonly|cmdMovableKeys, 0, 0, 0)
+	registerKeysFunc("sintercard", numKeysFunc(1))
+}
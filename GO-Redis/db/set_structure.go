@@ -0,0 +1,168 @@
+package db
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Set is an unordered collection of unique members stored in a key
+type Set struct {
+	table map[string]struct{}
+}
+
+func NewSet(members ...string) *Set {
+	s := &Set{
+		table: make(map[string]struct{}, len(members)),
+	}
+	for _, member := range members {
+		s.table[member] = struct{}{}
+	}
+	return s
+}
+
+// Add add member to the set, return true if it is new
+func (s *Set) Add(member string) bool {
+	if _, ok := s.table[member]; ok {
+		return false
+	}
+	s.table[member] = struct{}{}
+	return true
+}
+
+// Remove remove member from the set, return true if it existed
+func (s *Set) Remove(member string) bool {
+	if _, ok := s.table[member]; !ok {
+		return false
+	}
+	delete(s.table, member)
+	return true
+}
+
+func (s *Set) Has(member string) bool {
+	_, ok := s.table[member]
+	return ok
+}
+
+func (s *Set) Len() int {
+	return len(s.table)
+}
+
+func (s *Set) Members() []string {
+	members := make([]string, 0, len(s.table))
+	for member := range s.table {
+		members = append(members, member)
+	}
+	return members
+}
+
+// SortedMembers return all members in lexicographical order, it gives a stable order for scanning
+func (s *Set) SortedMembers() []string {
+	members := s.Members()
+	sort.Strings(members)
+	return members
+}
+
+// Random return count random members
+// If count is positive, the members are distinct and at most Len() members are returned
+// If count is negative, -count members are returned and the same member may appear several times
+func (s *Set) Random(count int) []string {
+	members := s.Members()
+	if count >= 0 {
+		if count > len(members) {
+			count = len(members)
+		}
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		return members[:count]
+	}
+	res := make([]string, 0)
+	if len(members) == 0 {
+		return res
+	}
+	for i := 0; i < -count; i++ {
+		res = append(res, members[rand.Intn(len(members))])
+	}
+	return res
+}
+
+// Pop remove and return count random members
+func (s *Set) Pop(count int) []string {
+	members := s.Random(count)
+	for _, member := range members {
+		delete(s.table, member)
+	}
+	return members
+}
+
+func (s *Set) Copy() *Set {
+	res := &Set{
+		table: make(map[string]struct{}, len(s.table)),
+	}
+	for member := range s.table {
+		res.table[member] = struct{}{}
+	}
+	return res
+}
+
+// Intersect return the members exist in all sets, nil set is treated as an empty set
+func Intersect(sets ...*Set) *Set {
+	res := NewSet()
+	if len(sets) == 0 {
+		return res
+	}
+	// iterate the smallest set
+	smallest := sets[0]
+	for _, s := range sets {
+		if s == nil {
+			return res
+		}
+		if s.Len() < smallest.Len() {
+			smallest = s
+		}
+	}
+	for member := range smallest.table {
+		inAll := true
+		for _, s := range sets {
+			if !s.Has(member) {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			res.Add(member)
+		}
+	}
+	return res
+}
+
+// Union return the members exist in any set, nil set is treated as an empty set
+func Union(sets ...*Set) *Set {
+	res := NewSet()
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+		for member := range s.table {
+			res.Add(member)
+		}
+	}
+	return res
+}
+
+// Diff return the members of the first set that are not in any of the other sets
+func Diff(sets ...*Set) *Set {
+	if len(sets) == 0 || sets[0] == nil {
+		return NewSet()
+	}
+	res := sets[0].Copy()
+	for _, s := range sets[1:] {
+		if s == nil {
+			continue
+		}
+		for member := range s.table {
+			res.Remove(member)
+		}
+	}
+	return res
+}
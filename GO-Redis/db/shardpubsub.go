@@ -0,0 +1,195 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// implements the sharded pub/sub commands of redis
+// The shard channels are kept in shards as the keys of a ConcurrentMap: a channel is in the shard that
+// getKeyPosition gives to a key of the same name, and each shard has its own lock, so that the publishes to
+// different channels do not contend on a single registry. In cluster mode a shard channel belongs to the slot
+// of its name like a key, and its messages only go to the nodes serving that slot.
+
+// pubSubShard is a shard of the registry of the shard channels
+type pubSubShard struct {
+	channels map[string]map[*subscriber]struct{}
+	rwMu     *sync.RWMutex
+}
+
+// shardPubSub is the registry of the subscribers of the shard channels
+type shardPubSub struct {
+	table []*pubSubShard
+	size  int
+}
+
+func newShardPubSub(size int) *shardPubSub {
+	if size <= 0 || size > MaxSize {
+		size = MaxSize
+	}
+	sps := &shardPubSub{
+		table: make([]*pubSubShard, size),
+		size:  size,
+	}
+	for i := 0; i < size; i++ {
+		sps.table[i] = &pubSubShard{channels: make(map[string]map[*subscriber]struct{}), rwMu: &sync.RWMutex{}}
+	}
+	return sps
+}
+
+// getKeyPosition return the shard of channel, the same as the one of a key in a ConcurrentMap of the same size
+func (sps *shardPubSub) getKeyPosition(channel string) int {
+	return HashKey(channel) % sps.size
+}
+
+func (sps *shardPubSub) getShard(channel string) *pubSubShard {
+	return sps.table[sps.getKeyPosition(channel)]
+}
+
+func (sps *shardPubSub) subscribe(channel string, s *subscriber) {
+	shard := sps.getShard(channel)
+	shard.rwMu.Lock()
+	defer shard.rwMu.Unlock()
+	if shard.channels[channel] == nil {
+		shard.channels[channel] = make(map[*subscriber]struct{})
+	}
+	shard.channels[channel][s] = struct{}{}
+}
+
+func (sps *shardPubSub) unsubscribe(channel string, s *subscriber) {
+	shard := sps.getShard(channel)
+	shard.rwMu.Lock()
+	defer shard.rwMu.Unlock()
+	subs := shard.channels[channel]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(shard.channels, channel)
+	}
+}
+
+// publish send message to the subscribers of channel and return how many were reached
+func (sps *shardPubSub) publish(channel string, message []byte) int {
+	shard := sps.getShard(channel)
+	shard.rwMu.RLock()
+	defer shard.rwMu.RUnlock()
+	subs := shard.channels[channel]
+	if len(subs) == 0 {
+		return 0
+	}
+	msg := data.MakePushData([]data.RedisData{
+		data.MakeBulkData([]byte("smessage")),
+		data.MakeBulkData([]byte(channel)),
+		data.MakeBulkData(message),
+	})
+	size := len(msg.ToBytes())
+	receivers := 0
+	for s := range subs {
+		if s.deliver(msg, size) {
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// channels return the shard channels which have subscribers and match pattern, all of them if pattern is nil
+func (sps *shardPubSub) channels(pattern []byte) []string {
+	res := make([]string, 0)
+	for _, shard := range sps.table {
+		shard.rwMu.RLock()
+		for channel := range shard.channels {
+			if pattern == nil || PattenMatch(string(pattern), channel) {
+				res = append(res, channel)
+			}
+		}
+		shard.rwMu.RUnlock()
+	}
+	return res
+}
+
+// numSub return the number of subscribers of channel
+func (sps *shardPubSub) numSub(channel string) int {
+	shard := sps.getShard(channel)
+	shard.rwMu.RLock()
+	defer shard.rwMu.RUnlock()
+	return len(shard.channels[channel])
+}
+
+// subscribeShardPubSub subscribe the client to shard channels
+// In cluster mode all the channels must be in the same slot, as the keys of a command.
+// SSUBSCRIBE shardchannel [shardchannel ...]
+func subscribeShardPubSub(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "ssubscribe" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || client.Conn == nil {
+		return data.MakeErrorData("ERR SSUBSCRIBE is only available on client connections")
+	}
+	s := subscriberOf(client)
+	sps := db.dbs.shardPubsub
+	for _, arg := range cmd[1:] {
+		channel := string(arg)
+		_, ok := s.shardChannels[channel]
+		s.shardChannels[channel] = struct{}{}
+		if err := client.WriteReply(subscribeReply("ssubscribe", arg, s.shardSubscriptions())); err != nil {
+			return nil
+		}
+		if !ok {
+			sps.subscribe(channel, s)
+		}
+	}
+	return nil
+}
+
+// unsubscribeShardPubSub unsubscribe the client from shard channels, or from all of them if none is given
+// SUNSUBSCRIBE [shardchannel [shardchannel ...]]
+func unsubscribeShardPubSub(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "sunsubscribe" {
+		return data.MakeErrorData("Server Error")
+	}
+	client, ok := conn.(*Client)
+	if !ok || client.Conn == nil {
+		return data.MakeErrorData("ERR SUNSUBSCRIBE is only available on client connections")
+	}
+	s := subscriberOf(client)
+	sps := db.dbs.shardPubsub
+	channels := cmd[1:]
+	if len(channels) == 0 {
+		for channel := range s.shardChannels {
+			channels = append(channels, []byte(channel))
+		}
+		if len(channels) == 0 {
+			return subscribeReply("sunsubscribe", nil, 0)
+		}
+	}
+	for _, arg := range channels {
+		channel := string(arg)
+		if _, ok := s.shardChannels[channel]; ok {
+			delete(s.shardChannels, channel)
+			sps.unsubscribe(channel, s)
+		}
+		if err := client.WriteReply(subscribeReply("sunsubscribe", arg, s.shardSubscriptions())); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// publishShardPubSub send a message to the subscribers of a shard channel and return how many received it
+// In cluster mode the message is also sent to the other nodes of the shard of the channel.
+// SPUBLISH shardchannel message
+func publishShardPubSub(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "spublish" {
+		return data.MakeErrorData("Server Error")
+	}
+	receivers := db.dbs.shardPubsub.publish(string(cmd[1]), cmd[2])
+	if c := db.dbs.cluster; c != nil {
+		c.mu.Lock()
+		c.broadcastShard(msgPublishShard, cmd[1], cmd[2])
+		c.mu.Unlock()
+	}
+	return data.MakeIntData(int64(receivers))
+}
@@ -0,0 +1,672 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidStreamID = data.MakeErrorData("ERR Invalid stream ID specified as stream command argument")
+
+func getStream(db *DB, key string) (*Stream, data.RedisData) {
+	tem, ok := db.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	stream, ok := tem.(*Stream)
+	if !ok {
+		return nil, data.MakeWrongType()
+	}
+	return stream, nil
+}
+
+// parseStreamID parse an id as <ms>-<seq> or <ms>, the seq of an id without one is missingSeq
+func parseStreamID(arg []byte, missingSeq uint64) (StreamID, bool) {
+	msPart, seqPart, hasSeq := bytes.Cut(arg, []byte("-"))
+	ms, err := strconv.ParseUint(string(msPart), 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(string(seqPart), 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	return StreamID{Ms: ms, Seq: seq}, true
+}
+
+// parseRangeID parse a bound of XRANGE, - and + are the smallest and the greatest ids and ( makes it exclusive
+// An id without seq is the first id of its ms for a start and the last one for an end.
+func parseRangeID(arg []byte, start bool) (StreamID, data.RedisData) {
+	switch string(arg) {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	missingSeq := uint64(0)
+	if !start {
+		missingSeq = maxStreamID.Seq
+	}
+	id, ok := parseStreamID(arg, missingSeq)
+	if !ok {
+		return StreamID{}, errInvalidStreamID
+	}
+	if !exclusive {
+		return id, nil
+	}
+	if start {
+		if id, ok = id.Next(); !ok {
+			return StreamID{}, data.MakeErrorData("ERR invalid start ID for the interval")
+		}
+	} else if id, ok = id.Prev(); !ok {
+		return StreamID{}, data.MakeErrorData("ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+// nextStreamID return the id of the entry XADD adds to a stream whose last id is last
+// arg is * to generate the id from the time, <ms>-* to generate its seq, or the id itself.
+func nextStreamID(last StreamID, arg []byte) (StreamID, data.RedisData) {
+	errSmaller := data.MakeErrorData("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	if string(arg) == "*" {
+		if now := uint64(time.Now().UnixMilli()); now > last.Ms {
+			return StreamID{Ms: now}, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return StreamID{}, data.MakeErrorData("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	if msPart, found := bytes.CutSuffix(arg, []byte("-*")); found {
+		ms, err := strconv.ParseUint(string(msPart), 10, 64)
+		if err != nil {
+			return StreamID{}, errInvalidStreamID
+		}
+		switch {
+		case ms > last.Ms && ms == 0:
+			return StreamID{Seq: 1}, nil
+		case ms > last.Ms:
+			return StreamID{Ms: ms}, nil
+		case ms == last.Ms && last.Seq < maxStreamID.Seq:
+			if ms == 0 && last.Seq == 0 {
+				return StreamID{Seq: 1}, nil
+			}
+			return StreamID{Ms: ms, Seq: last.Seq + 1}, nil
+		}
+		return StreamID{}, errSmaller
+	}
+	id, ok := parseStreamID(arg, 0)
+	if !ok {
+		return StreamID{}, errInvalidStreamID
+	}
+	if id == (StreamID{}) {
+		return StreamID{}, data.MakeErrorData("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if !last.Less(id) {
+		return StreamID{}, errSmaller
+	}
+	return id, nil
+}
+
+// streamTrim is the trimming of XADD and XTRIM
+type streamTrim struct {
+	// minID trims by id instead of length
+	minID     bool
+	maxLen    int
+	threshold StreamID
+	approx    bool
+	// limit is the max number of entries removed by an approximated trim, 0 means no limit
+	limit int
+}
+
+// parseStreamTrim parse MAXLEN | MINID [= | ~] threshold [LIMIT count] at cmd[i] and return the index after it
+func parseStreamTrim(cmd [][]byte, i int) (*streamTrim, int, data.RedisData) {
+	trim := &streamTrim{minID: strings.ToLower(string(cmd[i])) == "minid"}
+	i++
+	if i < len(cmd) && (string(cmd[i]) == "~" || string(cmd[i]) == "=") {
+		trim.approx = string(cmd[i]) == "~"
+		i++
+	}
+	if i >= len(cmd) {
+		return nil, 0, data.MakeErrorData("ERR syntax error")
+	}
+	if trim.minID {
+		id, ok := parseStreamID(cmd[i], 0)
+		if !ok {
+			return nil, 0, errInvalidStreamID
+		}
+		trim.threshold = id
+	} else {
+		maxLen, err := strconv.Atoi(string(cmd[i]))
+		if err != nil {
+			return nil, 0, data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return nil, 0, data.MakeErrorData("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = maxLen
+	}
+	i++
+	if trim.approx {
+		trim.limit = 100 * streamNodeMaxEntries
+	}
+	if i+1 < len(cmd) && strings.ToLower(string(cmd[i])) == "limit" {
+		if !trim.approx {
+			return nil, 0, data.MakeErrorData("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		limit, err := strconv.Atoi(string(cmd[i+1]))
+		if err != nil {
+			return nil, 0, data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return nil, 0, data.MakeErrorData("ERR The LIMIT argument must be >= 0.")
+		}
+		trim.limit = limit
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// apply trim stream and return the number of entries removed
+func (trim *streamTrim) apply(stream *Stream) int {
+	if trim.minID {
+		return stream.TrimMinID(trim.threshold, trim.approx, trim.limit)
+	}
+	return stream.TrimMaxLen(trim.maxLen, trim.approx, trim.limit)
+}
+
+// exactTrimArgs return the exact trimming which leaves the entries stream has now, it is what is propagated for a
+// trimming, as an approximated one depends on the nodes of the server
+func exactTrimArgs(stream *Stream) [][]byte {
+	if first := stream.First(); first != nil {
+		return [][]byte{[]byte("minid"), []byte("="), []byte(first.ID.String())}
+	}
+	return [][]byte{[]byte("maxlen"), []byte("="), []byte("0")}
+}
+
+// xAddArgs are the options of XADD
+type xAddArgs struct {
+	noMkStream bool
+	trim       *streamTrim
+	// idIndex is the index of the id, the fields follow it
+	idIndex int
+}
+
+// parseXAdd parse XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~] threshold [LIMIT count]] * | id field value [field value ...]
+func parseXAdd(cmd [][]byte) (*xAddArgs, data.RedisData) {
+	args := &xAddArgs{}
+	i := 2
+	for i < len(cmd) {
+		opt := strings.ToLower(string(cmd[i]))
+		if opt == "nomkstream" {
+			args.noMkStream = true
+			i++
+			continue
+		}
+		if opt != "maxlen" && opt != "minid" {
+			break
+		}
+		var errData data.RedisData
+		if args.trim, i, errData = parseStreamTrim(cmd, i); errData != nil {
+			return nil, errData
+		}
+	}
+	fields := len(cmd) - i - 1
+	if fields <= 0 || fields%2 != 0 {
+		return nil, data.MakeWrongNumberArgs("xadd")
+	}
+	args.idIndex = i
+	return args, nil
+}
+
+// makeEntriesReply reply entries as [id, [field, value, ...]] pairs
+func makeEntriesReply(entries []*StreamEntry) data.RedisData {
+	res := make([]data.RedisData, 0, len(entries))
+	for _, entry := range entries {
+		fields := make([]data.RedisData, 0, len(entry.Fields))
+		for _, field := range entry.Fields {
+			fields = append(fields, data.MakeBulkData(field))
+		}
+		res = append(res, data.MakeArrayData([]data.RedisData{
+			data.MakeBulkData([]byte(entry.ID.String())),
+			data.MakeArrayData(fields),
+		}))
+	}
+	return data.MakeArrayData(res)
+}
+
+// xAddStream append an entry to a stream and return its id, the stream is created unless NOMKSTREAM is given
+// XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~] threshold [LIMIT count]] * | id field value [field value ...]
+func xAddStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "xadd" {
+		log.Printf("xAddStream Function: cmdName is not xadd")
+		return data.MakeErrorData("server error")
+	}
+	args, errData := parseXAdd(cmd)
+	if errData != nil {
+		return errData
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	// wake up the clients reading key once the lock is released
+	defer db.signalKeyReady(key)
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	stream, errData := getStream(db, key)
+	if errData != nil {
+		return errData
+	}
+	if stream == nil && args.noMkStream {
+		return data.MakeBulkData(nil)
+	}
+	var last StreamID
+	if stream != nil {
+		last = stream.LastID()
+	}
+	id, errData := nextStreamID(last, cmd[args.idIndex])
+	if errData != nil {
+		return errData
+	}
+	if stream == nil {
+		stream = NewStream()
+		db.setKey(key, stream)
+	}
+	stream.Add(id, cmd[args.idIndex+1:])
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyStream, "xadd", key)
+	if args.trim != nil && args.trim.apply(stream) > 0 {
+		db.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+	return data.MakeBulkData([]byte(id.String()))
+}
+
+// xLenStream return the number of entries of a stream
+// XLEN key
+func xLenStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "xlen" {
+		log.Printf("xLenStream Function: cmdName is not xlen")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	stream, errData := getStream(db, key)
+	if errData != nil {
+		return errData
+	}
+	if stream == nil {
+		return data.MakeIntData(0)
+	}
+	return data.MakeIntData(int64(stream.Len()))
+}
+
+// xRangeStream return the entries whose ids are in a range, XREVRANGE returns them from the end
+// XRANGE key start end [COUNT count]
+// XREVRANGE key end start [COUNT count]
+func xRangeStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "xrange" && cmdName != "xrevrange" {
+		log.Printf("xRangeStream Function: cmdName is not xrange or xrevrange")
+		return data.MakeErrorData("server error")
+	}
+	rev := cmdName == "xrevrange"
+	startArg, endArg := cmd[2], cmd[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errData := parseRangeID(startArg, true)
+	if errData != nil {
+		return errData
+	}
+	end, errData := parseRangeID(endArg, false)
+	if errData != nil {
+		return errData
+	}
+	count := -1
+	if len(cmd) > 4 {
+		if len(cmd) != 6 || strings.ToLower(string(cmd[4])) != "count" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		var err error
+		if count, err = strconv.Atoi(string(cmd[5])); err != nil {
+			return data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeEmptyArrayData()
+	}
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	stream, errData := getStream(db, key)
+	if errData != nil {
+		return errData
+	}
+	if stream == nil {
+		return data.MakeEmptyArrayData()
+	}
+	if count == 0 {
+		return data.MakeEmptyArrayData()
+	}
+	entries := make([]*StreamEntry, 0)
+	stream.Range(start, end, rev, func(entry *StreamEntry) bool {
+		entries = append(entries, entry)
+		return count < 0 || len(entries) < count
+	})
+	return makeEntriesReply(entries)
+}
+
+// xDelStream delete entries of a stream and return how many existed
+// XDEL key id [id ...]
+func xDelStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "xdel" {
+		log.Printf("xDelStream Function: cmdName is not xdel")
+		return data.MakeErrorData("server error")
+	}
+	ids := make([]StreamID, 0, len(cmd)-2)
+	for _, arg := range cmd[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return errInvalidStreamID
+		}
+		ids = append(ids, id)
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	stream, errData := getStream(db, key)
+	if errData != nil {
+		return errData
+	}
+	if stream == nil {
+		return data.MakeIntData(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if stream.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyStream, "xdel", key)
+	}
+	return data.MakeIntData(int64(deleted))
+}
+
+// xTrimStream remove the oldest entries of a stream and return how many were removed
+// XTRIM key MAXLEN | MINID [= | ~] threshold [LIMIT count]
+func xTrimStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "xtrim" {
+		log.Printf("xTrimStream Function: cmdName is not xtrim")
+		return data.MakeErrorData("server error")
+	}
+	opt := strings.ToLower(string(cmd[2]))
+	if opt != "maxlen" && opt != "minid" {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	trim, next, errData := parseStreamTrim(cmd, 2)
+	if errData != nil {
+		return errData
+	}
+	if next != len(cmd) {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	stream, errData := getStream(db, key)
+	if errData != nil {
+		return errData
+	}
+	if stream == nil {
+		return data.MakeIntData(0)
+	}
+	removed := trim.apply(stream)
+	if removed > 0 {
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+	return data.MakeIntData(int64(removed))
+}
+
+// xSetIDStream change the last id of a stream, it is used to rebuild a stream whose last entries were deleted
+// XSETID key last-id
+func xSetIDStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "xsetid" {
+		log.Printf("xSetIDStream Function: cmdName is not xsetid")
+		return data.MakeErrorData("server error")
+	}
+	id, ok := parseStreamID(cmd[2], 0)
+	if !ok {
+		return errInvalidStreamID
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	stream, errData := getStream(db, key)
+	if errData != nil {
+		return errData
+	}
+	if stream == nil {
+		return data.MakeErrorData("ERR no such key")
+	}
+	if last := stream.Last(); last != nil && id.Less(last.ID) {
+		return data.MakeErrorData("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	stream.SetLastID(id)
+	db.touchKey(key)
+	db.notifyKeyspaceEvent(notifyStream, "xsetid", key)
+	return data.MakeStringData("OK")
+}
+
+// xReadKeyPositions return the positions of the keys of XREAD, the first half of the arguments after STREAMS
+func xReadKeyPositions(cmd [][]byte) []int {
+	for i := 1; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "count", "block":
+			i++
+		case "streams":
+			n := (len(cmd) - i - 1) / 2
+			res := make([]int, 0, n)
+			for j := i + 1; j <= i+n; j++ {
+				res = append(res, j)
+			}
+			return res
+		}
+	}
+	return nil
+}
+
+// xReadReply reply the entries read from each stream, a map for RESP3 and [key, entries] pairs for RESP2 as redis does
+func xReadReply(conn net.Conn, keys []string, entries []data.RedisData) data.RedisData {
+	if protocolOf(conn) == data.RESP3 {
+		res := data.MakeMapData()
+		for i, key := range keys {
+			res.Add(data.MakeBulkData([]byte(key)), entries[i])
+		}
+		return res
+	}
+	res := make([]data.RedisData, 0, len(keys))
+	for i, key := range keys {
+		res = append(res, data.MakeArrayData([]data.RedisData{data.MakeBulkData([]byte(key)), entries[i]}))
+	}
+	return data.MakeArrayData(res)
+}
+
+// readStream return the entries of the stream in key whose ids are greater than after, at most count of them
+// unless count is 0. The lock of key should be held.
+func readStream(db *DB, key string, after StreamID, count int) []*StreamEntry {
+	stream, _ := getStream(db, key)
+	if stream == nil {
+		return nil
+	}
+	start, ok := after.Next()
+	if !ok {
+		return nil
+	}
+	entries := make([]*StreamEntry, 0)
+	stream.Range(start, maxStreamID, false, func(entry *StreamEntry) bool {
+		entries = append(entries, entry)
+		return count == 0 || len(entries) < count
+	})
+	return entries
+}
+
+// xReadStream return the entries of streams with ids greater than the given ones, $ is the last id of a stream
+// With BLOCK the client waits at most timeout milliseconds for an entry when there is none, 0 waits forever.
+// The reply gives the entries of each stream having some, see xReadReply.
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func xReadStream(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "xread" {
+		log.Printf("xReadStream Function: cmdName is not xread")
+		return data.MakeErrorData("server error")
+	}
+	count := 0
+	block := false
+	var timeout time.Duration
+	streamsIndex := -1
+	for i := 1; i < len(cmd) && streamsIndex < 0; i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "count":
+			if i+1 >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			n, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil {
+				return data.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			count = max(n, 0)
+			i++
+		case "block":
+			if i+1 >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			ms, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return data.MakeErrorData("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return data.MakeErrorData("ERR timeout is negative")
+			}
+			block, timeout = true, time.Duration(ms)*time.Millisecond
+			i++
+		case "streams":
+			streamsIndex = i + 1
+		default:
+			return data.MakeErrorData("ERR syntax error")
+		}
+	}
+	if streamsIndex < 0 || streamsIndex == len(cmd) {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	if (len(cmd)-streamsIndex)%2 != 0 {
+		return data.MakeErrorData("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+
+	n := (len(cmd) - streamsIndex) / 2
+	keys := make([]string, 0, n)
+	after := make(map[string]StreamID, n)
+	for i := 0; i < n; i++ {
+		key := string(cmd[streamsIndex+i])
+		arg := cmd[streamsIndex+n+i]
+		db.CheckTTL(key)
+		db.locks.RLock(key)
+		stream, errData := getStream(db, key)
+		db.locks.RUnLock(key)
+		if errData != nil {
+			return errData
+		}
+		var id StreamID
+		if string(arg) == "$" {
+			if stream != nil {
+				id = stream.LastID()
+			}
+		} else {
+			var ok bool
+			if id, ok = parseStreamID(arg, 0); !ok {
+				return errInvalidStreamID
+			}
+		}
+		// a key given twice is read from its first id
+		if _, ok := after[key]; !ok {
+			keys = append(keys, key)
+			after[key] = id
+		}
+	}
+
+	readKeys := make([]string, 0)
+	readEntries := make([]data.RedisData, 0)
+	for _, key := range keys {
+		db.locks.RLock(key)
+		if entries := readStream(db, key, after[key], count); len(entries) > 0 {
+			readKeys = append(readKeys, key)
+			readEntries = append(readEntries, makeEntriesReply(entries))
+		}
+		db.locks.RUnLock(key)
+	}
+	if len(readKeys) > 0 {
+		return xReadReply(conn, readKeys, readEntries)
+	}
+	if !block {
+		return data.MakeArrayData(nil)
+	}
+
+	reply, ok := db.blockRead(ctx, keys, timeout, func(db *DB, key string) (data.RedisData, bool) {
+		entries := readStream(db, key, after[key], count)
+		if len(entries) == 0 {
+			return nil, false
+		}
+		return xReadReply(conn, []string{key}, []data.RedisData{makeEntriesReply(entries)}), true
+	})
+	if !ok {
+		return data.MakeArrayData(nil)
+	}
+	return reply
+}
+
+func RegisterStreamCommands() {
+	RegisterCommand("xadd", xAddStream, -5, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("xlen", xLenStream, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("xrange", xRangeStream, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("xrevrange", xRangeStream, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("xdel", xDelStream, -3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("xtrim", xTrimStream, -4, cmdWrite, 1, 1, 1)
+	RegisterCommand("xsetid", xSetIDStream, 3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("xread", xReadStream, -4, cmdReadonly|cmdBlocking|cmdMovableKeys, 0, 0, 0)
+	registerKeysFunc("xread", xReadKeyPositions)
+}
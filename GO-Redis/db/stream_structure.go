@@ -0,0 +1,247 @@
+package db
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// StreamID is the id of a stream entry, written as <ms>-<seq>
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// maxStreamID is the greatest id, no entry can be added after it
+var maxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less report whether id is before other
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Next return the id right after id, false if id is the greatest one
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev return the id right before id, false if id is 0-0
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry is an entry of a stream, Fields holds its field value pairs in order
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// streamNodeMaxEntries is the max number of entries of a node, as stream-node-max-entries of redis
+const streamNodeMaxEntries = 100
+
+// streamNode is a run of entries sorted by id
+type streamNode struct {
+	entries []*StreamEntry
+}
+
+func (n *streamNode) first() StreamID {
+	return n.entries[0].ID
+}
+
+func (n *streamNode) last() StreamID {
+	return n.entries[len(n.entries)-1].ID
+}
+
+// Stream is an append only log of entries ordered by their ids
+// The entries are kept in nodes of at most streamNodeMaxEntries entries, which play the part of the listpacks in
+// the radix tree of redis: an id is found by a binary search of its node and then of its entry, the oldest entries
+// are trimmed node by node and the appends only touch the last node.
+type Stream struct {
+	nodes  []*streamNode
+	length int
+	// lastID is the id of the last entry ever added, the next entry must have a greater id
+	lastID StreamID
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		nodes: make([]*streamNode, 0),
+	}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+// SetLastID change the id the next entries must be greater than
+func (s *Stream) SetLastID(id StreamID) {
+	s.lastID = id
+}
+
+// First return the oldest entry, nil if the stream is empty
+func (s *Stream) First() *StreamEntry {
+	if len(s.nodes) == 0 {
+		return nil
+	}
+	return s.nodes[0].entries[0]
+}
+
+// Last return the newest entry, nil if the stream is empty
+func (s *Stream) Last() *StreamEntry {
+	if len(s.nodes) == 0 {
+		return nil
+	}
+	n := s.nodes[len(s.nodes)-1]
+	return n.entries[len(n.entries)-1]
+}
+
+// Add append an entry, id should be greater than LastID
+func (s *Stream) Add(id StreamID, fields [][]byte) {
+	entry := &StreamEntry{ID: id, Fields: fields}
+	if len(s.nodes) == 0 || len(s.nodes[len(s.nodes)-1].entries) >= streamNodeMaxEntries {
+		s.nodes = append(s.nodes, &streamNode{entries: make([]*StreamEntry, 0, 1)})
+	}
+	n := s.nodes[len(s.nodes)-1]
+	n.entries = append(n.entries, entry)
+	s.length++
+	s.lastID = id
+}
+
+// seek return the position of the first entry whose id is not less than id, the node index is len(nodes) if
+// there is none
+func (s *Stream) seek(id StreamID) (int, int) {
+	i := sort.Search(len(s.nodes), func(i int) bool {
+		return !s.nodes[i].last().Less(id)
+	})
+	if i == len(s.nodes) {
+		return i, 0
+	}
+	entries := s.nodes[i].entries
+	j := sort.Search(len(entries), func(j int) bool {
+		return !entries[j].ID.Less(id)
+	})
+	return i, j
+}
+
+// Delete remove the entry of id, return true if it existed
+// LastID is not changed, so the ids of the deleted entries are never given again
+func (s *Stream) Delete(id StreamID) bool {
+	i, j := s.seek(id)
+	if i == len(s.nodes) || s.nodes[i].entries[j].ID != id {
+		return false
+	}
+	n := s.nodes[i]
+	n.entries = append(n.entries[:j], n.entries[j+1:]...)
+	if len(n.entries) == 0 {
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+	}
+	s.length--
+	return true
+}
+
+// Range call fn on the entries whose ids are in [start, end] in order, or in reverse order if rev is true
+// It stops once fn returns false.
+func (s *Stream) Range(start, end StreamID, rev bool, fn func(entry *StreamEntry) bool) {
+	if end.Less(start) {
+		return
+	}
+	if !rev {
+		for i, j := s.seek(start); i < len(s.nodes); i, j = i+1, 0 {
+			for _, entry := range s.nodes[i].entries[j:] {
+				if end.Less(entry.ID) || !fn(entry) {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	// from the last entry not greater than end
+	i, j := len(s.nodes)-1, -1
+	if next, ok := end.Next(); ok {
+		i, j = s.seek(next)
+		if i == len(s.nodes) || j == 0 {
+			i, j = i-1, -1
+		} else {
+			j--
+		}
+	}
+	for ; i >= 0; i, j = i-1, -1 {
+		entries := s.nodes[i].entries
+		if j < 0 {
+			j = len(entries) - 1
+		}
+		for ; j >= 0; j-- {
+			if entries[j].ID.Less(start) || !fn(entries[j]) {
+				return
+			}
+		}
+	}
+}
+
+// TrimMaxLen remove the oldest entries until the stream has at most maxLen entries, and return how many were
+// removed. An approximated trim only removes whole nodes, so more entries may be kept, and at most limit entries
+// when limit is positive.
+func (s *Stream) TrimMaxLen(maxLen int, approx bool, limit int) int {
+	return s.trim(approx, limit, func(count int, last StreamID) bool {
+		return s.length-count >= maxLen
+	})
+}
+
+// TrimMinID remove the entries whose ids are less than minID and return how many were removed
+// approx and limit are the same as TrimMaxLen.
+func (s *Stream) TrimMinID(minID StreamID, approx bool, limit int) int {
+	return s.trim(approx, limit, func(count int, last StreamID) bool {
+		return last.Less(minID)
+	})
+}
+
+// trim remove the oldest entries as long as removable allows to remove the count next entries, the last of them
+// having the id last
+func (s *Stream) trim(approx bool, limit int, removable func(count int, last StreamID) bool) int {
+	removed := 0
+	for len(s.nodes) > 0 {
+		n := s.nodes[0]
+		if approx {
+			if (limit > 0 && removed+len(n.entries) > limit) || !removable(len(n.entries), n.last()) {
+				break
+			}
+			s.nodes = s.nodes[1:]
+			s.length -= len(n.entries)
+			removed += len(n.entries)
+			continue
+		}
+		j := 0
+		for j < len(n.entries) && removable(1, n.entries[j].ID) {
+			s.length--
+			removed++
+			j++
+		}
+		if j < len(n.entries) {
+			n.entries = n.entries[j:]
+			break
+		}
+		s.nodes = s.nodes[1:]
+	}
+	return removed
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNextStreamID(t *testing.T) {
+	const (
+		errSmaller   = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+		errZero      = "ERR The ID specified in XADD must be greater than 0-0"
+		errExhausted = "ERR The stream has exhausted the last possible ID, unable to add more items"
+		errInvalid   = "ERR Invalid stream ID specified as stream command argument"
+	)
+	future := uint64(time.Now().Add(time.Hour).UnixMilli())
+	tests := []struct {
+		name string
+		last StreamID
+		arg  string
+		want StreamID
+		err  string
+	}{
+		{"explicit", StreamID{}, "5-3", StreamID{Ms: 5, Seq: 3}, ""},
+		{"explicit without seq", StreamID{}, "5", StreamID{Ms: 5}, ""},
+		{"explicit greater seq", StreamID{Ms: 5, Seq: 3}, "5-4", StreamID{Ms: 5, Seq: 4}, ""},
+		{"explicit equal", StreamID{Ms: 5, Seq: 3}, "5-3", StreamID{}, errSmaller},
+		{"explicit smaller", StreamID{Ms: 5, Seq: 3}, "4-9", StreamID{}, errSmaller},
+		{"explicit 0-0", StreamID{}, "0-0", StreamID{}, errZero},
+		{"explicit max", StreamID{Ms: 5}, "18446744073709551615-18446744073709551615", maxStreamID, ""},
+		{"auto seq on a new ms", StreamID{Ms: 5, Seq: 3}, "6-*", StreamID{Ms: 6}, ""},
+		{"auto seq on the last ms", StreamID{Ms: 5, Seq: 3}, "5-*", StreamID{Ms: 5, Seq: 4}, ""},
+		{"auto seq on ms 0", StreamID{}, "0-*", StreamID{Seq: 1}, ""},
+		{"auto seq on a smaller ms", StreamID{Ms: 5, Seq: 3}, "4-*", StreamID{}, errSmaller},
+		{"auto seq exhausted", StreamID{Ms: 5, Seq: math.MaxUint64}, "5-*", StreamID{}, errSmaller},
+		{"auto seq invalid ms", StreamID{}, "x-*", StreamID{}, errInvalid},
+		{"auto with the last id in the future", StreamID{Ms: future, Seq: 7}, "*", StreamID{Ms: future, Seq: 8}, ""},
+		{"auto with the last seq exhausted", StreamID{Ms: future, Seq: math.MaxUint64}, "*", StreamID{Ms: future + 1}, ""},
+		{"auto exhausted", maxStreamID, "*", StreamID{}, errExhausted},
+		{"invalid", StreamID{}, "5-x", StreamID{}, errInvalid},
+		{"negative", StreamID{}, "-1", StreamID{}, errInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errReply := nextStreamID(tt.last, []byte(tt.arg))
+			if tt.err != "" {
+				if errReply == nil || string(errReply.ToBytes()) != "-"+tt.err+"\r\n" {
+					t.Fatalf("nextStreamID(%v, %q) error = %v, want %q", tt.last, tt.arg, errReply, tt.err)
+				}
+				return
+			}
+			if errReply != nil {
+				t.Fatalf("nextStreamID(%v, %q) error = %q", tt.last, tt.arg, errReply.ToBytes())
+			}
+			if got != tt.want {
+				t.Fatalf("nextStreamID(%v, %q) = %v, want %v", tt.last, tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextStreamIDNow(t *testing.T) {
+	// * takes the current time with seq 0 once the clock is past the last id
+	before := uint64(time.Now().UnixMilli())
+	got, errReply := nextStreamID(StreamID{Ms: 1, Seq: 5}, []byte("*"))
+	after := uint64(time.Now().UnixMilli())
+	if errReply != nil {
+		t.Fatalf("nextStreamID() error = %q", errReply.ToBytes())
+	}
+	if got.Ms < before || got.Ms > after || got.Seq != 0 {
+		t.Fatalf("nextStreamID() = %v, want an id between %d-0 and %d-0", got, before, after)
+	}
+}
+
+func TestStreamIDNextPrev(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     StreamID
+		next   StreamID
+		nextOk bool
+		prev   StreamID
+		prevOk bool
+	}{
+		{"zero", StreamID{}, StreamID{Seq: 1}, true, StreamID{}, false},
+		{"middle", StreamID{Ms: 5, Seq: 3}, StreamID{Ms: 5, Seq: 4}, true, StreamID{Ms: 5, Seq: 2}, true},
+		{"carry", StreamID{Ms: 5, Seq: math.MaxUint64}, StreamID{Ms: 6}, true, StreamID{Ms: 5, Seq: math.MaxUint64 - 1}, true},
+		{"borrow", StreamID{Ms: 5}, StreamID{Ms: 5, Seq: 1}, true, StreamID{Ms: 4, Seq: math.MaxUint64}, true},
+		{"max", maxStreamID, maxStreamID, false, StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64 - 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if next, ok := tt.id.Next(); next != tt.next || ok != tt.nextOk {
+				t.Fatalf("%v.Next() = %v, %v, want %v, %v", tt.id, next, ok, tt.next, tt.nextOk)
+			}
+			if prev, ok := tt.id.Prev(); prev != tt.prev || ok != tt.prevOk {
+				t.Fatalf("%v.Prev() = %v, %v, want %v, %v", tt.id, prev, ok, tt.prev, tt.prevOk)
+			}
+		})
+	}
+}
+
+func TestParseRangeID(t *testing.T) {
+	tests := []struct {
+		name  string
+		arg   string
+		start bool
+		want  StreamID
+		err   bool
+	}{
+		{"minus", "-", true, StreamID{}, false},
+		{"plus", "+", false, maxStreamID, false},
+		{"start without seq", "5", true, StreamID{Ms: 5}, false},
+		{"end without seq", "5", false, StreamID{Ms: 5, Seq: math.MaxUint64}, false},
+		{"exclusive start", "(5-3", true, StreamID{Ms: 5, Seq: 4}, false},
+		{"exclusive end", "(5-3", false, StreamID{Ms: 5, Seq: 2}, false},
+		{"exclusive start at max", "(18446744073709551615-18446744073709551615", true, StreamID{}, true},
+		{"exclusive end at zero", "(0-0", false, StreamID{}, true},
+		{"invalid", "5-x", true, StreamID{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errReply := parseRangeID([]byte(tt.arg), tt.start)
+			if (errReply != nil) != tt.err {
+				t.Fatalf("parseRangeID(%q, %v) error = %v, want error %v", tt.arg, tt.start, errReply, tt.err)
+			}
+			if !tt.err && got != tt.want {
+				t.Fatalf("parseRangeID(%q, %v) = %v, want %v", tt.arg, tt.start, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXRangeCount(t *testing.T) {
+	db := NewDatabases(1).Get(0)
+	execString(db, "xadd", "s", "1-1", "f", "a")
+	execString(db, "xadd", "s", "2-1", "f", "b")
+	tests := []struct {
+		name string
+		cmd  []string
+		want string
+	}{
+		{"xrange count 0", []string{"xrange", "s", "-", "+", "count", "0"}, "*0\r\n"},
+		{"xrevrange count 0", []string{"xrevrange", "s", "+", "-", "count", "0"}, "*0\r\n"},
+		{"negative count", []string{"xrange", "s", "-", "+", "count", "-1"}, "*0\r\n"},
+		{"xrange count 1", []string{"xrange", "s", "-", "+", "count", "1"}, "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\na\r\n"},
+		{"xrevrange count 1", []string{"xrevrange", "s", "+", "-", "count", "1"}, "*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$1\r\nb\r\n"},
+		{"missing key", []string{"xrange", "missing", "-", "+", "count", "0"}, "*0\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := execString(db, tt.cmd...); got != tt.want {
+				t.Fatalf("%q = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
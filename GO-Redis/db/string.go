@@ -2,6 +2,7 @@ package db
 
 import (
 	"GO-Redis/data"
+	"bytes"
 	"context"
 	"log"
 	"net"
@@ -11,16 +12,15 @@ import (
 )
 
 func setString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
-	cmdKey := strings.ToLower(string(cmd[1]))
-	if len(cmd) < 3 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
+	cmdKey := string(cmd[1])
 
 	// check option params
 	var err error
-	var nx, xx, get, ex, px, keepttl, exat bool
+	var nx, xx, get, ex, px, keepttl, exat, ifEq, ifVer bool
 	var exval, exatval int64
 	var millisecPx int64
+	var eqVal []byte
+	var version int64
 
 	// parse flags
 	for i := 3; i < len(cmd); i++ {
@@ -39,7 +39,7 @@ func setString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 			if i >= len(cmd) {
 				return data.MakeErrorData("error: commands is invalid")
 			}
-			exatval, err = strconv.ParseInt(string(cmd[1]), 10, 64)
+			exatval, err = strconv.ParseInt(string(cmd[i]), 10, 64)
 			if err != nil {
 				return data.MakeErrorData("ERROR value is not integer or out of range")
 			}
@@ -60,6 +60,23 @@ func setString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 			if err != nil {
 				return data.MakeErrorData("ERROR value is not integer or out of range")
 			}
+		case "ifeq": // The operation sets the key only if it holds the given value
+			ifEq = true
+			i++
+			if i >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			eqVal = cmd[i]
+		case "ifver": // The operation sets the key only if its version is the given one, 0 if it does not exist
+			ifVer = true
+			i++
+			if i >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			var errRes data.RedisData
+			if version, errRes = parseVersion(cmd[i]); errRes != nil {
+				return errRes
+			}
 		default:
 			return data.MakeErrorData("Error unsupported option: " + string(cmd[i]))
 		}
@@ -68,6 +85,9 @@ func setString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	if (nx && xx) || (ex && keepttl) || (ex && px) || (ex && exat) || (px && exat) {
 		return data.MakeErrorData("error: commands is invalid")
 	}
+	if (ifEq || ifVer) && (nx || xx || get || (ifEq && ifVer)) {
+		return data.MakeErrorData("ERR syntax error")
+	}
 
 	// will very likely change value, lock the db methods for atomic manipulations
 	db.locks.Lock(cmdKey)
@@ -87,27 +107,42 @@ func setString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 		}
 	}
 
+	// nothing is done if the value or the version has changed since the client read it
+	if ifEq && (!oldOK || !bytes.Equal(oldTypeVal, eqVal)) {
+		return data.MakeBulkData(nil)
+	}
+	if ifVer && db.keyVersion(cmdKey) != version {
+		return data.MakeBulkData(nil)
+	}
+
 	// set key and check if it satisfies nx or xx condition
 	// return the set result if the get command is not given
+	written := false
 	if nx || xx {
 		if nx {
 			if !oldOK {
-				db.db.Set(cmdKey, cmd[2])
+				db.setKey(cmdKey, cmd[2])
+				written = true
 			} else {
 				res = data.MakeBulkData(nil)
 			}
 		} else {
 			if oldOK {
-				db.db.Set(cmdKey, cmd[2])
+				db.setKey(cmdKey, cmd[2])
+				written = true
 				res = data.MakeStringData("OK")
 			} else {
 				res = data.MakeBulkData(nil)
 			}
 		}
 	} else {
-		db.db.Set(cmdKey, cmd[2])
+		db.setKey(cmdKey, cmd[2])
+		written = true
 		res = data.MakeStringData("OK")
 	}
+	if written {
+		db.notifyKeyspaceEvent(notifyString, "set", cmdKey)
+	}
 
 	// If a get command offered, return GET result
 	if get {
@@ -133,6 +168,9 @@ func setString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	if exat {
 		db.SetTTL(cmdKey, exatval)
 	}
+	if written && (ex || px || exat) {
+		db.notifyKeyspaceEvent(notifyGeneric, "expire", cmdKey)
+	}
 
 	return res
 }
@@ -144,10 +182,6 @@ func getString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 2 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	db.locks.RLock(key)
@@ -166,6 +200,62 @@ func getString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.Re
 	return data.MakeBulkData(byteVal)
 }
 
+// getVString return the value of a string key with its version, nil if the key does not exist
+// GETV key
+func getVString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "getv" {
+		log.Printf("getVString func: cmdName != getv")
+		return data.MakeErrorData("Server Error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeBulkData(nil)
+	}
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	val, ok := db.db.Get(key)
+	if !ok {
+		return data.MakeBulkData(nil)
+	}
+	byteVal, ok := val.([]byte)
+	if !ok {
+		return data.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return data.MakeArrayData([]data.RedisData{data.MakeBulkData(byteVal), data.MakeIntData(db.keyVersion(key))})
+}
+
+// delIfEqString delete a string key only if it holds value, it returns 1 if the key is deleted
+// DELIFEQ key value
+func delIfEqString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "delifeq" {
+		log.Printf("delIfEqString func: cmdName != delifeq")
+		return data.MakeErrorData("Server Error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	val, ok := db.db.Get(key)
+	if !ok {
+		return data.MakeIntData(0)
+	}
+	byteVal, ok := val.([]byte)
+	if !ok {
+		return data.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if !bytes.Equal(byteVal, cmd[2]) {
+		return data.MakeIntData(0)
+	}
+	db.removeKey(key)
+	db.DeleteTTL(key)
+	db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	return data.MakeIntData(1)
+}
+
 // getRangeString Get the substring of the string stored in the specified key.
 // The intercept range of the string is determined by the start and end offsets (including start and end).
 func getRangeString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
@@ -174,10 +264,6 @@ func getRangeString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) da
 		return data.MakeErrorData("Server error")
 	}
 
-	if len(cmd) != 4 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	db.locks.RLock(key)
@@ -231,10 +317,6 @@ func setRangeString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) da
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 4 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	offset, err := strconv.Atoi(string(cmd[2]))
 	if err != nil || offset < 0 {
 		return data.MakeErrorData("error: offset is not a integer or less than 0")
@@ -269,7 +351,8 @@ func setRangeString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) da
 		newVal = append(newVal, cmd[3]...)
 	}
 
-	db.db.Set(key, newVal)
+	db.setKey(key, newVal)
+	db.notifyKeyspaceEvent(notifyString, "setrange", key)
 
 	return data.MakeIntData(int64(len(newVal)))
 }
@@ -281,10 +364,6 @@ func mGetString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) < 2 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	res := make([]data.RedisData, 0)
 
 	for i := 1; i < len(cmd); i++ {
@@ -316,8 +395,8 @@ func mSetString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) < 3 || len(cmd)&1 != 1 {
-		return data.MakeErrorData("error: commands is invalid")
+	if len(cmd)&1 != 1 {
+		return data.MakeWrongNumberArgs("mset")
 	}
 
 	keys := make([]string, 0)
@@ -334,7 +413,8 @@ func mSetString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 
 	for i := 0; i < len(keys); i++ {
 		db.DeleteTTL(keys[i])
-		db.db.Set(keys[i], vals[i])
+		db.setKey(keys[i], vals[i])
+		db.notifyKeyspaceEvent(notifyString, "set", keys[i])
 	}
 
 	return data.MakeStringData("OK")
@@ -347,10 +427,6 @@ func setExString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 4 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	ex, err := strconv.ParseInt(string(cmd[2]), 10, 64)
 	if err != nil {
 		return data.MakeErrorData("error: commands is invalid")
@@ -363,8 +439,10 @@ func setExString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.
 	db.locks.Lock(key)
 	defer db.locks.UnLock(key)
 
-	db.db.Set(key, val)
+	db.setKey(key, val)
 	db.SetTTL(key, ttl)
+	db.notifyKeyspaceEvent(notifyString, "set", key)
+	db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
 
 	return data.MakeStringData("OK")
 }
@@ -376,10 +454,6 @@ func setNxString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 3 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 	val := cmd[2]
 
@@ -387,6 +461,11 @@ func setNxString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.
 	defer db.locks.UnLock(key)
 
 	res := db.db.SetIfNotExist(key, val)
+	if res == 1 {
+		db.touchKey(key)
+		db.notifyKeyspaceEvent(notifyNew, "new", key)
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+	}
 
 	return data.MakeIntData(int64(res))
 }
@@ -398,10 +477,6 @@ func strLenString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 2 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	db.locks.RLock(key)
@@ -428,10 +503,6 @@ func incrString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 2 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	db.locks.Lock(key)
@@ -439,7 +510,8 @@ func incrString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 
 	val, ok := db.db.Get(key)
 	if !ok {
-		db.db.Set(key, []byte("1"))
+		db.setKey(key, []byte("1"))
+		db.notifyKeyspaceEvent(notifyString, "incrby", key)
 		return data.MakeIntData(1)
 	}
 
@@ -455,7 +527,8 @@ func incrString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 
 	intVal++
 
-	db.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.setKey(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.notifyKeyspaceEvent(notifyString, "incrby", key)
 
 	return data.MakeIntData(intVal)
 }
@@ -468,10 +541,6 @@ func incrByString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 3 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	incr, err := strconv.ParseInt(string(cmd[2]), 10, 64)
@@ -484,7 +553,8 @@ func incrByString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 
 	val, ok := db.db.Get(key)
 	if !ok {
-		db.db.Set(key, []byte(strconv.FormatInt(incr, 10)))
+		db.setKey(key, []byte(strconv.FormatInt(incr, 10)))
+		db.notifyKeyspaceEvent(notifyString, "incrby", key)
 		return data.MakeIntData(incr)
 	}
 
@@ -500,7 +570,8 @@ func incrByString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 
 	intVal += incr
 
-	db.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.setKey(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.notifyKeyspaceEvent(notifyString, "incrby", key)
 
 	return data.MakeIntData(intVal)
 }
@@ -513,10 +584,6 @@ func decrString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 2 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	db.locks.Lock(key)
@@ -524,7 +591,8 @@ func decrString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 
 	val, ok := db.db.Get(key)
 	if !ok {
-		db.db.Set(key, []byte("-1"))
+		db.setKey(key, []byte("-1"))
+		db.notifyKeyspaceEvent(notifyString, "incrby", key)
 		return data.MakeIntData(-1)
 	}
 
@@ -540,7 +608,8 @@ func decrString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.R
 
 	intVal--
 
-	db.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.setKey(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.notifyKeyspaceEvent(notifyString, "incrby", key)
 
 	return data.MakeIntData(intVal)
 }
@@ -553,10 +622,6 @@ func decrByString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 3 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	dec, err := strconv.ParseInt(string(cmd[2]), 10, 64)
@@ -569,7 +634,8 @@ func decrByString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 
 	val, ok := db.db.Get(key)
 	if !ok {
-		db.db.Set(key, []byte(strconv.FormatInt(-dec, 10)))
+		db.setKey(key, []byte(strconv.FormatInt(-dec, 10)))
+		db.notifyKeyspaceEvent(notifyString, "incrby", key)
 		return data.MakeIntData(-dec)
 	}
 
@@ -585,7 +651,8 @@ func decrByString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 
 	intVal -= dec
 
-	db.db.Set(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.setKey(key, []byte(strconv.FormatInt(intVal, 10)))
+	db.notifyKeyspaceEvent(notifyString, "incrby", key)
 
 	return data.MakeIntData(intVal)
 }
@@ -596,10 +663,6 @@ func incrByFloatString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn)
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 3 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 
 	inc, err := strconv.ParseFloat(string(cmd[2]), 64)
@@ -612,7 +675,8 @@ func incrByFloatString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn)
 
 	val, ok := db.db.Get(key)
 	if !ok {
-		db.db.Set(key, []byte(strconv.FormatFloat(inc, 'f', -1, 64)))
+		db.setKey(key, []byte(strconv.FormatFloat(inc, 'f', -1, 64)))
+		db.notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
 		return data.MakeBulkData([]byte(strconv.FormatFloat(inc, 'f', -1, 64)))
 	}
 
@@ -628,7 +692,8 @@ func incrByFloatString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn)
 
 	floatVal += inc
 
-	db.db.Set(key, []byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
+	db.setKey(key, []byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
+	db.notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
 
 	return data.MakeBulkData([]byte(strconv.FormatFloat(floatVal, 'f', -1, 64)))
 }
@@ -639,10 +704,6 @@ func appendString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 		return data.MakeErrorData("Server Error")
 	}
 
-	if len(cmd) != 3 {
-		return data.MakeErrorData("error: commands is invalid")
-	}
-
 	key := string(cmd[1])
 	val := cmd[2]
 
@@ -651,7 +712,8 @@ func appendString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 
 	oldVal, ok := db.db.Get(key)
 	if !ok {
-		db.db.Set(key, val)
+		db.setKey(key, val)
+		db.notifyKeyspaceEvent(notifyString, "append", key)
 		return data.MakeIntData(int64(len(val)))
 	}
 
@@ -661,25 +723,28 @@ func appendString(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data
 	}
 
 	newVal := append(typeVal, val...)
-	db.db.Set(key, newVal)
+	db.setKey(key, newVal)
+	db.notifyKeyspaceEvent(notifyString, "append", key)
 
 	return data.MakeIntData(int64(len(newVal)))
 }
 
 func RegisterStringCommands() {
-	RegisterCommand("set", setString)
-	RegisterCommand("get", getString)
-	RegisterCommand("getrange", getRangeString)
-	RegisterCommand("setrange", setRangeString)
-	RegisterCommand("mget", mGetString)
-	RegisterCommand("mset", mSetString)
-	RegisterCommand("setex", setExString)
-	RegisterCommand("setnx", setNxString)
-	RegisterCommand("strlen", strLenString)
-	RegisterCommand("incr", incrString)
-	RegisterCommand("incrby", incrByString)
-	RegisterCommand("decr", decrString)
-	RegisterCommand("decrby", decrByString)
-	RegisterCommand("incrbyfloat", incrByFloatString)
-	RegisterCommand("append", appendString)
+	RegisterCommand("set", setString, -3, cmdWrite|cmdDenyOOM, 1, 1, 1)
+	RegisterCommand("get", getString, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("getv", getVString, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("delifeq", delIfEqString, 3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("getrange", getRangeString, 4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("setrange", setRangeString, 4, cmdWrite|cmdDenyOOM, 1, 1, 1)
+	RegisterCommand("mget", mGetString, -2, cmdReadonly|cmdFast, 1, -1, 1)
+	RegisterCommand("mset", mSetString, -3, cmdWrite|cmdDenyOOM, 1, -1, 2)
+	RegisterCommand("setex", setExString, 4, cmdWrite|cmdDenyOOM, 1, 1, 1)
+	RegisterCommand("setnx", setNxString, 3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("strlen", strLenString, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("incr", incrString, 2, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("incrby", incrByString, 3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("decr", decrString, 2, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("decrby", decrByString, 3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("incrbyfloat", incrByFloatString, 3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("append", appendString, 3, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
 }
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"strconv"
+)
+
+// Every key carries a version, which is the value of a counter shared by all dbs when the key was last written.
+// So a version is never reused, even by a key deleted and created again or moved by MOVE or SWAPDB.
+// A write command gives a new version to a key when it changes it, while it still holds the lock of the key: setKey
+// does it for the keys it stores and the commands changing a value in place call touchKey. So once a write releases
+// the lock of a key, IFVER checked under the same lock sees the version of the write, and never an older one.
+// The keys deleted by removeKey lose their version.
+// Versions are local to the server, they are neither saved nor replicated, the keys loaded from a rdb are given new ones.
+
+// nextVersion return a version greater than all the versions given before
+func (db *DB) nextVersion() int64 {
+	return db.dbs.version.Add(1)
+}
+
+// touchKey give a new version to key, which was just written
+// The caller should hold the lock of key.
+func (db *DB) touchKey(key string) {
+	if db.dbs == nil {
+		return
+	}
+	db.versions.Set(key, db.nextVersion())
+}
+
+// keyVersion return the version of key, 0 if the key does not exist
+// A key without version is given one on the first read. The caller should hold the lock of key, at least for reading.
+func (db *DB) keyVersion(key string) int64 {
+	if _, ok := db.db.Get(key); !ok {
+		return 0
+	}
+	if v, ok := db.versions.Get(key); ok {
+		return v.(int64)
+	}
+	if db.dbs == nil {
+		return 0
+	}
+	// the readers sharing the lock of key all return the version set by the first of them
+	v := db.nextVersion()
+	if db.versions.SetIfNotExist(key, v) == 0 {
+		if cur, ok := db.versions.Get(key); ok {
+			return cur.(int64)
+		}
+	}
+	return v
+}
+
+// parseVersion parse the version given to IFVER, 0 means the key does not exist
+func parseVersion(arg []byte) (int64, data.RedisData) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || v < 0 {
+		return 0, data.MakeErrorData("ERR version is not a non negative integer or out of range")
+	}
+	return v, nil
+}
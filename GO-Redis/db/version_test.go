@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// getVer return the version of key given by GETVER
+func getVer(t *testing.T, db *DB, key string) int64 {
+	t.Helper()
+	res := execString(db, "getver", key)
+	if strings.HasPrefix(res, "$-1") {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(res, ":"), "\r\n"), 10, 64)
+	if err != nil {
+		t.Fatalf("getver %s = %q", key, res)
+	}
+	return v
+}
+
+// runExecutor run the executor of the command given as arguments alone, without execCommand around it
+func runExecutor(db *DB, args ...string) {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	c, _ := lookupCommand(cmd)
+	c.Executor(context.Background(), db, cmd, nil)
+}
+
+func TestVersionChanges(t *testing.T) {
+	// the version must be changed by the executor while it holds the key lock, so that IFVER can not pass on the
+	// version of a key written by a command which has released the lock but not returned yet
+	tests := []struct {
+		name    string
+		setup   [][]string
+		cmd     []string
+		key     string
+		changed bool
+	}{
+		{"set", [][]string{{"set", "k", "a"}}, []string{"set", "k", "b"}, "k", true},
+		{"set nx on an existing key", [][]string{{"set", "k", "a"}}, []string{"set", "k", "b", "nx"}, "k", false},
+		{"set ifeq with another value", [][]string{{"set", "k", "a"}}, []string{"set", "k", "b", "ifeq", "x"}, "k", false},
+		{"setnx on an existing key", [][]string{{"set", "k", "a"}}, []string{"setnx", "k", "b"}, "k", false},
+		{"incr", [][]string{{"set", "k", "1"}}, []string{"incr", "k"}, "k", true},
+		{"expire", [][]string{{"set", "k", "a"}}, []string{"expire", "k", "100"}, "k", true},
+		{"hset", [][]string{{"hset", "h", "f", "a"}}, []string{"hset", "h", "f", "b"}, "h", true},
+		{"hsetnx on an existing field", [][]string{{"hset", "h", "f", "a"}}, []string{"hsetnx", "h", "f", "b"}, "h", false},
+		{"hdel of a missing field", [][]string{{"hset", "h", "f", "a"}}, []string{"hdel", "h", "g"}, "h", false},
+		{"lpush", [][]string{{"rpush", "l", "a"}}, []string{"lpush", "l", "b"}, "l", true},
+		{"lmove destination", [][]string{{"rpush", "l", "a", "b"}, {"rpush", "d", "c"}}, []string{"lmove", "l", "d", "left", "left"}, "d", true},
+		{"sadd of an existing member", [][]string{{"sadd", "s", "a"}}, []string{"sadd", "s", "a"}, "s", false},
+		{"srem", [][]string{{"sadd", "s", "a", "b"}}, []string{"srem", "s", "a"}, "s", true},
+		{"zadd of the same score", [][]string{{"zadd", "z", "1", "a"}}, []string{"zadd", "z", "1", "a"}, "z", false},
+		{"zincrby", [][]string{{"zadd", "z", "1", "a"}}, []string{"zincrby", "z", "1", "a"}, "z", true},
+		{"xadd", [][]string{{"xadd", "x", "1-1", "f", "a"}}, []string{"xadd", "x", "*", "f", "b"}, "x", true},
+		{"get", [][]string{{"set", "k", "a"}}, []string{"get", "k"}, "k", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDatabases(1).Get(0)
+			for _, cmd := range tt.setup {
+				execString(db, cmd...)
+			}
+			before := getVer(t, db, tt.key)
+			runExecutor(db, tt.cmd...)
+			after := getVer(t, db, tt.key)
+			if before == 0 || after == 0 {
+				t.Fatalf("versions of %s = %d and %d, want existing keys", tt.key, before, after)
+			}
+			if changed := after != before; changed != tt.changed {
+				t.Fatalf("version of %s changed = %v after %q, want %v", tt.key, changed, tt.cmd, tt.changed)
+			}
+		})
+	}
+
+	db := NewDatabases(1).Get(0)
+	execString(db, "rpush", "l", "a")
+	runExecutor(db, "lpop", "l")
+	if v := getVer(t, db, "l"); v != 0 {
+		t.Fatalf("version of a deleted key = %d, want 0", v)
+	}
+}
+
+func TestIfVerConcurrent(t *testing.T) {
+	// every client adds one to the counter with a GETV then a SET IFVER, and retries when the version changed
+	// meanwhile, so no increment is lost if IFVER never passes on the version of an older write
+	db := NewDatabases(1).Get(0)
+	execString(db, "set", "counter", "0")
+	const clients, increments = 8, 200
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < increments; {
+				// *2\r\n$<len>\r\n<value>\r\n:<version>\r\n
+				parts := strings.Split(execString(db, "getv", "counter"), "\r\n")
+				val, _ := strconv.Atoi(parts[2])
+				if execString(db, "set", "counter", strconv.Itoa(val+1), "ifver", parts[3][1:]) == "+OK\r\n" {
+					n++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := execString(db, "get", "counter"), strconv.Itoa(clients*increments); got != "$"+strconv.Itoa(len(want))+"\r\n"+want+"\r\n" {
+		t.Fatalf("counter = %q, want %s", got, want)
+	}
+}
@@ -0,0 +1,1174 @@
+package db
+
+import (
+	"GO-Redis/data"
+	"context"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getSortedSet return the sorted set stored in key, nil if key does not exist
+// the caller should hold the lock of key
+func getSortedSet(db *DB, key string) (*SortedSet, data.RedisData) {
+	tem, ok := db.db.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	zs, ok := tem.(*SortedSet)
+	if !ok {
+		return nil, data.MakeWrongType()
+	}
+	return zs, nil
+}
+
+// removeEmptySortedSet delete key when its sorted set has no member
+func removeEmptySortedSet(db *DB, key string, zs *SortedSet) {
+	if zs != nil && zs.Len() == 0 {
+		db.removeKey(key)
+		db.DeleteTTL(key)
+	}
+}
+
+// storeSortedSet replace the value of key by zs, key is deleted if zs is empty
+// the caller should hold the lock of key
+func storeSortedSet(db *DB, key string, zs *SortedSet) {
+	db.DeleteTTL(key)
+	if zs.Len() == 0 {
+		db.removeKey(key)
+		return
+	}
+	db.setKey(key, zs)
+}
+
+// parseScore parse a score, "inf", "+inf" and "-inf" are accepted but NaN is not
+func parseScore(raw []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// parseScoreRange parse the min and max of ZRANGEBYSCORE, "(" prefix means exclusive
+func parseScoreRange(min, max []byte) (*ScoreRange, data.RedisData) {
+	r := &ScoreRange{}
+	var ok bool
+	if len(min) > 0 && min[0] == '(' {
+		r.MinEx = true
+		min = min[1:]
+	}
+	if len(max) > 0 && max[0] == '(' {
+		r.MaxEx = true
+		max = max[1:]
+	}
+	if r.Min, ok = parseScore(min); !ok {
+		return nil, data.MakeErrorData("ERR min or max is not a float")
+	}
+	if r.Max, ok = parseScore(max); !ok {
+		return nil, data.MakeErrorData("ERR min or max is not a float")
+	}
+	return r, nil
+}
+
+// parseLexRange parse the min and max of ZRANGEBYLEX
+// an item is "-", "+", or starts with "[" (inclusive) or "(" (exclusive)
+func parseLexRange(min, max []byte) (*LexRange, data.RedisData) {
+	r := &LexRange{}
+	var ok bool
+	if r.Min, r.MinEx, r.MinInf, ok = parseLexItem(min); !ok {
+		return nil, data.MakeErrorData("ERR min or max not valid string range item")
+	}
+	if r.Max, r.MaxEx, r.MaxInf, ok = parseLexItem(max); !ok {
+		return nil, data.MakeErrorData("ERR min or max not valid string range item")
+	}
+	return r, nil
+}
+
+func parseLexItem(item []byte) (string, bool, int, bool) {
+	if len(item) == 0 {
+		return "", false, 0, false
+	}
+	switch item[0] {
+	case '-':
+		if len(item) != 1 {
+			return "", false, 0, false
+		}
+		return "", false, -1, true
+	case '+':
+		if len(item) != 1 {
+			return "", false, 0, false
+		}
+		return "", false, 1, true
+	case '(':
+		return string(item[1:]), true, 0, true
+	case '[':
+		return string(item[1:]), false, 0, true
+	default:
+		return "", false, 0, false
+	}
+}
+
+// makeScoreReply reply a score as a double for RESP3 clients and as a bulk string for RESP2 clients
+func makeScoreReply(score float64) data.RedisData {
+	return data.MakeDoubleData(score)
+}
+
+// makeNodesReply reply the members of nodes, with their scores if withScores is set
+// RESP3 clients get [member, score] pairs, RESP2 clients get a flat array
+func makeNodesReply(conn net.Conn, nodes []*ZSetNode, withScores bool) data.RedisData {
+	res := make([]data.RedisData, 0, len(nodes))
+	nested := withScores && protocolOf(conn) == data.RESP3
+	for _, node := range nodes {
+		member := data.MakeBulkData([]byte(node.Member))
+		switch {
+		case nested:
+			res = append(res, data.MakeArrayData([]data.RedisData{member, makeScoreReply(node.Score)}))
+		case withScores:
+			res = append(res, member, makeScoreReply(node.Score))
+		default:
+			res = append(res, member)
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+// zAddSortedSet add members with scores or update their scores
+// ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func zAddSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zadd" {
+		log.Printf("zAddSortedSet Function: cmdName is not zadd")
+		return data.MakeErrorData("server error")
+	}
+	var nx, xx, gt, lt, ch, incr bool
+	i := 2
+	for ; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "nx":
+			nx = true
+			continue
+		case "xx":
+			xx = true
+			continue
+		case "gt":
+			gt = true
+			continue
+		case "lt":
+			lt = true
+			continue
+		case "ch":
+			ch = true
+			continue
+		case "incr":
+			incr = true
+			continue
+		}
+		break
+	}
+
+	pairs := cmd[i:]
+	if len(pairs) == 0 || len(pairs)&1 != 0 {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	if nx && xx {
+		return data.MakeErrorData("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return data.MakeErrorData("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return data.MakeErrorData("ERR INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j])
+		if !ok {
+			return data.MakeErrorData("ERR value is not a valid float")
+		}
+		scores = append(scores, score)
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		if xx {
+			if incr {
+				return data.MakeBulkData(nil)
+			}
+			return data.MakeIntData(0)
+		}
+		zs = NewSortedSet()
+		db.setKey(key, zs)
+	}
+	defer removeEmptySortedSet(db, key, zs)
+
+	added, changed := 0, 0
+	var incrScore float64
+	for j, score := range scores {
+		member := string(pairs[j*2+1])
+		old, exists := zs.Score(member)
+		if (nx && exists) || (xx && !exists) {
+			if incr {
+				return data.MakeBulkData(nil)
+			}
+			continue
+		}
+		if incr {
+			score += old
+			if math.IsNaN(score) {
+				return data.MakeErrorData("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists {
+			if (gt && score <= old) || (lt && score >= old) {
+				if incr {
+					return data.MakeBulkData(nil)
+				}
+				continue
+			}
+			if score != old {
+				changed++
+			}
+		} else {
+			added++
+		}
+		zs.Add(member, score)
+		incrScore = score
+	}
+	if added+changed > 0 {
+		db.touchKey(key)
+	}
+
+	if incr {
+		return makeScoreReply(incrScore)
+	}
+	if ch {
+		return data.MakeIntData(int64(added + changed))
+	}
+	return data.MakeIntData(int64(added))
+}
+
+func zIncrBySortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zincrby" {
+		log.Printf("zIncrBySortedSet Function: cmdName is not zincrby")
+		return data.MakeErrorData("server error")
+	}
+	incr, ok := parseScore(cmd[2])
+	if !ok {
+		return data.MakeErrorData("ERR value is not a valid float")
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	// wake up the clients blocked on key once the lock is released
+	defer db.signalKeyReady(key)
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		zs = NewSortedSet()
+		db.setKey(key, zs)
+	}
+	defer removeEmptySortedSet(db, key, zs)
+
+	member := string(cmd[3])
+	score, _ := zs.Score(member)
+	score += incr
+	if math.IsNaN(score) {
+		return data.MakeErrorData("ERR resulting score is not a number (NaN)")
+	}
+	zs.Add(member, score)
+	db.touchKey(key)
+	return makeScoreReply(score)
+}
+
+func zRemSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrem" {
+		log.Printf("zRemSortedSet Function: cmdName is not zrem")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		return data.MakeIntData(0)
+	}
+	defer removeEmptySortedSet(db, key, zs)
+
+	count := 0
+	for _, member := range cmd[2:] {
+		if zs.Remove(string(member)) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.touchKey(key)
+	}
+	return data.MakeIntData(int64(count))
+}
+
+func zScoreSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zscore" {
+		log.Printf("zScoreSortedSet Function: cmdName is not zscore")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeBulkData(nil)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		return data.MakeBulkData(nil)
+	}
+	score, ok := zs.Score(string(cmd[2]))
+	if !ok {
+		return data.MakeBulkData(nil)
+	}
+	return makeScoreReply(score)
+}
+
+func zMScoreSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zmscore" {
+		log.Printf("zMScoreSortedSet Function: cmdName is not zmscore")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+
+	res := make([]data.RedisData, 0, len(cmd)-2)
+	for _, member := range cmd[2:] {
+		if zs == nil {
+			res = append(res, data.MakeBulkData(nil))
+			continue
+		}
+		score, ok := zs.Score(string(member))
+		if !ok {
+			res = append(res, data.MakeBulkData(nil))
+		} else {
+			res = append(res, makeScoreReply(score))
+		}
+	}
+	return data.MakeArrayData(res)
+}
+
+func zCardSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zcard" {
+		log.Printf("zCardSortedSet Function: cmdName is not zcard")
+		return data.MakeErrorData("server error")
+	}
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		return data.MakeIntData(0)
+	}
+	return data.MakeIntData(int64(zs.Len()))
+}
+
+// zCountSortedSet implements ZCOUNT key min max and ZLEXCOUNT key min max
+func zCountSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zcount" && cmdName != "zlexcount" {
+		log.Printf("zCountSortedSet Function: cmdName is not zcount or zlexcount")
+		return data.MakeErrorData("server error")
+	}
+	var scoreRange *ScoreRange
+	var lexRange *LexRange
+	var err data.RedisData
+	if cmdName == "zcount" {
+		scoreRange, err = parseScoreRange(cmd[2], cmd[3])
+	} else {
+		lexRange, err = parseLexRange(cmd[2], cmd[3])
+	}
+	if err != nil {
+		return err
+	}
+
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	if zs == nil {
+		return data.MakeIntData(0)
+	}
+	if scoreRange != nil {
+		return data.MakeIntData(int64(zs.CountInScoreRange(scoreRange)))
+	}
+	return data.MakeIntData(int64(zs.CountInLexRange(lexRange)))
+}
+
+// zRankSortedSet implements ZRANK and ZREVRANK
+// ZRANK key member [WITHSCORE]
+func zRankSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zrank" && cmdName != "zrevrank" {
+		log.Printf("zRankSortedSet Function: cmdName is not zrank or zrevrank")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd) > 4 {
+		return data.MakeWrongNumberArgs(cmdName)
+	}
+	withScore := false
+	if len(cmd) == 4 {
+		if strings.ToLower(string(cmd[3])) != "withscore" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		withScore = true
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	zs, err := getSortedSet(db, key)
+	if err != nil {
+		return err
+	}
+	var nilReply data.RedisData = data.MakeBulkData(nil)
+	if withScore {
+		nilReply = data.MakeArrayData(nil)
+	}
+	if zs == nil {
+		return nilReply
+	}
+	member := string(cmd[2])
+	rank := zs.Rank(member, cmdName == "zrevrank")
+	if rank < 0 {
+		return nilReply
+	}
+	if withScore {
+		score, _ := zs.Score(member)
+		return data.MakeArrayData([]data.RedisData{data.MakeIntData(int64(rank)), makeScoreReply(score)})
+	}
+	return data.MakeIntData(int64(rank))
+}
+
+// zRangeSpec is the parsed range arguments of ZRANGE and its variants
+type zRangeSpec struct {
+	by         string // "rank", "score" or "lex"
+	reverse    bool
+	start      int
+	stop       int
+	score      *ScoreRange
+	lex        *LexRange
+	offset     int
+	count      int // -1 means no limit
+	withScores bool
+}
+
+// parseZRangeSpec parse "min max [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]"
+// by and reverse are the defaults of the command, keywords enables BYSCORE, BYLEX and REV which are only accepted by ZRANGE and ZRANGESTORE
+// withScores tells whether WITHSCORES is accepted
+func parseZRangeSpec(args [][]byte, by string, reverse bool, keywords bool, withScores bool) (*zRangeSpec, data.RedisData) {
+	spec := &zRangeSpec{
+		by:      by,
+		reverse: reverse,
+		count:   -1,
+	}
+	limit := false
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case keywords && opt == "byscore":
+			spec.by = "score"
+		case keywords && opt == "bylex":
+			spec.by = "lex"
+		case keywords && opt == "rev":
+			spec.reverse = true
+		case withScores && opt == "withscores":
+			spec.withScores = true
+		case opt == "limit":
+			if i+2 >= len(args) {
+				return nil, data.MakeErrorData("ERR syntax error")
+			}
+			offset, err1 := strconv.Atoi(string(args[i+1]))
+			count, err2 := strconv.Atoi(string(args[i+2]))
+			if err1 != nil || err2 != nil {
+				return nil, data.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			spec.offset, spec.count = offset, count
+			limit = true
+			i += 2
+		default:
+			return nil, data.MakeErrorData("ERR syntax error")
+		}
+	}
+	if limit && spec.by == "rank" {
+		return nil, data.MakeErrorData("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == "lex" {
+		return nil, data.MakeErrorData("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// the first argument is the upper bound when ranging by score or lex in reverse
+	min, max := args[0], args[1]
+	if spec.reverse && spec.by != "rank" {
+		min, max = max, min
+	}
+	var err data.RedisData
+	switch spec.by {
+	case "rank":
+		var err1, err2 error
+		spec.start, err1 = strconv.Atoi(string(args[0]))
+		spec.stop, err2 = strconv.Atoi(string(args[1]))
+		if err1 != nil || err2 != nil {
+			return nil, data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+	case "score":
+		spec.score, err = parseScoreRange(min, max)
+	case "lex":
+		spec.lex, err = parseLexRange(min, max)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// zRange return the nodes selected by spec
+func zRange(zs *SortedSet, spec *zRangeSpec) []*ZSetNode {
+	if spec.offset < 0 {
+		return nil
+	}
+	switch spec.by {
+	case "score":
+		return zs.RangeByScore(spec.score, spec.offset, spec.count, spec.reverse)
+	case "lex":
+		return zs.RangeByLex(spec.lex, spec.offset, spec.count, spec.reverse)
+	default:
+		start, stop := normalizeRankRange(spec.start, spec.stop, zs.Len())
+		if start < 0 {
+			return nil
+		}
+		return zs.RangeByRank(start, stop, spec.reverse)
+	}
+}
+
+// normalizeRankRange convert negative ranks and clip them to [0, length)
+// -1 is returned as start if the range is empty
+func normalizeRankRange(start, stop, length int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= length {
+		return -1, -1
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop
+}
+
+// zRangeSortedSet implements ZRANGE and its old variants
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func zRangeSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	var spec *zRangeSpec
+	var errData data.RedisData
+	switch cmdName {
+	case "zrange":
+		spec, errData = parseZRangeSpec(cmd[2:], "rank", false, true, true)
+	case "zrevrange":
+		spec, errData = parseZRangeSpec(cmd[2:], "rank", true, false, true)
+	case "zrangebyscore":
+		spec, errData = parseZRangeSpec(cmd[2:], "score", false, false, true)
+	case "zrevrangebyscore":
+		spec, errData = parseZRangeSpec(cmd[2:], "score", true, false, true)
+	case "zrangebylex":
+		spec, errData = parseZRangeSpec(cmd[2:], "lex", false, false, false)
+	case "zrevrangebylex":
+		spec, errData = parseZRangeSpec(cmd[2:], "lex", true, false, false)
+	default:
+		log.Printf("zRangeSortedSet Function: cmdName is not a zrange command")
+		return data.MakeErrorData("server error")
+	}
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+
+	zs, errData := getSortedSet(db, key)
+	if errData != nil {
+		return errData
+	}
+	if zs == nil {
+		return data.MakeEmptyArrayData()
+	}
+	return makeNodesReply(conn, zRange(zs, spec), spec.withScores)
+}
+
+// zRangeStoreSortedSet store the selected range in another key
+// ZRANGESTORE dst src min max [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+func zRangeStoreSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	if strings.ToLower(string(cmd[0])) != "zrangestore" {
+		log.Printf("zRangeStoreSortedSet Function: cmdName is not zrangestore")
+		return data.MakeErrorData("server error")
+	}
+	spec, errData := parseZRangeSpec(cmd[3:], "rank", false, true, false)
+	if errData != nil {
+		return errData
+	}
+
+	des, src := string(cmd[1]), string(cmd[2])
+	db.CheckTTL(des)
+	db.CheckTTL(src)
+
+	keys := []string{des, src}
+	defer db.signalKeyReady(des)
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+
+	zs, errData := getSortedSet(db, src)
+	if errData != nil {
+		return errData
+	}
+	res := NewSortedSet()
+	if zs != nil {
+		for _, node := range zRange(zs, spec) {
+			res.Add(node.Member, node.Score)
+		}
+	}
+	storeSortedSet(db, des, res)
+	return data.MakeIntData(int64(res.Len()))
+}
+
+// zRemRangeSortedSet implements ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX
+// ZREMRANGEBYSCORE key min max
+func zRemRangeSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zremrangebyrank" && cmdName != "zremrangebyscore" && cmdName != "zremrangebylex" {
+		log.Printf("zRemRangeSortedSet Function: cmdName is not a zremrange command")
+		return data.MakeErrorData("server error")
+	}
+	var start, stop int
+	var scoreRange *ScoreRange
+	var lexRange *LexRange
+	var errData data.RedisData
+	switch cmdName {
+	case "zremrangebyrank":
+		var err1, err2 error
+		start, err1 = strconv.Atoi(string(cmd[2]))
+		stop, err2 = strconv.Atoi(string(cmd[3]))
+		if err1 != nil || err2 != nil {
+			return data.MakeErrorData("ERR value is not an integer or out of range")
+		}
+	case "zremrangebyscore":
+		scoreRange, errData = parseScoreRange(cmd[2], cmd[3])
+	default:
+		lexRange, errData = parseLexRange(cmd[2], cmd[3])
+	}
+	if errData != nil {
+		return errData
+	}
+
+	key := string(cmd[1])
+	if !db.CheckTTL(key) {
+		return data.MakeIntData(0)
+	}
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	zs, errData := getSortedSet(db, key)
+	if errData != nil {
+		return errData
+	}
+	if zs == nil {
+		return data.MakeIntData(0)
+	}
+	defer removeEmptySortedSet(db, key, zs)
+
+	var removed int
+	switch {
+	case scoreRange != nil:
+		removed = zs.RemoveRangeByScore(scoreRange)
+	case lexRange != nil:
+		removed = zs.RemoveRangeByLex(lexRange)
+	default:
+		start, stop = normalizeRankRange(start, stop, zs.Len())
+		if start >= 0 {
+			removed = zs.RemoveRangeByRank(start, stop)
+		}
+	}
+	if removed > 0 {
+		db.touchKey(key)
+	}
+	return data.MakeIntData(int64(removed))
+}
+
+// zPopSortedSet implements ZPOPMIN and ZPOPMAX
+// ZPOPMIN key [count]
+func zPopSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zpopmin" && cmdName != "zpopmax" {
+		log.Printf("zPopSortedSet Function: cmdName is not zpopmin or zpopmax")
+		return data.MakeErrorData("server error")
+	}
+	if len(cmd) > 3 {
+		return data.MakeWrongNumberArgs(cmdName)
+	}
+
+	count := 1
+	withCount := len(cmd) == 3
+	if withCount {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil || count < 0 {
+			return data.MakeErrorData("ERR value is out of range, must be positive")
+		}
+	}
+
+	key := string(cmd[1])
+	db.CheckTTL(key)
+
+	db.locks.Lock(key)
+	defer db.locks.UnLock(key)
+
+	zs, errData := getSortedSet(db, key)
+	if errData != nil {
+		return errData
+	}
+	if zs == nil {
+		return data.MakeEmptyArrayData()
+	}
+	defer removeEmptySortedSet(db, key, zs)
+
+	var nodes []*ZSetNode
+	if cmdName == "zpopmin" {
+		nodes = zs.PopMin(count)
+	} else {
+		nodes = zs.PopMax(count)
+	}
+	if len(nodes) > 0 {
+		db.touchKey(key)
+	}
+	// a single pop is replied as a flat [member, score] for both protocols
+	if !withCount {
+		return makeNodesReply(nil, nodes, true)
+	}
+	return makeNodesReply(conn, nodes, true)
+}
+
+// popSortedSet pop at most count members with the lowest or highest scores of key
+// nil is returned if key is not a sorted set or is empty
+// the caller should hold the lock of key
+func popSortedSet(db *DB, key string, max bool, count int) []*ZSetNode {
+	zs, errData := getSortedSet(db, key)
+	if errData != nil || zs == nil || zs.Len() == 0 {
+		return nil
+	}
+	defer removeEmptySortedSet(db, key, zs)
+	var nodes []*ZSetNode
+	if max {
+		nodes = zs.PopMax(count)
+	} else {
+		nodes = zs.PopMin(count)
+	}
+	if len(nodes) > 0 {
+		db.touchKey(key)
+	}
+	return nodes
+}
+
+// bzPopSortedSet implements BZPOPMIN and BZPOPMAX
+// the client blocks until one of the keys has a member or timeout expires
+// BZPOPMIN key [key ...] timeout
+func bzPopSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "bzpopmin" && cmdName != "bzpopmax" {
+		log.Printf("bzPopSortedSet Function: cmdName is not bzpopmin or bzpopmax")
+		return data.MakeErrorData("server error")
+	}
+	timeout, errData := parseBlockTimeout(cmd[len(cmd)-1])
+	if errData != nil {
+		return errData
+	}
+
+	keys := make([]string, 0, len(cmd)-2)
+	for _, key := range cmd[1 : len(cmd)-1] {
+		keys = append(keys, string(key))
+	}
+	for _, key := range keys {
+		if errData := checkSortedSetType(db, key); errData != nil {
+			return errData
+		}
+	}
+
+	max := cmdName == "bzpopmax"
+	res, ok := db.blockPop(ctx, cmd, keys, keys, timeout, func(db *DB, key string) (data.RedisData, bool) {
+		nodes := popSortedSet(db, key, max, 1)
+		if len(nodes) == 0 {
+			return nil, false
+		}
+		return data.MakeArrayData([]data.RedisData{
+			data.MakeBulkData([]byte(key)),
+			data.MakeBulkData([]byte(nodes[0].Member)),
+			makeScoreReply(nodes[0].Score),
+		}), true
+	})
+	if !ok {
+		return data.MakeArrayData(nil)
+	}
+	return res
+}
+
+// zmPopSortedSet implements ZMPOP and BZMPOP, members are popped from the first non-empty key
+// ZMPOP numkeys key [key ...] MIN | MAX [COUNT count]
+// BZMPOP timeout numkeys key [key ...] MIN | MAX [COUNT count]
+func zmPopSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zmpop" && cmdName != "bzmpop" {
+		log.Printf("zmPopSortedSet Function: cmdName is not zmpop or bzmpop")
+		return data.MakeErrorData("server error")
+	}
+	args := cmd[1:]
+	blocking := cmdName == "bzmpop"
+	var timeout time.Duration
+	if blocking {
+		if len(args) == 0 {
+			return data.MakeWrongNumberArgs(cmdName)
+		}
+		var errData data.RedisData
+		timeout, errData = parseBlockTimeout(args[0])
+		if errData != nil {
+			return errData
+		}
+		args = args[1:]
+	}
+	if len(args) < 3 {
+		return data.MakeWrongNumberArgs(cmdName)
+	}
+
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return data.MakeErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys+1 >= len(args) {
+		return data.MakeErrorData("ERR syntax error")
+	}
+	keys := make([]string, 0, numKeys)
+	for _, key := range args[1 : 1+numKeys] {
+		keys = append(keys, string(key))
+	}
+	args = args[1+numKeys:]
+
+	var max bool
+	switch strings.ToLower(string(args[0])) {
+	case "min":
+	case "max":
+		max = true
+	default:
+		return data.MakeErrorData("ERR syntax error")
+	}
+	count := 1
+	if len(args) > 1 {
+		if len(args) != 3 || strings.ToLower(string(args[1])) != "count" {
+			return data.MakeErrorData("ERR syntax error")
+		}
+		count, err = strconv.Atoi(string(args[2]))
+		if err != nil || count <= 0 {
+			return data.MakeErrorData("ERR count should be greater than 0")
+		}
+	}
+
+	for _, key := range keys {
+		if errData := checkSortedSetType(db, key); errData != nil {
+			return errData
+		}
+	}
+
+	pop := func(db *DB, key string) (data.RedisData, bool) {
+		nodes := popSortedSet(db, key, max, count)
+		if len(nodes) == 0 {
+			return nil, false
+		}
+		pairs := make([]data.RedisData, 0, len(nodes))
+		for _, node := range nodes {
+			pairs = append(pairs, data.MakeArrayData([]data.RedisData{
+				data.MakeBulkData([]byte(node.Member)),
+				makeScoreReply(node.Score),
+			}))
+		}
+		return data.MakeArrayData([]data.RedisData{data.MakeBulkData([]byte(key)), data.MakeArrayData(pairs)}), true
+	}
+
+	if blocking {
+		res, ok := db.blockPop(ctx, cmd, keys, keys, timeout, pop)
+		if !ok {
+			return data.MakeArrayData(nil)
+		}
+		return res
+	}
+
+	for _, key := range keys {
+		db.CheckTTL(key)
+	}
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+	for _, key := range keys {
+		if res, ok := pop(db, key); ok {
+			return res
+		}
+	}
+	return data.MakeArrayData(nil)
+}
+
+// checkSortedSetType return a wrong type error if key holds a value other than a sorted set
+func checkSortedSetType(db *DB, key string) data.RedisData {
+	db.CheckTTL(key)
+	db.locks.RLock(key)
+	defer db.locks.RUnLock(key)
+	_, errData := getSortedSet(db, key)
+	return errData
+}
+
+// zStoreSortedSet implements ZUNIONSTORE and ZINTERSTORE, plain sets are treated as sorted sets with score 1
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM | MIN | MAX]
+func zStoreSortedSet(ctx context.Context, db *DB, cmd [][]byte, conn net.Conn) data.RedisData {
+	cmdName := strings.ToLower(string(cmd[0]))
+	if cmdName != "zunionstore" && cmdName != "zinterstore" {
+		log.Printf("zStoreSortedSet Function: cmdName is not zunionstore or zinterstore")
+		return data.MakeErrorData("server error")
+	}
+	numKeys, err := strconv.Atoi(string(cmd[2]))
+	if err != nil {
+		return data.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return data.MakeErrorData("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > len(cmd)-3 {
+		return data.MakeErrorData("ERR syntax error")
+	}
+
+	srcKeys := make([]string, 0, numKeys)
+	for _, key := range cmd[3 : 3+numKeys] {
+		srcKeys = append(srcKeys, string(key))
+	}
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "sum"
+	for i := 3 + numKeys; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "weights":
+			if i+numKeys >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			for j := 0; j < numKeys; j++ {
+				weight, ok := parseScore(cmd[i+1+j])
+				if !ok {
+					return data.MakeErrorData("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += numKeys
+		case "aggregate":
+			if i+1 >= len(cmd) {
+				return data.MakeErrorData("ERR syntax error")
+			}
+			i++
+			aggregate = strings.ToLower(string(cmd[i]))
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				return data.MakeErrorData("ERR syntax error")
+			}
+		default:
+			return data.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	des := string(cmd[1])
+	db.CheckTTL(des)
+	for _, key := range srcKeys {
+		db.CheckTTL(key)
+	}
+
+	keys := append([]string{des}, srcKeys...)
+	defer db.signalKeyReady(des)
+	db.locks.LockMulti(keys)
+	defer db.locks.UnLockMulti(keys)
+
+	// read every source as member -> score
+	sources := make([]map[string]float64, 0, numKeys)
+	for _, key := range srcKeys {
+		tem, ok := db.db.Get(key)
+		if !ok {
+			sources = append(sources, nil)
+			continue
+		}
+		switch v := tem.(type) {
+		case *SortedSet:
+			sources = append(sources, v.Members())
+		case *Set:
+			members := make(map[string]float64, v.Len())
+			for _, member := range v.Members() {
+				members[member] = 1
+			}
+			sources = append(sources, members)
+		default:
+			return data.MakeWrongType()
+		}
+	}
+
+	res := make(map[string]float64)
+	for i, source := range sources {
+		if cmdName == "zinterstore" && source == nil {
+			res = map[string]float64{}
+			break
+		}
+		if cmdName == "zinterstore" && i > 0 {
+			// keep only the members in every source
+			for member := range res {
+				if _, ok := source[member]; !ok {
+					delete(res, member)
+				}
+			}
+		}
+		for member, score := range source {
+			weighted := score * weights[i]
+			if math.IsNaN(weighted) {
+				weighted = 0
+			}
+			old, exists := res[member]
+			if cmdName == "zinterstore" && i > 0 && !exists {
+				continue
+			}
+			if !exists {
+				res[member] = weighted
+				continue
+			}
+			res[member] = aggregateScore(aggregate, old, weighted)
+		}
+	}
+
+	zs := NewSortedSet()
+	for member, score := range res {
+		zs.Add(member, score)
+	}
+	storeSortedSet(db, des, zs)
+	return data.MakeIntData(int64(zs.Len()))
+}
+
+func aggregateScore(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	default:
+		sum := a + b
+		// inf + -inf is treated as 0 as redis does
+		if math.IsNaN(sum) {
+			return 0
+		}
+		return sum
+	}
+}
+
+func RegisterSortedSetCommands() {
+	RegisterCommand("zadd", zAddSortedSet, -4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("zincrby", zIncrBySortedSet, 4, cmdWrite|cmdDenyOOM|cmdFast, 1, 1, 1)
+	RegisterCommand("zrem", zRemSortedSet, -3, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("zscore", zScoreSortedSet, 3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zmscore", zMScoreSortedSet, -3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zcard", zCardSortedSet, 2, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zcount", zCountSortedSet, 4, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zlexcount", zCountSortedSet, 4, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zrank", zRankSortedSet, -3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zrevrank", zRankSortedSet, -3, cmdReadonly|cmdFast, 1, 1, 1)
+	RegisterCommand("zrange", zRangeSortedSet, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("zrevrange", zRangeSortedSet, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("zrangebyscore", zRangeSortedSet, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("zrevrangebyscore", zRangeSortedSet, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("zrangebylex", zRangeSortedSet, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("zrevrangebylex", zRangeSortedSet, -4, cmdReadonly, 1, 1, 1)
+	RegisterCommand("zrangestore", zRangeStoreSortedSet, -5, cmdWrite|cmdDenyOOM, 1, 2, 1)
+	RegisterCommand("zremrangebyrank", zRemRangeSortedSet, 4, cmdWrite, 1, 1, 1)
+	RegisterCommand("zremrangebyscore", zRemRangeSortedSet, 4, cmdWrite, 1, 1, 1)
+	RegisterCommand("zremrangebylex", zRemRangeSortedSet, 4, cmdWrite, 1, 1, 1)
+	RegisterCommand("zpopmin", zPopSortedSet, -2, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("zpopmax", zPopSortedSet, -2, cmdWrite|cmdFast, 1, 1, 1)
+	RegisterCommand("bzpopmin", bzPopSortedSet, -3, cmdWrite|cmdBlocking|cmdFast, 1, -2, 1)
+	RegisterCommand("bzpopmax", bzPopSortedSet, -3, cmdWrite|cmdBlocking|cmdFast, 1, -2, 1)
+	RegisterCommand("zmpop", zmPopSortedSet, -4, cmdWrite|cmdMovableKeys, 0, 0, 0)
+	registerKeysFunc("zmpop", numKeysFunc(1))
+	RegisterCommand("bzmpop", zmPopSortedSet, -5, cmdWrite|cmdBlocking|cmdMovableKeys, 0, 0, 0)
+	registerKeysFunc("bzmpop", numKeysFunc(2))
+	RegisterCommand("zunionstore", zStoreSortedSet, -4, cmdWrite|cmdDenyOOM|cmdMovableKeys, 1, 1, 1)
+	registerKeysFunc("zunionstore", numKeysFunc(2, 1))
+	RegisterCommand("zinterstore", zStoreSortedSet, -4, cmdWrite|cmdDenyOOM|cmdMovableKeys, 1, 1, 1)
+	registerKeysFunc("zinterstore", numKeysFunc(2, 1))
+}
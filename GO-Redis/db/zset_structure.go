@@ -0,0 +1,569 @@
+package db
+
+import (
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel = 32
+	// skipListP is the probability for a node to get one more level
+	skipListP = 0.25
+)
+
+// SortedSet keeps members ordered by score, members with the same score are ordered lexicographically
+// dict gives the score of a member in O(1), the skip list gives ranks and ranges in O(log(n))
+type SortedSet struct {
+	dict map[string]float64
+	zsl  *skipList
+}
+
+// ZSetNode is a member of the skip list
+type ZSetNode struct {
+	Member   string
+	Score    float64
+	backward *ZSetNode
+	level    []zSetLevel
+}
+
+type zSetLevel struct {
+	forward *ZSetNode
+	// span is the number of nodes between this node and forward, it is used to compute ranks
+	span int
+}
+
+type skipList struct {
+	header *ZSetNode
+	tail   *ZSetNode
+	length int
+	level  int
+}
+
+// ScoreRange is a range of scores, min and max are excluded if MinEx or MaxEx is set
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+// LexRange is a range of members for sorted sets with the same score
+// MinInf and MaxInf are -1 for "-" (smaller than any member), 1 for "+" (greater than any member) and 0 otherwise
+type LexRange struct {
+	Min, Max       string
+	MinEx, MaxEx   bool
+	MinInf, MaxInf int
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict: make(map[string]float64),
+		zsl:  newSkipList(),
+	}
+}
+
+func newZSetNode(level int, score float64, member string) *ZSetNode {
+	return &ZSetNode{
+		Member: member,
+		Score:  score,
+		level:  make([]zSetLevel, level),
+	}
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		header: newZSetNode(skipListMaxLevel, 0, ""),
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// Next return the node after this one, nil at the tail
+func (node *ZSetNode) Next() *ZSetNode {
+	return node.level[0].forward
+}
+
+// Prev return the node before this one, nil at the head
+func (node *ZSetNode) Prev() *ZSetNode {
+	return node.backward
+}
+
+// less compare (score, member) pairs in the order of the skip list
+func less(score float64, member string, node *ZSetNode) bool {
+	return node.Score < score || (node.Score == score && node.Member < member)
+}
+
+func (zsl *skipList) insert(score float64, member string) *ZSetNode {
+	update := make([]*ZSetNode, skipListMaxLevel)
+	rank := make([]int, skipListMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && less(score, member, x.level[i].forward) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = newZSetNode(level, score, member)
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// increment span for untouched levels
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// deleteNode unlink x, update is the last node before x on every level
+func (zsl *skipList) deleteNode(x *ZSetNode, update []*ZSetNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *skipList) delete(score float64, member string) bool {
+	update := make([]*ZSetNode, skipListMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && less(score, member, x.level[i].forward) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.Score == score && x.Member == member {
+		zsl.deleteNode(x, update)
+		return true
+	}
+	return false
+}
+
+// getRank return the 1-based rank of the member, 0 if it is not found
+func (zsl *skipList) getRank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.Score < score ||
+				(x.level[i].forward.Score == score && x.level[i].forward.Member <= member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getByRank return the node at the 1-based rank
+func (zsl *skipList) getByRank(rank int) *ZSetNode {
+	if rank <= 0 || rank > zsl.length {
+		return nil
+	}
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+func (r *ScoreRange) gteMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r *ScoreRange) lteMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// isEmpty report whether no score can be in the range
+func (r *ScoreRange) isEmpty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+func (r *LexRange) gteMin(member string) bool {
+	switch r.MinInf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if r.MinEx {
+		return member > r.Min
+	}
+	return member >= r.Min
+}
+
+func (r *LexRange) lteMax(member string) bool {
+	switch r.MaxInf {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	if r.MaxEx {
+		return member < r.Max
+	}
+	return member <= r.Max
+}
+
+func (r *LexRange) isEmpty() bool {
+	if r.MinInf == 1 || r.MaxInf == -1 {
+		return true
+	}
+	if r.MinInf == -1 || r.MaxInf == 1 {
+		return false
+	}
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+// firstInScoreRange return the first node in the range, nil if there is none
+func (zsl *skipList) firstInScoreRange(r *ScoreRange) *ZSetNode {
+	if r.isEmpty() || zsl.tail == nil || !r.gteMin(zsl.tail.Score) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.Score) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x.Score) {
+		return nil
+	}
+	return x
+}
+
+// lastInScoreRange return the last node in the range, nil if there is none
+func (zsl *skipList) lastInScoreRange(r *ScoreRange) *ZSetNode {
+	if r.isEmpty() || zsl.tail == nil || !r.lteMax(zsl.header.level[0].forward.Score) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.Score) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.gteMin(x.Score) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *skipList) firstInLexRange(r *LexRange) *ZSetNode {
+	if r.isEmpty() || zsl.tail == nil || !r.gteMin(zsl.tail.Member) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.Member) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x.Member) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *skipList) lastInLexRange(r *LexRange) *ZSetNode {
+	if r.isEmpty() || zsl.tail == nil || !r.lteMax(zsl.header.level[0].forward.Member) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.Member) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.gteMin(x.Member) {
+		return nil
+	}
+	return x
+}
+
+// Len return the number of members
+func (zs *SortedSet) Len() int {
+	return zs.zsl.length
+}
+
+// Score return the score of member
+func (zs *SortedSet) Score(member string) (float64, bool) {
+	score, ok := zs.dict[member]
+	return score, ok
+}
+
+// Add set the score of member, return true if the member is new
+func (zs *SortedSet) Add(member string, score float64) bool {
+	old, ok := zs.dict[member]
+	if ok {
+		if old != score {
+			zs.zsl.delete(old, member)
+			zs.zsl.insert(score, member)
+			zs.dict[member] = score
+		}
+		return false
+	}
+	zs.zsl.insert(score, member)
+	zs.dict[member] = score
+	return true
+}
+
+// Remove delete member, return true if it existed
+func (zs *SortedSet) Remove(member string) bool {
+	score, ok := zs.dict[member]
+	if !ok {
+		return false
+	}
+	zs.zsl.delete(score, member)
+	delete(zs.dict, member)
+	return true
+}
+
+// Rank return the 0-based rank of member in ascending order, or in descending order if reverse is set
+// -1 is returned if the member does not exist
+func (zs *SortedSet) Rank(member string, reverse bool) int {
+	score, ok := zs.dict[member]
+	if !ok {
+		return -1
+	}
+	rank := zs.zsl.getRank(score, member)
+	if reverse {
+		return zs.zsl.length - rank
+	}
+	return rank - 1
+}
+
+// ByRank return the node at the 0-based rank in ascending order, nil if out of range
+func (zs *SortedSet) ByRank(rank int) *ZSetNode {
+	return zs.zsl.getByRank(rank + 1)
+}
+
+// First return the node with the lowest score
+func (zs *SortedSet) First() *ZSetNode {
+	return zs.zsl.header.level[0].forward
+}
+
+// Last return the node with the highest score
+func (zs *SortedSet) Last() *ZSetNode {
+	return zs.zsl.tail
+}
+
+// RangeByRank return the nodes between the 0-based rank start and end (both included)
+// the nodes are in descending order if reverse is set and the ranks are counted from the tail
+func (zs *SortedSet) RangeByRank(start, end int, reverse bool) []*ZSetNode {
+	if start < 0 || start > end || start >= zs.zsl.length {
+		return nil
+	}
+	if end >= zs.zsl.length {
+		end = zs.zsl.length - 1
+	}
+	res := make([]*ZSetNode, 0, end-start+1)
+	var node *ZSetNode
+	if reverse {
+		node = zs.zsl.getByRank(zs.zsl.length - start)
+	} else {
+		node = zs.zsl.getByRank(start + 1)
+	}
+	for i := start; i <= end && node != nil; i++ {
+		res = append(res, node)
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return res
+}
+
+// RangeByScore return the nodes in the score range, offset nodes are skipped and at most count nodes are returned
+// count < 0 means no limit
+func (zs *SortedSet) RangeByScore(r *ScoreRange, offset, count int, reverse bool) []*ZSetNode {
+	res := make([]*ZSetNode, 0)
+	var node *ZSetNode
+	if reverse {
+		node = zs.zsl.lastInScoreRange(r)
+	} else {
+		node = zs.zsl.firstInScoreRange(r)
+	}
+	for ; node != nil && offset > 0; offset-- {
+		node = zs.step(node, reverse)
+	}
+	for node != nil && count != 0 {
+		if (reverse && !r.gteMin(node.Score)) || (!reverse && !r.lteMax(node.Score)) {
+			break
+		}
+		res = append(res, node)
+		node = zs.step(node, reverse)
+		count--
+	}
+	return res
+}
+
+// RangeByLex return the nodes in the lex range, it is only meaningful when all members have the same score
+func (zs *SortedSet) RangeByLex(r *LexRange, offset, count int, reverse bool) []*ZSetNode {
+	res := make([]*ZSetNode, 0)
+	var node *ZSetNode
+	if reverse {
+		node = zs.zsl.lastInLexRange(r)
+	} else {
+		node = zs.zsl.firstInLexRange(r)
+	}
+	for ; node != nil && offset > 0; offset-- {
+		node = zs.step(node, reverse)
+	}
+	for node != nil && count != 0 {
+		if (reverse && !r.gteMin(node.Member)) || (!reverse && !r.lteMax(node.Member)) {
+			break
+		}
+		res = append(res, node)
+		node = zs.step(node, reverse)
+		count--
+	}
+	return res
+}
+
+func (zs *SortedSet) step(node *ZSetNode, reverse bool) *ZSetNode {
+	if reverse {
+		return node.backward
+	}
+	return node.level[0].forward
+}
+
+// CountInScoreRange return the number of members in the score range
+func (zs *SortedSet) CountInScoreRange(r *ScoreRange) int {
+	first := zs.zsl.firstInScoreRange(r)
+	if first == nil {
+		return 0
+	}
+	last := zs.zsl.lastInScoreRange(r)
+	return zs.zsl.getRank(last.Score, last.Member) - zs.zsl.getRank(first.Score, first.Member) + 1
+}
+
+// CountInLexRange return the number of members in the lex range
+func (zs *SortedSet) CountInLexRange(r *LexRange) int {
+	first := zs.zsl.firstInLexRange(r)
+	if first == nil {
+		return 0
+	}
+	last := zs.zsl.lastInLexRange(r)
+	return zs.zsl.getRank(last.Score, last.Member) - zs.zsl.getRank(first.Score, first.Member) + 1
+}
+
+// removeNodes delete the given nodes and return how many are deleted
+func (zs *SortedSet) removeNodes(nodes []*ZSetNode) int {
+	for _, node := range nodes {
+		zs.Remove(node.Member)
+	}
+	return len(nodes)
+}
+
+// RemoveRangeByRank delete the members between the 0-based rank start and end (both included)
+func (zs *SortedSet) RemoveRangeByRank(start, end int) int {
+	return zs.removeNodes(zs.RangeByRank(start, end, false))
+}
+
+// RemoveRangeByScore delete the members in the score range
+func (zs *SortedSet) RemoveRangeByScore(r *ScoreRange) int {
+	return zs.removeNodes(zs.RangeByScore(r, 0, -1, false))
+}
+
+// RemoveRangeByLex delete the members in the lex range
+func (zs *SortedSet) RemoveRangeByLex(r *LexRange) int {
+	return zs.removeNodes(zs.RangeByLex(r, 0, -1, false))
+}
+
+// PopMin remove and return at most count members with the lowest scores
+func (zs *SortedSet) PopMin(count int) []*ZSetNode {
+	res := make([]*ZSetNode, 0)
+	for i := 0; i < count; i++ {
+		node := zs.First()
+		if node == nil {
+			break
+		}
+		zs.Remove(node.Member)
+		res = append(res, node)
+	}
+	return res
+}
+
+// PopMax remove and return at most count members with the highest scores
+func (zs *SortedSet) PopMax(count int) []*ZSetNode {
+	res := make([]*ZSetNode, 0)
+	for i := 0; i < count; i++ {
+		node := zs.Last()
+		if node == nil {
+			break
+		}
+		zs.Remove(node.Member)
+		res = append(res, node)
+	}
+	return res
+}
+
+// Members return all members with their scores
+func (zs *SortedSet) Members() map[string]float64 {
+	return zs.dict
+}
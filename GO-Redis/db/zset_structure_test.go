@@ -0,0 +1,176 @@
+package db
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkRanks compare every rank of zs with members sorted by score then member
+func checkRanks(t *testing.T, zs *SortedSet) {
+	t.Helper()
+	type entry struct {
+		member string
+		score  float64
+	}
+	entries := make([]entry, 0, len(zs.dict))
+	for member, score := range zs.dict {
+		entries = append(entries, entry{member, score})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score < entries[j].score
+		}
+		return entries[i].member < entries[j].member
+	})
+	if zs.Len() != len(entries) {
+		t.Fatalf("Len() = %d, want %d", zs.Len(), len(entries))
+	}
+	for i, e := range entries {
+		if rank := zs.Rank(e.member, false); rank != i {
+			t.Fatalf("Rank(%q) = %d, want %d", e.member, rank, i)
+		}
+		if rank := zs.Rank(e.member, true); rank != len(entries)-1-i {
+			t.Fatalf("Rank(%q, reverse) = %d, want %d", e.member, rank, len(entries)-1-i)
+		}
+		if node := zs.ByRank(i); node == nil || node.Member != e.member || node.Score != e.score {
+			t.Fatalf("ByRank(%d) = %v, want %s", i, node, e.member)
+		}
+	}
+	if node := zs.ByRank(len(entries)); node != nil {
+		t.Fatalf("ByRank(%d) = %s, want nil", len(entries), node.Member)
+	}
+}
+
+func TestSortedSetRank(t *testing.T) {
+	tests := []struct {
+		name   string
+		add    map[string]float64
+		remove []string
+		update map[string]float64
+	}{
+		{"empty", nil, nil, nil},
+		{"one", map[string]float64{"a": 1}, nil, nil},
+		{"distinct scores", map[string]float64{"a": 3, "b": 1, "c": 2}, nil, nil},
+		{"same score ordered by member", map[string]float64{"c": 1, "a": 1, "b": 1}, nil, nil},
+		{"negative and infinite", map[string]float64{"a": -1, "b": math.Inf(-1), "c": math.Inf(1), "d": 0}, nil, nil},
+		{"remove", map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4}, []string{"b", "d", "missing"}, nil},
+		{"remove all", map[string]float64{"a": 1, "b": 2}, []string{"a", "b"}, nil},
+		{"update score", map[string]float64{"a": 1, "b": 2, "c": 3}, nil, map[string]float64{"a": 4, "c": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zs := NewSortedSet()
+			for member, score := range tt.add {
+				if !zs.Add(member, score) {
+					t.Fatalf("Add(%q) = false, want true for a new member", member)
+				}
+			}
+			for _, member := range tt.remove {
+				_, exists := tt.add[member]
+				if got := zs.Remove(member); got != exists {
+					t.Fatalf("Remove(%q) = %v, want %v", member, got, exists)
+				}
+			}
+			for member, score := range tt.update {
+				if zs.Add(member, score) {
+					t.Fatalf("Add(%q) = true, want false for an updated member", member)
+				}
+			}
+			checkRanks(t, zs)
+			if zs.Rank("missing", false) != -1 {
+				t.Fatalf("Rank(missing) = %d, want -1", zs.Rank("missing", false))
+			}
+		})
+	}
+}
+
+func TestSortedSetRankRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	zs := NewSortedSet()
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(r.Intn(500))
+		switch r.Intn(3) {
+		case 0, 1:
+			zs.Add(member, float64(r.Intn(50)))
+		case 2:
+			zs.Remove(member)
+		}
+		if i%100 == 0 {
+			checkRanks(t, zs)
+		}
+	}
+	checkRanks(t, zs)
+}
+
+func TestSortedSetRangeByRank(t *testing.T) {
+	zs := NewSortedSet()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		zs.Add(member, float64(i))
+	}
+	tests := []struct {
+		name       string
+		start, end int
+		reverse    bool
+		want       string
+	}{
+		{"all", 0, 4, false, "abcde"},
+		{"middle", 1, 3, false, "bcd"},
+		{"end out of range", 3, 10, false, "de"},
+		{"start out of range", 5, 10, false, ""},
+		{"start after end", 3, 1, false, ""},
+		{"reverse", 0, 1, true, "ed"},
+		{"reverse middle", 1, 3, true, "dcb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			for _, node := range zs.RangeByRank(tt.start, tt.end, tt.reverse) {
+				got += node.Member
+			}
+			if got != tt.want {
+				t.Fatalf("RangeByRank(%d, %d, %v) = %q, want %q", tt.start, tt.end, tt.reverse, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	zs := NewSortedSet()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		zs.Add(member, float64(i))
+	}
+	tests := []struct {
+		name          string
+		r             ScoreRange
+		offset, count int
+		reverse       bool
+		want          string
+	}{
+		{"inclusive", ScoreRange{Min: 1, Max: 3}, 0, -1, false, "bcd"},
+		{"exclusive", ScoreRange{Min: 1, Max: 3, MinEx: true, MaxEx: true}, 0, -1, false, "c"},
+		{"limit", ScoreRange{Min: 0, Max: 4}, 1, 2, false, "bc"},
+		{"reverse", ScoreRange{Min: 1, Max: 3}, 0, -1, true, "dcb"},
+		{"reverse limit", ScoreRange{Min: 0, Max: 4}, 1, 2, true, "dc"},
+		{"empty", ScoreRange{Min: 5, Max: 10}, 0, -1, false, ""},
+		{"min after max", ScoreRange{Min: 3, Max: 1}, 0, -1, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			for _, node := range zs.RangeByScore(&tt.r, tt.offset, tt.count, tt.reverse) {
+				got += node.Member
+			}
+			if got != tt.want {
+				t.Fatalf("RangeByScore() = %q, want %q", got, tt.want)
+			}
+			if tt.offset == 0 && tt.count < 0 {
+				if n := zs.CountInScoreRange(&tt.r); n != len(tt.want) {
+					t.Fatalf("CountInScoreRange() = %d, want %d", n, len(tt.want))
+				}
+			}
+		})
+	}
+}
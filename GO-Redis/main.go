@@ -0,0 +1,19 @@
+package main
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/server"
+	"log"
+)
+
+func main() {
+	cfg, err := config.Setup()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Configures = cfg
+
+	if err = server.Start(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
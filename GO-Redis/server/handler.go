@@ -0,0 +1,159 @@
+package server
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"GO-Redis/db"
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+// pipelineSize is how many parsed commands of one client can wait for execution
+const pipelineSize = 1024
+
+// Handler serve client connections and dispatch their commands into the command table
+type Handler struct {
+	dbs *db.Databases
+}
+
+// request is a parsed command or the error that stopped the parsing
+type request struct {
+	cmd [][]byte
+	err error
+}
+
+func NewHandler() *Handler {
+	db.RegisterKeyCommands()
+	db.RegisterStringCommands()
+	db.RegisterListCommands()
+	db.RegisterHashCommands()
+	db.RegisterSetCommands()
+	db.RegisterSortedSetCommands()
+	db.RegisterStreamCommands()
+	db.RegisterConnectionCommands()
+	db.RegisterServerCommands()
+	db.RegisterReplicationCommands()
+	db.RegisterClusterCommands()
+	db.RegisterPubSubCommands()
+	return &Handler{
+		dbs: db.NewDatabases(config.Configures.Databases),
+	}
+}
+
+// Handle read commands from conn until it is closed, execute them in order and write the replies back.
+// Pipelined commands are executed one by one as soon as they are parsed,
+// and their replies are buffered and flushed together once no more command is waiting.
+func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// close the connection when the server is shutting down, this also unblocks the reader below
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	// connCtx is done once the input of the client ends and only its last command is left, it wakes up the client
+	// blocked in that command. The commands queued before the end of the input still run, a client may half close
+	// the connection after sending them and wait for their replies.
+	connCtx, disconnect := context.WithCancel(ctx)
+	defer disconnect()
+
+	client := db.NewClient(conn)
+	defer h.dbs.ReleaseClient(client)
+	requests := make(chan *request, pipelineSize)
+	var inputEnded atomic.Bool
+	// lastCommand is called by both sides, the reader once the end of the input is queued and the loop below before
+	// each command, so connCtx is done whichever of them comes last
+	lastCommand := func() {
+		if inputEnded.Load() && len(requests) <= 1 {
+			disconnect()
+		}
+	}
+	go readRequests(ctx, conn, requests, &inputEnded, lastCommand)
+
+	for {
+		var req *request
+		select {
+		case req = <-requests:
+		default:
+			// the batch is drained, send all replies in one write before waiting for the next command
+			if err := client.Flush(); err != nil {
+				log.Printf("Write reply to %s error: %s", conn.RemoteAddr(), err.Error())
+				return
+			}
+			select {
+			case req = <-requests:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if req.err != nil {
+			var protoErr *data.ProtocolError
+			if errors.As(req.err, &protoErr) {
+				// the rest of the stream can not be parsed, reply the error and drop the client
+				_ = client.WriteReply(protoErr.ToErrorData())
+				log.Printf("Client %s protocol error: %s", conn.RemoteAddr(), req.err.Error())
+			} else if req.err != io.EOF && !errors.Is(req.err, net.ErrClosed) {
+				log.Printf("Read command from %s error: %s", conn.RemoteAddr(), req.err.Error())
+			}
+			_ = client.Flush()
+			return
+		}
+
+		lastCommand()
+		res := h.exec(connCtx, req.cmd, client)
+		if res == nil {
+			continue
+		}
+		if err := client.WriteReply(res); err != nil {
+			log.Printf("Write reply to %s error: %s", conn.RemoteAddr(), err.Error())
+			return
+		}
+	}
+}
+
+// readRequests parse commands from conn and send them to requests until an error happens, the error is sent last
+// inputEnded is set before the error is sent and ended is called after it
+func readRequests(ctx context.Context, conn net.Conn, requests chan<- *request, inputEnded *atomic.Bool, ended func()) {
+	parser := data.NewParser(bufio.NewReader(conn))
+	for {
+		req := &request{}
+		cmd, err := parser.ReadCommand()
+		if err != nil {
+			req.err = err
+			inputEnded.Store(true)
+		} else {
+			req.cmd = cmd.ToCommand()
+			if len(req.cmd) == 0 {
+				continue
+			}
+		}
+		select {
+		case requests <- req:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			ended()
+			return
+		}
+	}
+}
+
+// exec run one command on the db selected by the client
+// A panic in the executor is turned into an error reply to keep the connection alive
+func (h *Handler) exec(ctx context.Context, cmd [][]byte, client *db.Client) (res data.RedisData) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Exec command %s panic: %v", string(cmd[0]), r)
+			res = data.MakeErrorData("ERR server error")
+		}
+	}()
+	return h.dbs.Get(client.DBIndex()).ExecCommand(ctx, cmd, client)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/data"
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	config.Configures = &config.Config{
+		ShardNumber:         16,
+		Databases:           16,
+		ListMaxListpackSize: -2,
+		ReplicaReadOnly:     true,
+		ReplBacklogSize:     1024 * 1024,
+		Others:              make(map[string]any),
+	}
+	os.Exit(m.Run())
+}
+
+// testClient is a client connected to a Handler through the loopback
+type testClient struct {
+	conn   *net.TCPConn
+	parser *data.Parser
+	// done is closed once Handle returns
+	done chan struct{}
+}
+
+// connect start serving a new connection with h
+func connect(t *testing.T, h *Handler) *testClient {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	c := &testClient{
+		conn:   conn.(*net.TCPConn),
+		parser: data.NewParser(bufio.NewReader(conn)),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		h.Handle(context.Background(), server)
+		close(c.done)
+	}()
+	t.Cleanup(func() {
+		_ = c.conn.Close()
+	})
+	return c
+}
+
+// send write the requests as they are
+func (c *testClient) send(t *testing.T, raw string) {
+	t.Helper()
+	if _, err := c.conn.Write([]byte(raw)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+}
+
+// reply read the next reply in RESP
+func (c *testClient) reply(t *testing.T) string {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := c.parser.ReadValue()
+	if err != nil {
+		t.Fatalf("ReadValue() error = %v", err)
+	}
+	return string(res.ToBytes())
+}
+
+// do send a command and return its reply
+func (c *testClient) do(t *testing.T, raw string) string {
+	t.Helper()
+	c.send(t, raw)
+	return c.reply(t)
+}
+
+// waitDone wait until the handler of c returns
+func (c *testClient) waitDone(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the connection is still served after the client is gone")
+	}
+}
+
+func TestHandleDisconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		// sent by the client before it leaves
+		sent string
+		// halfClose keeps the client reading the replies after it closes its side
+		halfClose bool
+		// replies are the replies the client reads after it closes its side
+		replies []string
+		// llen is the length of q once the client is gone and an element is pushed
+		llen string
+	}{
+		{
+			name: "blocking client closes",
+			sent: "*3\r\n$5\r\nblpop\r\n$1\r\nq\r\n$1\r\n0\r\n",
+			llen: ":1\r\n",
+		},
+		{
+			name: "blocking client after queued commands closes",
+			sent: "*1\r\n$4\r\nping\r\n*3\r\n$5\r\nblpop\r\n$1\r\nq\r\n$1\r\n0\r\n",
+			llen: ":1\r\n",
+		},
+		{
+			name:      "blocking client half closes",
+			sent:      "*3\r\n$5\r\nblpop\r\n$1\r\nq\r\n$1\r\n0\r\n",
+			halfClose: true,
+			replies:   []string{"*-1\r\n"},
+			llen:      ":1\r\n",
+		},
+		{
+			name:      "queued commands run after a half close",
+			sent:      "*3\r\n$5\r\nrpush\r\n$1\r\nq\r\n$1\r\na\r\n*2\r\n$4\r\nllen\r\n$1\r\nq\r\n",
+			halfClose: true,
+			replies:   []string{":1\r\n", ":1\r\n"},
+			llen:      ":2\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler()
+			c := connect(t, h)
+			c.send(t, tt.sent)
+			// give the commands the time to block before the client leaves
+			time.Sleep(50 * time.Millisecond)
+			if tt.halfClose {
+				_ = c.conn.CloseWrite()
+				for _, want := range tt.replies {
+					if got := c.reply(t); got != want {
+						t.Fatalf("reply = %q, want %q", got, want)
+					}
+				}
+			} else {
+				_ = c.conn.Close()
+			}
+			c.waitDone(t)
+
+			other := connect(t, h)
+			other.do(t, "*3\r\n$5\r\nrpush\r\n$1\r\nq\r\n$1\r\nx\r\n")
+			if got := other.do(t, "*2\r\n$4\r\nllen\r\n$1\r\nq\r\n"); got != tt.llen {
+				t.Fatalf("llen q = %q, want %q", got, tt.llen)
+			}
+		})
+	}
+}
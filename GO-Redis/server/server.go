@@ -0,0 +1,91 @@
+package server
+
+import (
+	"GO-Redis/config"
+	"GO-Redis/db"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Start bind the host and port in cfg and serve every client connection in its own goroutine
+// It blocks until the listener is closed by SIGINT or SIGTERM
+func Start(cfg *config.Config) error {
+	handler := NewHandler()
+	if cfg.AppendOnly {
+		if err := handler.dbs.OpenAOF(db.AOFPath(), cfg.AppendFsync); err != nil {
+			return err
+		}
+	} else if err := handler.dbs.LoadRDB(db.RDBPath()); err != nil {
+		return err
+	}
+	if cfg.MasterHost != "" {
+		handler.dbs.ReplicaOf(cfg.MasterHost, cfg.MasterPort)
+	}
+	if cfg.ClusterEnabled {
+		if err := handler.dbs.OpenCluster(db.ClusterConfigPath()); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
+	if err != nil {
+		return err
+	}
+	log.Printf("Server listen at %s:%d", cfg.Host, cfg.Port)
+	var busListener net.Listener
+	if cfg.ClusterEnabled {
+		busListener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port+10000))
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		log.Printf("Cluster bus listen at %s:%d", cfg.Host, cfg.Port+10000)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// close the listener when the process is asked to stop
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Server receive signal %s, shutting down", sig)
+		cancel()
+		_ = listener.Close()
+	}()
+
+	go handler.dbs.ServeSaveRules(ctx)
+	go handler.dbs.ServeAOFFsync(ctx)
+	go handler.dbs.ServeReplication(ctx)
+	if busListener != nil {
+		go handler.dbs.ServeClusterBus(ctx, busListener)
+	}
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			log.Printf("Accept connection error: %s", err.Error())
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.Handle(ctx, conn)
+		}()
+	}
+	wg.Wait()
+	handler.dbs.Shutdown()
+	return nil
+}